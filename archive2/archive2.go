@@ -31,8 +31,19 @@ type Archive2 struct {
 	VCP              *Message5
 }
 
-// Extract data from a given archive 2 data file.
+// Extract data from a given archive 2 data file. The program exits on data
+// that can not be decoded, see ExtractErr.
 func Extract(f io.ReadSeeker) *Archive2 {
+	ar2, err := ExtractErr(f)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return ar2
+}
+
+// ExtractErr extracts data from a given archive 2 data file, returning an
+// error for files that are truncated, corrupt or not archive 2 files.
+func ExtractErr(f io.ReadSeeker) (*Archive2, error) {
 	ar2ExtractTimeStart := time.Now()
 	defer func() {
 		logrus.Debugf("ar2: done %s", time.Since(ar2ExtractTimeStart))
@@ -45,18 +56,21 @@ func Extract(f io.ReadSeeker) *Archive2 {
 	}
 
 	// older archive2 files are gzipped, check for those and decompress if found
-	if yes, ctype := isCompressed(f); yes {
+	yes, ctype, err := isCompressed(f)
+	if err != nil {
+		return nil, err
+	}
+	if yes {
 		if ctype != "gz" {
-			logrus.Fatalf("unsupported compression %s", ctype)
+			return nil, fmt.Errorf("unsupported compression %s", ctype)
 		}
 		var gzd *gzip.Reader
-		var err error
 		if gzd, err = gzip.NewReader(f); err != nil {
-			logrus.Fatalf("failed to open gzip file: %s", err)
+			return nil, fmt.Errorf("failed to open gzip file: %s", err)
 		}
 		gzb, err := ioutil.ReadAll(gzd)
 		if err != nil {
-			logrus.Fatalf("failed to read gzip file: %s", err)
+			return nil, fmt.Errorf("failed to read gzip file: %s", err)
 		}
 		f = bytes.NewReader(gzb)
 	}
@@ -71,7 +85,9 @@ func Extract(f io.ReadSeeker) *Archive2 {
 	// Archive II filename.
 
 	// read in the 24 byte volume header record
-	binary.Read(f, binary.BigEndian, &ar2.VolumeHeader)
+	if err := binary.Read(f, binary.BigEndian, &ar2.VolumeHeader); err != nil {
+		return nil, fmt.Errorf("failed to read the volume header: %s", err)
+	}
 
 	logrus.Debug(ar2.VolumeHeader)
 
@@ -92,9 +108,9 @@ func Extract(f io.ReadSeeker) *Archive2 {
 		// read in control word (size) of LDM record
 		if err := binary.Read(f, binary.BigEndian, &ldm.Size); err != nil {
 			if err != io.EOF {
-				logrus.Panic(err.Error())
+				return nil, fmt.Errorf("failed to read LDM record size: %s", err)
 			}
-			return &ar2
+			return &ar2, nil
 		}
 
 		// As the control word contains a negative size under some circumstances,
@@ -111,10 +127,16 @@ func Extract(f io.ReadSeeker) *Archive2 {
 			"size": ldm.Size,
 		}).Tracef("ar2: ldm: new LDM record")
 
+		c, _, err := isCompressed(f)
+		if err != nil {
+			return nil, err
+		}
 		var msgBuf io.ReadSeeker
-		if c, _ := isCompressed(f); c {
+		if c {
 			logrus.Tracef("ar2: ldm: decompressing %d bytes", ldm.Size)
-			msgBuf = decompressBZ2(f, ldm.Size)
+			if msgBuf, err = decompressBZ2(f, ldm.Size); err != nil {
+				return nil, err
+			}
 		} else {
 			msgBuf = f
 		}
@@ -138,7 +160,7 @@ func Extract(f io.ReadSeeker) *Archive2 {
 			if err := binary.Read(msgBuf, binary.BigEndian, &msgHeader); err != nil {
 				if err != io.EOF {
 					logrus.Debugf("processed %d messages", numMessages)
-					logrus.Panic(err.Error())
+					return nil, fmt.Errorf("failed to read message header: %s", err)
 				}
 				break
 			}
@@ -184,7 +206,10 @@ func Extract(f io.ReadSeeker) *Archive2 {
 			// 	// move to the end of the message
 			// 	msgBuf.Seek(MessageBodySize-int64(msgHeader.MessageSize), io.SeekCurrent)
 			case 31:
-				m31 := msg31(msgBuf)
+				m31, err := msg31(msgBuf)
+				if err != nil {
+					return nil, err
+				}
 				// logrus.Trace(m31.Header.String())
 				ar2.ElevationScans[int(m31.Header.ElevationNumber)] = append(ar2.ElevationScans[int(m31.Header.ElevationNumber)], m31)
 			default:
@@ -193,7 +218,7 @@ func Extract(f io.ReadSeeker) *Archive2 {
 				}
 				_, err := msgBuf.Seek(MessageBodySize, io.SeekCurrent)
 				if err != nil {
-					logrus.Panic("failed to seek forward header message size")
+					return nil, fmt.Errorf("failed to seek forward header message size: %s", err)
 				}
 			}
 
@@ -201,7 +226,7 @@ func Extract(f io.ReadSeeker) *Archive2 {
 		}
 		logrus.Tracef("ar2: ldm: done: %s messages:%v", time.Since(ldmExtractTimeStart), messageCounts)
 	}
-	return &ar2
+	return &ar2, nil
 }
 
 func (ar2 *Archive2) String() string {
@@ -1,6 +1,8 @@
 package archive2
 
 import (
+	"bytes"
+	"encoding/binary"
 	"os"
 	"testing"
 )
@@ -29,3 +31,38 @@ func TestRawDatasets(t *testing.T) {
 		Extract(tamu)
 	}
 }
+
+func TestExtractErr(t *testing.T) {
+	header := func() *bytes.Buffer {
+		var buf bytes.Buffer
+		buf.WriteString("AR2V0006.001")
+		buf.Write(make([]byte, 8))
+		buf.WriteString("KTLX")
+		return &buf
+	}
+
+	ar2, err := ExtractErr(bytes.NewReader(header().Bytes()))
+	if err != nil || string(ar2.VolumeHeader.ICAO[:]) != "KTLX" {
+		t.Errorf("volume header only: %v, %v", ar2, err)
+	}
+
+	truncated := map[string][]byte{
+		"empty":                 nil,
+		"partial volume header": []byte("AR2V0006"),
+	}
+	// an LDM control word without its record
+	buf := header()
+	binary.Write(buf, binary.BigEndian, int32(1000))
+	truncated["LDM control word"] = buf.Bytes()
+	// a partial bzip2 record
+	buf = header()
+	binary.Write(buf, binary.BigEndian, int32(1000))
+	buf.WriteString("BZh91AY&SY")
+	truncated["partial LDM record"] = buf.Bytes()
+
+	for name, data := range truncated {
+		if _, err := ExtractErr(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
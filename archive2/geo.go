@@ -0,0 +1,109 @@
+package archive2
+
+import "math"
+
+const (
+	// EarthRadius mean radius of the earth in meters
+	EarthRadius = 6371000.0
+	// effectiveEarthRadius is the radius used by the standard 4/3 earth model
+	// to account for the refraction of the beam in a standard atmosphere.
+	effectiveEarthRadius = EarthRadius * 4 / 3
+)
+
+// BeamHeight returns the height in meters above the radar of the center of the
+// beam at slant range r in meters and elevation angle el in degrees, using the
+// 4/3 earth model.
+func BeamHeight(r, el float64) float64 {
+	ke := effectiveEarthRadius
+	sinEl := math.Sin(el * math.Pi / 180)
+	return math.Sqrt(r*r+ke*ke+2*r*ke*sinEl) - ke
+}
+
+// GroundRange returns the distance in meters along the surface of the earth
+// from the radar to the point below the beam center at slant range r in meters
+// and elevation angle el in degrees.
+func GroundRange(r, el float64) float64 {
+	ke := effectiveEarthRadius
+	h := BeamHeight(r, el)
+	return ke * math.Asin(r*math.Cos(el*math.Pi/180)/(ke+h))
+}
+
+// Destination returns the latitude and longitude reached by travelling the
+// given distance in meters from lat, lon along the initial bearing in degrees.
+func Destination(lat, lon, bearing, distance float64) (float64, float64) {
+	φ1 := lat * math.Pi / 180
+	λ1 := lon * math.Pi / 180
+	θ := bearing * math.Pi / 180
+	δ := distance / EarthRadius
+
+	φ2 := math.Asin(math.Sin(φ1)*math.Cos(δ) + math.Cos(φ1)*math.Sin(δ)*math.Cos(θ))
+	λ2 := λ1 + math.Atan2(math.Sin(θ)*math.Sin(δ)*math.Cos(φ1), math.Cos(δ)-math.Sin(φ1)*math.Sin(φ2))
+
+	return φ2 * 180 / math.Pi, math.Mod(λ2*180/math.Pi+540, 360) - 180
+}
+
+// SiteAltitude returns the height of the radar antenna above mean sea level in meters.
+func (v VolumeData) SiteAltitude() float64 {
+	return float64(v.SiteHeight) + float64(v.FeedhornHeight)
+}
+
+// GateHeight returns the height in meters above mean sea level of the beam
+// center at slant range r in meters along the radial.
+func (m31 *Message31) GateHeight(r float64) float64 {
+	return m31.VolumeData.SiteAltitude() + BeamHeight(r, float64(m31.Header.ElevationAngle))
+}
+
+// GateLocation returns the latitude, longitude and height in meters above mean
+// sea level of the beam center at slant range r in meters along the radial.
+func (m31 *Message31) GateLocation(r float64) (lat, lon, height float64) {
+	el := float64(m31.Header.ElevationAngle)
+	lat, lon = Destination(float64(m31.VolumeData.Lat), float64(m31.VolumeData.Long), float64(m31.Header.AzimuthAngle), GroundRange(r, el))
+	return lat, lon, m31.GateHeight(r)
+}
+
+// SlantRange returns the slant range in meters along a beam with elevation
+// angle el in degrees to the point above the given ground range in meters.
+// It is the inverse of GroundRange.
+func SlantRange(groundRange, el float64) float64 {
+	ke := effectiveEarthRadius
+	θ := groundRange / ke
+	elr := el * math.Pi / 180
+	// law of sines in the triangle formed by the earth center, radar and gate
+	return ke * math.Sin(θ) / math.Cos(elr+θ)
+}
+
+// BearingDistance returns the initial bearing in degrees and the great circle
+// distance in meters from lat1, lon1 to lat2, lon2.
+func BearingDistance(lat1, lon1, lat2, lon2 float64) (bearing, distance float64) {
+	φ1 := lat1 * math.Pi / 180
+	φ2 := lat2 * math.Pi / 180
+	Δλ := (lon2 - lon1) * math.Pi / 180
+
+	y := math.Sin(Δλ) * math.Cos(φ2)
+	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(Δλ)
+	bearing = math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+
+	a := math.Sin((φ2-φ1)/2)*math.Sin((φ2-φ1)/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(Δλ/2)*math.Sin(Δλ/2)
+	distance = 2 * EarthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+	return bearing, distance
+}
+
+// GateCorners returns the latitude, longitude pairs of the corners of the gate
+// centered at slant range r in meters along the radial and spacing meters
+// long. The gate spans half the azimuth resolution spacing on either side of
+// the radial. The corners are counterclockwise on a map, starting at the near
+// corner of the lower azimuth.
+func (m31 *Message31) GateCorners(r, spacing float64) [4][2]float64 {
+	el := float64(m31.Header.ElevationAngle)
+	az := float64(m31.Header.AzimuthAngle)
+	half := m31.Header.AzimuthResolutionSpacing() / 2
+	near := GroundRange(math.Max(0, r-spacing/2), el)
+	far := GroundRange(r+spacing/2, el)
+
+	lat, lon := float64(m31.VolumeData.Lat), float64(m31.VolumeData.Long)
+	corners := [4][2]float64{}
+	for i, c := range []struct{ az, d float64 }{{az - half, near}, {az + half, near}, {az + half, far}, {az - half, far}} {
+		corners[i][0], corners[i][1] = Destination(lat, lon, c.az, c.d)
+	}
+	return corners
+}
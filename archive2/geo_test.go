@@ -0,0 +1,73 @@
+package archive2
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBeamHeight(t *testing.T) {
+	// 0.5 degree beam is roughly 5.1km above the radar at 230km
+	h := BeamHeight(230000, 0.5)
+	if math.Abs(h-5100) > 100 {
+		t.Errorf("got %f want ~5100", h)
+	}
+	if BeamHeight(0, 0.5) != 0 {
+		t.Error("expected zero height at the radar")
+	}
+}
+
+func TestDestination(t *testing.T) {
+	// one degree of latitude north of the equator
+	lat, lon := Destination(0, 0, 0, EarthRadius*math.Pi/180)
+	if math.Abs(lat-1) > 1e-9 || math.Abs(lon) > 1e-9 {
+		t.Errorf("got %f,%f want 1,0", lat, lon)
+	}
+}
+
+func TestSlantRange(t *testing.T) {
+	for _, el := range []float64{0.5, 3.5, 19.5} {
+		r := 120000.0
+		if got := SlantRange(GroundRange(r, el), el); math.Abs(got-r) > 1 {
+			t.Errorf("el %f: got %f want %f", el, got, r)
+		}
+	}
+}
+
+func TestGateCorners(t *testing.T) {
+	m31 := &Message31{}
+	m31.Header.AzimuthAngle = 90.25
+	m31.Header.ElevationAngle = 0.5
+	m31.Header.AzimuthResolutionSpacingCode = 1
+	m31.VolumeData.Lat = 35.333
+	m31.VolumeData.Long = -97.278
+
+	c := m31.GateCorners(50000, 250)
+	// the gate center lies within the corners
+	lat, lon, _ := m31.GateLocation(50000)
+	if lat > math.Max(c[0][0], c[3][0]) || lat < math.Min(c[1][0], c[2][0]) {
+		t.Errorf("center latitude %f outside of %v", lat, c)
+	}
+	if lon < math.Min(c[0][1], c[1][1]) || lon > math.Max(c[2][1], c[3][1]) {
+		t.Errorf("center longitude %f outside of %v", lon, c)
+	}
+
+	// 250m deep and 0.5 degrees wide at 50km
+	_, depth := BearingDistance(c[0][0], c[0][1], c[3][0], c[3][1])
+	_, width := BearingDistance(c[0][0], c[0][1], c[1][0], c[1][1])
+	if math.Abs(depth-250) > 1 {
+		t.Errorf("depth %f want 250", depth)
+	}
+	if want := GroundRange(49875, 0.5) * 0.5 * math.Pi / 180; math.Abs(width-want) > 1 {
+		t.Errorf("width %f want %f", width, want)
+	}
+
+	// counterclockwise in longitude, latitude
+	var area float64
+	for i, p := range c {
+		q := c[(i+1)%4]
+		area += p[1]*q[0] - q[1]*p[0]
+	}
+	if area <= 0 {
+		t.Errorf("corners %v are not counterclockwise", c)
+	}
+}
@@ -3,11 +3,10 @@ package archive2
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 // Message31 Digital Radar Data Generic Format
@@ -33,6 +32,29 @@ type Message31 struct {
 	CfpData          *DataMoment // CfpData (Clutter Filter Power Removed)
 }
 
+// Moment returns the data moment with the given data block name, one of REF,
+// VEL, SW, ZDR, PHI, RHO or CFP. nil is returned if the radial does not hold
+// the moment.
+func (m31 *Message31) Moment(name string) *DataMoment {
+	switch name {
+	case "REF":
+		return m31.ReflectivityData
+	case "VEL":
+		return m31.VelocityData
+	case "SW", "SW ":
+		return m31.SwData
+	case "ZDR":
+		return m31.ZdrData
+	case "PHI":
+		return m31.PhiData
+	case "RHO":
+		return m31.RhoData
+	case "CFP":
+		return m31.CfpData
+	}
+	return nil
+}
+
 func (h Message31Header) String() string {
 	return fmt.Sprintf("Message 31 - %s @ %v deg=%.2f tilt=%.2f",
 		string(h.RadarIdentifier[:]),
@@ -95,13 +117,15 @@ func (h *Message31Header) AzimuthResolutionSpacing() float64 {
 	return 1
 }
 
-func msg31(r io.ReadSeeker) *Message31 {
+func msg31(r io.ReadSeeker) (*Message31, error) {
 	m31h := Message31Header{}
 
 	// save the position of the first byte so we can easily process data blocks later.
 	startPos, _ := r.Seek(0, io.SeekCurrent)
 
-	binary.Read(r, binary.BigEndian, &m31h)
+	if err := binary.Read(r, binary.BigEndian, &m31h); err != nil {
+		return nil, fmt.Errorf("M31 Header: %s", err)
+	}
 
 	m31 := Message31{
 		Header: m31h,
@@ -119,7 +143,7 @@ func msg31(r io.ReadSeeker) *Message31 {
 
 	blockPointers := make([]uint32, m31h.DataBlockCount)
 	if err := binary.Read(r, binary.BigEndian, blockPointers); err != nil {
-		logrus.Panic(err.Error())
+		return nil, fmt.Errorf("M31 Header: failed to read the datablock pointers: %s", err)
 	}
 
 	// check for more DataBlockPointers
@@ -130,7 +154,7 @@ func msg31(r io.ReadSeeker) *Message31 {
 	maxLoops := 20
 	for i := 0; true; i++ {
 		if err := binary.Read(r, binary.BigEndian, &lookahead); err != nil {
-			logrus.Panic(err.Error())
+			return nil, fmt.Errorf("M31 Header: failed to read the datablock pointers: %s", err)
 		}
 
 		if bytes.Equal(lookahead, hexRVOL) {
@@ -146,7 +170,7 @@ func msg31(r io.ReadSeeker) *Message31 {
 
 		// prevent infinite loop
 		if i == maxLoops {
-			logrus.Fatal("M31 Header: failed to find the end of the datablock pointers.")
+			return nil, errors.New("M31 Header: failed to find the end of the datablock pointers")
 		}
 		i++
 	}
@@ -159,7 +183,7 @@ func msg31(r io.ReadSeeker) *Message31 {
 
 		d := DataBlock{}
 		if err := binary.Read(r, binary.BigEndian, &d); err != nil {
-			logrus.Panic(err.Error())
+			return nil, fmt.Errorf("M31 Data Block: %s", err)
 		}
 
 		// rewind from reading the datalblock
@@ -222,8 +246,8 @@ func msg31(r io.ReadSeeker) *Message31 {
 			}
 		default:
 			// preview(r, 256)
-			logrus.Panicf("Data Block - unknown type '%s'", blockName)
+			return nil, fmt.Errorf("Data Block - unknown type '%s'", blockName)
 		}
 	}
-	return &m31
+	return &m31, nil
 }
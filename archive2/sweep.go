@@ -0,0 +1,108 @@
+package archive2
+
+import "math"
+
+// sweepBinsPerDegree is the resolution of the azimuth lookup table of a Sweep.
+const sweepBinsPerDegree = 10
+
+// Sweep indexes the radials of a single elevation scan by azimuth and caches
+// their scaled moment data. A Sweep is not safe for concurrent use.
+type Sweep struct {
+	Radials []*Message31
+	// ElevationAngle is the mean elevation angle of the radials in degrees.
+	ElevationAngle float64
+	index          []int
+	cache          []map[string][]float32
+}
+
+// NewSweep returns a Sweep for the radials of an elevation scan.
+func NewSweep(radials []*Message31) *Sweep {
+	s := &Sweep{
+		Radials: radials,
+		index:   make([]int, 360*sweepBinsPerDegree),
+		cache:   make([]map[string][]float32, len(radials)),
+	}
+	for i := range s.index {
+		s.index[i] = -1
+	}
+
+	if len(radials) == 0 {
+		return s
+	}
+
+	var el float64
+	for i, radial := range radials {
+		el += float64(radial.Header.ElevationAngle)
+
+		// every radial covers half its resolution spacing on either side of its azimuth
+		half := radial.Header.AzimuthResolutionSpacing() / 2
+		az := float64(radial.Header.AzimuthAngle)
+		start := int(math.Round((az - half) * sweepBinsPerDegree))
+		end := int(math.Round((az + half) * sweepBinsPerDegree))
+		for b := start; b < end; b++ {
+			s.index[(b+len(s.index))%len(s.index)] = i
+		}
+	}
+	s.ElevationAngle = el / float64(len(radials))
+	return s
+}
+
+// Index returns the index of the radial covering the azimuth in degrees, or -1
+// if no radial covers it.
+func (s *Sweep) Index(azimuth float64) int {
+	b := int(math.Floor(azimuth*sweepBinsPerDegree)) % len(s.index)
+	if b < 0 {
+		b += len(s.index)
+	}
+	return s.index[b]
+}
+
+// Gates returns the scaled gates of the named moment of radial i, see
+// Message31.Moment. The result is cached and must not be modified.
+func (s *Sweep) Gates(i int, moment string) []float32 {
+	if s.cache[i] == nil {
+		s.cache[i] = map[string][]float32{}
+	}
+	if gates, ok := s.cache[i][moment]; ok {
+		return gates
+	}
+	var gates []float32
+	if m := s.Radials[i].Moment(moment); m != nil {
+		gates = m.ScaledData()
+	}
+	s.cache[i][moment] = gates
+	return gates
+}
+
+// ValueAt returns the value of the named moment at the given azimuth in degrees
+// and slant range in meters. ok is false if no radial or gate covers the
+// location.
+func (s *Sweep) ValueAt(moment string, azimuth, slantRange float64) (v float32, ok bool) {
+	i := s.Index(azimuth)
+	if i < 0 {
+		return 0, false
+	}
+	m := s.Radials[i].Moment(moment)
+	if m == nil {
+		return 0, false
+	}
+	g := m.GateIndex(slantRange)
+	if g < 0 {
+		return 0, false
+	}
+	gates := s.Gates(i, moment)
+	if g >= len(gates) {
+		return 0, false
+	}
+	return gates[g], true
+}
+
+// Sweeps returns a Sweep for every elevation scan in the volume ordered by
+// elevation number.
+func (ar2 *Archive2) Sweeps() []*Sweep {
+	sweeps := []*Sweep{}
+	for _, elv := range ar2.Elevations() {
+		sweeps = append(sweeps, NewSweep(ar2.ElevationScans[elv]))
+	}
+	return sweeps
+}
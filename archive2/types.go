@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -234,3 +235,22 @@ func scaleUint(n uint16, offset, scale float32) float32 {
 	}
 	return (float32(n) - offset) / scale
 }
+
+// GateRange returns the range in meters from the radar to the center of gate i.
+func (d *DataMoment) GateRange(i int) float64 {
+	return float64(d.DataMomentRange) + float64(i)*float64(d.DataMomentRangeSampleInterval)
+}
+
+// GateIndex returns the index of the gate covering the given range in meters,
+// or -1 if the range falls outside of the moment's gates.
+func (d *DataMoment) GateIndex(r float64) int {
+	if d.DataMomentRangeSampleInterval == 0 {
+		return -1
+	}
+	interval := float64(d.DataMomentRangeSampleInterval)
+	i := int(math.Floor((r-float64(d.DataMomentRange))/interval + 0.5))
+	if i < 0 || i >= int(d.NumberDataMomentGates) {
+		return -1
+	}
+	return i
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/bzip2"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"time"
 
@@ -18,32 +19,36 @@ func preview(r io.ReadSeeker, n int) {
 	r.Seek(-int64(n), io.SeekCurrent)
 }
 
-func decompressBZ2(f io.Reader, size int32) *bytes.Reader {
+func decompressBZ2(f io.Reader, size int32) (*bytes.Reader, error) {
 	start := time.Now()
 	defer func() {
 		logrus.Tracef("ar2: bz2 extracted %d Bytes in %s", size, time.Since(start))
 	}()
 	compressedData := make([]byte, size)
-	binary.Read(f, binary.BigEndian, &compressedData)
+	if _, err := io.ReadFull(f, compressedData); err != nil {
+		return nil, fmt.Errorf("failed to read %d bytes of bz2 data: %s", size, err)
+	}
 	bz2Reader := bzip2.NewReader(bytes.NewReader(compressedData))
 	extractedData := bytes.NewBuffer([]byte{})
-	io.Copy(extractedData, bz2Reader)
-	return bytes.NewReader(extractedData.Bytes())
+	if _, err := io.Copy(extractedData, bz2Reader); err != nil {
+		return nil, fmt.Errorf("failed to decompress bz2 data: %s", err)
+	}
+	return bytes.NewReader(extractedData.Bytes()), nil
 }
 
 // isCompressed return true if the file is compressed and string indicating the compression algorithm.
-func isCompressed(f io.ReadSeeker) (bool, string) {
+func isCompressed(f io.ReadSeeker) (bool, string, error) {
 	header := make([]byte, 2)
-	if _, err := f.Read(header); err != nil {
-		logrus.Fatalf("isCompressed: failed to peek header: %s\n", err)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false, "", fmt.Errorf("isCompressed: failed to peek header: %s", err)
 	}
 	f.Seek(-2, io.SeekCurrent)
 	headerString := string(header)
 	switch headerString {
 	case "BZ":
-		return true, "bz2"
+		return true, "bz2", nil
 	case "\x1f\x8b":
-		return true, "gz"
+		return true, "gz", nil
 	}
-	return false, ""
+	return false, "", nil
 }
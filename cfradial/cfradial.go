@@ -0,0 +1,363 @@
+// Package cfradial writes NEXRAD Level 2 volumes as CfRadial 1.4 NetCDF
+// files, readable by community tools such as Py-ART and LROSE.
+package cfradial
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/netcdf"
+)
+
+// fillValue marks gates below threshold, range folded or outside the range
+// of a moment.
+const fillValue = math.MinInt16
+
+// stringLength is the length of the string dimension.
+const stringLength = 32
+
+// field describes how a data moment is written as a CfRadial field.
+type field struct {
+	moment, name, standardName, longName, units string
+}
+
+var fields = []field{
+	{"REF", "DBZ", "equivalent_reflectivity_factor", "reflectivity", "dBZ"},
+	{"VEL", "VEL", "radial_velocity_of_scatterers_away_from_instrument", "radial velocity", "m/s"},
+	{"SW", "WIDTH", "doppler_spectrum_width", "spectrum width", "m/s"},
+	{"ZDR", "ZDR", "log_differential_reflectivity_hv", "differential reflectivity", "dB"},
+	{"PHI", "PHIDP", "differential_phase_hv", "differential phase", "degrees"},
+	{"RHO", "RHOHV", "cross_correlation_ratio_hv", "correlation coefficient", "unitless"},
+	{"CFP", "CFP", "", "clutter filter power removed", "dB"},
+}
+
+// Write writes the volume to w as a CfRadial 1.4 file. Every elevation scan
+// of the volume is a sweep, moments missing from a sweep are filled. The
+// moments share the range axis of the finest gate spacing and keep their
+// original precision as scaled shorts.
+func Write(w io.Writer, ar2 *archive2.Archive2) error {
+	f, err := NewFile(ar2)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// NewFile returns the CfRadial NetCDF file of the volume, see Write.
+func NewFile(ar2 *archive2.Archive2) (*netcdf.File, error) {
+	elevations := ar2.Elevations()
+	rays := []*archive2.Message31{}
+	sweepStart, sweepEnd := []int32{}, []int32{}
+	sweepNumber, fixedAngle := []int32{}, []float32{}
+	sweepMode := []byte{}
+	for i, e := range elevations {
+		radials := ar2.ElevationScans[e]
+		if len(radials) == 0 {
+			continue
+		}
+		sweepNumber = append(sweepNumber, int32(i))
+		sweepStart = append(sweepStart, int32(len(rays)))
+		rays = append(rays, radials...)
+		sweepEnd = append(sweepEnd, int32(len(rays)-1))
+		fixedAngle = append(fixedAngle, float32(archive2.NewSweep(radials).ElevationAngle))
+		sweepMode = append(sweepMode, fixed("azimuth_surveillance")...)
+	}
+	if len(rays) == 0 {
+		return nil, fmt.Errorf("cfradial: volume has no radials")
+	}
+
+	first, spacing, gates := rangeGeometry(rays)
+	if gates == 0 {
+		return nil, fmt.Errorf("cfradial: volume has no gates")
+	}
+	start := rays[0].Header.Date()
+	end := start
+	for _, ray := range rays {
+		if t := ray.Header.Date(); t.Before(start) {
+			start = t
+		} else if t.After(end) {
+			end = t
+		}
+	}
+
+	f := netcdf.New()
+	f.AddDimension("time", len(rays))
+	f.AddDimension("range", gates)
+	f.AddDimension("sweep", len(sweepNumber))
+	f.AddDimension("string_length", stringLength)
+	f.AddDimension("r_calib", 1)
+
+	station := strings.TrimSpace(string(ar2.VolumeHeader.ICAO[:]))
+	f.AddAttribute("Conventions", "CF/Radial instrument_parameters radar_calibration")
+	f.AddAttribute("version", "1.4")
+	f.AddAttribute("title", fmt.Sprintf("%s NEXRAD Level 2 volume", station))
+	f.AddAttribute("institution", "NOAA National Weather Service")
+	f.AddAttribute("references", "Interface Control Document for the Archive II/User 2620010")
+	f.AddAttribute("source", ar2.VolumeHeader.FileName())
+	f.AddAttribute("history", fmt.Sprintf("%s converted by go-nexrad", time.Now().UTC().Format(time.RFC3339)))
+	f.AddAttribute("comment", "")
+	f.AddAttribute("instrument_name", station)
+	f.AddAttribute("platform_type", "fixed")
+	f.AddAttribute("instrument_type", "radar")
+	f.AddAttribute("primary_axis", "axis_z")
+	f.AddAttribute("time_coverage_start", isoTime(start))
+	f.AddAttribute("time_coverage_end", isoTime(end))
+	if ar2.RadarStatus != nil {
+		f.AddAttribute("volume_coverage_pattern", int32(ar2.RadarStatus.VolumeCoveragePatternNum))
+		f.AddAttribute("rda_build", float64(ar2.RadarStatus.GetBuildNumber()))
+	}
+
+	f.AddVariable("volume_number", nil, int32(volumeNumber(ar2)))
+	f.AddVariable("time_coverage_start", []string{"string_length"}, fixed(isoTime(start))).
+		AddAttribute("standard_name", "data_volume_start_time_utc")
+	f.AddVariable("time_coverage_end", []string{"string_length"}, fixed(isoTime(end))).
+		AddAttribute("standard_name", "data_volume_end_time_utc")
+
+	site := rays[0].VolumeData
+	f.AddVariable("latitude", nil, float64(site.Lat)).
+		AddAttribute("standard_name", "latitude").
+		AddAttribute("units", "degrees_north")
+	f.AddVariable("longitude", nil, float64(site.Long)).
+		AddAttribute("standard_name", "longitude").
+		AddAttribute("units", "degrees_east")
+	f.AddVariable("altitude", nil, site.SiteAltitude()).
+		AddAttribute("standard_name", "altitude").
+		AddAttribute("units", "meters").
+		AddAttribute("positive", "up")
+
+	f.AddVariable("sweep_number", []string{"sweep"}, sweepNumber).
+		AddAttribute("standard_name", "sweep_index_number_0_based")
+	f.AddVariable("sweep_mode", []string{"sweep", "string_length"}, sweepMode).
+		AddAttribute("standard_name", "scan_mode_for_sweep")
+	f.AddVariable("fixed_angle", []string{"sweep"}, fixedAngle).
+		AddAttribute("standard_name", "beam_target_fixed_angle").
+		AddAttribute("units", "degrees")
+	f.AddVariable("sweep_start_ray_index", []string{"sweep"}, sweepStart).
+		AddAttribute("standard_name", "index_of_first_ray_in_sweep")
+	f.AddVariable("sweep_end_ray_index", []string{"sweep"}, sweepEnd).
+		AddAttribute("standard_name", "index_of_last_ray_in_sweep")
+
+	ranges := make([]float32, gates)
+	for i := range ranges {
+		ranges[i] = float32(first + float64(i)*spacing)
+	}
+	f.AddVariable("range", []string{"range"}, ranges).
+		AddAttribute("standard_name", "projection_range_coordinate").
+		AddAttribute("long_name", "range_to_measurement_volume").
+		AddAttribute("units", "meters").
+		AddAttribute("axis", "radial_range_coordinate").
+		AddAttribute("spacing_is_constant", "true").
+		AddAttribute("meters_to_center_of_first_gate", float32(first)).
+		AddAttribute("meters_between_gates", float32(spacing))
+
+	times := make([]float64, len(rays))
+	azimuths := make([]float32, len(rays))
+	elevs := make([]float32, len(rays))
+	nyquist := make([]float32, len(rays))
+	unambiguous := make([]float32, len(rays))
+	for i, ray := range rays {
+		times[i] = ray.Header.Date().Sub(start).Seconds()
+		azimuths[i] = ray.Header.AzimuthAngle
+		elevs[i] = ray.Header.ElevationAngle
+		nyquist[i] = float32(ray.RadialData.NyquistVelocity) / 100
+		// the unambiguous range is given in tenths of km
+		unambiguous[i] = float32(ray.RadialData.UnambiguousRange) * 100
+	}
+	f.AddVariable("time", []string{"time"}, times).
+		AddAttribute("standard_name", "time").
+		AddAttribute("long_name", "time in seconds since volume start").
+		AddAttribute("units", fmt.Sprintf("seconds since %s", isoTime(start))).
+		AddAttribute("calendar", "gregorian")
+	f.AddVariable("azimuth", []string{"time"}, azimuths).
+		AddAttribute("standard_name", "ray_azimuth_angle").
+		AddAttribute("units", "degrees").
+		AddAttribute("axis", "radial_azimuth_coordinate")
+	f.AddVariable("elevation", []string{"time"}, elevs).
+		AddAttribute("standard_name", "ray_elevation_angle").
+		AddAttribute("units", "degrees").
+		AddAttribute("axis", "radial_elevation_coordinate").
+		AddAttribute("positive", "up")
+
+	// instrument parameters
+	f.AddVariable("nyquist_velocity", []string{"time"}, nyquist).
+		AddAttribute("long_name", "unambiguous_doppler_velocity").
+		AddAttribute("units", "meters per second").
+		AddAttribute("meta_group", "instrument_parameters")
+	f.AddVariable("unambiguous_range", []string{"time"}, unambiguous).
+		AddAttribute("long_name", "unambiguous_range").
+		AddAttribute("units", "meters").
+		AddAttribute("meta_group", "instrument_parameters")
+	f.AddVariable("radar_beam_width_h", nil, float32(0.95)).
+		AddAttribute("long_name", "half_power_radar_beam_width_h_channel").
+		AddAttribute("units", "degrees").
+		AddAttribute("meta_group", "radar_parameters")
+	f.AddVariable("radar_beam_width_v", nil, float32(0.95)).
+		AddAttribute("long_name", "half_power_radar_beam_width_v_channel").
+		AddAttribute("units", "degrees").
+		AddAttribute("meta_group", "radar_parameters")
+
+	// radar calibration
+	f.AddVariable("r_calib_radar_constant_h", []string{"r_calib"}, []float32{site.CalibrationConstant}).
+		AddAttribute("long_name", "calibrated_radar_constant_h_channel").
+		AddAttribute("units", "dB").
+		AddAttribute("meta_group", "radar_calibration")
+	f.AddVariable("r_calib_xmit_power_h", []string{"r_calib"}, []float32{dBm(site.SHVTXPowerHor)}).
+		AddAttribute("long_name", "calibrated_radar_xmit_power_h_channel").
+		AddAttribute("units", "dBm").
+		AddAttribute("meta_group", "radar_calibration")
+	f.AddVariable("r_calib_xmit_power_v", []string{"r_calib"}, []float32{dBm(site.SHVTXPowerVer)}).
+		AddAttribute("long_name", "calibrated_radar_xmit_power_v_channel").
+		AddAttribute("units", "dBm").
+		AddAttribute("meta_group", "radar_calibration")
+	f.AddVariable("r_calib_zdr_correction", []string{"r_calib"}, []float32{site.SystemDifferentialReflectivity}).
+		AddAttribute("long_name", "calibrated_radar_zdr_correction").
+		AddAttribute("units", "dB").
+		AddAttribute("meta_group", "radar_calibration")
+	f.AddVariable("r_calib_system_phidp", []string{"r_calib"}, []float32{site.InitialSystemDifferentialPhase}).
+		AddAttribute("long_name", "calibrated_radar_system_phidp").
+		AddAttribute("units", "degrees").
+		AddAttribute("meta_group", "radar_calibration")
+
+	for _, fd := range fields {
+		scale, offset, ok := packing(rays, fd.moment)
+		if !ok {
+			continue
+		}
+
+		data := make([]int16, len(rays)*gates)
+		for i := range data {
+			data[i] = fillValue
+		}
+		for i, ray := range rays {
+			m := ray.Moment(fd.moment)
+			if m == nil {
+				continue
+			}
+			values := m.ScaledData()
+			for g := 0; g < gates; g++ {
+				mg := m.GateIndex(first + float64(g)*spacing)
+				if mg < 0 || mg >= len(values) {
+					continue
+				}
+				v := values[mg]
+				if v == archive2.MomentDataBelowThreshold || v == archive2.MomentDataFolded {
+					continue
+				}
+				packed := math.Round((float64(v) - offset) / scale)
+				data[i*gates+g] = int16(math.Max(math.MinInt16+1, math.Min(math.MaxInt16, packed)))
+			}
+		}
+
+		v := f.AddVariable(fd.name, []string{"time", "range"}, data)
+		v.AddAttribute("long_name", fd.longName)
+		if fd.standardName != "" {
+			v.AddAttribute("standard_name", fd.standardName)
+		}
+		v.AddAttribute("units", fd.units).
+			AddAttribute("scale_factor", float32(scale)).
+			AddAttribute("add_offset", float32(offset)).
+			AddAttribute("_FillValue", int16(fillValue)).
+			AddAttribute("coordinates", "elevation azimuth range")
+	}
+
+	return f, nil
+}
+
+// rangeGeometry returns the range in meters to the first gate, the gate
+// spacing and the number of gates of the range axis shared by every moment of
+// the rays. The axis starts at the first gate of any moment and has the finest
+// gate spacing, so no gates are lost when moments of different resolutions
+// are resampled onto it.
+func rangeGeometry(rays []*archive2.Message31) (first, spacing float64, gates int) {
+	first, spacing = math.Inf(1), math.Inf(1)
+	last := math.Inf(-1)
+	for _, ray := range rays {
+		for _, fd := range fields {
+			m := ray.Moment(fd.moment)
+			if m == nil || m.NumberDataMomentGates == 0 || m.DataMomentRangeSampleInterval == 0 {
+				continue
+			}
+			first = math.Min(first, float64(m.DataMomentRange))
+			spacing = math.Min(spacing, float64(m.DataMomentRangeSampleInterval))
+			last = math.Max(last, m.GateRange(int(m.NumberDataMomentGates)-1))
+		}
+	}
+	if math.IsInf(first, 1) {
+		return 0, 0, 0
+	}
+	return first, spacing, int(math.Round((last-first)/spacing)) + 1
+}
+
+// packing returns the scale factor and offset of the shorts a moment is
+// written as, valid for the moment of every ray. The finest resolution of the
+// rays is kept over all the values they can hold, unless these do not fit in
+// 16 bits. ok is false if no ray holds the moment or it is stored as floating
+// point values, which are not supported.
+func packing(rays []*archive2.Message31, moment string) (scale, offset float64, ok bool) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	scale = math.Inf(1)
+	for _, ray := range rays {
+		m := ray.Moment(moment)
+		if m == nil {
+			continue
+		}
+		if m.Scale == 0 {
+			return 0, 0, false
+		}
+		max := 255.0
+		if m.DataWordSize == 16 {
+			max = 65535
+		}
+		// raw values 0 and 1 are below threshold and range folded
+		lo = math.Min(lo, (2-float64(m.Offset))/float64(m.Scale))
+		hi = math.Max(hi, (max-float64(m.Offset))/float64(m.Scale))
+		scale = math.Min(scale, 1/float64(m.Scale))
+	}
+	if math.IsInf(scale, 1) {
+		return 0, 0, false
+	}
+
+	// the packed values run from one above the fill value to math.MaxInt16
+	const steps = math.MaxInt16 - (fillValue + 1)
+	if (hi-lo)/scale > steps {
+		scale = (hi - lo) / steps
+	}
+	return scale, lo - (fillValue+1)*scale, true
+}
+
+// volumeNumber returns the sequence number of the volume from the last three
+// characters of the file name.
+func volumeNumber(ar2 *archive2.Archive2) int {
+	name := ar2.VolumeHeader.FileName()
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		if n, err := strconv.Atoi(strings.TrimRight(name[i+1:], "\x00")); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// fixed returns s as a NUL padded string of the string length.
+func fixed(s string) []byte {
+	b := make([]byte, stringLength)
+	copy(b, s)
+	return b
+}
+
+func isoTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// dBm converts a power in kW to dBm.
+func dBm(kw float32) float32 {
+	if kw <= 0 {
+		return 0
+	}
+	return float32(10 * math.Log10(float64(kw)*1e6))
+}
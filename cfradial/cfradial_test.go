@@ -0,0 +1,186 @@
+package cfradial
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/netcdf"
+)
+
+// newMoment returns an 8 bit moment with the scale and offset of reflectivity.
+func newMoment(first, interval uint16, raw []byte) *archive2.DataMoment {
+	m := &archive2.DataMoment{}
+	m.NumberDataMomentGates = uint16(len(raw))
+	m.DataMomentRange = first
+	m.DataMomentRangeSampleInterval = interval
+	m.DataWordSize = 8
+	m.Scale = 2
+	m.Offset = 66
+	m.Data = raw
+	return m
+}
+
+func testVolume() *archive2.Archive2 {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	copy(ar2.VolumeHeader.ICAO[:], "KTLX")
+	for e, el := range []float32{0.5, 1.5} {
+		for i := 0; i < 4; i++ {
+			r := &archive2.Message31{}
+			r.Header.AzimuthAngle = float32(i * 90)
+			r.Header.ElevationAngle = el
+			r.Header.CollectionDate = 15845
+			r.Header.CollectionTime = uint32(e*20000 + i*1000)
+			r.VolumeData.Lat = 35.333
+			r.VolumeData.Long = -97.278
+			r.VolumeData.SiteHeight = 370
+			r.VolumeData.FeedhornHeight = 20
+			r.RadialData.NyquistVelocity = 2850
+			// 20 dBZ, below threshold, folded, 40 dBZ
+			r.ReflectivityData = newMoment(2125, 250, []byte{106, 0, 1, 146})
+			if e == 0 {
+				// velocity only covers the first two gates
+				r.VelocityData = newMoment(2125, 250, []byte{70, 62})
+				r.VelocityData.Scale = 2
+				r.VelocityData.Offset = 64
+			}
+			ar2.ElevationScans[e+1] = append(ar2.ElevationScans[e+1], r)
+		}
+	}
+	return ar2
+}
+
+func variable(f *netcdf.File, name string) *netcdf.Variable {
+	for _, v := range f.Variables {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func attribute(v *netcdf.Variable, name string) interface{} {
+	for _, a := range v.Attributes {
+		if a.Name == name {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func TestNewFile(t *testing.T) {
+	f, err := NewFile(testVolume())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dims := map[string]int{}
+	for _, d := range f.Dimensions {
+		dims[d.Name] = d.Length
+	}
+	if dims["time"] != 8 || dims["range"] != 4 || dims["sweep"] != 2 {
+		t.Fatalf("dimensions = %v", dims)
+	}
+
+	if got := variable(f, "sweep_start_ray_index").Data.([]int32); got[0] != 0 || got[1] != 4 {
+		t.Errorf("sweep_start_ray_index = %v", got)
+	}
+	if got := variable(f, "sweep_end_ray_index").Data.([]int32); got[0] != 3 || got[1] != 7 {
+		t.Errorf("sweep_end_ray_index = %v", got)
+	}
+	if got := variable(f, "fixed_angle").Data.([]float32); math.Abs(float64(got[1])-1.5) > 0.01 {
+		t.Errorf("fixed_angle = %v", got)
+	}
+	if got := variable(f, "time").Data.([]float64); got[7] != 23 {
+		t.Errorf("time = %v", got)
+	}
+	if got := variable(f, "range").Data.([]float32); got[0] != 2125 || got[3] != 2875 {
+		t.Errorf("range = %v", got)
+	}
+	if got := variable(f, "altitude").Data.(float64); got != 390 {
+		t.Errorf("altitude = %v", got)
+	}
+
+	unpack := func(name string, packed int16) float64 {
+		v := variable(f, name)
+		return float64(packed)*float64(attribute(v, "scale_factor").(float32)) + float64(attribute(v, "add_offset").(float32))
+	}
+	dbz := variable(f, "DBZ").Data.([]int16)
+	if got := unpack("DBZ", dbz[0]); math.Abs(got-20) > 1e-3 {
+		t.Errorf("DBZ[0] = %v, want 20", got)
+	}
+	if dbz[1] != fillValue || dbz[2] != fillValue {
+		t.Errorf("DBZ below threshold and folded gates = %d %d, want fill", dbz[1], dbz[2])
+	}
+	if got := unpack("DBZ", dbz[3]); math.Abs(got-40) > 1e-3 {
+		t.Errorf("DBZ[3] = %v, want 40", got)
+	}
+
+	vel := variable(f, "VEL").Data.([]int16)
+	if got := unpack("VEL", vel[1]); math.Abs(got+1) > 1e-3 {
+		t.Errorf("VEL[1] = %v, want -1", got)
+	}
+	if vel[2] != fillValue || vel[4*4] != fillValue {
+		t.Error("VEL beyond its gates or missing from the sweep should be filled")
+	}
+	if variable(f, "WIDTH") != nil {
+		t.Error("WIDTH should be omitted from a volume without spectrum width")
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("CDF\x01")) {
+		t.Errorf("file starts with %q", buf.Bytes()[:4])
+	}
+}
+
+func TestNewFileMixedResolution(t *testing.T) {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	// 1 km reflectivity with 250 m velocity at half a m/s
+	r := &archive2.Message31{}
+	r.Header.ElevationAngle = 0.5
+	r.ReflectivityData = newMoment(2125, 1000, []byte{106, 146})
+	r.VelocityData = newMoment(2125, 250, []byte{70, 62, 64, 80, 40, 2, 255, 100})
+	r.VelocityData.Offset = 64
+	ar2.ElevationScans[1] = []*archive2.Message31{r}
+	// velocity at one m/s reaching beyond the range of the first sweep
+	r = &archive2.Message31{}
+	r.Header.ElevationAngle = 1.5
+	r.VelocityData = newMoment(2125, 250, []byte{160, 30})
+	r.VelocityData.Scale = 1
+	r.VelocityData.Offset = 64
+	ar2.ElevationScans[2] = []*archive2.Message31{r}
+
+	f, err := NewFile(ar2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := variable(f, "range").Data.([]float32); len(got) != 8 || got[1] != 2375 {
+		t.Fatalf("range = %v", got)
+	}
+
+	unpack := func(name string, packed int16) float64 {
+		v := variable(f, name)
+		return float64(packed)*float64(attribute(v, "scale_factor").(float32)) + float64(attribute(v, "add_offset").(float32))
+	}
+	vel := variable(f, "VEL").Data.([]int16)
+	for g, raw := range ar2.ElevationScans[1][0].VelocityData.Data {
+		if want := (float64(raw) - 64) / 2; math.Abs(unpack("VEL", vel[g])-want) > 1e-3 {
+			t.Errorf("VEL[%d] = %v, want %v", g, unpack("VEL", vel[g]), want)
+		}
+	}
+	if got := unpack("VEL", vel[8]); math.Abs(got-96) > 1e-3 {
+		t.Errorf("VEL of the second sweep = %v, want 96", got)
+	}
+
+	dbz := variable(f, "DBZ").Data.([]int16)
+	if got := unpack("DBZ", dbz[0]); math.Abs(got-20) > 1e-3 {
+		t.Errorf("DBZ[0] = %v, want 20", got)
+	}
+	if got := unpack("DBZ", dbz[4]); math.Abs(got-40) > 1e-3 {
+		t.Errorf("DBZ[4] = %v, want 40", got)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/geojson"
+	"github.com/bwiggs/go-nexrad/products"
+)
+
+// writeIsobands writes the isobands of a grid of product values as GeoJSON
+// MultiPolygons, one feature per band.
+func writeIsobands(f *os.File, grid *products.Grid, t time.Time) error {
+	levels := legendValues[product]
+	if thresholdsFlag != "" {
+		var err error
+		if levels, err = parseThresholds(thresholdsFlag); err != nil {
+			return err
+		}
+	}
+	if len(levels) == 0 {
+		return fmt.Errorf("no isoband thresholds for %s, use --thresholds", product)
+	}
+
+	fc := geojson.NewFeatureCollection()
+	fc.Metadata = map[string]interface{}{
+		"product": product,
+		"time":    t.UTC().Format(time.RFC3339),
+	}
+	for _, b := range products.Isobands(grid, levels, minArea*1e6) {
+		if len(b.Polygons) == 0 {
+			continue
+		}
+		props := map[string]interface{}{
+			"product": product,
+			"time":    t.UTC().Format(time.RFC3339),
+			"min":     b.Min,
+		}
+		if !math.IsInf(float64(b.Max), 1) {
+			props["max"] = b.Max
+		}
+		if unit, ok := legendUnits[product]; ok {
+			props["unit"] = unit
+		}
+		fc.Add(geojson.MultiPolygon(b.Polygons), props)
+	}
+	return fc.Write(f)
+}
+
+// parseThresholds parses a comma separated list of values.
+func parseThresholds(s string) ([]float32, error) {
+	levels := []float32{}
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold %q", part)
+		}
+		levels = append(levels, float32(v))
+	}
+	return levels, nil
+}
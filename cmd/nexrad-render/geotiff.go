@@ -0,0 +1,173 @@
+package main
+
+import (
+	"image"
+	"math"
+	"os"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/geotiff"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/sirupsen/logrus"
+)
+
+// renderExtent is the distance in meters from the radar to the edges of
+// rendered images.
+const renderExtent = 460000
+
+// radarLocation returns the location of the radar of a volume.
+func radarLocation(ar2 *archive2.Archive2) (lat, lon float64) {
+	for _, e := range ar2.Elevations() {
+		if len(ar2.ElevationScans[e]) > 0 {
+			return float64(ar2.ElevationScans[e][0].VolumeData.Lat), float64(ar2.ElevationScans[e][0].VolumeData.Long)
+		}
+	}
+	return 0, 0
+}
+
+// save writes an image of the volume at time t rendered centered on the radar
+// at lat, lon to out in the selected format. With --values the grid returned
+// by values is written instead of the colors, geojson isobands are traced
+// over it.
+func save(out string, canvas *image.RGBA, t time.Time, lat, lon float64, values func() *products.Grid) {
+	var err error
+	switch {
+	case format == "png":
+		err = draw2dimg.SaveToPngFile(out, canvas)
+	case format == "kmz" && kmzWriter != nil:
+		err = kmzWriter.Add(groundOverlay(canvas, lat, lon, t))
+	default:
+		var f *os.File
+		if f, err = os.Create(out); err != nil {
+			break
+		}
+		switch {
+		case format == "kmz":
+			err = writeKMZ(f, canvas, lat, lon, t)
+		case format == "geojson":
+			err = writeIsobands(f, values(), t)
+		case geotiffValues:
+			err = writeGridGeoTIFF(f, values())
+		default:
+			err = writeImageGeoTIFF(f, canvas, lat, lon)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// writeImageGeoTIFF writes an image rendered centered on the radar as an RGBA
+// GeoTIFF in the selected CRS.
+func writeImageGeoTIFF(f *os.File, canvas *image.RGBA, lat, lon float64) error {
+	size := canvas.Bounds().Dx()
+	mPerPx := 2 * renderExtent / float64(size)
+	if crs == "aeqd" {
+		return geotiff.WriteRGBA(f, canvas, geotiff.GeoReference{
+			CRS:         geotiff.AzimuthalEquidistant,
+			CenterLat:   lat,
+			CenterLon:   lon,
+			X:           -renderExtent,
+			Y:           renderExtent,
+			PixelWidth:  mPerPx,
+			PixelHeight: mPerPx,
+		})
+	}
+
+	img, ref := toGeographicImage(canvas, lat, lon)
+	return geotiff.WriteRGBA(f, img, ref)
+}
+
+// toGeographicImage resamples an image rendered centered on the radar at lat,
+// lon onto a latitude longitude image of the same size.
+func toGeographicImage(canvas *image.RGBA, lat, lon float64) (*image.RGBA, geotiff.GeoReference) {
+	size := canvas.Bounds().Dx()
+	mPerPx := 2 * renderExtent / float64(size)
+	img := image.NewRGBA(canvas.Bounds())
+	ref := toGeographic(size, lat, lon, renderExtent, func(x, y int, east, north float64) {
+		px := int(math.Floor(east/mPerPx + float64(size)/2))
+		py := int(math.Floor(float64(size)/2 - north/mPerPx))
+		if px >= 0 && py >= 0 && px < size && py < size {
+			img.Set(x, y, canvas.At(px, py))
+		}
+	})
+	return img, ref
+}
+
+// writeGridGeoTIFF writes the values of a grid as a float32 GeoTIFF in the
+// selected CRS.
+func writeGridGeoTIFF(f *os.File, grid *products.Grid) error {
+	const nodata = archive2.MomentDataBelowThreshold
+	extent := grid.Extent()
+	if crs == "aeqd" {
+		return geotiff.WriteFloat32(f, grid.Size, grid.Size, grid.Data, nodata, geotiff.GeoReference{
+			CRS:         geotiff.AzimuthalEquidistant,
+			CenterLat:   grid.Lat,
+			CenterLon:   grid.Lon,
+			X:           -extent,
+			Y:           extent,
+			PixelWidth:  grid.Spacing,
+			PixelHeight: grid.Spacing,
+		})
+	}
+
+	data := make([]float32, grid.Size*grid.Size)
+	for i := range data {
+		data[i] = nodata
+	}
+	ref := toGeographic(grid.Size, grid.Lat, grid.Lon, extent, func(x, y int, east, north float64) {
+		if gx, gy, ok := grid.Cell(east, north); ok {
+			data[y*grid.Size+x] = grid.At(gx, gy)
+		}
+	})
+	return geotiff.WriteFloat32(f, grid.Size, grid.Size, data, nodata, ref)
+}
+
+// latLonBounds returns the bounds in degrees of the square reaching extent
+// meters east, west, north and south of lat, lon in the azimuthal equidistant
+// projection of rendered images.
+func latLonBounds(lat, lon, extent float64) (west, south, east, north float64) {
+	west, south, east, north = lon, lat, lon, lat
+	const steps = 100
+	for i := 0; i <= steps; i++ {
+		t := -extent + 2*extent*float64(i)/steps
+		for _, p := range [][2]float64{{t, extent}, {t, -extent}, {extent, t}, {-extent, t}} {
+			az := math.Atan2(p[0], p[1]) * 180 / math.Pi
+			plat, plon := archive2.Destination(lat, lon, az, math.Hypot(p[0], p[1]))
+			west, east = math.Min(west, plon), math.Max(east, plon)
+			south, north = math.Min(south, plat), math.Max(north, plat)
+		}
+	}
+	return west, south, east, north
+}
+
+// toGeographic resamples a square azimuthal equidistant image centered on
+// lat, lon onto a size x size latitude longitude image covering the same area.
+// set is called for every pixel x, y of the new image with the location of
+// its center in meters east and north of the radar. The georeference of the
+// new image is returned.
+func toGeographic(size int, lat, lon, extent float64, set func(x, y int, east, north float64)) geotiff.GeoReference {
+	west, south, east, north := latLonBounds(lat, lon, extent)
+	dLon := (east - west) / float64(size)
+	dLat := (north - south) / float64(size)
+	for y := 0; y < size; y++ {
+		plat := north - (float64(y)+0.5)*dLat
+		for x := 0; x < size; x++ {
+			plon := west + (float64(x)+0.5)*dLon
+			az, d := archive2.BearingDistance(lat, lon, plat, plon)
+			set(x, y, d*math.Sin(az*math.Pi/180), d*math.Cos(az*math.Pi/180))
+		}
+	}
+	return geotiff.GeoReference{
+		CRS:         geotiff.Geographic,
+		X:           west,
+		Y:           north,
+		PixelWidth:  dLon,
+		PixelHeight: dLat,
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/colorscheme"
+	"github.com/bwiggs/go-nexrad/kml"
+	"github.com/bwiggs/go-nexrad/products"
+)
+
+// kmzWriter collects the overlays of every file of a directory into a single
+// animated kmz.
+var kmzWriter *kml.KMZWriter
+
+// legendValues are the values shown in the legend of each product, in the
+// units of the product.
+var legendValues = map[string][]float32{
+	"ref":     {5, 10, 15, 20, 25, 30, 35, 40, 45, 50, 55, 60, 65, 70, 75},
+	"vel":     {-50, -40, -30, -20, -10, -5, 5, 10, 20, 30, 40, 50},
+	"srm":     {-50, -40, -30, -20, -10, -5, 5, 10, 20, 30, 40, 50},
+	"sw":      {2, 4, 6, 8, 10, 15, 20, 25, 30},
+	"rho":     {0.3, 0.5, 0.7, 0.8, 0.9, 0.93, 0.95, 0.97, 0.98, 0.99, 1},
+	"zdr":     {-4, -2, -1, 0, 0.5, 1, 1.5, 2, 3, 4, 6, 8},
+	"kdp":     {-1, 0, 0.25, 0.5, 1, 1.5, 2, 3, 4, 5},
+	"rr":      {2.5, 6.35, 12.7, 19.05, 25.4, 38.1, 50.8, 76.2, 101.6, 152.4, 254},
+	"qpe":     {2.5, 6.35, 12.7, 19.05, 25.4, 38.1, 50.8, 76.2, 101.6, 152.4, 254},
+	"azshear": {-0.01, -0.006, -0.004, 0.004, 0.006, 0.008, 0.01, 0.015, 0.02},
+	"mesh":    {6.35, 12.7, 19.05, 25.4, 31.75, 38.1, 50.8, 63.5, 76.2, 101.6},
+	"cfp":     {5, 10, 15, 20, 25, 30, 35, 40, 45, 50, 55, 60, 65, 70, 75},
+}
+
+// legendUnits are the units of each product shown in the legend.
+var legendUnits = map[string]string{
+	"ref":     "dBZ",
+	"vel":     "m/s",
+	"srm":     "m/s",
+	"sw":      "m/s",
+	"zdr":     "dB",
+	"kdp":     "deg/km",
+	"rr":      "mm/h",
+	"qpe":     "mm",
+	"azshear": "1/s",
+	"mesh":    "mm",
+	"cfp":     "dB",
+}
+
+// legendImage returns the legend of the selected product and color scheme, or
+// nil when the product has no legend.
+func legendImage() image.Image {
+	entries := []legendEntry{}
+	if product == "hca" {
+		for _, c := range products.HydrometeorClasses {
+			entries = append(entries, legendEntry{c.String(), colorscheme.HCAColors[c]})
+		}
+	} else {
+		for _, v := range legendValues[product] {
+			label := strconv.FormatFloat(float64(v), 'g', -1, 32)
+			if unit := legendUnits[product]; unit != "" {
+				label += " " + unit
+			}
+			entries = append(entries, legendEntry{label, colorSchemes[product][colorScheme](v)})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 140, 20*len(entries)+10))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.NRGBA{0, 0, 0, 0xc0}), image.ZP, draw.Src)
+	addLegend(img, 8, 6, entries)
+	return img
+}
+
+// groundOverlay resamples an image rendered centered on the radar at lat, lon
+// onto the latitude longitude box Google Earth drapes it over.
+func groundOverlay(canvas *image.RGBA, lat, lon float64, t time.Time) kml.GroundOverlay {
+	img, _ := toGeographicImage(canvas, lat, lon)
+	west, south, east, north := latLonBounds(lat, lon, renderExtent)
+	return kml.GroundOverlay{
+		Name:  t.UTC().Format(time.RFC3339),
+		Image: img,
+		North: north,
+		South: south,
+		East:  east,
+		West:  west,
+		Begin: t,
+	}
+}
+
+// writeKMZ writes a kmz of a single image to f.
+func writeKMZ(f *os.File, canvas *image.RGBA, lat, lon float64, t time.Time) error {
+	o := groundOverlay(canvas, lat, lon, t)
+	k, err := kml.NewKMZWriter(f, fmt.Sprintf("%s %s", strings.ToUpper(product), o.Name), legendImage())
+	if err != nil {
+		return err
+	}
+	if err := k.Add(o); err != nil {
+		return err
+	}
+	return k.Close()
+}
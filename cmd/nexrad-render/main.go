@@ -8,7 +8,10 @@ import (
 	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +23,9 @@ import (
 	"golang.org/x/image/math/fixed"
 
 	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/colorscheme"
+	"github.com/bwiggs/go-nexrad/kml"
+	"github.com/bwiggs/go-nexrad/products"
 	"github.com/cheggaaa/pb/v3"
 	"github.com/llgcode/draw2d/draw2dimg"
 	"github.com/sirupsen/logrus"
@@ -31,7 +37,7 @@ var cmd = &cobra.Command{
 	Use:   "nexrad-render [flags] file",
 	Short: "nexrad-render generates products from NEXRAD Level 2 (archive 2) data files.",
 	Run:   run,
-	Args:  cobra.MinimumNArgs(1),
+	Args:  cobra.MaximumNArgs(1),
 }
 
 var inputFile string
@@ -44,14 +50,65 @@ var product string
 var imageSize int32
 var elevation int
 var runners int
-var products []string
+var productNames []string
+var stormMotionFlag string
+var stormMotion *products.StormMotion
+var kdpOptions = products.DefaultKDPOptions()
+var meltingLayerFlag string
+var hcaOptions = products.DefaultHCAOptions()
+var qpeOptions = products.DefaultQPEOptions()
+var zrName string
+var rainMethod string
+var accumulation string
+var qcFilter bool
+var qcOptions = products.DefaultQCOptions()
+var shearOptions = products.DefaultShearOptions()
+var hdaOptions = products.DefaultHDAOptions()
+var overlayCells bool
+var qvpOptions = products.DefaultQVPOptions()
+var crossSectionFlag string
+var crossSection []float64
+var cellTracker = products.NewTracker(products.DefaultSCITOptions())
+var overlayBoundaries bool
+var boundaryOptions = products.DefaultBoundaryOptions()
+var previousBoundaries []products.Boundary
+var format string
+var geotiffValues bool
+var crs string
+var thresholdsFlag string
+var minArea float64
+var zoomFlag string
+
+// background fills images before rendering, transparent for geotiff output
+var background image.Image = image.Black
+
+// productMoments maps products to the data moment their gates are aligned with
+var productMoments = map[string]string{
+	"ref": "REF",
+	"vel": "VEL",
+	"srm": "VEL",
+	"sw":  "SW",
+	"phi": "PHI",
+	"rho": "RHO",
+	"zdr": "ZDR",
+	"cfp": "CFP",
+	"kdp": "PHI",
+	"hca": "REF",
+	"rr":  "REF",
+
+	"azshear": "VEL",
+}
+
+// knotsToMps converts knots to meters per second
+const knotsToMps = 0.514444
 
-var colorSchemes map[string]map[string]func(float32) color.Color
+// colorSchemes maps products and color scheme names to their color functions
+var colorSchemes = colorscheme.Schemes
 
 func init() {
 	// cmd.PersistentFlags().StringVarP(&inputFile, "file", "f", "", "archive 2 file to process")
 	cmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "radar.png", "output file")
-	cmd.PersistentFlags().StringVarP(&product, "product", "p", "ref", "product to produce. ex: ref, vel, sw, rho")
+	cmd.PersistentFlags().StringVarP(&product, "product", "p", "ref", "product to produce. ex: ref, vel, srm, sw, rho, kdp, hca, rr, qpe, azshear, mesh, qvp")
 	cmd.PersistentFlags().StringVarP(&colorScheme, "color-scheme", "c", "noaa", "color scheme to use. noaa, scope, pink")
 	cmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "warn", "log level, debug, info, warn, error")
 	cmd.PersistentFlags().Int32VarP(&imageSize, "size", "s", 1024, "size in pixel of the output image")
@@ -59,37 +116,28 @@ func init() {
 	cmd.PersistentFlags().IntVarP(&elevation, "elevation", "e", 1, "1-15")
 	cmd.PersistentFlags().StringVarP(&directory, "directory", "d", "", "directory of L2 files to process")
 	cmd.PersistentFlags().BoolVarP(&renderLabel, "label", "L", false, "label the image with station and date")
-
-	products = []string{"ref", "vel", "sw", "rho"}
-
-	colorSchemes = make(map[string]map[string]func(float32) color.Color)
-	colorSchemes["ref"] = map[string]func(float32) color.Color{
-		"noaa":          dbzColorNOAA,
-		"scope":         dbzColorScope,
-		"scope-classic": dbzColorScopeClassic,
-		"pink":          dbzPink,
-		"clean-air":     dbzColorCleanAirMode,
-	}
-	colorSchemes["vel"] = map[string]func(float32) color.Color{
-		"noaa":  velColorScope, // placeholder for default product value
-		"scope": velColorScope,
-	}
-	colorSchemes["sw"] = map[string]func(float32) color.Color{
-		"noaa": swColor,
-	}
-	colorSchemes["phi"] = map[string]func(float32) color.Color{
-		"noaa": ccColor,
-	}
-	colorSchemes["rho"] = map[string]func(float32) color.Color{
-		"noaa": ccColor,
-	}
-	colorSchemes["zdr"] = map[string]func(float32) color.Color{
-		"noaa":  zdrColorScope,
-		"scope": zdrColorScope,
-	}
-	colorSchemes["cfp"] = map[string]func(float32) color.Color{
-		"noaa": dbzColorNOAA,
-	}
+	cmd.PersistentFlags().StringVar(&stormMotionFlag, "storm-motion", "", "storm motion for srm as DIR/SPEED, direction the storm moves from in degrees and speed in knots. ex: 240/35. estimated from the sweep when empty")
+
+	cmd.PersistentFlags().StringVar(&meltingLayerFlag, "melting-layer", "", "melting layer for hca as BOTTOM/TOP in km above sea level. ex: 2.8/3.4. detected from the volume when empty")
+	cmd.PersistentFlags().StringVar(&zrName, "zr", "convective", "Z-R relationship for rr and qpe. marshall-palmer, convective, tropical, cool-stratiform, warm-stratiform")
+	cmd.PersistentFlags().StringVar(&rainMethod, "rain-method", "z", "rain rate estimator for rr and qpe. z, kdp, zzdr")
+	cmd.PersistentFlags().StringVar(&accumulation, "accumulation", "total", "qpe accumulation period. 1h, 3h, total")
+	cmd.PersistentFlags().BoolVar(&qcFilter, "qc", false, "remove non meteorological echoes such as clutter, birds and chaff")
+	cmd.PersistentFlags().Float64Var(&hdaOptions.FreezingLevel, "freezing-level", hdaOptions.FreezingLevel/1000, "height of the 0C level for mesh in km above sea level")
+	cmd.PersistentFlags().Float64Var(&hdaOptions.Minus20Level, "minus20-level", hdaOptions.Minus20Level/1000, "height of the -20C level for mesh in km above sea level")
+	cmd.PersistentFlags().Float64Var(&qvpOptions.Elevation, "qvp-elevation", qvpOptions.Elevation, "elevation angle in degrees of the sweep used for qvp")
+	cmd.PersistentFlags().Float64Var(&qvpOptions.MaxHeight, "qvp-top", qvpOptions.MaxHeight/1000, "top of the qvp display in km above sea level")
+	cmd.PersistentFlags().StringVar(&crossSectionFlag, "cross-section", "", "draw a vertical cross section of ref, vel, sw, rho, zdr or phi between two points as LAT1,LON1,LAT2,LON2. ex: 35.2,-97.8,35.4,-97.2")
+	cmd.PersistentFlags().BoolVar(&overlayCells, "cells", false, "overlay storm cells with their track forecast. tracks are only available when processing a directory")
+	cmd.PersistentFlags().StringVar(&format, "format", "png", "output format. png, geotiff, kmz, geojson, tiles (a directory of z/x/y.png web mercator tiles), mbtiles. a directory is rendered to a single animated kmz")
+	cmd.PersistentFlags().StringVar(&zoomFlag, "zoom", "4-10", "zoom levels of tiles and mbtiles as MIN-MAX")
+	cmd.PersistentFlags().StringVar(&thresholdsFlag, "thresholds", "", "comma separated isoband thresholds for geojson. ex: 20,30,40,50,60. defaults to the legend values of the product")
+	cmd.PersistentFlags().Float64Var(&minArea, "min-area", 4, "smallest isoband ring kept in geojson in km²")
+	cmd.PersistentFlags().BoolVar(&geotiffValues, "values", false, "write the product values as float32 instead of colors, geotiff only")
+	cmd.PersistentFlags().StringVar(&crs, "crs", "aeqd", "coordinate reference system of geotiff output. aeqd (azimuthal equidistant centered on the radar), epsg:4326")
+	cmd.PersistentFlags().BoolVar(&overlayBoundaries, "boundaries", false, "overlay gust fronts and convergence boundaries. propagation is only available when processing a directory")
+
+	productNames = []string{"ref", "vel", "srm", "sw", "rho", "kdp", "hca", "rr", "qpe", "azshear", "mesh", "qvp"}
 }
 
 func main() {
@@ -102,7 +150,9 @@ func main() {
 
 func run(cmd *cobra.Command, args []string) {
 
-	inputFile = args[0]
+	if len(args) > 0 {
+		inputFile = args[0]
+	}
 
 	if _, ok := colorSchemes[product][colorScheme]; !ok {
 		logrus.Fatal(fmt.Sprintf("unsupported %s colorscheme %s", product, colorScheme))
@@ -114,15 +164,123 @@ func run(cmd *cobra.Command, args []string) {
 	}
 	logrus.SetLevel(lvl)
 
-	if inputFile != "" {
-		out := "radar.png"
-		if outputFile != "" {
+	if stormMotionFlag != "" {
+		sm, err := parseStormMotion(stormMotionFlag)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		stormMotion = &sm
+	}
+
+	if meltingLayerFlag != "" {
+		ml, err := parseMeltingLayer(meltingLayerFlag)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		hcaOptions.MeltingLayer = ml
+	}
+
+	if crossSectionFlag != "" {
+		crossSection, err = parseCrossSection(crossSectionFlag)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		// only base moments can be interpolated between sweeps
+		if m, ok := productMoments[product]; !ok || strings.ToLower(m) != product {
+			logrus.Fatalf("unsupported cross section product %s", product)
+		}
+	}
+
+	switch format {
+	case "png":
+	case "geotiff":
+		if product == "qvp" || crossSection != nil {
+			logrus.Fatal("geotiff is only supported for products drawn on a map")
+		}
+		if crs != "aeqd" && crs != "epsg:4326" {
+			logrus.Fatalf("unsupported crs %s", crs)
+		}
+		background = image.Transparent
+	case "kmz":
+		if product == "qvp" || crossSection != nil {
+			logrus.Fatal("kmz is only supported for products drawn on a map")
+		}
+		background = image.Transparent
+	case "geojson":
+		if product == "qvp" || product == "hca" || crossSection != nil {
+			logrus.Fatal("geojson is only supported for continuous products drawn on a map")
+		}
+		if thresholdsFlag != "" {
+			if _, err := parseThresholds(thresholdsFlag); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+	case "tiles", "mbtiles":
+		if product == "qvp" || crossSection != nil {
+			logrus.Fatal("tiles are only supported for products drawn on a map")
+		}
+		if minZoom, maxZoom, err = parseZoom(zoomFlag); err != nil {
+			logrus.Fatal(err)
+		}
+	default:
+		logrus.Fatalf("unsupported format %s", format)
+	}
+
+	// heights are given in km on the command line
+	hdaOptions.FreezingLevel *= 1000
+	hdaOptions.Minus20Level *= 1000
+	qvpOptions.MaxHeight *= 1000
+
+	zr, ok := products.ZRRelationships[zrName]
+	if !ok {
+		logrus.Fatalf("unsupported Z-R relationship %s", zrName)
+	}
+	qpeOptions.ZR = zr
+	switch rainMethod {
+	case "z":
+		qpeOptions.Method = products.RainRateZ
+	case "kdp":
+		qpeOptions.Method = products.RainRateKDP
+	case "zzdr":
+		qpeOptions.Method = products.RainRateZZDR
+	default:
+		logrus.Fatalf("unsupported rain rate method %s", rainMethod)
+	}
+
+	if product == "qpe" {
+		dir := directory
+		if dir == "" {
+			dir = inputFile
+		}
+		if dir == "" {
+			logrus.Fatal("qpe requires a directory of L2 files")
+		}
+		out := "qpe" + extension()
+		if cmd.Flags().Changed("output") {
+			out = outputFile
+		}
+		accumulate(dir, out)
+	} else if product == "qvp" {
+		if directory == "" {
+			logrus.Fatal("qvp requires a directory of L2 files")
+		}
+		out := "qvp.png"
+		if cmd.Flags().Changed("output") {
+			out = outputFile
+		}
+		profiles(directory, out)
+	} else if inputFile != "" {
+		out := "radar" + extension()
+		if cmd.Flags().Changed("output") {
 			out = outputFile
 		}
 		single(inputFile, out, product)
 	} else if directory != "" {
 		out := "out"
-		if outputFile != "" {
+		if format == "kmz" {
+			out = "radar.kmz"
+		}
+		if cmd.Flags().Changed("output") {
 			out = outputFile
 		}
 		animate(directory, out, product)
@@ -135,20 +293,36 @@ func animate(dir, outdir, prod string) {
 		logrus.Fatal(err)
 	}
 
-	// create the output dir
-	if _, err := os.Stat(outdir); os.IsNotExist(err) {
+	if format == "kmz" {
+		// every file is added to a single kmz instead of the output dir
+		f, err := os.Create(outdir)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer f.Close()
+		kmzWriter, err = kml.NewKMZWriter(f, fmt.Sprintf("%s %s", filepath.Base(dir), strings.ToUpper(prod)), legendImage())
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	} else if _, err := os.Stat(outdir); os.IsNotExist(err) {
+		// create the output dir
 		os.Mkdir(outdir, os.ModePerm)
 	}
 
 	bar := pb.StartNew(len(files))
 
+	// cells and boundaries are tracked in time order, one file at a time
+	if overlayCells || overlayBoundaries {
+		runners = 1
+	}
+
 	source := make(chan string, runners)
 	wg := sync.WaitGroup{}
 	wg.Add(runners)
 	for i := 0; i < runners; i++ {
 		go func(i int) {
 			for l2f := range source {
-				outf := fmt.Sprintf("%s/%s.png", outdir, l2f)
+				outf := fmt.Sprintf("%s/%s%s", outdir, l2f, extension())
 				// fmt.Printf("Generating %s from %s -> %s\n", prod, l2f, outf)
 				f, err := os.Open(dir + "/" + l2f)
 				if err != nil {
@@ -157,7 +331,7 @@ func animate(dir, outdir, prod string) {
 				}
 				ar2 := archive2.Extract(f)
 				f.Close()
-				render(outf, ar2.ElevationScans[elevation], fmt.Sprintf("%s - %s", ar2.VolumeHeader.ICAO, ar2.VolumeHeader.Date()))
+				renderProduct(outf, ar2, fmt.Sprintf("%s - %s", ar2.VolumeHeader.ICAO, ar2.VolumeHeader.Date()))
 				bar.Increment()
 			}
 			wg.Done()
@@ -174,6 +348,118 @@ func animate(dir, outdir, prod string) {
 	close(source)
 	wg.Wait()
 	bar.Finish()
+
+	if kmzWriter != nil {
+		if err := kmzWriter.Close(); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+}
+
+// accumulate renders the rainfall accumulated over every file in a directory.
+func accumulate(dir, out string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	acc := products.NewAccumulator(qpeOptions)
+	var station string
+
+	bar := pb.StartNew(len(files))
+	for _, fn := range files {
+		bar.Increment()
+		if fn.IsDir() || !strings.HasSuffix(fn.Name(), ".ar2v") {
+			continue
+		}
+		f, err := os.Open(dir + "/" + fn.Name())
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		ar2, err := archive2.ExtractErr(f)
+		f.Close()
+		if err != nil {
+			logrus.Errorf("%s: %s", fn.Name(), err)
+			continue
+		}
+		if err := acc.Add(ar2); err != nil {
+			logrus.Errorf("%s: %s", fn.Name(), err)
+			continue
+		}
+		station = string(ar2.VolumeHeader.ICAO[:])
+	}
+	bar.Finish()
+
+	start, end := acc.Start(), acc.End()
+	switch accumulation {
+	case "1h":
+		start = end.Add(-time.Hour)
+	case "3h":
+		start = end.Add(-3 * time.Hour)
+	case "total":
+	default:
+		logrus.Fatalf("unsupported accumulation %s", accumulation)
+	}
+	grid := acc.Accumulation(start, end)
+	if grid == nil {
+		logrus.Fatalf("no volumes found in %s", dir)
+	}
+	if missing := acc.Missing(start, end); missing > 0 {
+		logrus.Warnf("no data for %s of the accumulation period", missing)
+	}
+
+	if tileFormat() {
+		name := fmt.Sprintf("%s QPE %s", strings.TrimSpace(station), strings.ToUpper(accumulation))
+		if err := writeTiles(out, name, acc.End(), grid.Lat, grid.Lon, gridSampler(grid)); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	label := fmt.Sprintf("%s QPE %s %s - %s", station, strings.ToUpper(accumulation), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	save(out, renderGrid(grid, colorSchemes[product][colorScheme], label), acc.End(), grid.Lat, grid.Lon, func() *products.Grid { return grid })
+}
+
+// profiles renders the quasi vertical profiles of every file in a directory
+// as a time height display of REF, ZDR, RHO and KDP.
+func profiles(dir, out string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	qvps := []products.QuasiVerticalProfile{}
+	bar := pb.StartNew(len(files))
+	for _, fn := range files {
+		bar.Increment()
+		if fn.IsDir() || !strings.HasSuffix(fn.Name(), ".ar2v") {
+			continue
+		}
+		f, err := os.Open(dir + "/" + fn.Name())
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		ar2, err := archive2.ExtractErr(f)
+		f.Close()
+		if err != nil {
+			logrus.Errorf("%s: %s", fn.Name(), err)
+			continue
+		}
+		if qvp, ok := products.QVP(ar2, qvpOptions); ok {
+			qvps = append(qvps, qvp)
+		}
+	}
+	bar.Finish()
+
+	if len(qvps) == 0 {
+		logrus.Fatalf("no dual polarization volumes found in %s", dir)
+	}
+	sort.Slice(qvps, func(i, j int) bool { return qvps[i].Time.Before(qvps[j].Time) })
+
+	label := fmt.Sprintf("%s QVP %.1f %s - %s", qvps[0].Station, qvps[0].ElevationAngle, qvps[0].Time.Format(time.RFC3339), qvps[len(qvps)-1].Time.Format(time.RFC3339))
+	draw2dimg.SaveToPngFile(out, renderQVP(qvps, label))
 }
 
 func single(in, out, product string) {
@@ -189,17 +475,96 @@ func single(in, out, product string) {
 	logrus.Debug(ar2)
 
 	label := fmt.Sprintf("%s %f %s VCP:%d %s %s", ar2.VolumeHeader.ICAO, ar2.ElevationScans[2][0].Header.ElevationAngle, strings.ToUpper(product), ar2.RadarStatus.VolumeCoveragePatternNum, ar2.VolumeHeader.FileName(), ar2.VolumeHeader.Date().Format(time.RFC3339))
-	logrus.Info("Generating %s from %s -> %s\n", strings.ToUpper(product), in, out)
-	render(out, ar2.ElevationScans[elevation], label)
+	logrus.Infof("Generating %s from %s -> %s\n", strings.ToUpper(product), in, out)
+	renderProduct(out, ar2, label)
 }
 
-func render(out string, radials []*archive2.Message31, label string) {
+// renderProduct renders the selected product of a volume. Products computed
+// over the whole volume are drawn as grids, others as the selected sweep.
+func renderProduct(out string, ar2 *archive2.Archive2, label string) {
+	if tileFormat() {
+		lat, lon := radarLocation(ar2)
+		name := fmt.Sprintf("%s %s", strings.TrimSpace(string(ar2.VolumeHeader.ICAO[:])), strings.ToUpper(product))
+		if err := writeTiles(out, name, ar2.VolumeHeader.Date(), lat, lon, productSampler(ar2)); err != nil {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	var canvas *image.RGBA
+	var values func() *products.Grid
+	switch {
+	case crossSection != nil:
+		cs := products.NewCrossSection(ar2, productMoments[product], crossSection[0], crossSection[1], crossSection[2], crossSection[3], products.DefaultCrossSectionOptions())
+		canvas = renderCrossSection(cs, colorSchemes[product][colorScheme], label)
+	case product == "mesh":
+		mesh := products.DetectHail(ar2, hdaOptions).MESH
+		canvas = renderGrid(mesh, colorSchemes[product][colorScheme], label)
+		values = func() *products.Grid { return mesh }
+	default:
+		radials := ar2.ElevationScans[elevation]
+		hca := volumeHCAOptions(ar2)
+		canvas = render(radials, label, hca)
+		values = func() *products.Grid {
+			return products.SweepGrid(radials, sweepGates(radials, hca), productMoments[product], renderExtent, 2*renderExtent/float64(imageSize))
+		}
+	}
+
+	if overlayCells {
+		drawCells(canvas, ar2, cellTracker.Add(ar2))
+	}
+
+	if overlayBoundaries {
+		boundaries := products.DetectBoundaries(ar2, boundaryOptions)
+		products.PropagateBoundaries(previousBoundaries, boundaries, boundaryOptions)
+		previousBoundaries = boundaries
+		drawBoundaries(canvas, ar2, boundaries)
+	}
+
+	// Save to file
+	lat, lon := radarLocation(ar2)
+	save(out, canvas, ar2.VolumeHeader.Date(), lat, lon, values)
+}
+
+// extension returns the file extension of the selected output format.
+func extension() string {
+	switch format {
+	case "geotiff":
+		return ".tif"
+	case "kmz":
+		return ".kmz"
+	case "geojson":
+		return ".geojson"
+	case "tiles":
+		// a directory of tiles
+		return ""
+	case "mbtiles":
+		return ".mbtiles"
+	}
+	return ".png"
+}
+
+// volumeHCAOptions returns the hca options for a volume, detecting the melting
+// layer from the volume when none was given on the command line.
+func volumeHCAOptions(ar2 *archive2.Archive2) products.HCAOptions {
+	opts := hcaOptions
+	if product != "hca" || meltingLayerFlag != "" {
+		return opts
+	}
+	if d := products.DetectMeltingLayer(ar2, products.DefaultMeltingLayerOptions()); d.Found {
+		logrus.Infof("detected melting layer %.1f/%.1fkm", d.Overall.Bottom/1000, d.Overall.Top/1000)
+		opts.MeltingLayerDetection = &d
+	}
+	return opts
+}
+
+func render(radials []*archive2.Message31, label string, hca products.HCAOptions) *image.RGBA {
 
 	width := float64(imageSize)
 	height := float64(imageSize)
 
 	canvas := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
-	draw.Draw(canvas, canvas.Bounds(), image.Black, image.ZP, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), background, image.ZP, draw.Src)
 
 	gc := draw2dimg.NewGraphicContext(canvas)
 
@@ -210,9 +575,9 @@ func render(out string, radials []*archive2.Message31, label string) {
 	gateIntervalKm := float64(radials[0].ReflectivityData.DataMomentRangeSampleInterval) / 1000
 	gateWidthPx := gateIntervalKm * pxPerKm
 
-	// valueDist := map[float32]int{}
+	sweep := sweepGates(radials, hca)
 
-	for _, radial := range radials {
+	for ri, radial := range radials {
 		// round to the nearest rounded azimuth for the given resolution.
 		// ex: for radial 20.5432, round to 20.5
 		azimuthAngle := float64(radial.Header.AzimuthAngle) - 90
@@ -232,23 +597,7 @@ func render(out string, radials []*archive2.Message31, label string) {
 		gc.SetLineWidth(gateWidthPx + 1)
 		gc.SetLineCap(draw2d.ButtCap)
 
-		var gates []float32
-		switch product {
-		case "vel":
-			gates = radial.VelocityData.ScaledData()
-		case "sw":
-			gates = radial.SwData.ScaledData()
-		case "phi":
-			gates = radial.PhiData.ScaledData()
-		case "rho":
-			gates = radial.RhoData.ScaledData()
-		case "zdr":
-			gates = radial.ZdrData.ScaledData()
-		case "cfp":
-			gates = radial.CfpData.ScaledData()
-		default:
-			gates = radial.ReflectivityData.ScaledData()
-		}
+		gates := sweep[ri]
 
 		numGates := len(gates)
 		for i, v := range gates {
@@ -283,8 +632,359 @@ func render(out string, radials []*archive2.Message31, label string) {
 		addLabel(canvas, int(width-495.0), int(height-10.0), label)
 	}
 
-	// Save to file
-	draw2dimg.SaveToPngFile(out, canvas)
+	// the legend would be georeferenced with the image
+	if product == "hca" && format == "png" {
+		legend := []legendEntry{}
+		for _, c := range products.HydrometeorClasses {
+			legend = append(legend, legendEntry{c.String(), colorscheme.HCAColors[c]})
+		}
+		addLegend(canvas, 10, 10, legend)
+	}
+
+	return canvas
+}
+
+// sweepGates returns the values of the selected product for every radial of a
+// sweep.
+func sweepGates(radials []*archive2.Message31, hca products.HCAOptions) [][]float32 {
+	var sm products.StormMotion
+	if product == "srm" {
+		if stormMotion != nil {
+			sm = *stormMotion
+		} else if est, ok := products.EstimateStormMotion(radials); ok {
+			sm = est
+			logrus.Infof("estimated storm motion %.0f/%.0fkt", sm.Direction, sm.Speed/knotsToMps)
+		} else {
+			logrus.Warn("unable to estimate storm motion, rendering ground relative velocity")
+		}
+	}
+
+	// products computed over the whole sweep rather than a single radial
+	var shear [][]float32
+	if product == "azshear" {
+		shear = products.AzimuthalShear(radials, shearOptions)
+	}
+
+	values := make([][]float32, len(radials))
+	for ri, radial := range radials {
+		var gates []float32
+		switch product {
+		case "vel":
+			gates = radial.VelocityData.ScaledData()
+		case "srm":
+			gates = products.StormRelativeVelocity(radial, sm)
+		case "sw":
+			gates = radial.SwData.ScaledData()
+		case "phi":
+			gates = radial.PhiData.ScaledData()
+		case "rho":
+			gates = radial.RhoData.ScaledData()
+		case "kdp":
+			gates = products.KDP(radial, kdpOptions)
+		case "hca":
+			gates = products.HCA(radial, hca)
+		case "rr":
+			gates = products.RainRate(radial, qpeOptions)
+		case "azshear":
+			gates = shear[ri]
+		case "zdr":
+			gates = radial.ZdrData.ScaledData()
+		case "cfp":
+			gates = radial.CfpData.ScaledData()
+		default:
+			gates = radial.ReflectivityData.ScaledData()
+		}
+
+		if qcFilter {
+			gates = products.QC(radial, qcOptions).Apply(radial.Moment(productMoments[product]), gates)
+		}
+		values[ri] = gates
+	}
+	return values
+}
+
+// renderGrid draws a grid of values centered on the radar using the same scale
+// as render.
+func renderGrid(grid *products.Grid, colorFn func(float32) color.Color, label string) *image.RGBA {
+	width := int(imageSize)
+	height := int(imageSize)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), background, image.ZP, draw.Src)
+
+	mPerPx := 460000 / (float64(width) / 2)
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			east := (float64(px) + 0.5 - float64(width)/2) * mPerPx
+			north := (float64(height)/2 - float64(py) - 0.5) * mPerPx
+			x, y, ok := grid.Cell(east, north)
+			if !ok {
+				continue
+			}
+			if v := grid.At(x, y); v != archive2.MomentDataBelowThreshold {
+				c := colorFn(v)
+				if _, _, _, a := c.RGBA(); a > 0 {
+					canvas.Set(px, py, c)
+				}
+			}
+		}
+	}
+
+	if renderLabel {
+		addLabel(canvas, width-495, height-10, label)
+	}
+
+	return canvas
+}
+
+// renderQVP draws the profiles as a time height display with a panel for each
+// of the averaged moments.
+func renderQVP(qvps []products.QuasiVerticalProfile, label string) *image.RGBA {
+	const margin = 60
+	width := int(imageSize)
+	height := int(imageSize)
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.Black, image.ZP, draw.Src)
+
+	colors := map[string]func(float32) color.Color{
+		"REF": colorSchemes["ref"][colorScheme],
+		"ZDR": colorSchemes["zdr"]["noaa"],
+		"RHO": colorSchemes["rho"]["noaa"],
+		"KDP": colorSchemes["kdp"]["noaa"],
+	}
+
+	panelHeight := (height - margin) / len(products.QVPMoments)
+	colWidth := float64(width-margin) / float64(len(qvps))
+	bottom := qvps[0].Levels[0].Height - qvpOptions.HeightStep/2
+	mPerPx := (qvpOptions.MaxHeight - bottom) / float64(panelHeight)
+
+	for p, name := range products.QVPMoments {
+		top := margin + p*panelHeight
+		for i, qvp := range qvps {
+			x0 := margin + int(float64(i)*colWidth)
+			x1 := margin + int(float64(i+1)*colWidth)
+			for py := 0; py < panelHeight; py++ {
+				h := bottom + (float64(panelHeight-py)-0.5)*mPerPx
+				k := int((h - qvp.Levels[0].Height) / qvpOptions.HeightStep)
+				if k < 0 || k >= len(qvp.Levels) {
+					continue
+				}
+				v := qvp.Levels[k].Value(name)
+				if v == archive2.MomentDataBelowThreshold {
+					continue
+				}
+				c := colors[name](v)
+				for x := x0; x < x1; x++ {
+					canvas.Set(x, top+py, c)
+				}
+			}
+		}
+		addLabel(canvas, 8, top+16, name)
+		addLabel(canvas, 8, top+panelHeight-4, fmt.Sprintf("%.0fkm", bottom/1000))
+		addLabel(canvas, 8, top+32, fmt.Sprintf("%.0fkm", qvpOptions.MaxHeight/1000))
+	}
+
+	addLabel(canvas, margin, 24, label)
+
+	return canvas
+}
+
+// renderCrossSection draws a vertical cross section with distance along the
+// horizontal axis and height along the vertical axis, outlining the beam of
+// every sweep.
+func renderCrossSection(cs *products.CrossSection, colorFn func(float32) color.Color, label string) *image.RGBA {
+	const margin = 60
+	width := int(imageSize)
+	height := int(imageSize) / 2
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.Black, image.ZP, draw.Src)
+
+	plotWidth := width - 2*margin
+	plotHeight := height - 2*margin
+	top := cs.Bottom + float64(cs.Rows)*cs.HeightStep
+	toPx := func(distance, h float64) (float64, float64) {
+		return margin + distance/cs.Length*float64(plotWidth), float64(margin+plotHeight) - (h-cs.Bottom)/(top-cs.Bottom)*float64(plotHeight)
+	}
+
+	if cs.Rows > 0 && cs.Length > 0 {
+		for py := 0; py < plotHeight; py++ {
+			y := py * cs.Rows / plotHeight
+			for px := 0; px < plotWidth; px++ {
+				x := int(math.Round(float64(px) / float64(plotWidth) * cs.Length / cs.Spacing))
+				if x >= cs.Columns {
+					continue
+				}
+				if v := cs.At(x, y); v != archive2.MomentDataBelowThreshold {
+					canvas.Set(margin+px, margin+py, colorFn(v))
+				}
+			}
+		}
+	}
+
+	gc := draw2dimg.NewGraphicContext(canvas)
+	gc.SetStrokeColor(colornames.Dimgray)
+	gc.SetLineWidth(1)
+	for _, beam := range cs.Beams {
+		for _, edge := range [][]float64{beam.Bottom, beam.Top} {
+			drawing := false
+			for x, h := range edge {
+				if math.IsNaN(h) || h > top {
+					drawing = false
+					continue
+				}
+				px, py := toPx(math.Min(float64(x)*cs.Spacing, cs.Length), h)
+				if drawing {
+					gc.LineTo(px, py)
+				} else {
+					gc.MoveTo(px, py)
+					drawing = true
+				}
+			}
+			gc.Stroke()
+		}
+	}
+
+	// axes every 10 km of distance and 2 km of height
+	for d := 0.0; d <= cs.Length; d += 10000 {
+		px, py := toPx(d, cs.Bottom)
+		addLabel(canvas, int(px)-8, int(py)+20, fmt.Sprintf("%.0f", d/1000))
+	}
+	for h := math.Ceil(cs.Bottom/2000) * 2000; h <= top; h += 2000 {
+		_, py := toPx(0, h)
+		addLabel(canvas, 8, int(py)+5, fmt.Sprintf("%2.0fkm", h/1000))
+	}
+	addLabel(canvas, margin, 24, fmt.Sprintf("%s %.3f,%.3f - %.3f,%.3f", label, cs.StartLat, cs.StartLon, cs.EndLat, cs.EndLon))
+
+	return canvas
+}
+
+// drawCells marks the storm cells of the volume with their track id and draws
+// their forecast track.
+func drawCells(canvas *image.RGBA, ar2 *archive2.Archive2, cells []products.StormCell) {
+	var lat, lon float64
+	for _, e := range ar2.Elevations() {
+		lat, lon = float64(ar2.ElevationScans[e][0].VolumeData.Lat), float64(ar2.ElevationScans[e][0].VolumeData.Long)
+		break
+	}
+
+	size := float64(canvas.Bounds().Dx())
+	pxPerM := size / 2 / 460000
+	toPx := func(plat, plon float64) (float64, float64) {
+		az, d := archive2.BearingDistance(lat, lon, plat, plon)
+		return size/2 + math.Sin(az*math.Pi/180)*d*pxPerM, size/2 - math.Cos(az*math.Pi/180)*d*pxPerM
+	}
+
+	gc := draw2dimg.NewGraphicContext(canvas)
+	gc.SetStrokeColor(colornames.White)
+	gc.SetLineWidth(2)
+	for _, c := range cells {
+		x, y := toPx(c.Lat, c.Lon)
+		gc.MoveTo(x+6, y)
+		gc.ArcTo(x, y, 6, 6, 0, 2*math.Pi)
+		gc.Stroke()
+
+		if len(c.Forecast) > 0 {
+			gc.MoveTo(x, y)
+			for _, f := range c.Forecast {
+				fx, fy := toPx(f.Lat, f.Lon)
+				gc.LineTo(fx, fy)
+			}
+			gc.Stroke()
+		}
+
+		addLabel(canvas, int(x)+8, int(y)-8, strconv.Itoa(c.ID))
+	}
+}
+
+// drawBoundaries draws the polyline of every boundary, labelled with its
+// propagation speed in knots when known.
+func drawBoundaries(canvas *image.RGBA, ar2 *archive2.Archive2, boundaries []products.Boundary) {
+	var lat, lon float64
+	for _, e := range ar2.Elevations() {
+		lat, lon = float64(ar2.ElevationScans[e][0].VolumeData.Lat), float64(ar2.ElevationScans[e][0].VolumeData.Long)
+		break
+	}
+
+	size := float64(canvas.Bounds().Dx())
+	pxPerM := size / 2 / 460000
+	toPx := func(plat, plon float64) (float64, float64) {
+		az, d := archive2.BearingDistance(lat, lon, plat, plon)
+		return size/2 + math.Sin(az*math.Pi/180)*d*pxPerM, size/2 - math.Cos(az*math.Pi/180)*d*pxPerM
+	}
+
+	gc := draw2dimg.NewGraphicContext(canvas)
+	gc.SetStrokeColor(colornames.Yellow)
+	gc.SetLineWidth(2)
+	for _, b := range boundaries {
+		for i, p := range b.Points {
+			x, y := toPx(p[0], p[1])
+			if i == 0 {
+				gc.MoveTo(x, y)
+			} else {
+				gc.LineTo(x, y)
+			}
+		}
+		gc.Stroke()
+
+		if b.Propagation != nil {
+			x, y := toPx(b.Lat, b.Lon)
+			addLabel(canvas, int(x)+8, int(y)-8, fmt.Sprintf("%.0f/%.0fkt", b.Propagation.Direction, b.Propagation.Speed/knotsToMps))
+		}
+	}
+}
+
+// parseStormMotion parses a DIR/SPEED storm motion with the speed in knots.
+func parseStormMotion(s string) (products.StormMotion, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return products.StormMotion{}, fmt.Errorf("invalid storm motion %q, expected DIR/SPEED", s)
+	}
+	dir, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return products.StormMotion{}, fmt.Errorf("invalid storm motion direction %q: %s", parts[0], err)
+	}
+	speed, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return products.StormMotion{}, fmt.Errorf("invalid storm motion speed %q: %s", parts[1], err)
+	}
+	return products.StormMotion{Direction: dir, Speed: speed * knotsToMps}, nil
+}
+
+// parseMeltingLayer parses a BOTTOM/TOP melting layer with heights in km.
+func parseMeltingLayer(s string) (products.MeltingLayer, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return products.MeltingLayer{}, fmt.Errorf("invalid melting layer %q, expected BOTTOM/TOP", s)
+	}
+	bottom, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return products.MeltingLayer{}, fmt.Errorf("invalid melting layer bottom %q: %s", parts[0], err)
+	}
+	top, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return products.MeltingLayer{}, fmt.Errorf("invalid melting layer top %q: %s", parts[1], err)
+	}
+	return products.MeltingLayer{Bottom: bottom * 1000, Top: top * 1000}, nil
+}
+
+// parseCrossSection parses the LAT1,LON1,LAT2,LON2 endpoints of a cross
+// section.
+func parseCrossSection(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid cross section %q, expected LAT1,LON1,LAT2,LON2", s)
+	}
+	points := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cross section coordinate %q: %s", p, err)
+		}
+		points[i] = v
+	}
+	return points, nil
 }
 
 func addLabel(img *image.RGBA, x, y int, label string) {
@@ -299,9 +999,24 @@ func addLabel(img *image.RGBA, x, y int, label string) {
 	d.DrawString(label)
 }
 
-// scaleInt scales a number form one range to another range
-func scaleInt(value, oldMax, oldMin, newMax, newMin int32) int32 {
-	oldRange := (oldMax - oldMin)
-	newRange := (newMax - newMin)
-	return (((value - oldMin) * newRange) / oldRange) + newMin
+type legendEntry struct {
+	label string
+	color color.Color
+}
+
+// addLegend draws a color swatch and label for each entry, starting at the top
+// left corner x, y.
+func addLegend(img *image.RGBA, x, y int, entries []legendEntry) {
+	const rowHeight = 20
+	for i, e := range entries {
+		top := y + i*rowHeight
+		draw.Draw(img, image.Rect(x, top, x+14, top+14), image.NewUniform(e.color), image.ZP, draw.Src)
+		d := &font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(colornames.Gray),
+			Face: inconsolata.Bold8x16,
+			Dot:  fixed.P(x+20, top+12),
+		}
+		d.DrawString(e.label)
+	}
 }
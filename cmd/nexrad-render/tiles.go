@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/mbtiles"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/bwiggs/go-nexrad/tiles"
+)
+
+// minZoom and maxZoom are the zoom levels of tile output.
+var minZoom, maxZoom int
+
+// tileFormat returns whether the selected output format is made of tiles.
+func tileFormat() bool {
+	return format == "tiles" || format == "mbtiles"
+}
+
+// parseZoom parses a zoom level or range of levels as MIN-MAX.
+func parseZoom(s string) (min, max int, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	if min, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, fmt.Errorf("invalid zoom %q", s)
+	}
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, fmt.Errorf("invalid zoom %q", s)
+		}
+	}
+	if min < 0 || max < min || max > 18 {
+		return 0, 0, fmt.Errorf("invalid zoom %q, levels are 0-18", s)
+	}
+	return min, max, nil
+}
+
+// productSampler returns the values of the selected product of a volume at
+// locations on the ground.
+func productSampler(ar2 *archive2.Archive2) func(lat, lon float64) (float32, bool) {
+	if product == "mesh" {
+		return gridSampler(products.DetectHail(ar2, hdaOptions).MESH)
+	}
+	radials := ar2.ElevationScans[elevation]
+	gates := sweepGates(radials, volumeHCAOptions(ar2))
+	return products.NewSweepSampler(radials, gates, productMoments[product]).At
+}
+
+// gridSampler returns the values of a grid at locations on the ground.
+func gridSampler(grid *products.Grid) func(lat, lon float64) (float32, bool) {
+	return func(lat, lon float64) (float32, bool) {
+		x, y, ok := grid.CellAt(lat, lon)
+		if !ok {
+			return 0, false
+		}
+		return grid.At(x, y), true
+	}
+}
+
+// writeTiles renders the selected product around the radar at lat, lon as
+// XYZ tiles of every zoom level, into the directory out or the MBTiles file
+// out. Tiles without data are not written.
+func writeTiles(out, name string, t time.Time, lat, lon float64, sample func(lat, lon float64) (float32, bool)) error {
+	colorOf := colorSchemes[product][colorScheme]
+	colorAt := func(lat, lon float64) (color.Color, bool) {
+		v, ok := sample(lat, lon)
+		if !ok || v == archive2.MomentDataBelowThreshold {
+			return nil, false
+		}
+		return colorOf(v), true
+	}
+
+	west, south, east, north := latLonBounds(lat, lon, renderExtent)
+	var mb *mbtiles.Writer
+	if format == "mbtiles" {
+		var err error
+		if mb, err = mbtiles.Create(out); err != nil {
+			return err
+		}
+		for _, m := range [][2]string{
+			{"name", name},
+			{"format", "png"},
+			{"type", "overlay"},
+			{"version", "1"},
+			{"description", fmt.Sprintf("%s %s", strings.ToUpper(product), t.UTC().Format(time.RFC3339))},
+			{"bounds", fmt.Sprintf("%f,%f,%f,%f", west, south, east, north)},
+			{"center", fmt.Sprintf("%f,%f,%d", lon, lat, minZoom)},
+			{"minzoom", strconv.Itoa(minZoom)},
+			{"maxzoom", strconv.Itoa(maxZoom)},
+		} {
+			mb.SetMetadata(m[0], m[1])
+		}
+	}
+
+	source := make(chan tiles.Tile)
+	errs := make(chan error, runners)
+	wg := sync.WaitGroup{}
+	for i := 0; i < runners; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for tile := range source {
+				img, empty := tiles.Render(tile, colorAt)
+				if empty {
+					continue
+				}
+				if mb == nil {
+					if err := tiles.WriteDir(out, tile, img); err != nil {
+						errs <- err
+						return
+					}
+					continue
+				}
+				var buf bytes.Buffer
+				if err := png.Encode(&buf, img); err != nil {
+					errs <- err
+					return
+				}
+				if err := mb.Write(tile.Z, tile.X, tile.Y, buf.Bytes()); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+send:
+	for z := minZoom; z <= maxZoom; z++ {
+		for _, tile := range tiles.Covering(z, west, south, east, north) {
+			select {
+			case source <- tile:
+			case err = <-errs:
+				break send
+			}
+		}
+	}
+	close(source)
+	wg.Wait()
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+
+	if mb != nil {
+		if cerr := mb.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
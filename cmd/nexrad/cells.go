@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/geojson"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var cellsCmd = &cobra.Command{
+	Use:   "cells [flags] file...",
+	Short: "cells identifies and tracks storm cells across archive 2 files.",
+	Run:   runCells,
+}
+
+var (
+	cellsOutput    string
+	cellsFormat    string
+	cellsDirectory string
+)
+
+func init() {
+	cellsCmd.Flags().StringVarP(&cellsOutput, "output", "o", "", "output file, defaults to stdout")
+	cellsCmd.Flags().StringVarP(&cellsFormat, "format", "f", "geojson", "output format. json, geojson")
+	cellsCmd.Flags().StringVarP(&cellsDirectory, "directory", "d", "", "directory of L2 files to process")
+	cmd.AddCommand(cellsCmd)
+}
+
+func runCells(cmd *cobra.Command, args []string) {
+	if cellsFormat != "json" && cellsFormat != "geojson" {
+		logrus.Fatalf("unsupported format %s", cellsFormat)
+	}
+
+	tracker := products.NewTracker(products.DefaultSCITOptions())
+	err := eachVolume(args, cellsDirectory, func(ar2 *archive2.Archive2) {
+		cells := tracker.Add(ar2)
+		logrus.Infof("%s: %d cells", ar2.VolumeHeader.Date().Format(time.RFC3339), len(cells))
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	out, err := createOutput(cellsOutput)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer out.Close()
+
+	if cellsFormat == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(tracker.Tracks())
+	} else {
+		err = tracksGeoJSON(tracker.Tracks()).Write(out)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// tracksGeoJSON converts the tracks to features: a point for the latest cell
+// of each track, a line for its past positions and a line for its forecast.
+func tracksGeoJSON(tracks []*products.StormTrack) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for _, track := range tracks {
+		last := track.Cells[len(track.Cells)-1]
+
+		props := map[string]interface{}{
+			"kind":           "cell",
+			"id":             track.ID,
+			"time":           last.Time.Format(time.RFC3339),
+			"base":           last.Base,
+			"top":            last.Top,
+			"max_ref":        last.MaxREF,
+			"max_ref_height": last.MaxREFHeight,
+			"vil":            last.VIL,
+		}
+		if track.Motion != nil {
+			props["direction"] = track.Motion.Direction
+			props["speed"] = track.Motion.Speed
+		}
+		fc.Add(geojson.Point(last.Lon, last.Lat), props)
+
+		if len(track.Cells) > 1 {
+			coords := [][]float64{}
+			for _, c := range track.Cells {
+				coords = append(coords, []float64{c.Lon, c.Lat})
+			}
+			fc.Add(geojson.LineString(coords), map[string]interface{}{
+				"kind":  "track",
+				"id":    track.ID,
+				"start": track.Cells[0].Time.Format(time.RFC3339),
+				"end":   last.Time.Format(time.RFC3339),
+			})
+		}
+
+		if len(last.Forecast) > 0 {
+			coords := [][]float64{{last.Lon, last.Lat}}
+			for _, p := range last.Forecast {
+				coords = append(coords, []float64{p.Lon, p.Lat})
+			}
+			fc.Add(geojson.LineString(coords), map[string]interface{}{
+				"kind": "forecast",
+				"id":   track.ID,
+				"end":  last.Forecast[len(last.Forecast)-1].Time.Format(time.RFC3339),
+			})
+		}
+	}
+	return fc
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwiggs/go-nexrad/cfradial"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export converts archive 2 files to other formats.",
+}
+
+var cfradialCmd = &cobra.Command{
+	Use:   "cfradial [flags] file",
+	Short: "cfradial writes an archive 2 volume as a CfRadial 1.4 NetCDF file.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runCFRadial,
+}
+
+var exportOutput string
+
+func init() {
+	cfradialCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "output file, defaults to the input file name with a .nc extension")
+	exportCmd.AddCommand(cfradialCmd)
+	cmd.AddCommand(exportCmd)
+}
+
+func runCFRadial(cmd *cobra.Command, args []string) {
+	ar2, err := extract(args[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if exportOutput == "" {
+		base := filepath.Base(args[0])
+		exportOutput = strings.TrimSuffix(base, filepath.Ext(base)) + ".nc"
+	}
+	f, err := os.Create(exportOutput)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer f.Close()
+	if err := cfradial.Write(f, ar2); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("wrote %s", exportOutput)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/geojson"
+	"github.com/bwiggs/go-nexrad/shapefile"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var gatesCmd = &cobra.Command{
+	Use:   "gates [flags] file",
+	Short: "gates writes every gate of a sweep as a polygon with its moment values.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runGates,
+}
+
+var (
+	gatesElevation int
+	gatesFormat    string
+	gatesMoments   []string
+	gatesMaxRange  float64
+)
+
+func init() {
+	gatesCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "output file, defaults to the input file name with the extension of the format")
+	gatesCmd.Flags().IntVarP(&gatesElevation, "elevation", "e", 1, "elevation number of the sweep, 1-15")
+	gatesCmd.Flags().StringVarP(&gatesFormat, "format", "f", "geojson", "geojson or shapefile")
+	gatesCmd.Flags().StringSliceVarP(&gatesMoments, "moments", "m", []string{"REF", "VEL", "SW", "ZDR", "PHI", "RHO", "CFP"}, "moments written as attributes, the gates of the first one present on a radial are the polygons")
+	gatesCmd.Flags().Float64Var(&gatesMaxRange, "max-range", 0, "maximum slant range in km of the gates, 0 for all")
+	exportCmd.AddCommand(gatesCmd)
+}
+
+// momentNames are the moments of a radial, see Message31.Moment.
+var momentNames = map[string]bool{"REF": true, "VEL": true, "SW": true, "ZDR": true, "PHI": true, "RHO": true, "CFP": true}
+
+// gate is a gate of a radial with the values of the exported moments.
+type gate struct {
+	radial *archive2.Message31
+	// rng is the slant range to the center and spacing the length of the
+	// gate in meters.
+	rng, spacing float64
+	values       []float32
+}
+
+// gateFilter selects the gates exported from the radials of a sweep.
+type gateFilter struct {
+	// moments are exported in order, the gates of the first one a radial
+	// holds are the gates of the radial.
+	moments []string
+	// minRange and maxRange limit the slant range in meters, 0 for none.
+	minRange, maxRange float64
+}
+
+// parseMoments returns the upper case moment names, exiting on unknown ones.
+func parseMoments(names []string) []string {
+	moments := make([]string, len(names))
+	for i, m := range names {
+		moments[i] = strings.ToUpper(m)
+		if !momentNames[moments[i]] {
+			logrus.Fatalf("unknown moment %s", m)
+		}
+	}
+	return moments
+}
+
+func runGates(cmd *cobra.Command, args []string) {
+	if gatesFormat != "geojson" && gatesFormat != "shapefile" {
+		logrus.Fatalf("unsupported format %s, geojson or shapefile", gatesFormat)
+	}
+	filter := gateFilter{moments: parseMoments(gatesMoments), maxRange: gatesMaxRange * 1000}
+
+	ar2, err := extract(args[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	radials := ar2.ElevationScans[gatesElevation]
+	if len(radials) == 0 {
+		logrus.Fatalf("no sweep %d in %s", gatesElevation, args[0])
+	}
+
+	if exportOutput == "" {
+		ext := ".geojson"
+		if gatesFormat == "shapefile" {
+			ext = ".shp"
+		}
+		base := filepath.Base(args[0])
+		exportOutput = strings.TrimSuffix(base, filepath.Ext(base)) + ext
+	}
+
+	var n int
+	if gatesFormat == "shapefile" {
+		n, err = writeGatesShapefile(exportOutput, radials, filter)
+	} else {
+		n, err = writeGatesGeoJSON(exportOutput, radials, filter)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("wrote %d gates to %s", n, exportOutput)
+}
+
+// each calls fn for every gate of the radials within the range limits with a
+// value of any of the moments.
+func (f gateFilter) each(radials []*archive2.Message31, fn func(g gate) error) error {
+	for _, radial := range radials {
+		var geometry *archive2.DataMoment
+		for _, m := range f.moments {
+			if geometry = radial.Moment(m); geometry != nil {
+				break
+			}
+		}
+		if geometry == nil {
+			continue
+		}
+
+		moments := make([]*archive2.DataMoment, len(f.moments))
+		data := make([][]float32, len(f.moments))
+		for i, m := range f.moments {
+			if moments[i] = radial.Moment(m); moments[i] != nil {
+				data[i] = moments[i].ScaledData()
+			}
+		}
+
+		spacing := float64(geometry.DataMomentRangeSampleInterval)
+		for gi := 0; gi < int(geometry.NumberDataMomentGates); gi++ {
+			r := geometry.GateRange(gi)
+			if r < f.minRange {
+				continue
+			}
+			if f.maxRange > 0 && r > f.maxRange {
+				break
+			}
+			g := gate{radial: radial, rng: r, spacing: spacing, values: make([]float32, len(f.moments))}
+			any := false
+			for i, m := range moments {
+				g.values[i] = archive2.MomentDataBelowThreshold
+				if m == nil {
+					continue
+				}
+				if j := m.GateIndex(r); j >= 0 && j < len(data[i]) {
+					g.values[i] = data[i][j]
+					any = any || valid(g.values[i])
+				}
+			}
+			if !any {
+				continue
+			}
+			if err := fn(g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// valid returns whether v is a value rather than below threshold or range
+// folded.
+func valid(v float32) bool {
+	return v != archive2.MomentDataBelowThreshold && v != archive2.MomentDataFolded
+}
+
+// ring returns the closed ring of longitude, latitude pairs of the corners of
+// the gate.
+func (g gate) ring() [][]float64 {
+	corners := g.radial.GateCorners(g.rng, g.spacing)
+	ring := make([][]float64, 0, 5)
+	for _, i := range []int{0, 1, 2, 3, 0} {
+		ring = append(ring, []float64{corners[i][1], corners[i][0]})
+	}
+	return ring
+}
+
+// writeGatesGeoJSON writes the gates as a GeoJSON feature collection of
+// polygons, missing values are null.
+func writeGatesGeoJSON(out string, radials []*archive2.Message31, filter gateFilter) (int, error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fw := geojson.NewFeatureWriter(w)
+
+	el := float64(radials[0].Header.ElevationAngle)
+	n := 0
+	err = filter.each(radials, func(g gate) error {
+		props := map[string]interface{}{
+			"azimuth":   round(float64(g.radial.Header.AzimuthAngle), 2),
+			"elevation": round(el, 2),
+			"range":     g.rng,
+			"height":    math.Round(g.radial.GateHeight(g.rng)),
+		}
+		for i, m := range filter.moments {
+			props[m] = nil
+			if valid(g.values[i]) {
+				props[m] = round(float64(g.values[i]), 3)
+			}
+		}
+		n++
+		return fw.Write(geojson.Polygon([][][]float64{g.ring()}), props)
+	})
+	if err != nil {
+		return n, err
+	}
+	if err := fw.Close(); err != nil {
+		return n, err
+	}
+	if err := w.Flush(); err != nil {
+		return n, err
+	}
+	return n, f.Close()
+}
+
+// writeGatesShapefile writes the gates as a shapefile of polygons, missing
+// values are null.
+func writeGatesShapefile(out string, radials []*archive2.Message31, filter gateFilter) (int, error) {
+	fields := []shapefile.Field{
+		{Name: "AZIMUTH", Width: 7, Decimals: 2},
+		{Name: "ELEVATION", Width: 6, Decimals: 2},
+		{Name: "RANGE", Width: 7, Decimals: 0},
+		{Name: "HEIGHT", Width: 6, Decimals: 0},
+	}
+	for _, m := range filter.moments {
+		fields = append(fields, shapefile.Field{Name: m, Width: 9, Decimals: 3})
+	}
+	w, err := shapefile.Create(out, fields)
+	if err != nil {
+		return 0, err
+	}
+
+	el := float64(radials[0].Header.ElevationAngle)
+	n := 0
+	values := make([]float64, len(fields))
+	err = filter.each(radials, func(g gate) error {
+		values[0], values[1], values[2], values[3] = float64(g.radial.Header.AzimuthAngle), el, g.rng, g.radial.GateHeight(g.rng)
+		for i, v := range g.values {
+			values[4+i] = math.NaN()
+			if valid(v) {
+				values[4+i] = float64(v)
+			}
+		}
+		n++
+		return w.Write([][][]float64{g.ring()}, values)
+	})
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// round rounds v to the given number of decimals.
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
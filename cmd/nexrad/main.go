@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var cmd = &cobra.Command{
+	Use:   "nexrad",
+	Short: "nexrad converts, analyzes and serves NEXRAD Level 2 (archive 2) data files.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		lvl, err := logrus.ParseLevel(logLevel)
+		if err != nil {
+			logrus.Fatalf("failed to parse level: %s", err)
+		}
+		logrus.SetLevel(lvl)
+	},
+}
+
+var logLevel string
+
+func init() {
+	cmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "warn", "log level, debug, info, warn, error")
+}
+
+func main() {
+	if err := cmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// extract decodes the archive 2 file fn.
+func extract(fn string) (*archive2.Archive2, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ar2, err := archive2.ExtractErr(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn, err)
+	}
+	return ar2, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/geojson"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var microburstCmd = &cobra.Command{
+	Use:   "microburst [flags] file...",
+	Short: "microburst detects microbursts, divergence and descending reflectivity cores in archive 2 files.",
+	Run:   runMicroburst,
+}
+
+var (
+	microburstOutput    string
+	microburstFormat    string
+	microburstDirectory string
+	microburstSite      string
+	microburstRadius    float64
+)
+
+func init() {
+	microburstCmd.Flags().StringVarP(&microburstOutput, "output", "o", "", "output file, defaults to stdout")
+	microburstCmd.Flags().StringVarP(&microburstFormat, "format", "f", "json", "output format. json, geojson")
+	microburstCmd.Flags().StringVarP(&microburstDirectory, "directory", "d", "", "directory of L2 files to process")
+	microburstCmd.Flags().StringVar(&microburstSite, "site", "", "only report alerts near a site such as an airport, as LAT,LON. ex: 35.39,-97.6")
+	microburstCmd.Flags().Float64Var(&microburstRadius, "radius", 10, "distance in km from the site alerts are reported within")
+	cmd.AddCommand(microburstCmd)
+}
+
+func runMicroburst(cmd *cobra.Command, args []string) {
+	if microburstFormat != "json" && microburstFormat != "geojson" {
+		logrus.Fatalf("unsupported format %s", microburstFormat)
+	}
+
+	var siteLat, siteLon float64
+	if microburstSite != "" {
+		parts := strings.Split(microburstSite, ",")
+		if len(parts) != 2 {
+			logrus.Fatalf("invalid site %q, expected LAT,LON", microburstSite)
+		}
+		var err error
+		if siteLat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+			logrus.Fatalf("invalid site latitude %q: %s", parts[0], err)
+		}
+		if siteLon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+			logrus.Fatalf("invalid site longitude %q: %s", parts[1], err)
+		}
+	}
+
+	detector := products.NewMicroburstDetector(products.DefaultMicroburstOptions())
+	alerts := []products.Alert{}
+	err := eachVolume(args, microburstDirectory, func(ar2 *archive2.Archive2) {
+		found := detector.Add(ar2)
+		logrus.Infof("%s: %d alerts", ar2.VolumeHeader.Date().Format(time.RFC3339), len(found))
+		for _, a := range found {
+			if microburstSite != "" && a.Distance(siteLat, siteLon) > microburstRadius*1000 {
+				continue
+			}
+			alerts = append(alerts, a)
+		}
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	out, err := createOutput(microburstOutput)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer out.Close()
+
+	if microburstFormat == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(alerts)
+	} else {
+		err = alertsGeoJSON(alerts).Write(out)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// alertsGeoJSON converts the alerts to point features.
+func alertsGeoJSON(alerts []products.Alert) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for _, a := range alerts {
+		props := map[string]interface{}{
+			"kind":   a.Kind.String(),
+			"time":   a.Time.Format(time.RFC3339),
+			"height": a.Height,
+		}
+		if a.Kind == products.DescendingCoreAlert {
+			props["cell"] = a.CellID
+			props["max_ref"] = a.MaxREF
+			props["descent"] = a.Descent
+			props["descent_rate"] = a.DescentRate
+		} else {
+			props["delta_v"] = a.DeltaV
+			props["length"] = a.Length
+		}
+		fc.Add(geojson.Point(a.Lon, a.Lat), props)
+	}
+	return fc
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/bwiggs/go-nexrad/server"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [flags] dir",
+	Short: "serve serves the archive 2 files of a directory over HTTP and a web viewer.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runServe,
+}
+
+var (
+	serveAddr    string
+	serveOptions = server.DefaultOptions()
+)
+
+func init() {
+	serveCmd.Flags().StringVarP(&serveAddr, "addr", "a", "localhost:8080", "address to listen on")
+	serveCmd.Flags().IntVar(&serveOptions.CacheSize, "cache", serveOptions.CacheSize, "number of decoded volumes kept in memory")
+	serveCmd.Flags().IntVar(&serveOptions.ImageSize, "size", serveOptions.ImageSize, "default size of images in pixels")
+	cmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	serveOptions.Dir = args[0]
+	fmt.Printf("serving %s on http://%s\n", serveOptions.Dir, serveAddr)
+	logrus.Fatal(http.ListenAndServe(serveAddr, server.New(serveOptions)))
+}
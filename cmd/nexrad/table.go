@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/parquet"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var tableCmd = &cobra.Command{
+	Use:   "table [flags] file",
+	Short: "table writes one row per gate with its location and moment values as CSV or Parquet.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runTable,
+}
+
+var (
+	tableFormat     string
+	tableElevations []int
+	tableMoments    []string
+	tableMinRange   float64
+	tableMaxRange   float64
+)
+
+func init() {
+	tableCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "output file, - for stdout, defaults to the input file name with the extension of the format")
+	tableCmd.Flags().StringVarP(&tableFormat, "format", "f", "csv", "csv or parquet")
+	tableCmd.Flags().IntSliceVarP(&tableElevations, "elevations", "e", nil, "elevation numbers to export, defaults to all")
+	tableCmd.Flags().StringSliceVarP(&tableMoments, "moments", "m", []string{"REF", "VEL", "SW", "ZDR", "PHI", "RHO", "CFP"}, "moment columns, the gates of the first one present on a radial are the rows")
+	tableCmd.Flags().Float64Var(&tableMinRange, "min-range", 0, "minimum slant range in km of the gates")
+	tableCmd.Flags().Float64Var(&tableMaxRange, "max-range", 0, "maximum slant range in km of the gates, 0 for all")
+	exportCmd.AddCommand(tableCmd)
+}
+
+// tableColumns are the columns of every row before the moments.
+var tableColumns = []parquet.Column{
+	{Name: "station", Type: parquet.String},
+	{Name: "time", Type: parquet.Timestamp},
+	{Name: "elevation_number", Type: parquet.Int32},
+	{Name: "elevation", Type: parquet.Float},
+	{Name: "azimuth", Type: parquet.Float},
+	{Name: "range", Type: parquet.Float},
+	{Name: "lat", Type: parquet.Double},
+	{Name: "lon", Type: parquet.Double},
+	{Name: "height", Type: parquet.Float},
+}
+
+// tableWriter writes the rows of the table.
+type tableWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+func runTable(cmd *cobra.Command, args []string) {
+	if tableFormat != "csv" && tableFormat != "parquet" {
+		logrus.Fatalf("unsupported format %s, csv or parquet", tableFormat)
+	}
+	filter := gateFilter{
+		moments:  parseMoments(tableMoments),
+		minRange: tableMinRange * 1000,
+		maxRange: tableMaxRange * 1000,
+	}
+
+	ar2, err := extract(args[0])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	elevations := tableElevations
+	if len(elevations) == 0 {
+		elevations = ar2.Elevations()
+	}
+
+	if exportOutput == "" {
+		base := filepath.Base(args[0])
+		exportOutput = strings.TrimSuffix(base, filepath.Ext(base)) + "." + tableFormat
+	}
+	var out io.Writer = os.Stdout
+	if exportOutput != "-" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+
+	columns := append([]parquet.Column{}, tableColumns...)
+	for _, m := range filter.moments {
+		columns = append(columns, parquet.Column{Name: m, Type: parquet.Float, Optional: true})
+	}
+	var w tableWriter
+	if tableFormat == "parquet" {
+		if w, err = parquet.NewWriter(bw, columns); err != nil {
+			logrus.Fatal(err)
+		}
+	} else {
+		w = newCSVTable(bw, columns)
+	}
+
+	station := strings.TrimSpace(string(ar2.VolumeHeader.ICAO[:]))
+	n := 0
+	row := make([]interface{}, len(columns))
+	for _, e := range elevations {
+		radials := ar2.ElevationScans[e]
+		if len(radials) == 0 {
+			logrus.Warnf("no sweep %d in %s", e, args[0])
+			continue
+		}
+		err := filter.each(radials, func(g gate) error {
+			lat, lon, height := g.radial.GateLocation(g.rng)
+			row[0] = station
+			row[1] = g.radial.Header.Date()
+			row[2] = int32(e)
+			row[3] = g.radial.Header.ElevationAngle
+			row[4] = g.radial.Header.AzimuthAngle
+			row[5] = float32(g.rng)
+			row[6] = lat
+			row[7] = lon
+			row[8] = float32(height)
+			for i, v := range g.values {
+				row[len(tableColumns)+i] = nil
+				if valid(v) {
+					row[len(tableColumns)+i] = v
+				}
+			}
+			n++
+			return w.Write(row)
+		})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := bw.Flush(); err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Infof("wrote %d gates to %s", n, exportOutput)
+}
+
+// csvTable writes rows as CSV with a header, nulls are empty.
+type csvTable struct {
+	w      *csv.Writer
+	header []string
+	record []string
+}
+
+func newCSVTable(w io.Writer, columns []parquet.Column) *csvTable {
+	t := &csvTable{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	for _, c := range columns {
+		t.header = append(t.header, c.Name)
+	}
+	return t
+}
+
+// writeHeader writes the header before the first row.
+func (t *csvTable) writeHeader() error {
+	if t.header == nil {
+		return nil
+	}
+	err := t.w.Write(t.header)
+	t.header = nil
+	return err
+}
+
+func (t *csvTable) Write(row []interface{}) error {
+	if err := t.writeHeader(); err != nil {
+		return err
+	}
+	for i, v := range row {
+		switch v := v.(type) {
+		case nil:
+			t.record[i] = ""
+		case string:
+			t.record[i] = v
+		case time.Time:
+			t.record[i] = v.UTC().Format("2006-01-02T15:04:05.000Z")
+		case int32:
+			t.record[i] = strconv.Itoa(int(v))
+		case float32:
+			t.record[i] = strconv.FormatFloat(float64(v), 'g', -1, 32)
+		case float64:
+			t.record[i] = strconv.FormatFloat(v, 'f', 6, 64)
+		}
+	}
+	return t.w.Write(t.record)
+}
+
+func (t *csvTable) Close() error {
+	if err := t.writeHeader(); err != nil {
+		return err
+	}
+	t.w.Flush()
+	return t.w.Error()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/sirupsen/logrus"
+)
+
+// volumeFiles returns the files given as arguments and the files of dir, if
+// any, in file name order, which is time order for NEXRAD Level 2 files.
+func volumeFiles(args []string, dir string) ([]string, error) {
+	files := append([]string{}, args...)
+	if dir != "" {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range infos {
+			if !fi.IsDir() {
+				files = append(files, filepath.Join(dir, fi.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no L2 files to process")
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// eachVolume decodes the files given as arguments and the files of dir in time
+// order and calls fn with every volume. Files that can not be decoded are
+// logged and skipped.
+func eachVolume(args []string, dir string, fn func(ar2 *archive2.Archive2)) error {
+	files, err := volumeFiles(args, dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		ar2, err := extract(f)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		fn(ar2)
+	}
+	return nil
+}
+
+// createOutput creates the output file name, or returns stdout if name is
+// empty.
+func createOutput(name string) (io.WriteCloser, error) {
+	if name == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(name)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/llgcode/draw2d/draw2dimg"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/image/colornames"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/fixed"
+)
+
+var vwpCmd = &cobra.Command{
+	Use:   "vwp [flags] file...",
+	Short: "vwp generates VAD wind profiles from archive 2 files.",
+	Run:   runVWP,
+}
+
+const mpsToKnots = 1.94384
+
+var (
+	vwpOutput    string
+	vwpFormat    string
+	vwpDirectory string
+	vwpMaxHeight float64
+)
+
+func init() {
+	vwpCmd.Flags().StringVarP(&vwpOutput, "output", "o", "", "output file, defaults to stdout, vwp.png for png")
+	vwpCmd.Flags().StringVarP(&vwpFormat, "format", "f", "json", "output format. json, csv, png")
+	vwpCmd.Flags().StringVarP(&vwpDirectory, "directory", "d", "", "directory of L2 files to process")
+	vwpCmd.Flags().Float64Var(&vwpMaxHeight, "max-height", 10, "top of the png time height display in km")
+	cmd.AddCommand(vwpCmd)
+}
+
+func runVWP(cmd *cobra.Command, args []string) {
+	if vwpFormat != "json" && vwpFormat != "csv" && vwpFormat != "png" {
+		logrus.Fatalf("unsupported format %s", vwpFormat)
+	}
+
+	opts := products.DefaultVADOptions()
+	profiles := []products.WindProfile{}
+	err := eachVolume(args, vwpDirectory, func(ar2 *archive2.Archive2) {
+		profile := products.VADProfile(ar2, opts)
+		logrus.Infof("%s: %d levels", profile.Time.Format(time.RFC3339), len(profile.Levels))
+		profiles = append(profiles, profile)
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	if vwpFormat == "png" {
+		if vwpOutput == "" {
+			vwpOutput = "vwp.png"
+		}
+		img := renderVWP(profiles, vwpMaxHeight*1000)
+		if err := draw2dimg.SaveToPngFile(vwpOutput, img); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	out, err := createOutput(vwpOutput)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer out.Close()
+
+	if vwpFormat == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(profiles)
+	} else {
+		err = writeVWPCSV(out, profiles)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// writeVWPCSV writes one row per level of every profile.
+func writeVWPCSV(out io.Writer, profiles []products.WindProfile) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"station", "time", "height_m", "direction_deg", "speed_mps", "speed_kt", "rms_mps", "estimates"})
+	for _, p := range profiles {
+		for _, l := range p.Levels {
+			w.Write([]string{
+				p.Station,
+				p.Time.Format(time.RFC3339),
+				strconv.FormatFloat(l.Height, 'f', 0, 64),
+				strconv.FormatFloat(l.Direction, 'f', 0, 64),
+				strconv.FormatFloat(l.Speed, 'f', 1, 64),
+				strconv.FormatFloat(l.Speed*mpsToKnots, 'f', 0, 64),
+				strconv.FormatFloat(l.RMS, 'f', 1, 64),
+				strconv.Itoa(l.Estimates),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// renderVWP draws the profiles as a time height display of wind barbs colored
+// by the RMS error of the estimate, like the WSR-88D VAD wind profile product.
+func renderVWP(profiles []products.WindProfile, top float64) *image.RGBA {
+	const (
+		margin    = 60
+		colWidth  = 50
+		rowHeight = 24
+	)
+	rows := int(math.Ceil(top / 304.8))
+	width := 2*margin + colWidth*len(profiles)
+	height := 2*margin + rowHeight*rows
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.Black, image.ZP, draw.Src)
+
+	gc := draw2dimg.NewGraphicContext(canvas)
+	gc.SetStrokeColor(colornames.Dimgray)
+	gc.SetLineWidth(1)
+
+	// height axis every 1000 ft
+	for i := 0; i <= rows; i++ {
+		y := float64(height - margin - i*rowHeight)
+		gc.MoveTo(margin, y)
+		gc.LineTo(float64(width-margin), y)
+		gc.Stroke()
+		if i%5 == 0 {
+			addLabel(canvas, 8, int(y)+5, fmt.Sprintf("%3dkft", i))
+		}
+	}
+
+	for i, p := range profiles {
+		x := float64(margin + i*colWidth + colWidth/2)
+		if i%2 == 0 {
+			addLabel(canvas, int(x)-18, height-margin+20, p.Time.UTC().Format("1504"))
+		}
+		for _, l := range p.Levels {
+			if l.Height > top {
+				continue
+			}
+			y := float64(height-margin) - l.Height/304.8*rowHeight
+			drawBarb(gc, x, y, l.Direction, l.Speed*mpsToKnots, rmsColor(l.RMS*mpsToKnots))
+		}
+	}
+
+	if len(profiles) > 0 {
+		first := profiles[0]
+		addLabel(canvas, margin, 24, fmt.Sprintf("%s VWP %s UTC", first.Station, first.Time.UTC().Format("2006-01-02")))
+	}
+
+	return canvas
+}
+
+// drawBarb draws a wind barb centered on x, y pointing into the direction the
+// wind blows from with a pennant per 50 kt, a barb per 10 kt and a half barb
+// per 5 kt.
+func drawBarb(gc *draw2dimg.GraphicContext, x, y, direction, knots float64, c color.Color) {
+	const staff = 20.0
+	gc.SetStrokeColor(c)
+	gc.SetFillColor(c)
+	gc.SetLineWidth(1.5)
+
+	if knots < 2.5 {
+		gc.MoveTo(x+4, y)
+		gc.ArcTo(x, y, 4, 4, 0, 2*math.Pi)
+		gc.Stroke()
+		return
+	}
+
+	rad := direction * math.Pi / 180
+	// unit vectors along the staff toward the tip and perpendicular to it
+	dx, dy := math.Sin(rad), -math.Cos(rad)
+	px, py := -dy, dx
+
+	tipX, tipY := x+dx*staff/2, y+dy*staff/2
+	gc.MoveTo(x-dx*staff/2, y-dy*staff/2)
+	gc.LineTo(tipX, tipY)
+	gc.Stroke()
+
+	n := int(math.Round(knots/5)) * 5
+	pos := 0.0
+	for ; n >= 50; n -= 50 {
+		bx, by := tipX-dx*pos, tipY-dy*pos
+		gc.MoveTo(bx, by)
+		gc.LineTo(bx+px*8, by+py*8)
+		gc.LineTo(bx-dx*5, by-dy*5)
+		gc.Close()
+		gc.Fill()
+		pos += 6
+	}
+	for ; n >= 10; n -= 10 {
+		bx, by := tipX-dx*pos, tipY-dy*pos
+		gc.MoveTo(bx, by)
+		gc.LineTo(bx+px*8+dx*2, by+py*8+dy*2)
+		gc.Stroke()
+		pos += 4
+	}
+	if n >= 5 {
+		if pos == 0 {
+			pos = 4
+		}
+		bx, by := tipX-dx*pos, tipY-dy*pos
+		gc.MoveTo(bx, by)
+		gc.LineTo(bx+px*4+dx, by+py*4+dy)
+		gc.Stroke()
+	}
+}
+
+// rmsColor returns the color of a barb by the RMS error in knots of its
+// estimate.
+func rmsColor(rms float64) color.Color {
+	switch {
+	case rms < 4:
+		return colornames.Lime
+	case rms < 8:
+		return colornames.Yellow
+	case rms < 12:
+		return colornames.Red
+	case rms < 16:
+		return colornames.Cyan
+	default:
+		return colornames.Magenta
+	}
+}
+
+func addLabel(img *image.RGBA, x, y int, label string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(colornames.Gray),
+		Face: inconsolata.Bold8x16,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(label)
+}
@@ -1,9 +1,10 @@
-package main
+package colorscheme
 
 import (
 	"image/color"
 
 	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/products"
 	"golang.org/x/image/colornames"
 )
 
@@ -419,3 +420,142 @@ func dbzColorScope(dbz float32) color.Color {
 	}
 	return colornames.Black
 }
+
+// kdpColor Specific Differential Phase color spectrum in deg/km
+func kdpColor(kdp float32) color.Color {
+	gradient := []gradientValue{
+		{-2, colornames.Black},
+		{-1, color.RGBA{0x55, 0x55, 0x55, 0xFF}},
+		{-0.5, color.RGBA{0x99, 0x99, 0x99, 0xFF}},
+		{0, color.RGBA{0xcc, 0xcc, 0xcc, 0xFF}},
+		{0.25, colornames.Navy},
+		{0.5, colornames.Blue},
+		{0.75, colornames.Deepskyblue},
+		{1, colornames.Cyan},
+		{1.5, colornames.Lime},
+		{2, colornames.Green},
+		{2.5, colornames.Yellow},
+		{3, colornames.Gold},
+		{4, colornames.Orange},
+		{5, colornames.Orangered},
+		{6, colornames.Red},
+		{7, colornames.Maroon},
+		{8, colornames.Hotpink},
+		{10, colornames.Pink},
+	}
+
+	for _, gv := range gradient {
+		if kdp < gv.val {
+			return gv.color
+		}
+	}
+
+	return colornames.White
+}
+
+// HCAColors Hydrometeor Classification category colors
+var HCAColors = map[products.HydrometeorClass]color.RGBA{
+	products.HydroBiological:    colornames.Dimgray,
+	products.HydroGroundClutter: colornames.Saddlebrown,
+	products.HydroIceCrystals:   colornames.Pink,
+	products.HydroDrySnow:       colornames.Lightskyblue,
+	products.HydroWetSnow:       colornames.Blue,
+	products.HydroRain:          colornames.Lime,
+	products.HydroHeavyRain:     colornames.Darkgreen,
+	products.HydroGraupel:       colornames.Yellow,
+	products.HydroHail:          colornames.Red,
+}
+
+func hcaColor(class float32) color.Color {
+	if c, ok := HCAColors[products.HydrometeorClass(class)]; ok {
+		return c
+	}
+	return color.NRGBA{0x00, 0x00, 0x00, 0x00}
+}
+
+// precipColor rainfall color spectrum for rain rates in mm/h and accumulations in mm
+func precipColor(mm float32) color.Color {
+	gradient := []gradientValue{
+		{0.25, color.RGBA{0x00, 0x00, 0x00, 0x00}},
+		{2.5, color.RGBA{0x3c, 0xe4, 0xe4, 0xFF}},
+		{6.35, color.RGBA{0x00, 0x9f, 0xf5, 0xFF}},
+		{12.7, color.RGBA{0x00, 0x00, 0xf6, 0xFF}},
+		{19.05, color.RGBA{0x00, 0xff, 0x00, 0xFF}},
+		{25.4, color.RGBA{0x00, 0xc6, 0x00, 0xFF}},
+		{38.1, color.RGBA{0x00, 0x8e, 0x00, 0xFF}},
+		{50.8, color.RGBA{0xfd, 0xf8, 0x02, 0xFF}},
+		{76.2, color.RGBA{0xe5, 0xbc, 0x00, 0xFF}},
+		{101.6, color.RGBA{0xfd, 0x95, 0x00, 0xFF}},
+		{127, color.RGBA{0xfd, 0x00, 0x00, 0xFF}},
+		{152.4, color.RGBA{0xd4, 0x00, 0x00, 0xFF}},
+		{203.2, color.RGBA{0xbc, 0x00, 0x00, 0xFF}},
+		{254, color.RGBA{0xf8, 0x00, 0xfd, 0xFF}},
+		{381, color.RGBA{0x98, 0x54, 0xc6, 0xFF}},
+	}
+
+	for _, gv := range gradient {
+		if mm < gv.val {
+			return gv.color
+		}
+	}
+
+	return colornames.White
+}
+
+// shearColor Azimuthal Shear color spectrum in s^-1, cyclonic shear is positive
+func shearColor(shear float32) color.Color {
+	gradient := []gradientValue{
+		{-0.01, colornames.Cyan},
+		{-0.006, colornames.Deepskyblue},
+		{-0.004, colornames.Royalblue},
+		{-0.002, colornames.Navy},
+		{0.002, color.RGBA{0x00, 0x00, 0x00, 0x00}},
+		{0.004, colornames.Darkgreen},
+		{0.006, colornames.Yellow},
+		{0.008, colornames.Orange},
+		{0.01, colornames.Red},
+		{0.015, colornames.Darkred},
+		{0.02, colornames.Hotpink},
+	}
+
+	for _, gv := range gradient {
+		if shear < gv.val {
+			return gv.color
+		}
+	}
+
+	return colornames.White
+}
+
+// meshColor Maximum Expected Size of Hail color spectrum in mm
+func meshColor(mm float32) color.Color {
+	gradient := []gradientValue{
+		{2, color.RGBA{0x00, 0x00, 0x00, 0x00}},
+		{6.35, colornames.Lightcyan},
+		{12.7, colornames.Deepskyblue},
+		{19.05, colornames.Lime},
+		{25.4, colornames.Yellow},
+		{31.75, colornames.Gold},
+		{38.1, colornames.Orange},
+		{44.45, colornames.Orangered},
+		{50.8, colornames.Red},
+		{63.5, colornames.Darkred},
+		{76.2, colornames.Fuchsia},
+		{101.6, colornames.Purple},
+	}
+
+	for _, gv := range gradient {
+		if mm < gv.val {
+			return gv.color
+		}
+	}
+
+	return colornames.White
+}
+
+// scaleInt scales a number form one range to another range
+func scaleInt(value, oldMax, oldMin, newMax, newMin int32) int32 {
+	oldRange := (oldMax - oldMin)
+	newRange := (newMax - newMin)
+	return (((value - oldMin) * newRange) / oldRange) + newMin
+}
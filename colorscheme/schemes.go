@@ -0,0 +1,56 @@
+// Package colorscheme holds the color tables used to draw radar products.
+package colorscheme
+
+import "image/color"
+
+// Schemes maps product names and color scheme names to the function
+// returning the color of a product value. Every product has a "noaa" scheme.
+var Schemes = map[string]map[string]func(float32) color.Color{}
+
+func init() {
+	Schemes["ref"] = map[string]func(float32) color.Color{
+		"noaa":          dbzColorNOAA,
+		"scope":         dbzColorScope,
+		"scope-classic": dbzColorScopeClassic,
+		"pink":          dbzPink,
+		"clean-air":     dbzColorCleanAirMode,
+	}
+	Schemes["vel"] = map[string]func(float32) color.Color{
+		"noaa":  velColorScope, // placeholder for default product value
+		"scope": velColorScope,
+	}
+	Schemes["srm"] = Schemes["vel"]
+	Schemes["sw"] = map[string]func(float32) color.Color{
+		"noaa": swColor,
+	}
+	Schemes["phi"] = map[string]func(float32) color.Color{
+		"noaa": ccColor,
+	}
+	Schemes["rho"] = map[string]func(float32) color.Color{
+		"noaa": ccColor,
+	}
+	Schemes["kdp"] = map[string]func(float32) color.Color{
+		"noaa": kdpColor,
+	}
+	Schemes["hca"] = map[string]func(float32) color.Color{
+		"noaa": hcaColor,
+	}
+	Schemes["rr"] = map[string]func(float32) color.Color{
+		"noaa": precipColor,
+	}
+	Schemes["qpe"] = Schemes["rr"]
+	Schemes["azshear"] = map[string]func(float32) color.Color{
+		"noaa": shearColor,
+	}
+	Schemes["mesh"] = map[string]func(float32) color.Color{
+		"noaa": meshColor,
+	}
+	Schemes["qvp"] = Schemes["ref"]
+	Schemes["zdr"] = map[string]func(float32) color.Color{
+		"noaa":  zdrColorScope,
+		"scope": zdrColorScope,
+	}
+	Schemes["cfp"] = map[string]func(float32) color.Color{
+		"noaa": dbzColorNOAA,
+	}
+}
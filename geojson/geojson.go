@@ -0,0 +1,67 @@
+// Package geojson writes GeoJSON (RFC 7946) feature collections.
+package geojson
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// FeatureCollection is a GeoJSON FeatureCollection.
+type FeatureCollection struct {
+	Type     string                 `json:"type"`
+	Features []Feature              `json:"features"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Feature is a GeoJSON Feature.
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a GeoJSON geometry. Coordinates are longitude, latitude pairs
+// nested according to the geometry type.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// NewFeatureCollection returns an empty FeatureCollection.
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+}
+
+// Add appends a feature with the given geometry and properties.
+func (fc *FeatureCollection) Add(g Geometry, properties map[string]interface{}) {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	fc.Features = append(fc.Features, Feature{Type: "Feature", Geometry: g, Properties: properties})
+}
+
+// Write encodes the feature collection as JSON.
+func (fc *FeatureCollection) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(fc)
+}
+
+// Point returns a Point geometry.
+func Point(lon, lat float64) Geometry {
+	return Geometry{Type: "Point", Coordinates: []float64{lon, lat}}
+}
+
+// LineString returns a LineString geometry from longitude, latitude pairs.
+func LineString(coords [][]float64) Geometry {
+	return Geometry{Type: "LineString", Coordinates: coords}
+}
+
+// Polygon returns a Polygon geometry from its linear rings, the first being
+// the exterior ring and any others holes.
+func Polygon(rings [][][]float64) Geometry {
+	return Geometry{Type: "Polygon", Coordinates: rings}
+}
+
+// MultiPolygon returns a MultiPolygon geometry.
+func MultiPolygon(polygons [][][][]float64) Geometry {
+	return Geometry{Type: "MultiPolygon", Coordinates: polygons}
+}
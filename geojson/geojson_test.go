@@ -0,0 +1,39 @@
+package geojson
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestFeatureCollectionWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewFeatureCollection().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != `{"type":"FeatureCollection","features":[]}`+"\n" {
+		t.Errorf("empty collection = %s", got)
+	}
+
+	fc := NewFeatureCollection()
+	fc.Add(Point(-97.5, 35.25), map[string]interface{}{"id": 1})
+	fc.Add(LineString([][]float64{{-97, 35}, {-96, 36}}), nil)
+	buf.Reset()
+	if err := fc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var got FeatureCollection
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%s: %s", err, buf.String())
+	}
+	if got.Type != "FeatureCollection" || len(got.Features) != 2 {
+		t.Fatalf("collection = %+v", got)
+	}
+	if f := got.Features[0]; f.Type != "Feature" || f.Geometry.Type != "Point" || f.Properties["id"] != 1.0 {
+		t.Errorf("point = %+v", f)
+	}
+	if f := got.Features[1]; f.Geometry.Type != "LineString" || f.Properties == nil {
+		t.Errorf("line = %+v", f)
+	}
+}
@@ -0,0 +1,49 @@
+package geojson
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// FeatureWriter writes a FeatureCollection one feature at a time, for
+// collections too large to hold in memory.
+type FeatureWriter struct {
+	w        io.Writer
+	features int
+}
+
+// NewFeatureWriter returns a FeatureWriter writing to w.
+func NewFeatureWriter(w io.Writer) *FeatureWriter {
+	return &FeatureWriter{w: w}
+}
+
+// Write writes a feature with the given geometry and properties.
+func (fw *FeatureWriter) Write(g Geometry, properties map[string]interface{}) error {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	data, err := json.Marshal(Feature{Type: "Feature", Geometry: g, Properties: properties})
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if fw.features == 0 {
+		sep = `{"type":"FeatureCollection","features":[` + "\n"
+	}
+	if _, err := io.WriteString(fw.w, sep); err != nil {
+		return err
+	}
+	fw.features++
+	_, err = fw.w.Write(data)
+	return err
+}
+
+// Close ends the feature collection, it does not close the underlying writer.
+func (fw *FeatureWriter) Close() error {
+	end := "\n]}\n"
+	if fw.features == 0 {
+		end = `{"type":"FeatureCollection","features":[]}` + "\n"
+	}
+	_, err := io.WriteString(fw.w, end)
+	return err
+}
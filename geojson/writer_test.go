@@ -0,0 +1,66 @@
+package geojson
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestFeatureWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	fw := NewFeatureWriter(&buf)
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got FeatureCollection
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%s: %s", err, buf.String())
+	}
+	if got.Type != "FeatureCollection" || got.Features == nil || len(got.Features) != 0 {
+		t.Errorf("collection = %s", buf.String())
+	}
+}
+
+func TestFeatureWriter(t *testing.T) {
+	var buf bytes.Buffer
+	fw := NewFeatureWriter(&buf)
+	ring := [][][]float64{{{-97, 35}, {-96, 35}, {-96, 36}, {-97, 35}}}
+	for i := 0; i < 3; i++ {
+		if err := fw.Write(Polygon(ring), map[string]interface{}{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fw.Write(Point(-97, 35), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same document as writing the collection at once
+	fc := NewFeatureCollection()
+	for i := 0; i < 3; i++ {
+		fc.Add(Polygon(ring), map[string]interface{}{"i": i})
+	}
+	fc.Add(Point(-97, 35), nil)
+
+	var got, want interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%s: %s", err, buf.String())
+	}
+	var fcBuf bytes.Buffer
+	fc.Write(&fcBuf)
+	json.Unmarshal(fcBuf.Bytes(), &want)
+	if g, w := mustMarshal(t, got), mustMarshal(t, want); g != w {
+		t.Errorf("got %s\nwant %s", g, w)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
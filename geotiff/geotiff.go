@@ -0,0 +1,340 @@
+// Package geotiff writes georeferenced images and grids of values as GeoTIFF
+// files readable by GDAL, QGIS and ArcGIS.
+//
+// Images are written uncompressed, one strip per row, either as 8 bit RGBA
+// or as a single band of 32 bit floats with a GDAL nodata value.
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// CRS is the coordinate reference system of the model coordinates of an image.
+type CRS int
+
+const (
+	// Geographic is latitude and longitude in degrees on WGS 84, EPSG:4326.
+	Geographic CRS = iota
+	// AzimuthalEquidistant is meters east and north of a center point, the
+	// projection of PPI images and products.Grid. The datum is the sphere
+	// used by the distance calculations of archive2.
+	AzimuthalEquidistant
+)
+
+// GeoReference places an image on the earth.
+type GeoReference struct {
+	CRS CRS
+	// CenterLat and CenterLon are the center of the azimuthal equidistant
+	// projection, usually the radar.
+	CenterLat, CenterLon float64
+	// X and Y are the model coordinates of the upper left corner of the
+	// image, longitude and latitude for Geographic.
+	X, Y float64
+	// PixelWidth and PixelHeight are the size of a pixel in model units.
+	PixelWidth, PixelHeight float64
+}
+
+// TIFF tags
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagExtraSamples    = 338
+	tagSampleFormat    = 339
+	tagModelPixelScale = 33550
+	tagModelTiepoint   = 33922
+	tagGeoKeyDirectory = 34735
+	tagGeoDoubleParams = 34736
+	tagGeoASCIIParams  = 34737
+	tagGDALNoData      = 42113
+)
+
+// TIFF field types
+const (
+	typeASCII  = 2
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+// GeoKeys
+const (
+	keyModelType         = 1024
+	keyRasterType        = 1025
+	keyCitation          = 1026
+	keyGeographicType    = 2048
+	keyGeogCitation      = 2049
+	keyGeodeticDatum     = 2050
+	keyPrimeMeridian     = 2051
+	keyAngularUnits      = 2054
+	keyEllipsoid         = 2056
+	keySemiMajorAxis     = 2057
+	keySemiMinorAxis     = 2058
+	keyProjectedCSType   = 3072
+	keyPCSCitation       = 3073
+	keyProjection        = 3074
+	keyProjCoordTrans    = 3075
+	keyProjLinearUnits   = 3076
+	keyProjFalseEasting  = 3082
+	keyProjFalseNorthing = 3083
+	keyProjCenterLong    = 3088
+	keyProjCenterLat     = 3089
+)
+
+// GeoKey values
+const (
+	modelTypeProjected     = 1
+	modelTypeGeographic    = 2
+	rasterPixelIsArea      = 1
+	userDefined            = 32767
+	gcsWGS84               = 4326
+	primeMeridianGreenwich = 8901
+	angularDegree          = 9102
+	linearMeter            = 9001
+	ctAzimuthalEquidistant = 12
+)
+
+// WriteRGBA writes img as a 4 band RGBA GeoTIFF. The alpha band is
+// unassociated, transparent pixels are no data.
+func WriteRGBA(w io.Writer, img image.Image, ref GeoReference) error {
+	b := img.Bounds()
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || b.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	}
+	rows := make([][]byte, b.Dy())
+	for y := range rows {
+		rows[y] = nrgba.Pix[y*nrgba.Stride : y*nrgba.Stride+4*b.Dx()]
+	}
+
+	t := &tiff{}
+	t.short(tagBitsPerSample, 8, 8, 8, 8)
+	t.short(tagPhotometric, 2)
+	t.short(tagSamplesPerPixel, 4)
+	t.short(tagExtraSamples, 2)
+	t.short(tagSampleFormat, 1, 1, 1, 1)
+	return t.write(w, b.Dx(), rows, ref)
+}
+
+// WriteFloat32 writes width x height values, row by row from the top, as a
+// single band float32 GeoTIFF. Pixels holding nodata are marked as no data.
+func WriteFloat32(w io.Writer, width, height int, data []float32, nodata float32, ref GeoReference) error {
+	if len(data) != width*height {
+		return fmt.Errorf("geotiff: %d values for a %dx%d image", len(data), width, height)
+	}
+	rows := make([][]byte, height)
+	for y := range rows {
+		row := make([]byte, 4*width)
+		for x := 0; x < width; x++ {
+			binary.LittleEndian.PutUint32(row[4*x:], math.Float32bits(data[y*width+x]))
+		}
+		rows[y] = row
+	}
+
+	t := &tiff{}
+	t.short(tagBitsPerSample, 32)
+	t.short(tagPhotometric, 1)
+	t.short(tagSamplesPerPixel, 1)
+	t.short(tagSampleFormat, 3)
+	t.ascii(tagGDALNoData, strconv.FormatFloat(float64(nodata), 'g', -1, 32))
+	return t.write(w, width, rows, ref)
+}
+
+// entry is a TIFF directory entry. value holds the encoded values.
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+// tiff accumulates the directory entries of a little endian TIFF.
+type tiff struct {
+	entries []entry
+}
+
+func (t *tiff) add(tag, typ uint16, count int, values interface{}) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, values)
+	t.entries = append(t.entries, entry{tag, typ, uint32(count), b.Bytes()})
+}
+
+func (t *tiff) short(tag uint16, values ...uint16) {
+	t.add(tag, typeShort, len(values), values)
+}
+
+func (t *tiff) long(tag uint16, values ...uint32) {
+	t.add(tag, typeLong, len(values), values)
+}
+
+func (t *tiff) double(tag uint16, values ...float64) {
+	t.add(tag, typeDouble, len(values), values)
+}
+
+func (t *tiff) ascii(tag uint16, s string) {
+	t.add(tag, typeASCII, len(s)+1, append([]byte(s), 0))
+}
+
+// geoKeys adds the GeoTIFF tags describing ref.
+func (t *tiff) geoKeys(ref GeoReference) {
+	t.double(tagModelPixelScale, ref.PixelWidth, ref.PixelHeight, 0)
+	t.double(tagModelTiepoint, 0, 0, 0, ref.X, ref.Y, 0)
+
+	type key struct {
+		id, location, count, value uint16
+	}
+	keys := []key{}
+	doubles := []float64{}
+	ascii := ""
+	short := func(id, value uint16) {
+		keys = append(keys, key{id, 0, 1, value})
+	}
+	double := func(id uint16, value float64) {
+		keys = append(keys, key{id, tagGeoDoubleParams, 1, uint16(len(doubles))})
+		doubles = append(doubles, value)
+	}
+	text := func(id uint16, value string) {
+		value += "|"
+		keys = append(keys, key{id, tagGeoASCIIParams, uint16(len(value)), uint16(len(ascii))})
+		ascii += value
+	}
+
+	short(keyRasterType, rasterPixelIsArea)
+	switch ref.CRS {
+	case Geographic:
+		short(keyModelType, modelTypeGeographic)
+		short(keyGeographicType, gcsWGS84)
+		short(keyAngularUnits, angularDegree)
+		text(keyCitation, "WGS 84")
+	case AzimuthalEquidistant:
+		short(keyModelType, modelTypeProjected)
+		text(keyCitation, "Radar centered azimuthal equidistant")
+		short(keyGeographicType, userDefined)
+		text(keyGeogCitation, "Sphere")
+		short(keyGeodeticDatum, userDefined)
+		short(keyPrimeMeridian, primeMeridianGreenwich)
+		short(keyAngularUnits, angularDegree)
+		short(keyEllipsoid, userDefined)
+		double(keySemiMajorAxis, archive2.EarthRadius)
+		double(keySemiMinorAxis, archive2.EarthRadius)
+		short(keyProjectedCSType, userDefined)
+		text(keyPCSCitation, "Radar centered azimuthal equidistant")
+		short(keyProjection, userDefined)
+		short(keyProjCoordTrans, ctAzimuthalEquidistant)
+		short(keyProjLinearUnits, linearMeter)
+		double(keyProjFalseEasting, 0)
+		double(keyProjFalseNorthing, 0)
+		double(keyProjCenterLong, ref.CenterLon)
+		double(keyProjCenterLat, ref.CenterLat)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].id < keys[j].id })
+
+	// version 1.1.0
+	directory := []uint16{1, 1, 0, uint16(len(keys))}
+	for _, k := range keys {
+		directory = append(directory, k.id, k.location, k.count, k.value)
+	}
+	t.short(tagGeoKeyDirectory, directory...)
+	if len(doubles) > 0 {
+		t.double(tagGeoDoubleParams, doubles...)
+	}
+	if ascii != "" {
+		t.ascii(tagGeoASCIIParams, ascii)
+	}
+}
+
+// write writes the image with the given rows of pixel data, one strip per row.
+func (t *tiff) write(w io.Writer, width int, rows [][]byte, ref GeoReference) error {
+	height := len(rows)
+	t.long(tagImageWidth, uint32(width))
+	t.long(tagImageLength, uint32(height))
+	t.short(tagCompression, 1)
+	t.long(tagRowsPerStrip, 1)
+	t.short(tagPlanarConfig, 1)
+	t.geoKeys(ref)
+
+	counts := make([]uint32, height)
+	for i, row := range rows {
+		counts[i] = uint32(len(row))
+	}
+	t.long(tagStripByteCounts, counts...)
+	// the offsets are filled in once the layout is known
+	t.long(tagStripOffsets, make([]uint32, height)...)
+	sort.Slice(t.entries, func(i, j int) bool { return t.entries[i].tag < t.entries[j].tag })
+
+	// header, directory, values that don't fit in an entry, then the strips
+	const headerSize = 8
+	offset := headerSize + 2 + 12*len(t.entries) + 4
+	valueOffsets := make([]int, len(t.entries))
+	for i, e := range t.entries {
+		if len(e.value) > 4 {
+			valueOffsets[i] = offset
+			offset += len(e.value) + len(e.value)%2
+		}
+	}
+	for i, e := range t.entries {
+		if e.tag == tagStripOffsets {
+			var b bytes.Buffer
+			for _, row := range rows {
+				binary.Write(&b, binary.LittleEndian, uint32(offset))
+				offset += len(row)
+			}
+			t.entries[i].value = b.Bytes()
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("II")
+	binary.Write(&b, binary.LittleEndian, uint16(42))
+	binary.Write(&b, binary.LittleEndian, uint32(headerSize))
+	binary.Write(&b, binary.LittleEndian, uint16(len(t.entries)))
+	for i, e := range t.entries {
+		binary.Write(&b, binary.LittleEndian, e.tag)
+		binary.Write(&b, binary.LittleEndian, e.typ)
+		binary.Write(&b, binary.LittleEndian, e.count)
+		if len(e.value) > 4 {
+			binary.Write(&b, binary.LittleEndian, uint32(valueOffsets[i]))
+		} else {
+			value := make([]byte, 4)
+			copy(value, e.value)
+			b.Write(value)
+		}
+	}
+	// no next directory
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	for _, e := range t.entries {
+		if len(e.value) > 4 {
+			b.Write(e.value)
+			if len(e.value)%2 == 1 {
+				b.WriteByte(0)
+			}
+		}
+	}
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
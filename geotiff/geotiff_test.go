@@ -0,0 +1,148 @@
+package geotiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	xtiff "golang.org/x/image/tiff"
+)
+
+// readTags returns the raw values of the tags of the first directory of a
+// little endian TIFF.
+func readTags(t *testing.T, data []byte) map[uint16][]byte {
+	if string(data[:4]) != "II*\x00" {
+		t.Fatalf("header = %q", data[:4])
+	}
+	sizes := map[uint16]int{typeASCII: 1, typeShort: 2, typeLong: 4, typeDouble: 8}
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	tags := map[uint16][]byte{}
+	for i := 0; i < n; i++ {
+		e := data[int(ifd)+2+12*i:]
+		tag := binary.LittleEndian.Uint16(e)
+		size := sizes[binary.LittleEndian.Uint16(e[2:])] * int(binary.LittleEndian.Uint32(e[4:]))
+		if size <= 4 {
+			tags[tag] = e[8 : 8+size]
+		} else {
+			offset := binary.LittleEndian.Uint32(e[8:])
+			tags[tag] = data[offset : int(offset)+size]
+		}
+	}
+	return tags
+}
+
+func shorts(b []byte) []uint16 {
+	values := make([]uint16, len(b)/2)
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, values)
+	return values
+}
+
+func doubles(b []byte) []float64 {
+	values := make([]float64, len(b)/8)
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, values)
+	return values
+}
+
+// geoKeys returns the GeoKeys of the directory with the value of short keys
+// or the index of double and ascii keys.
+func geoKeys(t *testing.T, tags map[uint16][]byte) map[uint16]uint16 {
+	dir := shorts(tags[tagGeoKeyDirectory])
+	keys := map[uint16]uint16{}
+	for i := 0; i < int(dir[3]); i++ {
+		k := dir[4+4*i:]
+		keys[k[0]] = k[3]
+	}
+	return keys
+}
+
+func TestWriteRGBA(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(2, 1, color.RGBA{0, 0, 255, 255})
+
+	ref := GeoReference{
+		CRS:         AzimuthalEquidistant,
+		CenterLat:   35.333,
+		CenterLon:   -97.278,
+		X:           -1500,
+		Y:           1000,
+		PixelWidth:  1000,
+		PixelHeight: 1000,
+	}
+	var buf bytes.Buffer
+	if err := WriteRGBA(&buf, img, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := xtiff.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds().Dx() != 3 || decoded.Bounds().Dy() != 2 {
+		t.Fatalf("bounds = %v", decoded.Bounds())
+	}
+	if r, _, _, a := decoded.At(0, 0).RGBA(); r != 0xffff || a != 0xffff {
+		t.Errorf("pixel 0,0 = %v, want red", decoded.At(0, 0))
+	}
+	if _, _, b, _ := decoded.At(2, 1).RGBA(); b != 0xffff {
+		t.Errorf("pixel 2,1 = %v, want blue", decoded.At(2, 1))
+	}
+	if _, _, _, a := decoded.At(1, 0).RGBA(); a != 0 {
+		t.Errorf("pixel 1,0 = %v, want transparent", decoded.At(1, 0))
+	}
+
+	tags := readTags(t, buf.Bytes())
+	if got := doubles(tags[tagModelTiepoint]); got[3] != -1500 || got[4] != 1000 {
+		t.Errorf("tiepoint = %v", got)
+	}
+	if got := doubles(tags[tagModelPixelScale]); got[0] != 1000 || got[1] != 1000 {
+		t.Errorf("pixel scale = %v", got)
+	}
+	keys := geoKeys(t, tags)
+	if keys[keyModelType] != modelTypeProjected || keys[keyProjCoordTrans] != ctAzimuthalEquidistant {
+		t.Errorf("keys = %v, want projected azimuthal equidistant", keys)
+	}
+	params := doubles(tags[tagGeoDoubleParams])
+	if got := params[keys[keyProjCenterLat]]; got != 35.333 {
+		t.Errorf("center latitude = %v", got)
+	}
+	if got := params[keys[keyProjCenterLong]]; got != -97.278 {
+		t.Errorf("center longitude = %v", got)
+	}
+}
+
+func TestWriteFloat32(t *testing.T) {
+	data := []float32{1.5, -999, 42, 7}
+	ref := GeoReference{CRS: Geographic, X: -98, Y: 36, PixelWidth: 0.5, PixelHeight: 0.5}
+	var buf bytes.Buffer
+	if err := WriteFloat32(&buf, 2, 2, data, -999, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := readTags(t, buf.Bytes())
+	if got := shorts(tags[tagSampleFormat]); got[0] != 3 {
+		t.Errorf("sample format = %v, want float", got)
+	}
+	if got := string(tags[tagGDALNoData]); got != "-999\x00" {
+		t.Errorf("nodata = %q", got)
+	}
+	keys := geoKeys(t, tags)
+	if keys[keyModelType] != modelTypeGeographic || keys[keyGeographicType] != gcsWGS84 {
+		t.Errorf("keys = %v, want EPSG:4326", keys)
+	}
+
+	offsets := tags[tagStripOffsets]
+	row := binary.LittleEndian.Uint32(offsets[4:])
+	got := math.Float32frombits(binary.LittleEndian.Uint32(buf.Bytes()[row:]))
+	if got != 42 {
+		t.Errorf("value 0,1 = %v, want 42", got)
+	}
+
+	if err := WriteFloat32(&buf, 3, 2, data, -999, ref); err == nil {
+		t.Error("expected an error for data not matching the size")
+	}
+}
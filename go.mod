@@ -1,6 +1,6 @@
 module github.com/bwiggs/go-nexrad
 
-go 1.12
+go 1.16
 
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
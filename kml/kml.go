@@ -0,0 +1,195 @@
+// Package kml writes images as KMZ ground overlays for Google Earth.
+package kml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GroundOverlay is an image draped over the ground within a latitude longitude
+// box. The image must be in an equirectangular projection, rows of equal
+// latitude and columns of equal longitude.
+type GroundOverlay struct {
+	Name  string
+	Image image.Image
+	// North, South, East and West are the edges of the image in degrees.
+	North, South, East, West float64
+	// Begin and End are the time span the overlay is shown for. Overlays
+	// without an End are shown until the Begin of the next overlay, the
+	// time span is omitted when Begin is zero.
+	Begin, End time.Time
+}
+
+// KMZWriter writes a KMZ file of ground overlays. The images are written as
+// the overlays are added, the KML document when the writer is closed. A
+// KMZWriter is safe for concurrent use.
+type KMZWriter struct {
+	mu       sync.Mutex
+	zip      *zip.Writer
+	name     string
+	legend   bool
+	overlays []overlay
+}
+
+// overlay is a GroundOverlay written to the archive.
+type overlay struct {
+	GroundOverlay
+	href string
+}
+
+// NewKMZWriter returns a KMZWriter of a document with the given name. When
+// legend is not nil it is shown in the upper left corner of the screen.
+func NewKMZWriter(w io.Writer, name string, legend image.Image) (*KMZWriter, error) {
+	k := &KMZWriter{zip: zip.NewWriter(w), name: name}
+	if legend != nil {
+		if err := k.writeImage("files/legend.png", legend); err != nil {
+			return nil, err
+		}
+		k.legend = true
+	}
+	return k, nil
+}
+
+// writeImage encodes img as a png and stores it in the archive at name.
+func (k *KMZWriter) writeImage(name string, img image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	// the images are already compressed
+	f, err := k.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+// Add adds a ground overlay.
+func (k *KMZWriter) Add(o GroundOverlay) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, o.Image); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	href := fmt.Sprintf("files/%04d.png", len(k.overlays))
+	f, err := k.zip.CreateHeader(&zip.FileHeader{Name: href, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	o.Image = nil
+	k.overlays = append(k.overlays, overlay{o, href})
+	return nil
+}
+
+// KML elements
+type (
+	kmlDocument struct {
+		XMLName  xml.Name `xml:"kml"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Document document
+	}
+	document struct {
+		Name           string `xml:"name"`
+		ScreenOverlay  *screenOverlay
+		GroundOverlays []groundOverlay `xml:"GroundOverlay"`
+	}
+	icon struct {
+		Href string `xml:"href"`
+	}
+	vec2 struct {
+		X      float64 `xml:"x,attr"`
+		Y      float64 `xml:"y,attr"`
+		XUnits string  `xml:"xunits,attr"`
+		YUnits string  `xml:"yunits,attr"`
+	}
+	screenOverlay struct {
+		Name      string `xml:"name"`
+		Icon      icon
+		OverlayXY vec2 `xml:"overlayXY"`
+		ScreenXY  vec2 `xml:"screenXY"`
+		Size      vec2 `xml:"size"`
+	}
+	timeSpan struct {
+		Begin string `xml:"begin"`
+		End   string `xml:"end,omitempty"`
+	}
+	latLonBox struct {
+		North float64 `xml:"north"`
+		South float64 `xml:"south"`
+		East  float64 `xml:"east"`
+		West  float64 `xml:"west"`
+	}
+	groundOverlay struct {
+		Name      string    `xml:"name"`
+		TimeSpan  *timeSpan `xml:",omitempty"`
+		Icon      icon
+		LatLonBox latLonBox
+	}
+)
+
+// Close writes the KML document and finishes the archive, it does not close
+// the underlying writer.
+func (k *KMZWriter) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	overlays := append([]overlay{}, k.overlays...)
+	sort.SliceStable(overlays, func(i, j int) bool { return overlays[i].Begin.Before(overlays[j].Begin) })
+
+	doc := kmlDocument{Xmlns: "http://www.opengis.net/kml/2.2", Document: document{Name: k.name}}
+	if k.legend {
+		doc.Document.ScreenOverlay = &screenOverlay{
+			Name:      "Legend",
+			Icon:      icon{"files/legend.png"},
+			OverlayXY: vec2{0, 1, "fraction", "fraction"},
+			ScreenXY:  vec2{0.01, 0.99, "fraction", "fraction"},
+			Size:      vec2{0, 0, "pixels", "pixels"},
+		}
+	}
+	for i, o := range overlays {
+		g := groundOverlay{
+			Name:      o.Name,
+			Icon:      icon{o.href},
+			LatLonBox: latLonBox{o.North, o.South, o.East, o.West},
+		}
+		if !o.Begin.IsZero() {
+			end := o.End
+			if end.IsZero() && i+1 < len(overlays) {
+				end = overlays[i+1].Begin
+			}
+			g.TimeSpan = &timeSpan{Begin: o.Begin.UTC().Format(time.RFC3339)}
+			if !end.IsZero() {
+				g.TimeSpan.End = end.UTC().Format(time.RFC3339)
+			}
+		}
+		doc.Document.GroundOverlays = append(doc.Document.GroundOverlays, g)
+	}
+
+	f, err := k.zip.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return k.zip.Close()
+}
@@ -0,0 +1,83 @@
+package kml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"image"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestKMZWriter(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	k, err := NewKMZWriter(&buf, "KTLX REF", img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2013, 5, 20, 20, 0, 0, 0, time.UTC)
+	// added out of order, as when rendered concurrently
+	for _, minutes := range []int{5, 0, 10} {
+		err := k.Add(GroundOverlay{
+			Name:  "KTLX",
+			Image: img,
+			North: 39.1, South: 30.8, East: -91.7, West: -102.3,
+			Begin: start.Add(time.Duration(minutes) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range r.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"doc.kml", "files/legend.png", "files/0000.png", "files/0001.png", "files/0002.png"} {
+		if files[name] == nil {
+			t.Fatalf("missing %s in %v", name, files)
+		}
+	}
+
+	rc, _ := files["doc.kml"].Open()
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	for _, want := range []string{"<name>KTLX REF</name>", "<LatLonBox>", "<TimeSpan>", "<ScreenOverlay>"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("doc.kml is missing %s", want)
+		}
+	}
+	var doc kmlDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Document.Name != "KTLX REF" || doc.Document.ScreenOverlay == nil {
+		t.Errorf("document = %+v, want the name and a legend", doc.Document)
+	}
+	overlays := doc.Document.GroundOverlays
+	if len(overlays) != 3 {
+		t.Fatalf("%d overlays, want 3", len(overlays))
+	}
+	if got := overlays[0].TimeSpan; got.Begin != "2013-05-20T20:00:00Z" || got.End != "2013-05-20T20:05:00Z" {
+		t.Errorf("first time span = %+v", got)
+	}
+	if overlays[0].Icon.Href != "files/0001.png" {
+		t.Errorf("first overlay image = %s, want files/0001.png", overlays[0].Icon.Href)
+	}
+	if got := overlays[2].TimeSpan; got.Begin != "2013-05-20T20:10:00Z" || got.End != "" {
+		t.Errorf("last time span = %+v, want no end", got)
+	}
+	if got := overlays[1].LatLonBox; got.North != 39.1 || got.West != -102.3 {
+		t.Errorf("box = %+v", got)
+	}
+}
@@ -0,0 +1,371 @@
+// Package mbtiles writes tiles to an MBTiles 1.3 file, a single SQLite
+// database of tiles that map servers and mobile apps read directly. It is
+// written without an SQLite library.
+package mbtiles
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// applicationID identifies MBTiles files in the SQLite header, "MPBX".
+const applicationID = 0x4d504258
+
+// schema of the database as given by the MBTiles specification
+var schema = []struct{ kind, name, table, sql string }{
+	{"table", "metadata", "metadata", "CREATE TABLE metadata (name text, value text)"},
+	{"table", "tiles", "tiles", "CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)"},
+	{"index", "tile_index", "tiles", "CREATE UNIQUE INDEX tile_index on tiles (zoom_level, tile_column, tile_row)"},
+}
+
+// Writer writes tiles to an MBTiles file. The tiles are written as they are
+// added, the metadata, index and schema when the writer is closed. A Writer
+// is safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	f     *os.File
+	pages uint32
+
+	// leaf of the tiles table being filled and the leaves written so far
+	cells    [][]byte
+	used     int
+	leafKey  int64
+	leaves   []child
+	rowid    int64
+	index    []indexEntry
+	seen     map[[3]int]bool
+	metadata [][2]string
+}
+
+// child is a page of a table b-tree and the largest rowid it holds.
+type child struct {
+	page uint32
+	key  int64
+}
+
+// indexEntry is a row of the tile index.
+type indexEntry struct {
+	zoom, column, row int
+	rowid             int64
+}
+
+// Create creates an MBTiles file at path.
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	// page 1 holds the header and schema, it is written on close
+	return &Writer{f: f, pages: 1, seen: map[[3]int]bool{}}, nil
+}
+
+// SetMetadata sets a metadata value, such as name, format, bounds, center,
+// minzoom and maxzoom.
+func (w *Writer) SetMetadata(name, value string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, m := range w.metadata {
+		if m[0] == name {
+			w.metadata[i][1] = value
+			return
+		}
+	}
+	w.metadata = append(w.metadata, [2]string{name, value})
+}
+
+// Write adds the encoded image of the XYZ tile z/x/y, rows counted from the
+// north as in slippy maps. It is stored in the TMS row of MBTiles.
+func (w *Writer) Write(z, x, y int, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	row := 1<<uint(z) - 1 - y
+	key := [3]int{z, x, row}
+	if w.seen[key] {
+		return fmt.Errorf("mbtiles: duplicate tile %d/%d/%d", z, x, y)
+	}
+	w.seen[key] = true
+
+	w.rowid++
+	cell, err := w.tableCell(w.rowid, record(z, x, row, data))
+	if err != nil {
+		return err
+	}
+	if w.used+len(cell)+2 > pageCapacity(tableLeaf) {
+		if err := w.flushLeaf(); err != nil {
+			return err
+		}
+	}
+	w.cells = append(w.cells, cell)
+	w.used += len(cell) + 2
+	w.leafKey = w.rowid
+	w.index = append(w.index, indexEntry{z, x, row, w.rowid})
+	return nil
+}
+
+// flushLeaf writes the leaf of the tiles table being filled.
+func (w *Writer) flushLeaf() error {
+	if len(w.cells) == 0 {
+		return nil
+	}
+	page := w.allocate()
+	if err := w.writePage(page, encodePage(tableLeaf, w.cells, 0, 0)); err != nil {
+		return err
+	}
+	w.leaves = append(w.leaves, child{page, w.leafKey})
+	w.cells, w.used = nil, 0
+	return nil
+}
+
+// allocate returns the number of a new page.
+func (w *Writer) allocate() uint32 {
+	w.pages++
+	return w.pages
+}
+
+func (w *Writer) writePage(n uint32, page []byte) error {
+	_, err := w.f.WriteAt(page, int64(n-1)*pageSize)
+	return err
+}
+
+// tableCell returns the leaf cell of a table row, writing the end of large
+// payloads to overflow pages.
+func (w *Writer) tableCell(rowid int64, payload []byte) ([]byte, error) {
+	cell := putVarint(nil, uint64(len(payload)))
+	cell = putVarint(cell, uint64(rowid))
+	local := localPayload(len(payload), false)
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell, nil
+	}
+
+	rest := payload[local:]
+	first := w.allocate()
+	for page := first; len(rest) > 0; {
+		n := len(rest)
+		if n > pageSize-4 {
+			n = pageSize - 4
+		}
+		var next uint32
+		if n < len(rest) {
+			next = w.allocate()
+		}
+		data := make([]byte, pageSize)
+		binary.BigEndian.PutUint32(data, next)
+		copy(data[4:], rest[:n])
+		if err := w.writePage(page, data); err != nil {
+			return nil, err
+		}
+		rest, page = rest[n:], next
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], first)
+	return append(cell, b[:]...), nil
+}
+
+// table writes a table of small rows and returns its root page.
+func (w *Writer) table(rows [][]byte) (uint32, error) {
+	leaves := []child{}
+	cells := [][]byte{}
+	used := 0
+	for i, r := range rows {
+		cell, err := w.tableCell(int64(i+1), r)
+		if err != nil {
+			return 0, err
+		}
+		if used+len(cell)+2 > pageCapacity(tableLeaf) {
+			page := w.allocate()
+			if err := w.writePage(page, encodePage(tableLeaf, cells, 0, 0)); err != nil {
+				return 0, err
+			}
+			leaves = append(leaves, child{page, int64(i)})
+			cells, used = nil, 0
+		}
+		cells = append(cells, cell)
+		used += len(cell) + 2
+	}
+	if len(cells) > 0 || len(leaves) == 0 {
+		page := w.allocate()
+		if err := w.writePage(page, encodePage(tableLeaf, cells, 0, 0)); err != nil {
+			return 0, err
+		}
+		leaves = append(leaves, child{page, int64(len(rows))})
+	}
+	return w.tableInteriors(leaves)
+}
+
+// tableInteriors writes the interior pages of a table b-tree over its leaves
+// and returns the root page.
+func (w *Writer) tableInteriors(level []child) (uint32, error) {
+	// a child pointer and the largest varint key
+	per := pageCapacity(tableInterior) / (4 + 9 + 2)
+	for len(level) > 1 {
+		next := []child{}
+		i := 0
+		for _, n := range even(len(level), ceilDiv(len(level), per+1)) {
+			group := level[i : i+n]
+			i += n
+			cells := [][]byte{}
+			for _, c := range group[:n-1] {
+				var b [4]byte
+				binary.BigEndian.PutUint32(b[:], c.page)
+				cells = append(cells, putVarint(b[:], uint64(c.key)))
+			}
+			page := w.allocate()
+			if err := w.writePage(page, encodePage(tableInterior, cells, group[n-1].page, 0)); err != nil {
+				return 0, err
+			}
+			next = append(next, child{page, group[n-1].key})
+		}
+		level = next
+	}
+	return level[0].page, nil
+}
+
+// tileIndex writes the index of the tiles and returns its root page. Unlike
+// tables, the entries of an index b-tree are spread over the interior pages,
+// every interior cell holding the entry between its child and the next.
+func (w *Writer) tileIndex() (uint32, error) {
+	sort.Slice(w.index, func(i, j int) bool {
+		a, b := w.index[i], w.index[j]
+		if a.zoom != b.zoom {
+			return a.zoom < b.zoom
+		}
+		if a.column != b.column {
+			return a.column < b.column
+		}
+		return a.row < b.row
+	})
+	entries := make([][]byte, len(w.index))
+	largest := 0
+	for i, e := range w.index {
+		payload := record(e.zoom, e.column, e.row, e.rowid)
+		entries[i] = append(putVarint(nil, uint64(len(payload))), payload...)
+		if len(entries[i]) > largest {
+			largest = len(entries[i])
+		}
+	}
+	if len(entries) == 0 {
+		page := w.allocate()
+		return page, w.writePage(page, encodePage(indexLeaf, nil, 0, 0))
+	}
+
+	// leaves with an entry promoted to the level above between each of them
+	per := pageCapacity(indexInterior) / (largest + 4 + 2)
+	leaves := ceilDiv(len(entries)+1, per+1)
+	children := []uint32{}
+	dividers := [][]byte{}
+	i := 0
+	for l, n := range even(len(entries)-(leaves-1), leaves) {
+		page := w.allocate()
+		if err := w.writePage(page, encodePage(indexLeaf, entries[i:i+n], 0, 0)); err != nil {
+			return 0, err
+		}
+		children = append(children, page)
+		i += n
+		if l < leaves-1 {
+			dividers = append(dividers, entries[i])
+			i++
+		}
+	}
+
+	for len(children) > 1 {
+		nextChildren := []uint32{}
+		nextDividers := [][]byte{}
+		i := 0
+		groups := even(len(children), ceilDiv(len(children), per+1))
+		for g, n := range groups {
+			cells := [][]byte{}
+			for j := i; j < i+n-1; j++ {
+				var b [4]byte
+				binary.BigEndian.PutUint32(b[:], children[j])
+				cells = append(cells, append(b[:], dividers[j]...))
+			}
+			page := w.allocate()
+			if err := w.writePage(page, encodePage(indexInterior, cells, children[i+n-1], 0)); err != nil {
+				return 0, err
+			}
+			nextChildren = append(nextChildren, page)
+			i += n
+			if g < len(groups)-1 {
+				nextDividers = append(nextDividers, dividers[i-1])
+			}
+		}
+		children, dividers = nextChildren, nextDividers
+	}
+	return children[0], nil
+}
+
+// Close writes the metadata, index and schema and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.close()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *Writer) close() error {
+	if err := w.flushLeaf(); err != nil {
+		return err
+	}
+	if len(w.leaves) == 0 {
+		// an empty tiles table is a single empty leaf
+		page := w.allocate()
+		if err := w.writePage(page, encodePage(tableLeaf, nil, 0, 0)); err != nil {
+			return err
+		}
+		w.leaves = append(w.leaves, child{page, 0})
+	}
+	tiles, err := w.tableInteriors(w.leaves)
+	if err != nil {
+		return err
+	}
+
+	rows := [][]byte{}
+	for _, m := range w.metadata {
+		rows = append(rows, record(m[0], m[1]))
+	}
+	metadata, err := w.table(rows)
+	if err != nil {
+		return err
+	}
+	index, err := w.tileIndex()
+	if err != nil {
+		return err
+	}
+
+	// the schema table is rooted on page 1 after the database header
+	cells := [][]byte{}
+	for i, s := range schema {
+		root := []uint32{metadata, tiles, index}[i]
+		payload := record(s.kind, s.name, s.table, int64(root), s.sql)
+		cells = append(cells, append(putVarint(putVarint(nil, uint64(len(payload))), uint64(i+1)), payload...))
+	}
+	page := encodePage(tableLeaf, cells, 0, fileHeaderSize)
+	copy(page, w.header())
+	return w.writePage(1, page)
+}
+
+// header returns the database header.
+func (w *Writer) header() []byte {
+	h := make([]byte, fileHeaderSize)
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], pageSize)
+	// legacy rollback journal, no reserved space and the fixed payload fractions
+	copy(h[18:], []byte{1, 1, 0, 64, 32, 32})
+	binary.BigEndian.PutUint32(h[24:], 1) // change counter
+	binary.BigEndian.PutUint32(h[28:], w.pages)
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[68:], applicationID)
+	binary.BigEndian.PutUint32(h[92:], 1) // version valid for the change counter
+	binary.BigEndian.PutUint32(h[96:], 3040001)
+	return h
+}
@@ -0,0 +1,264 @@
+package mbtiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// database reads back the b-trees of a written file.
+type database []byte
+
+func (db database) page(n uint32) []byte {
+	return db[int(n-1)*pageSize : int(n)*pageSize]
+}
+
+func varint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// decode returns the values of a record as int64, string or []byte.
+func decode(rec []byte) []interface{} {
+	headerSize, n := varint(rec)
+	types := []uint64{}
+	for n < int(headerSize) {
+		t, m := varint(rec[n:])
+		types = append(types, t)
+		n += m
+	}
+	body := rec[headerSize:]
+	values := []interface{}{}
+	for _, t := range types {
+		switch {
+		case t == 8 || t == 9:
+			values = append(values, int64(t-8))
+		case t >= 1 && t <= 6:
+			size := []int{0, 1, 2, 3, 4, 6, 8}[t]
+			var v int64
+			for _, b := range body[:size] {
+				v = v<<8 | int64(b)
+			}
+			// sign extend
+			shift := uint(64 - 8*size)
+			values = append(values, v<<shift>>shift)
+			body = body[size:]
+		case t >= 12 && t%2 == 0:
+			values = append(values, append([]byte{}, body[:(t-12)/2]...))
+			body = body[(t-12)/2:]
+		case t >= 13:
+			values = append(values, string(body[:(t-13)/2]))
+			body = body[(t-13)/2:]
+		}
+	}
+	return values
+}
+
+// cells returns the page type, the cells and the right child of a page.
+func (db database) cells(n uint32) (byte, [][]byte, uint32) {
+	p := db.page(n)
+	offset := 0
+	if n == 1 {
+		offset = fileHeaderSize
+	}
+	kind := p[offset]
+	count := int(binary.BigEndian.Uint16(p[offset+3:]))
+	header := 8
+	var right uint32
+	if kind == tableInterior || kind == indexInterior {
+		header = 12
+		right = binary.BigEndian.Uint32(p[offset+8:])
+	}
+	cells := [][]byte{}
+	for i := 0; i < count; i++ {
+		cells = append(cells, p[binary.BigEndian.Uint16(p[offset+header+2*i:]):])
+	}
+	return kind, cells, right
+}
+
+// payload reads a payload of size bytes starting in cell, following overflow
+// pages.
+func (db database) payload(cell []byte, size int, index bool) []byte {
+	local := localPayload(size, index)
+	data := append([]byte{}, cell[:local]...)
+	if local == size {
+		return data
+	}
+	next := binary.BigEndian.Uint32(cell[local:])
+	for next != 0 {
+		p := db.page(next)
+		n := size - len(data)
+		if n > pageSize-4 {
+			n = pageSize - 4
+		}
+		data = append(data, p[4:4+n]...)
+		next = binary.BigEndian.Uint32(p)
+	}
+	return data
+}
+
+// table returns the rowids and records of a table in order.
+func (db database) table(root uint32, fn func(rowid int64, values []interface{})) {
+	kind, cells, right := db.cells(root)
+	if kind == tableInterior {
+		for _, c := range cells {
+			db.table(binary.BigEndian.Uint32(c), fn)
+		}
+		db.table(right, fn)
+		return
+	}
+	for _, c := range cells {
+		size, n := varint(c)
+		rowid, m := varint(c[n:])
+		fn(int64(rowid), decode(db.payload(c[n+m:], int(size), false)))
+	}
+}
+
+// index returns the entries of an index in order.
+func (db database) index(root uint32, fn func(values []interface{})) {
+	kind, cells, right := db.cells(root)
+	for _, c := range cells {
+		if kind == indexInterior {
+			db.index(binary.BigEndian.Uint32(c), fn)
+			c = c[4:]
+		}
+		size, n := varint(c)
+		fn(decode(db.payload(c[n:], int(size), true)))
+	}
+	if kind == indexInterior {
+		db.index(right, fn)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mbtiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "radar.mbtiles")
+
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetMetadata("name", "KTLX REF")
+	w.SetMetadata("format", "png")
+	w.SetMetadata("name", "KTLX")
+
+	// enough tiles for interior pages, large enough for overflow pages, added
+	// out of index order
+	const tiles = 3000
+	tile := func(i int) (z, x, y int, data []byte) {
+		data = bytes.Repeat([]byte{byte(i)}, 100+(i%7)*1500)
+		return 10, 300 - i/50, i % 50, data
+	}
+	for i := 0; i < tiles; i++ {
+		z, x, y, data := tile(i)
+		if err := w.Write(z, x, y, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(10, 300, 0, nil); err == nil {
+		t.Error("expected an error writing a duplicate tile")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := database(data)
+	if !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		t.Fatal("missing sqlite header")
+	}
+	if pages := binary.BigEndian.Uint32(data[28:]); int(pages)*pageSize != len(data) {
+		t.Errorf("%d pages, file is %d bytes", pages, len(data))
+	}
+	if id := binary.BigEndian.Uint32(data[68:]); id != applicationID {
+		t.Errorf("application id %x", id)
+	}
+
+	roots := map[string]uint32{}
+	db.table(1, func(rowid int64, values []interface{}) {
+		roots[values[1].(string)] = uint32(values[3].(int64))
+	})
+	if len(roots) != 3 {
+		t.Fatalf("schema = %v", roots)
+	}
+
+	metadata := map[string]string{}
+	db.table(roots["metadata"], func(rowid int64, values []interface{}) {
+		metadata[values[0].(string)] = values[1].(string)
+	})
+	if metadata["name"] != "KTLX" || metadata["format"] != "png" || len(metadata) != 2 {
+		t.Errorf("metadata = %v", metadata)
+	}
+
+	rows := 0
+	db.table(roots["tiles"], func(rowid int64, values []interface{}) {
+		rows++
+		if rowid != int64(rows) {
+			t.Fatalf("rowid %d, want %d", rowid, rows)
+		}
+		z, x, y, want := tile(rows - 1)
+		// rows are stored south up
+		if values[0].(int64) != int64(z) || values[1].(int64) != int64(x) || values[2].(int64) != int64(1<<10-1-y) {
+			t.Fatalf("tile %d = %v", rows, values[:3])
+		}
+		if !bytes.Equal(values[3].([]byte), want) {
+			t.Fatalf("tile %d data differs", rows)
+		}
+	})
+	if rows != tiles {
+		t.Errorf("%d tiles, want %d", rows, tiles)
+	}
+
+	entries := 0
+	var previous []interface{}
+	db.index(roots["tile_index"], func(values []interface{}) {
+		entries++
+		if previous != nil {
+			less := previous[1].(int64) < values[1].(int64) ||
+				previous[1].(int64) == values[1].(int64) && previous[2].(int64) < values[2].(int64)
+			if !less {
+				t.Fatalf("index entry %v after %v", values, previous)
+			}
+		}
+		previous = values
+	})
+	if entries != tiles {
+		t.Errorf("%d index entries, want %d", entries, tiles)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	values := decode(record(0, 1, -2, 300, int64(1)<<40, "tiles", []byte{1, 2}, nil))
+	want := []interface{}{int64(0), int64(1), int64(-2), int64(300), int64(1) << 40, "tiles", []byte{1, 2}}
+	for i, w := range want {
+		if b, ok := w.([]byte); ok {
+			if !bytes.Equal(values[i].([]byte), b) {
+				t.Errorf("value %d = %v, want %v", i, values[i], w)
+			}
+		} else if values[i] != w {
+			t.Errorf("value %d = %v, want %v", i, values[i], w)
+		}
+	}
+
+	for _, v := range []uint64{0, 127, 128, 1 << 20, 1<<56 - 1, 1 << 60} {
+		if got, _ := varint(putVarint(nil, v)); got != v {
+			t.Errorf("varint %d decoded as %d", v, got)
+		}
+	}
+}
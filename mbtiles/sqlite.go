@@ -0,0 +1,182 @@
+package mbtiles
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// An MBTiles file is an SQLite database. The database is written directly in
+// the SQLite file format: every table and index is a b-tree of fixed size
+// pages, built bottom up once all rows are known, except for the leaves of the
+// tiles table which are written as the tiles arrive.
+
+const (
+	pageSize = 4096
+
+	// b-tree page types
+	indexInterior = 0x02
+	tableInterior = 0x05
+	indexLeaf     = 0x0a
+	tableLeaf     = 0x0d
+
+	// fileHeaderSize is the size of the database header at the start of page 1.
+	fileHeaderSize = 100
+)
+
+// putVarint appends the SQLite varint encoding of v to b, big endian groups of
+// 7 bits with the high bit set on all but the last byte, and a ninth byte of 8
+// bits for values of more than 56 bits.
+func putVarint(b []byte, v uint64) []byte {
+	if v>>56 != 0 {
+		for i := 7; i >= 0; i-- {
+			b = append(b, byte(v>>(uint(i)*7+8))|0x80)
+		}
+		return append(b, byte(v))
+	}
+	var groups [8]byte
+	n := 0
+	for {
+		groups[n] = byte(v & 0x7f)
+		n++
+		v >>= 7
+		if v == 0 {
+			break
+		}
+	}
+	for i := n - 1; i > 0; i-- {
+		b = append(b, groups[i]|0x80)
+	}
+	return append(b, groups[0])
+}
+
+// record returns the SQLite record of the values, which may be int, int64,
+// string, []byte or nil.
+func record(values ...interface{}) []byte {
+	types := []byte{}
+	body := []byte{}
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = putVarint(types, 0)
+		case int:
+			types, body = appendInt(types, body, int64(v))
+		case int64:
+			types, body = appendInt(types, body, v)
+		case string:
+			types = putVarint(types, uint64(2*len(v)+13))
+			body = append(body, v...)
+		case []byte:
+			types = putVarint(types, uint64(2*len(v)+12))
+			body = append(body, v...)
+		default:
+			panic(fmt.Sprintf("mbtiles: unsupported record value %T", v))
+		}
+	}
+
+	// the header size includes its own varint
+	size := len(types) + 1
+	if len(putVarint(nil, uint64(size))) > 1 {
+		size++
+	}
+	rec := putVarint(nil, uint64(size))
+	rec = append(rec, types...)
+	return append(rec, body...)
+}
+
+// appendInt appends the serial type and big endian bytes of the smallest
+// integer encoding of v.
+func appendInt(types, body []byte, v int64) ([]byte, []byte) {
+	if v == 0 || v == 1 {
+		// serial types 8 and 9 hold the constants 0 and 1
+		return putVarint(types, uint64(8+v)), body
+	}
+	serial, size := uint64(6), 8
+	for _, s := range []struct {
+		serial uint64
+		size   int
+	}{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 6}} {
+		limit := int64(1) << (uint(s.size)*8 - 1)
+		if v >= -limit && v < limit {
+			serial, size = s.serial, s.size
+			break
+		}
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	return putVarint(types, serial), append(body, b[8-size:]...)
+}
+
+// localPayload returns the number of bytes of a payload of n bytes stored in a
+// b-tree cell, the rest is stored in overflow pages.
+func localPayload(n int, index bool) int {
+	u := pageSize
+	x := u - 35
+	if index {
+		x = (u-12)*64/255 - 23
+	}
+	if n <= x {
+		return n
+	}
+	m := (u-12)*32/255 - 23
+	k := m + (n-m)%(u-4)
+	if k <= x {
+		return k
+	}
+	return m
+}
+
+// encodePage returns a b-tree page of the given type holding the cells, the
+// header starting at offset, which is fileHeaderSize on page 1. right is the
+// right most child of interior pages.
+func encodePage(kind byte, cells [][]byte, right uint32, offset int) []byte {
+	page := make([]byte, pageSize)
+	headerSize := 8
+	if kind == indexInterior || kind == tableInterior {
+		headerSize = 12
+		binary.BigEndian.PutUint32(page[offset+8:], right)
+	}
+
+	content := pageSize
+	pointers := offset + headerSize
+	for i, c := range cells {
+		content -= len(c)
+		copy(page[content:], c)
+		binary.BigEndian.PutUint16(page[pointers+2*i:], uint16(content))
+	}
+	if pointers+2*len(cells) > content {
+		panic("mbtiles: page overflow")
+	}
+
+	page[offset] = kind
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	// a content area starting at the end of the page is written as 0 only
+	// for 65536 byte pages
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(content))
+	return page
+}
+
+// pageCapacity is the space for cells and their pointers on a page.
+func pageCapacity(kind byte) int {
+	if kind == indexInterior || kind == tableInterior {
+		return pageSize - 12
+	}
+	return pageSize - 8
+}
+
+// even divides n items into the given number of groups as evenly as
+// possible, returning the size of each group.
+func even(n, groups int) []int {
+	sizes := make([]int, groups)
+	for i := range sizes {
+		sizes[i] = n / groups
+		if i < n%groups {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
+
+// ceilDiv returns n / d rounded up.
+func ceilDiv(n, d int) int {
+	return (n + d - 1) / d
+}
@@ -0,0 +1,364 @@
+// Package netcdf writes NetCDF classic and 64-bit offset format files.
+//
+// Only fixed size variables are supported, there is no record (unlimited)
+// dimension. Files are built in memory with New, AddDimension, AddAttribute
+// and AddVariable and written out with WriteTo.
+package netcdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is a NetCDF external data type.
+type Type int32
+
+// NetCDF classic data types.
+const (
+	Byte   Type = 1
+	Char   Type = 2
+	Short  Type = 3
+	Int    Type = 4
+	Float  Type = 5
+	Double Type = 6
+)
+
+// Size returns the size in bytes of a value of the type.
+func (t Type) Size() int {
+	switch t {
+	case Byte, Char:
+		return 1
+	case Short:
+		return 2
+	case Int, Float:
+		return 4
+	case Double:
+		return 8
+	}
+	return 0
+}
+
+// header tags
+const (
+	tagDimension = 0x0A
+	tagVariable  = 0x0B
+	tagAttribute = 0x0C
+)
+
+// Dimension is a named dimension of a file.
+type Dimension struct {
+	Name   string
+	Length int
+}
+
+// Attribute is a named attribute of a file or variable. Value is a string,
+// an int8, int16, int32, int, float32 or float64, or a slice of one of these
+// numeric types.
+type Attribute struct {
+	Name  string
+	Value interface{}
+}
+
+// Variable is a named array of values over some of the dimensions of a file.
+type Variable struct {
+	Name       string
+	Dimensions []string
+	Attributes []Attribute
+	// Data is a []int8, []byte (characters), string, []int16, []int32,
+	// []float32 or []float64 holding the values in row major order.
+	Data interface{}
+}
+
+// AddAttribute adds an attribute to the variable.
+func (v *Variable) AddAttribute(name string, value interface{}) *Variable {
+	v.Attributes = append(v.Attributes, Attribute{name, value})
+	return v
+}
+
+// File is a NetCDF file being built.
+type File struct {
+	Dimensions []Dimension
+	Attributes []Attribute
+	Variables  []*Variable
+}
+
+// New returns an empty File.
+func New() *File {
+	return &File{}
+}
+
+// AddDimension adds a dimension of the given length. The length has to be
+// positive, a length of 0 denotes the record dimension, which is not supported.
+func (f *File) AddDimension(name string, length int) {
+	f.Dimensions = append(f.Dimensions, Dimension{name, length})
+}
+
+// AddAttribute adds a global attribute.
+func (f *File) AddAttribute(name string, value interface{}) {
+	f.Attributes = append(f.Attributes, Attribute{name, value})
+}
+
+// AddVariable adds a variable over the named dimensions. A variable without
+// dimensions is a scalar.
+func (f *File) AddVariable(name string, dimensions []string, data interface{}) *Variable {
+	v := &Variable{Name: name, Dimensions: dimensions, Data: data}
+	f.Variables = append(f.Variables, v)
+	return v
+}
+
+// dimension returns the index of the named dimension.
+func (f *File) dimension(name string) (int, bool) {
+	for i, d := range f.Dimensions {
+		if d.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// values returns the type and number of values of attribute or variable data.
+func values(data interface{}) (Type, int, error) {
+	switch d := data.(type) {
+	case string:
+		return Char, len(d), nil
+	case []byte:
+		return Char, len(d), nil
+	case int8:
+		return Byte, 1, nil
+	case []int8:
+		return Byte, len(d), nil
+	case int16:
+		return Short, 1, nil
+	case []int16:
+		return Short, len(d), nil
+	case int32, int:
+		return Int, 1, nil
+	case []int32:
+		return Int, len(d), nil
+	case []int:
+		return Int, len(d), nil
+	case float32:
+		return Float, 1, nil
+	case []float32:
+		return Float, len(d), nil
+	case float64:
+		return Double, 1, nil
+	case []float64:
+		return Double, len(d), nil
+	}
+	return 0, 0, fmt.Errorf("netcdf: unsupported data type %T", data)
+}
+
+// padding returns the number of bytes needed to pad n bytes to a multiple of 4.
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
+
+// writer accumulates big endian values.
+type writer struct {
+	bytes.Buffer
+}
+
+func (w *writer) int32(v int32) {
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *writer) pad(n int) {
+	w.Write(make([]byte, padding(n)))
+}
+
+func (w *writer) name(s string) {
+	w.int32(int32(len(s)))
+	w.WriteString(s)
+	w.pad(len(s))
+}
+
+// data writes the values of an attribute or variable without padding.
+func (w *writer) data(data interface{}) {
+	switch d := data.(type) {
+	case string:
+		w.WriteString(d)
+	case []byte:
+		w.Write(d)
+	case int:
+		w.int32(int32(d))
+	case []int:
+		for _, v := range d {
+			w.int32(int32(v))
+		}
+	default:
+		binary.Write(w, binary.BigEndian, d)
+	}
+}
+
+func (w *writer) attributes(attrs []Attribute) error {
+	if len(attrs) == 0 {
+		w.int32(0)
+		w.int32(0)
+		return nil
+	}
+	w.int32(tagAttribute)
+	w.int32(int32(len(attrs)))
+	for _, a := range attrs {
+		t, n, err := values(a.Value)
+		if err != nil {
+			return fmt.Errorf("netcdf: attribute %s: %s", a.Name, err)
+		}
+		w.name(a.Name)
+		w.int32(int32(t))
+		w.int32(int32(n))
+		w.data(a.Value)
+		w.pad(n * t.Size())
+	}
+	return nil
+}
+
+// header encodes the header of the file with the given data offsets. offsets
+// are 64 bits wide when wide is set.
+func (f *File) header(begins []int64, wide bool) ([]byte, error) {
+	w := &writer{}
+	w.WriteString("CDF")
+	if wide {
+		w.WriteByte(2)
+	} else {
+		w.WriteByte(1)
+	}
+	// number of records, there is no record dimension
+	w.int32(0)
+
+	if len(f.Dimensions) == 0 {
+		w.int32(0)
+		w.int32(0)
+	} else {
+		w.int32(tagDimension)
+		w.int32(int32(len(f.Dimensions)))
+		for _, d := range f.Dimensions {
+			w.name(d.Name)
+			w.int32(int32(d.Length))
+		}
+	}
+
+	if err := w.attributes(f.Attributes); err != nil {
+		return nil, err
+	}
+
+	if len(f.Variables) == 0 {
+		w.int32(0)
+		w.int32(0)
+		return w.Bytes(), nil
+	}
+	w.int32(tagVariable)
+	w.int32(int32(len(f.Variables)))
+	for i, v := range f.Variables {
+		t, _, err := values(v.Data)
+		if err != nil {
+			return nil, fmt.Errorf("netcdf: variable %s: %s", v.Name, err)
+		}
+		w.name(v.Name)
+		w.int32(int32(len(v.Dimensions)))
+		for _, name := range v.Dimensions {
+			id, _ := f.dimension(name)
+			w.int32(int32(id))
+		}
+		if err := w.attributes(v.Attributes); err != nil {
+			return nil, err
+		}
+		w.int32(int32(t))
+		size, _ := f.size(v)
+		// the size is clamped for variables over 4 GiB, allowed for the last variable
+		if size > math.MaxUint32-3 {
+			w.int32(-1)
+		} else {
+			w.int32(int32(uint32(size + padding(size))))
+		}
+		if wide {
+			binary.Write(w, binary.BigEndian, begins[i])
+		} else {
+			w.int32(int32(begins[i]))
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// size returns the size in bytes of the data of a variable, checking it
+// matches its dimensions.
+func (f *File) size(v *Variable) (int, error) {
+	t, n, err := values(v.Data)
+	if err != nil {
+		return 0, fmt.Errorf("netcdf: variable %s: %s", v.Name, err)
+	}
+	expected := 1
+	for _, name := range v.Dimensions {
+		id, ok := f.dimension(name)
+		if !ok {
+			return 0, fmt.Errorf("netcdf: variable %s: unknown dimension %s", v.Name, name)
+		}
+		expected *= f.Dimensions[id].Length
+	}
+	if n != expected {
+		return 0, fmt.Errorf("netcdf: variable %s: has %d values, dimensions hold %d", v.Name, n, expected)
+	}
+	return n * t.Size(), nil
+}
+
+// WriteTo writes the file to w. The classic format is used unless the file
+// is too large for 32 bit offsets, then the 64-bit offset format is used.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	for _, d := range f.Dimensions {
+		if d.Length <= 0 {
+			return 0, fmt.Errorf("netcdf: dimension %s: invalid length %d", d.Name, d.Length)
+		}
+	}
+
+	sizes := make([]int, len(f.Variables))
+	var total int64
+	for i, v := range f.Variables {
+		size, err := f.size(v)
+		if err != nil {
+			return 0, err
+		}
+		sizes[i] = size + padding(size)
+		total += int64(sizes[i])
+	}
+
+	// the header size doesn't depend on the offsets, only on their width
+	begins := make([]int64, len(f.Variables))
+	header, err := f.header(begins, false)
+	if err != nil {
+		return 0, err
+	}
+	wide := int64(len(header))+total > math.MaxInt32
+	if wide {
+		if header, err = f.header(begins, true); err != nil {
+			return 0, err
+		}
+	}
+	offset := int64(len(header))
+	for i := range f.Variables {
+		begins[i] = offset
+		offset += int64(sizes[i])
+	}
+	if header, err = f.header(begins, wide); err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	for i, v := range f.Variables {
+		data := &writer{}
+		data.data(v.Data)
+		data.Write(make([]byte, sizes[i]-data.Len()))
+		n, err := w.Write(data.Bytes())
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
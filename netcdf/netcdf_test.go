@@ -0,0 +1,59 @@
+package netcdf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	f := New()
+	f.AddDimension("x", 3)
+	f.AddAttribute("title", "ab")
+	f.AddVariable("v", []string{"x"}, []int16{1, 2, 3}).AddAttribute("scale", float32(0.5))
+
+	buf := &bytes.Buffer{}
+	if _, err := f.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		'C', 'D', 'F', 1,
+		0, 0, 0, 0, // numrecs
+		0, 0, 0, 0x0A, 0, 0, 0, 1, // dimension list
+		0, 0, 0, 1, 'x', 0, 0, 0, 0, 0, 0, 3,
+		0, 0, 0, 0x0C, 0, 0, 0, 1, // global attributes
+		0, 0, 0, 5, 't', 'i', 't', 'l', 'e', 0, 0, 0,
+		0, 0, 0, 2, 0, 0, 0, 2, 'a', 'b', 0, 0,
+		0, 0, 0, 0x0B, 0, 0, 0, 1, // variable list
+		0, 0, 0, 1, 'v', 0, 0, 0,
+		0, 0, 0, 1, 0, 0, 0, 0, // dimension ids
+		0, 0, 0, 0x0C, 0, 0, 0, 1, // variable attributes
+		0, 0, 0, 5, 's', 'c', 'a', 'l', 'e', 0, 0, 0,
+		0, 0, 0, 5, 0, 0, 0, 1, 0x3F, 0, 0, 0,
+		0, 0, 0, 3, // short
+		0, 0, 0, 8, // vsize
+		0, 0, 0, 128, // begin
+		0, 1, 0, 2, 0, 3, 0, 0, // data
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("got\n%v\nwant\n%v", buf.Bytes(), expected)
+	}
+}
+
+func TestWriteToMismatch(t *testing.T) {
+	f := New()
+	f.AddDimension("x", 3)
+	f.AddVariable("v", []string{"x"}, []float32{1, 2})
+	if _, err := f.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("expected an error for data not matching the dimensions")
+	}
+}
+
+func TestWriteToEmptyDimension(t *testing.T) {
+	f := New()
+	f.AddDimension("x", 0)
+	f.AddVariable("v", []string{"x"}, []float32{})
+	if _, err := f.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("expected an error for a dimension of length 0")
+	}
+}
@@ -0,0 +1,342 @@
+// Package parquet writes flat Apache Parquet files, plain encoded and
+// uncompressed, readable by pandas, duckdb, Spark and other tools.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Type is the type of the values of a column.
+type Type int
+
+// Column types and the Go types of their values.
+const (
+	Int32     Type = iota // int32
+	Int64                 // int64
+	Float                 // float32
+	Double                // float64
+	String                // string
+	Timestamp             // time.Time, stored as milliseconds since the epoch in UTC
+)
+
+// physical types
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeFloat     = 4
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// encodings, repetition and page types
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	pageData = 0
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+)
+
+// DefaultRowGroupSize is the number of rows buffered before they are written
+// as a row group.
+const DefaultRowGroupSize = 1 << 17
+
+var magic = []byte("PAR1")
+
+// Column is a column of a file.
+type Column struct {
+	Name string
+	Type Type
+	// Optional columns accept nil values.
+	Optional bool
+}
+
+// Writer writes rows to a Parquet file. Rows are buffered in memory and
+// written a row group at a time, the file metadata when the writer is closed.
+type Writer struct {
+	// RowGroupSize is the number of rows of each row group.
+	RowGroupSize int
+
+	w         *countingWriter
+	columns   []Column
+	chunks    []chunk
+	rows      int
+	rowGroups thriftList
+	totalRows int64
+}
+
+// chunk holds the buffered values of a column.
+type chunk struct {
+	values bytes.Buffer
+	// definition levels, 1 for values and 0 for nulls
+	defs []byte
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewWriter returns a Writer of a file with the given columns.
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("parquet: no columns")
+	}
+	for _, c := range columns {
+		if c.Type < Int32 || c.Type > Timestamp {
+			return nil, fmt.Errorf("parquet: invalid type of column %s", c.Name)
+		}
+	}
+	pw := &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            &countingWriter{w: w},
+		columns:      columns,
+		chunks:       make([]chunk, len(columns)),
+	}
+	if _, err := pw.w.Write(magic); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Write adds a row holding a value for every column, nil for nulls.
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: %d values for %d columns", len(row), len(w.columns))
+	}
+	// check every value before buffering any so a bad row leaves no trace
+	for i, c := range w.columns {
+		if err := check(c, row[i]); err != nil {
+			return err
+		}
+	}
+	for i, c := range w.columns {
+		ch := &w.chunks[i]
+		if row[i] == nil {
+			ch.defs = append(ch.defs, 0)
+			continue
+		}
+		if c.Optional {
+			ch.defs = append(ch.defs, 1)
+		}
+		var b [8]byte
+		switch v := row[i].(type) {
+		case int32:
+			binary.LittleEndian.PutUint32(b[:], uint32(v))
+			ch.values.Write(b[:4])
+		case int64:
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			ch.values.Write(b[:])
+		case float32:
+			binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+			ch.values.Write(b[:4])
+		case float64:
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+			ch.values.Write(b[:])
+		case string:
+			binary.LittleEndian.PutUint32(b[:], uint32(len(v)))
+			ch.values.Write(b[:4])
+			ch.values.WriteString(v)
+		case time.Time:
+			ms := v.UnixNano() / int64(time.Millisecond)
+			binary.LittleEndian.PutUint64(b[:], uint64(ms))
+			ch.values.Write(b[:])
+		}
+	}
+	w.rows++
+	if w.rows >= w.RowGroupSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// check returns an error if v is not a value of column c.
+func check(c Column, v interface{}) error {
+	if v == nil {
+		if !c.Optional {
+			return fmt.Errorf("parquet: null value in required column %s", c.Name)
+		}
+		return nil
+	}
+	ok := false
+	switch v.(type) {
+	case int32:
+		ok = c.Type == Int32
+	case int64:
+		ok = c.Type == Int64
+	case float32:
+		ok = c.Type == Float
+	case float64:
+		ok = c.Type == Double
+	case string:
+		ok = c.Type == String
+	case time.Time:
+		ok = c.Type == Timestamp
+	}
+	if !ok {
+		return fmt.Errorf("parquet: value of type %T in column %s", v, c.Name)
+	}
+	return nil
+}
+
+// Flush writes the buffered rows as a row group.
+func (w *Writer) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+	columns := thriftList{}
+	var size int64
+	for i, c := range w.columns {
+		ch := &w.chunks[i]
+		var page bytes.Buffer
+		encodings := thriftList{int32(encodingPlain)}
+		if c.Optional {
+			levels := encodeLevels(ch.defs)
+			var n [4]byte
+			binary.LittleEndian.PutUint32(n[:], uint32(len(levels)))
+			page.Write(n[:])
+			page.Write(levels)
+			encodings = append(encodings, int32(encodingRLE))
+		}
+		page.Write(ch.values.Bytes())
+
+		header := encodeThrift(thriftStruct{
+			1: int32(pageData),
+			2: int32(page.Len()),
+			3: int32(page.Len()),
+			5: thriftStruct{
+				1: int32(w.rows),
+				2: int32(encodingPlain),
+				3: int32(encodingRLE),
+				4: int32(encodingRLE),
+			},
+		})
+
+		offset := w.w.n
+		if _, err := w.w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(page.Bytes()); err != nil {
+			return err
+		}
+		chunkSize := int64(len(header) + page.Len())
+		size += chunkSize
+
+		columns = append(columns, thriftStruct{
+			2: offset,
+			3: thriftStruct{
+				1: int32(physicalType(c.Type)),
+				2: encodings,
+				3: thriftList{c.Name},
+				4: int32(0), // uncompressed
+				5: int64(w.rows),
+				6: chunkSize,
+				7: chunkSize,
+				9: offset,
+			},
+		})
+		ch.values.Reset()
+		ch.defs = ch.defs[:0]
+	}
+
+	w.rowGroups = append(w.rowGroups, thriftStruct{
+		1: columns,
+		2: size,
+		3: int64(w.rows),
+	})
+	w.totalRows += int64(w.rows)
+	w.rows = 0
+	return nil
+}
+
+// Close writes the remaining rows and the file metadata, it does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	schema := thriftList{thriftStruct{4: "schema", 5: int32(len(w.columns))}}
+	for _, c := range w.columns {
+		e := thriftStruct{
+			1: int32(physicalType(c.Type)),
+			3: int32(repetitionRequired),
+			4: c.Name,
+		}
+		if c.Optional {
+			e[3] = int32(repetitionOptional)
+		}
+		switch c.Type {
+		case String:
+			e[6] = int32(convertedUTF8)
+			e[10] = thriftStruct{1: thriftStruct{}}
+		case Timestamp:
+			e[6] = int32(convertedTimestampMillis)
+			// adjusted to UTC, in milliseconds
+			e[10] = thriftStruct{8: thriftStruct{1: true, 2: thriftStruct{1: thriftStruct{}}}}
+		}
+		schema = append(schema, e)
+	}
+
+	footer := encodeThrift(thriftStruct{
+		1: int32(1),
+		2: schema,
+		3: w.totalRows,
+		4: w.rowGroups,
+		6: "go-nexrad",
+	})
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, n[:], magic} {
+		if _, err := w.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func physicalType(t Type) int {
+	switch t {
+	case Int32:
+		return typeInt32
+	case Int64, Timestamp:
+		return typeInt64
+	case Float:
+		return typeFloat
+	case Double:
+		return typeDouble
+	}
+	return typeByteArray
+}
+
+// encodeLevels encodes definition levels of bit width 1 with the RLE part of
+// the RLE/bit-packing hybrid encoding.
+func encodeLevels(levels []byte) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		writeVarint(&buf, uint64(j-i)<<1)
+		buf.WriteByte(levels[i])
+		i = j
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,213 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// thriftReader decodes the compact protocol into thriftStruct, thriftList,
+// int64, bool and []byte values.
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) int() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case compactTrue:
+		return true
+	case compactFalse:
+		return false
+	case compactI32, compactI64:
+		return r.int()
+	case compactBinary:
+		n := int(r.varint())
+		b := r.data[r.pos : r.pos+n]
+		r.pos += n
+		return b
+	case compactList:
+		h := r.data[r.pos]
+		r.pos++
+		n, et := int(h>>4), h&0x0f
+		if n == 15 {
+			n = int(r.varint())
+		}
+		l := thriftList{}
+		for i := 0; i < n; i++ {
+			l = append(l, r.value(et))
+		}
+		return l
+	case compactStruct:
+		s := thriftStruct{}
+		var id int16
+		for {
+			h := r.data[r.pos]
+			r.pos++
+			if h == 0 {
+				return s
+			}
+			if delta := int16(h >> 4); delta != 0 {
+				id += delta
+			} else {
+				id = int16(r.int())
+			}
+			s[id] = r.value(h & 0x0f)
+		}
+	}
+	panic("unexpected type")
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+	in := thriftStruct{
+		1:  int32(-3),
+		2:  thriftList{"a", "b"},
+		4:  int64(1 << 40),
+		5:  true,
+		6:  false,
+		40: thriftStruct{1: thriftStruct{}},
+	}
+	r := &thriftReader{data: encodeThrift(in)}
+	out := r.value(compactStruct).(thriftStruct)
+	if out[1].(int64) != -3 || out[4].(int64) != 1<<40 {
+		t.Errorf("integers = %v, %v", out[1], out[4])
+	}
+	if l := out[2].(thriftList); len(l) != 2 || string(l[1].([]byte)) != "b" {
+		t.Errorf("list = %v", out[2])
+	}
+	if out[5] != true || out[6] != false {
+		t.Errorf("booleans = %v, %v", out[5], out[6])
+	}
+	if _, ok := out[40].(thriftStruct)[1].(thriftStruct); !ok {
+		t.Errorf("struct = %v", out[40])
+	}
+	if r.pos != len(r.data) {
+		t.Errorf("read %d of %d bytes", r.pos, len(r.data))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{
+		{Name: "time", Type: Timestamp},
+		{Name: "azimuth", Type: Float},
+		{Name: "REF", Type: Double, Optional: true},
+		{Name: "station", Type: String},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RowGroupSize = 2
+
+	start := time.Date(2013, 5, 20, 20, 3, 56, 0, time.UTC)
+	refs := []interface{}{42.5, nil, nil, -3.0, 10.0}
+	for i, ref := range refs {
+		if err := w.Write([]interface{}{start.Add(time.Duration(i) * time.Second), float32(i) / 2, ref, "KTLX"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write([]interface{}{start, float32(0), nil, nil}); err == nil {
+		t.Error("expected an error writing null to a required column")
+	}
+	if err := w.Write([]interface{}{start, 0.0, nil, "KTLX"}); err == nil {
+		t.Error("expected an error writing a float64 to a float column")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, magic) || !bytes.HasSuffix(data, magic) {
+		t.Fatal("missing magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	r := &thriftReader{data: data[len(data)-8-footerLength : len(data)-8]}
+	meta := r.value(compactStruct).(thriftStruct)
+
+	if rows := meta[3].(int64); rows != 5 {
+		t.Errorf("%d rows, want 5", rows)
+	}
+	schema := meta[2].(thriftList)
+	if len(schema) != 5 || schema[0].(thriftStruct)[5].(int64) != 4 {
+		t.Fatalf("schema = %v", schema)
+	}
+	ref := schema[3].(thriftStruct)
+	if string(ref[4].([]byte)) != "REF" || ref[1].(int64) != typeDouble || ref[3].(int64) != repetitionOptional {
+		t.Errorf("REF schema = %v", ref)
+	}
+	if ts := schema[1].(thriftStruct); ts[6].(int64) != convertedTimestampMillis {
+		t.Errorf("time schema = %v", ts)
+	}
+
+	rowGroups := meta[4].(thriftList)
+	if len(rowGroups) != 3 {
+		t.Fatalf("%d row groups, want 3", len(rowGroups))
+	}
+
+	// read the values of the REF and time columns back from the pages
+	gotRefs := []interface{}{}
+	gotTimes := []int64{}
+	for _, rg := range rowGroups {
+		columns := rg.(thriftStruct)[1].(thriftList)
+		for ci, c := range columns {
+			md := c.(thriftStruct)[3].(thriftStruct)
+			offset := int(md[9].(int64))
+			pr := &thriftReader{data: data, pos: offset}
+			header := pr.value(compactStruct).(thriftStruct)
+			n := int(header[5].(thriftStruct)[1].(int64))
+			page := data[pr.pos : pr.pos+int(header[2].(int64))]
+			if end := pr.pos + len(page); int64(end-offset) != md[6].(int64) {
+				t.Errorf("column %d size %d, want %d", ci, md[6], end-offset)
+			}
+
+			switch ci {
+			case 0:
+				for i := 0; i < n; i++ {
+					gotTimes = append(gotTimes, int64(binary.LittleEndian.Uint64(page[8*i:])))
+				}
+			case 2:
+				levelsLength := int(binary.LittleEndian.Uint32(page))
+				lr := &thriftReader{data: page[4 : 4+levelsLength]}
+				values := page[4+levelsLength:]
+				for lr.pos < len(lr.data) {
+					run := int(lr.varint() >> 1)
+					level := lr.data[lr.pos]
+					lr.pos++
+					for i := 0; i < run; i++ {
+						if level == 0 {
+							gotRefs = append(gotRefs, nil)
+							continue
+						}
+						gotRefs = append(gotRefs, math.Float64frombits(binary.LittleEndian.Uint64(values)))
+						values = values[8:]
+					}
+				}
+			}
+		}
+	}
+
+	if len(gotRefs) != len(refs) {
+		t.Fatalf("REF = %v, want %v", gotRefs, refs)
+	}
+	for i := range refs {
+		if gotRefs[i] != refs[i] {
+			t.Errorf("REF[%d] = %v, want %v", i, gotRefs[i], refs[i])
+		}
+	}
+	if want := start.Unix()*1000 + 4000; len(gotTimes) != 5 || gotTimes[4] != want {
+		t.Errorf("times = %v, want the last %d", gotTimes, want)
+	}
+}
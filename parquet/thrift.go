@@ -0,0 +1,126 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// Parquet metadata is serialized with the Thrift compact protocol. The
+// structures are built as generic values rather than generated code:
+// thriftStruct, thriftList, int32, int64, bool, string and []byte.
+
+// compact protocol type ids
+const (
+	compactTrue   = 1
+	compactFalse  = 2
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// thriftStruct maps field ids to values, nil values are omitted.
+type thriftStruct map[int16]interface{}
+
+// thriftList is a list of values of the same type.
+type thriftList []interface{}
+
+// encodeThrift returns the compact protocol encoding of s.
+func encodeThrift(s thriftStruct) []byte {
+	var buf bytes.Buffer
+	writeStruct(&buf, s)
+	return buf.Bytes()
+}
+
+func writeStruct(buf *bytes.Buffer, s thriftStruct) {
+	ids := make([]int, 0, len(s))
+	for id, v := range s {
+		if v != nil {
+			ids = append(ids, int(id))
+		}
+	}
+	sort.Ints(ids)
+
+	last := 0
+	for _, id := range ids {
+		v := s[int16(id)]
+		typ := compactType(v)
+		if b, ok := v.(bool); ok && !b {
+			typ = compactFalse
+		}
+		if delta := id - last; delta > 0 && delta <= 15 {
+			buf.WriteByte(byte(delta<<4) | typ)
+		} else {
+			buf.WriteByte(typ)
+			writeVarint(buf, zigzag(int64(id)))
+		}
+		last = id
+		if _, ok := v.(bool); !ok {
+			writeValue(buf, v)
+		}
+	}
+	// stop
+	buf.WriteByte(0)
+}
+
+func compactType(v interface{}) byte {
+	switch v.(type) {
+	case bool:
+		return compactTrue
+	case int32:
+		return compactI32
+	case int64:
+		return compactI64
+	case string, []byte:
+		return compactBinary
+	case thriftList:
+		return compactList
+	case thriftStruct:
+		return compactStruct
+	}
+	panic("parquet: unsupported thrift value")
+}
+
+func writeValue(buf *bytes.Buffer, v interface{}) {
+	// booleans are only written as fields, their value is in the field type
+	switch v := v.(type) {
+	case int32:
+		writeVarint(buf, zigzag(int64(v)))
+	case int64:
+		writeVarint(buf, zigzag(v))
+	case string:
+		writeVarint(buf, uint64(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		writeVarint(buf, uint64(len(v)))
+		buf.Write(v)
+	case thriftList:
+		var typ byte = compactStruct
+		if len(v) > 0 {
+			typ = compactType(v[0])
+		}
+		if len(v) < 15 {
+			buf.WriteByte(byte(len(v)<<4) | typ)
+		} else {
+			buf.WriteByte(0xf0 | typ)
+			writeVarint(buf, uint64(len(v)))
+		}
+		for _, e := range v {
+			writeValue(buf, e)
+		}
+	case thriftStruct:
+		writeStruct(buf, v)
+	}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func writeVarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
@@ -0,0 +1,408 @@
+package products
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// BoundaryOptions configures the detection of gust fronts and other
+// convergence boundaries.
+type BoundaryOptions struct {
+	// MaxElevation is the highest elevation angle in degrees of the sweeps
+	// searched.
+	MaxElevation float64
+	// MaxRange ignores gates beyond this range in meters.
+	MaxRange float64
+	// MinREF and MaxREF bound the reflectivity in dBZ of thin line gates.
+	MinREF, MaxREF float32
+	// ThinLineWidth is the distance in meters on either side of a gate at
+	// which the reflectivity has to drop for the gate to be on a thin line.
+	ThinLineWidth float64
+	// ThinLineEnhancement is the drop in dBZ needed on both sides.
+	ThinLineEnhancement float32
+	// ConvergenceWindow is the distance in meters along the radial over
+	// which the velocity difference is measured.
+	ConvergenceWindow float64
+	// MinConvergence is the radial convergence in 1/s needed.
+	MinConvergence float64
+	// Spacing is the size in meters of the grid cells the detections are
+	// clustered on.
+	Spacing float64
+	// MinLength is the length in meters a boundary needs.
+	MinLength float64
+	// MinElongation is the ratio of the length to the width a boundary needs.
+	MinElongation float64
+	// VertexSpacing is the distance in meters between polyline vertices.
+	VertexSpacing float64
+	// MaxSpeed is the fastest a boundary is assumed to move in m/s when
+	// matching boundaries between volumes.
+	MaxSpeed float64
+	// MaxTurn is the largest change in orientation in degrees between
+	// matched boundaries.
+	MaxTurn float64
+}
+
+// DefaultBoundaryOptions returns options suited to gust fronts within 100 km
+// of the radar.
+func DefaultBoundaryOptions() BoundaryOptions {
+	return BoundaryOptions{
+		MaxElevation:        1,
+		MaxRange:            100000,
+		MinREF:              5,
+		MaxREF:              35,
+		ThinLineWidth:       3000,
+		ThinLineEnhancement: 5,
+		ConvergenceWindow:   2000,
+		MinConvergence:      3e-3,
+		Spacing:             1000,
+		MinLength:           10000,
+		MinElongation:       3,
+		VertexSpacing:       2000,
+		MaxSpeed:            30,
+		MaxTurn:             30,
+	}
+}
+
+// Boundary is a line of convergence or enhanced reflectivity such as a gust
+// front.
+type Boundary struct {
+	Time time.Time
+	// Lat and Lon of the center of the boundary.
+	Lat, Lon float64
+	// Points of the polyline along the boundary as lat, lon pairs.
+	Points [][2]float64
+	// Length in meters along the polyline.
+	Length float64
+	// Orientation of the boundary in degrees clockwise from north, 0 to 180.
+	Orientation float64
+	// ThinLine and Convergence report which signatures were found along the
+	// boundary.
+	ThinLine, Convergence bool
+	// MaxConvergence is the strongest radial convergence in 1/s.
+	MaxConvergence float64
+	// Propagation is the motion of the boundary normal to its orientation,
+	// nil until matched with a boundary of a previous volume.
+	Propagation *StormMotion
+
+	east, north float64
+}
+
+// boundaryThinLine and boundaryConvergence flag the grid cells of detections.
+const (
+	boundaryThinLine = 1 << iota
+	boundaryConvergence
+)
+
+// DetectBoundaries finds boundaries on the lowest sweeps of the volume from
+// thin lines of reflectivity and radial convergence of the velocity.
+func DetectBoundaries(ar2 *archive2.Archive2, opts BoundaryOptions) []Boundary {
+	lat, lon := radarLocation(ar2)
+	flags := NewGrid(lat, lon, opts.MaxRange, opts.Spacing)
+	for i := range flags.Data {
+		flags.Data[i] = 0
+	}
+	convergence := NewGrid(lat, lon, opts.MaxRange, opts.Spacing)
+
+	mark := func(radial *archive2.Message31, el, r float64, flag float32) {
+		az := float64(radial.Header.AzimuthAngle) * degToRad
+		ground := archive2.GroundRange(r, el)
+		if x, y, ok := flags.Cell(ground*math.Sin(az), ground*math.Cos(az)); ok {
+			flags.Set(x, y, float32(int(flags.At(x, y))|int(flag)))
+		}
+	}
+
+	for _, sweep := range distinctSweeps(ar2, "REF") {
+		if sweep.ElevationAngle > opts.MaxElevation {
+			break
+		}
+		for i, radial := range sweep.Radials {
+			for _, g := range thinLineGates(sweep, i, opts) {
+				mark(radial, sweep.ElevationAngle, radial.ReflectivityData.GateRange(g), boundaryThinLine)
+			}
+		}
+	}
+
+	for _, sweep := range distinctSweeps(ar2, "VEL") {
+		if sweep.ElevationAngle > opts.MaxElevation {
+			break
+		}
+		for i, radial := range sweep.Radials {
+			m := radial.VelocityData
+			for g, c := range radialConvergence(sweep.Gates(i, "VEL"), m, float64(radial.RadialData.NyquistVelocity)/100, opts) {
+				if c < opts.MinConvergence {
+					continue
+				}
+				r := m.GateRange(g)
+				mark(radial, sweep.ElevationAngle, r, boundaryConvergence)
+				az := float64(radial.Header.AzimuthAngle) * degToRad
+				ground := archive2.GroundRange(r, sweep.ElevationAngle)
+				if x, y, ok := convergence.Cell(ground*math.Sin(az), ground*math.Cos(az)); ok {
+					if v := convergence.At(x, y); !valid(v) || float64(v) < c {
+						convergence.Set(x, y, float32(c))
+					}
+				}
+			}
+		}
+	}
+
+	boundaries := []Boundary{}
+	visited := make([]bool, len(flags.Data))
+	for start := range flags.Data {
+		if visited[start] || flags.Data[start] == 0 {
+			continue
+		}
+
+		cells := []int{}
+		stack := []int{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			cells = append(cells, c)
+			cx, cy := c%flags.Size, c/flags.Size
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					x, y := cx+dx, cy+dy
+					if x < 0 || y < 0 || x >= flags.Size || y >= flags.Size {
+						continue
+					}
+					n := y*flags.Size + x
+					if !visited[n] && flags.Data[n] != 0 {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+
+		if b, ok := boundary(flags, convergence, cells, opts); ok {
+			b.Time = ar2.VolumeHeader.Date()
+			boundaries = append(boundaries, b)
+		}
+	}
+
+	sort.SliceStable(boundaries, func(i, j int) bool {
+		return boundaries[i].Length > boundaries[j].Length
+	})
+	return boundaries
+}
+
+// thinLineGates returns the gates of radial i of the sweep whose reflectivity
+// exceeds that of the gates on both sides, along the radial or across it, by
+// the thin line enhancement.
+func thinLineGates(sweep *archive2.Sweep, i int, opts BoundaryOptions) []int {
+	radial := sweep.Radials[i]
+	m := radial.ReflectivityData
+	if m == nil {
+		return nil
+	}
+	ref := sweep.Gates(i, "REF")
+	az := float64(radial.Header.AzimuthAngle)
+	k := int(math.Round(opts.ThinLineWidth / float64(m.DataMomentRangeSampleInterval)))
+	if k < 1 {
+		k = 1
+	}
+
+	side := func(v float32, ok bool) float32 {
+		if !ok || !valid(v) {
+			return opts.MinREF
+		}
+		return v
+	}
+
+	gates := []int{}
+	for g, v := range ref {
+		if !valid(v) || v < opts.MinREF || v > opts.MaxREF {
+			continue
+		}
+		r := m.GateRange(g)
+		if r > opts.MaxRange {
+			break
+		}
+
+		// along the radial
+		if g-k >= 0 && g+k < len(ref) {
+			near, far := side(ref[g-k], true), side(ref[g+k], true)
+			if v-near >= opts.ThinLineEnhancement && v-far >= opts.ThinLineEnhancement {
+				gates = append(gates, g)
+				continue
+			}
+		}
+
+		// across the radial
+		da := opts.ThinLineWidth / r * radToDeg
+		left := side(sweep.ValueAt("REF", az-da, r))
+		right := side(sweep.ValueAt("REF", az+da, r))
+		if v-left >= opts.ThinLineEnhancement && v-right >= opts.ThinLineEnhancement {
+			gates = append(gates, g)
+		}
+	}
+	return gates
+}
+
+// radialConvergence returns the radial convergence in 1/s at every gate, the
+// negative of the velocity difference across the convergence window divided
+// by its length. Differences larger than the Nyquist velocity are likely
+// aliased and skipped.
+func radialConvergence(vel []float32, m *archive2.DataMoment, nyquist float64, opts BoundaryOptions) []float64 {
+	conv := make([]float64, len(vel))
+	if m == nil {
+		return conv
+	}
+	half := int(math.Round(opts.ConvergenceWindow / 2 / float64(m.DataMomentRangeSampleInterval)))
+	if half < 1 {
+		half = 1
+	}
+	for g := half; g+half < len(vel); g++ {
+		if m.GateRange(g) > opts.MaxRange {
+			break
+		}
+		near, far := vel[g-half], vel[g+half]
+		if !valid(near) || !valid(far) {
+			continue
+		}
+		dv := float64(far - near)
+		if nyquist > 0 && math.Abs(dv) > nyquist {
+			continue
+		}
+		conv[g] = -dv / (m.GateRange(g+half) - m.GateRange(g-half))
+	}
+	return conv
+}
+
+// boundary fits a polyline to a cluster of grid cells. ok is false if the
+// cluster is too short or not elongated enough to be a boundary.
+func boundary(flags, convergence *Grid, cells []int, opts BoundaryOptions) (b Boundary, ok bool) {
+	n := float64(len(cells))
+	var se, sn float64
+	for _, c := range cells {
+		e, no := flags.XY(c%flags.Size, c/flags.Size)
+		se += e
+		sn += no
+	}
+	me, mn := se/n, sn/n
+
+	// principal axis of the cells
+	var cee, cnn, cen float64
+	for _, c := range cells {
+		e, no := flags.XY(c%flags.Size, c/flags.Size)
+		cee += (e - me) * (e - me)
+		cnn += (no - mn) * (no - mn)
+		cen += (e - me) * (no - mn)
+	}
+	cee, cnn, cen = cee/n, cnn/n, cen/n
+	theta := 0.5 * math.Atan2(2*cen, cee-cnn)
+	ue, un := math.Cos(theta), math.Sin(theta)
+
+	min, max := math.Inf(1), math.Inf(-1)
+	var across float64
+	for _, c := range cells {
+		e, no := flags.XY(c%flags.Size, c/flags.Size)
+		along := (e-me)*ue + (no-mn)*un
+		min, max = math.Min(min, along), math.Max(max, along)
+		d := -(e-me)*un + (no-mn)*ue
+		across += d * d
+	}
+	length := max - min + flags.Spacing
+	width := math.Max(2*math.Sqrt(across/n), flags.Spacing)
+	if length < opts.MinLength || length/width < opts.MinElongation {
+		return b, false
+	}
+
+	// average the cells in bins along the axis into the polyline vertices
+	bins := int(math.Ceil((max-min)/opts.VertexSpacing)) + 1
+	type bin struct {
+		e, n  float64
+		count int
+	}
+	vertices := make([]bin, bins)
+	for _, c := range cells {
+		x, y := c%flags.Size, c/flags.Size
+		e, no := flags.XY(x, y)
+		k := int(((e-me)*ue + (no-mn)*un - min) / opts.VertexSpacing)
+		vertices[k].e += e
+		vertices[k].n += no
+		vertices[k].count++
+
+		f := int(flags.At(x, y))
+		b.ThinLine = b.ThinLine || f&boundaryThinLine != 0
+		b.Convergence = b.Convergence || f&boundaryConvergence != 0
+		if v := convergence.At(x, y); valid(v) {
+			b.MaxConvergence = math.Max(b.MaxConvergence, float64(v))
+		}
+	}
+
+	var pe, pn float64
+	for _, v := range vertices {
+		if v.count == 0 {
+			continue
+		}
+		e, no := v.e/float64(v.count), v.n/float64(v.count)
+		if len(b.Points) > 0 {
+			b.Length += math.Hypot(e-pe, no-pn)
+		}
+		pe, pn = e, no
+		b.Points = append(b.Points, eastNorthLatLon(flags.Lat, flags.Lon, e, no))
+	}
+
+	b.east, b.north = me, mn
+	center := eastNorthLatLon(flags.Lat, flags.Lon, me, mn)
+	b.Lat, b.Lon = center[0], center[1]
+	// theta is counter clockwise from east
+	b.Orientation = math.Mod(90-theta*radToDeg+360, 180)
+	return b, true
+}
+
+// eastNorthLatLon returns the lat, lon of the location east and north meters
+// from lat, lon.
+func eastNorthLatLon(lat, lon, east, north float64) [2]float64 {
+	az := math.Mod(math.Atan2(east, north)*radToDeg+360, 360)
+	plat, plon := archive2.Destination(lat, lon, az, math.Hypot(east, north))
+	return [2]float64{plat, plon}
+}
+
+// PropagateBoundaries matches the boundaries of a volume with those of the
+// previous volume from the same radar and sets their propagation, the motion
+// of the boundary normal to its orientation.
+func PropagateBoundaries(previous, current []Boundary, opts BoundaryOptions) {
+	for i := range current {
+		c := &current[i]
+		var best *Boundary
+		bestDist := math.Inf(1)
+		for j := range previous {
+			p := &previous[j]
+			dt := c.Time.Sub(p.Time).Seconds()
+			if dt <= 0 {
+				continue
+			}
+			turn := math.Abs(c.Orientation - p.Orientation)
+			if math.Min(turn, 180-turn) > opts.MaxTurn {
+				continue
+			}
+			d := math.Hypot(c.east-p.east, c.north-p.north)
+			if d <= opts.MaxSpeed*dt && d < bestDist {
+				best, bestDist = p, d
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		// only the displacement normal to the boundary can be measured
+		dt := c.Time.Sub(best.Time).Seconds()
+		normal := (c.Orientation + 90) * degToRad
+		ne, nn := math.Sin(normal), math.Cos(normal)
+		d := (c.east-best.east)*ne + (c.north-best.north)*nn
+		toward := c.Orientation + 90
+		if d < 0 {
+			toward, d = c.Orientation+270, -d
+		}
+		c.Propagation = &StormMotion{
+			Direction: math.Mod(toward+180, 360),
+			Speed:     d / dt,
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package products
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// gustFrontVolume returns a volume with a thin line of reflectivity and a
+// velocity convergence along an east west gust front y0 meters north of the
+// radar, with 10 m/s southerly outflow behind it. The thin line is 40 km long.
+func gustFrontVolume(t time.Time, y0 float64) *archive2.Archive2 {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	days := t.Sub(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 + 1
+	ar2.VolumeHeader.X_ModifiedJulianDate = int32(days)
+	ar2.VolumeHeader.X_ModifiedTime = int32(t.Sub(t.Truncate(24*time.Hour)) / time.Millisecond)
+
+	for az := 0.25; az < 360; az += 0.5 {
+		ref, vel := make([]float32, 400), make([]float32, 400)
+		for g := range ref {
+			r := 2125 + float64(g)*250
+			x, y := r*math.Sin(az*degToRad), r*math.Cos(az*degToRad)
+			ref[g] = 0
+			if math.Abs(y-y0) < 500 && math.Abs(x) < 20000 {
+				ref[g] = 20
+			}
+			vel[g] = 0
+			if y < y0 {
+				vel[g] = float32(10 * math.Cos(az*degToRad))
+			}
+		}
+		radial := newRadial(float32(az), 0.5)
+		radial.VolumeData.Lat = 35
+		radial.VolumeData.Long = -97
+		radial.RadialData.NyquistVelocity = 3000
+		radial.ReflectivityData = newMoment(2125, 250, ref)
+		radial.VelocityData = newMoment(2125, 250, vel)
+		ar2.ElevationScans[1] = append(ar2.ElevationScans[1], radial)
+	}
+	return ar2
+}
+
+func TestDetectBoundaries(t *testing.T) {
+	start := time.Date(2013, 5, 20, 20, 0, 0, 0, time.UTC)
+	opts := DefaultBoundaryOptions()
+
+	first := DetectBoundaries(gustFrontVolume(start, 30000), opts)
+	if len(first) != 1 {
+		t.Fatalf("got %d boundaries, want 1", len(first))
+	}
+	b := first[0]
+	if !b.ThinLine || !b.Convergence {
+		t.Errorf("expected thin line and convergence, got %+v", b)
+	}
+	if math.Abs(b.Orientation-90) > 10 {
+		t.Errorf("got orientation %f, want 90", b.Orientation)
+	}
+	// the radial convergence exceeds 3e-3/s where the front is within 53
+	// degrees of normal to the radials, 40 km either side of north
+	if math.Abs(b.Length-80000) > 5000 {
+		t.Errorf("got length %f, want about 80000", b.Length)
+	}
+
+	second := DetectBoundaries(gustFrontVolume(start.Add(5*time.Minute), 33000), opts)
+	if len(second) != 1 {
+		t.Fatalf("got %d boundaries, want 1", len(second))
+	}
+	PropagateBoundaries(first, second, opts)
+	p := second[0].Propagation
+	if p == nil || math.Abs(p.Speed-10) > 2 || math.Abs(p.Direction-180) > 10 {
+		t.Errorf("got propagation %+v, want 10 m/s from 180", p)
+	}
+}
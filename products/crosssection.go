@@ -0,0 +1,169 @@
+package products
+
+import (
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// CrossSectionOptions configures vertical cross sections.
+type CrossSectionOptions struct {
+	// Spacing is the horizontal distance in meters between columns.
+	Spacing float64
+	// HeightStep is the vertical distance in meters between rows.
+	HeightStep float64
+	// MaxHeight is the top of the cross section in meters above sea level.
+	MaxHeight float64
+	// BeamWidth is the half power beam width of the radar in degrees.
+	BeamWidth float64
+}
+
+// DefaultCrossSectionOptions returns options for a cross section up to 18 km
+// with the beam width of the WSR-88D.
+func DefaultCrossSectionOptions() CrossSectionOptions {
+	return CrossSectionOptions{
+		Spacing:    250,
+		HeightStep: 100,
+		MaxHeight:  18000,
+		BeamWidth:  0.95,
+	}
+}
+
+// BeamOutline is the height of the bottom and top of the beam of a sweep
+// along a cross section, for every column. Columns outside the range of the
+// sweep hold NaN.
+type BeamOutline struct {
+	ElevationAngle float64
+	Bottom, Top    []float64
+}
+
+// CrossSection is a moment interpolated onto a vertical plane between two
+// points. Data holds Columns x Rows values, row by row from the top down, with
+// archive2.MomentDataBelowThreshold where there is no data.
+type CrossSection struct {
+	Moment              string
+	StartLat, StartLon  float64
+	EndLat, EndLon      float64
+	Length              float64
+	Columns, Rows       int
+	Spacing, HeightStep float64
+	// Bottom is the height in meters above sea level of the bottom row.
+	Bottom float64
+	Data   []float32
+	Beams  []BeamOutline
+}
+
+// At returns the value at column x and row y, counted from the top.
+func (cs *CrossSection) At(x, y int) float32 {
+	return cs.Data[y*cs.Columns+x]
+}
+
+// Height returns the height in meters above sea level of the center of row y.
+func (cs *CrossSection) Height(y int) float64 {
+	return cs.Bottom + (float64(cs.Rows-y)-0.5)*cs.HeightStep
+}
+
+// NewCrossSection interpolates the named moment from every sweep of the volume
+// onto the vertical plane along the great circle from lat1, lon1 to lat2,
+// lon2. Values between two beams are interpolated linearly in height, values
+// above the top or below the bottom beam are filled within half the beam
+// width.
+func NewCrossSection(ar2 *archive2.Archive2, moment string, lat1, lon1, lat2, lon2 float64, opts CrossSectionOptions) *CrossSection {
+	bearing, length := archive2.BearingDistance(lat1, lon1, lat2, lon2)
+	cs := &CrossSection{
+		Moment:     moment,
+		StartLat:   lat1,
+		StartLon:   lon1,
+		EndLat:     lat2,
+		EndLon:     lon2,
+		Length:     length,
+		Columns:    int(math.Ceil(length/opts.Spacing)) + 1,
+		Spacing:    opts.Spacing,
+		HeightStep: opts.HeightStep,
+	}
+
+	// the cross section starts at the height of the radar
+	sweeps := distinctSweeps(ar2, moment)
+	var site float64
+	if len(sweeps) > 0 {
+		site = sweeps[0].Radials[0].VolumeData.SiteAltitude()
+	}
+	cs.Bottom = site
+	cs.Rows = int(math.Ceil((opts.MaxHeight - cs.Bottom) / opts.HeightStep))
+	if cs.Rows < 0 {
+		cs.Rows = 0
+	}
+	cs.Data = make([]float32, cs.Columns*cs.Rows)
+	for i := range cs.Data {
+		cs.Data[i] = archive2.MomentDataBelowThreshold
+	}
+	if len(sweeps) == 0 {
+		return cs
+	}
+
+	for _, s := range sweeps {
+		beam := BeamOutline{
+			ElevationAngle: s.ElevationAngle,
+			Bottom:         make([]float64, cs.Columns),
+			Top:            make([]float64, cs.Columns),
+		}
+		cs.Beams = append(cs.Beams, beam)
+	}
+
+	lat, lon := radarLocation(ar2)
+	type sample struct {
+		height, half float64
+		v            float32
+		ok           bool
+	}
+	samples := make([]sample, len(sweeps))
+	for x := 0; x < cs.Columns; x++ {
+		plat, plon := archive2.Destination(lat1, lon1, bearing, math.Min(float64(x)*opts.Spacing, length))
+		az, groundRange := archive2.BearingDistance(lat, lon, plat, plon)
+
+		for i, s := range sweeps {
+			el := s.ElevationAngle
+			r := archive2.SlantRange(groundRange, el)
+			v, ok := s.ValueAt(moment, az, r)
+			h := site + archive2.BeamHeight(r, el)
+			samples[i] = sample{height: h, v: v, ok: ok && valid(v)}
+
+			// the beam covers half the beam width above and below its center
+			bottom := site + archive2.BeamHeight(r, el-opts.BeamWidth/2)
+			top := site + archive2.BeamHeight(r, el+opts.BeamWidth/2)
+			samples[i].half = (top - bottom) / 2
+			if ok {
+				cs.Beams[i].Bottom[x], cs.Beams[i].Top[x] = bottom, top
+			} else {
+				cs.Beams[i].Bottom[x], cs.Beams[i].Top[x] = math.NaN(), math.NaN()
+			}
+		}
+
+		for y := 0; y < cs.Rows; y++ {
+			h := cs.Height(y)
+			var below, above *sample
+			for i := range samples {
+				s := &samples[i]
+				if s.height <= h && (below == nil || s.height > below.height) {
+					below = s
+				}
+				if s.height > h && (above == nil || s.height < above.height) {
+					above = s
+				}
+			}
+
+			v := float32(archive2.MomentDataBelowThreshold)
+			switch {
+			case below != nil && above != nil && below.ok && above.ok:
+				w := (h - below.height) / (above.height - below.height)
+				v = below.v + float32(w)*(above.v-below.v)
+			case below != nil && below.ok && h-below.height <= below.half:
+				v = below.v
+			case above != nil && above.ok && above.height-h <= above.half:
+				v = above.v
+			}
+			cs.Data[y*cs.Columns+x] = v
+		}
+	}
+	return cs
+}
@@ -0,0 +1,50 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestNewCrossSection(t *testing.T) {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for elv, el := range []float32{0.5, 1.5} {
+		for az := 0.25; az < 360; az += 0.5 {
+			radial := newRadial(float32(az), el)
+			radial.VolumeData.Lat = 35
+			radial.VolumeData.Long = -97
+			radial.ReflectivityData = newMoment(2125, 250, fill(400, 40))
+			ar2.ElevationScans[elv+1] = append(ar2.ElevationScans[elv+1], radial)
+		}
+	}
+
+	// west to east through a point 50 km north of the radar
+	lat, lon := archive2.Destination(35, -97, 0, 50000)
+	lat1, lon1 := archive2.Destination(lat, lon, 270, 20000)
+	lat2, lon2 := archive2.Destination(lat, lon, 90, 20000)
+	cs := NewCrossSection(ar2, "REF", lat1, lon1, lat2, lon2, DefaultCrossSectionOptions())
+
+	if len(cs.Beams) != 2 {
+		t.Fatalf("got %d beams, want 2", len(cs.Beams))
+	}
+	if math.Abs(cs.Length-40000) > 100 {
+		t.Errorf("got length %f, want 40000", cs.Length)
+	}
+
+	x := cs.Columns / 2
+	if math.IsNaN(cs.Beams[0].Bottom[x]) {
+		t.Fatal("expected the beams to cover the middle of the cross section")
+	}
+	for y := 0; y < cs.Rows; y++ {
+		h := cs.Height(y)
+		v := cs.At(x, y)
+		inside := h >= cs.Beams[0].Bottom[x] && h <= cs.Beams[1].Top[x]
+		if inside && v != 40 {
+			t.Errorf("got %f at %f inside the beams, want 40", v, h)
+		}
+		if h > cs.Beams[1].Top[x]+cs.HeightStep && v != archive2.MomentDataBelowThreshold {
+			t.Errorf("got %f at %f above the beams, want no data", v, h)
+		}
+	}
+}
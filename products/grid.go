@@ -0,0 +1,165 @@
+package products
+
+import (
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// Grid is a square grid of values centered on the radar in an azimuthal
+// equidistant projection, the same projection used to draw PPI images. Rows
+// run from north to south and columns from west to east.
+type Grid struct {
+	// Lat and Lon of the radar at the center of the grid.
+	Lat, Lon float64
+	// Spacing is the size of a grid cell in meters.
+	Spacing float64
+	// Size is the number of rows and columns.
+	Size int
+	// Data holds the values row by row, missing values are
+	// archive2.MomentDataBelowThreshold.
+	Data []float32
+}
+
+// NewGrid returns a grid centered on lat, lon reaching extent meters from the
+// center with cells spacing meters wide. All cells are missing.
+func NewGrid(lat, lon, extent, spacing float64) *Grid {
+	size := int(math.Ceil(2 * extent / spacing))
+	g := &Grid{
+		Lat:     lat,
+		Lon:     lon,
+		Spacing: spacing,
+		Size:    size,
+		Data:    make([]float32, size*size),
+	}
+	for i := range g.Data {
+		g.Data[i] = archive2.MomentDataBelowThreshold
+	}
+	return g
+}
+
+// Extent returns the distance in meters from the center to the edge of the grid.
+func (g *Grid) Extent() float64 {
+	return float64(g.Size) * g.Spacing / 2
+}
+
+// At returns the value of the cell at column x and row y.
+func (g *Grid) At(x, y int) float32 {
+	return g.Data[y*g.Size+x]
+}
+
+// Set sets the value of the cell at column x and row y.
+func (g *Grid) Set(x, y int, v float32) {
+	g.Data[y*g.Size+x] = v
+}
+
+// XY returns the distance in meters east and north of the radar of the center
+// of the cell at column x and row y.
+func (g *Grid) XY(x, y int) (east, north float64) {
+	extent := g.Extent()
+	return (float64(x)+0.5)*g.Spacing - extent, extent - (float64(y)+0.5)*g.Spacing
+}
+
+// Polar returns the azimuth in degrees and ground range in meters from the
+// radar to the center of the cell at column x and row y.
+func (g *Grid) Polar(x, y int) (azimuth, groundRange float64) {
+	east, north := g.XY(x, y)
+	azimuth = math.Mod(math.Atan2(east, north)*radToDeg+360, 360)
+	return azimuth, math.Hypot(east, north)
+}
+
+// LatLon returns the latitude and longitude of the center of the cell at
+// column x and row y.
+func (g *Grid) LatLon(x, y int) (lat, lon float64) {
+	az, r := g.Polar(x, y)
+	return archive2.Destination(g.Lat, g.Lon, az, r)
+}
+
+// Cell returns the column and row of the cell holding the location east and
+// north meters from the radar. ok is false if it falls outside the grid.
+func (g *Grid) Cell(east, north float64) (x, y int, ok bool) {
+	extent := g.Extent()
+	x = int(math.Floor((east + extent) / g.Spacing))
+	y = int(math.Floor((extent - north) / g.Spacing))
+	if x < 0 || y < 0 || x >= g.Size || y >= g.Size {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+// CellAt returns the column and row of the cell holding lat, lon. ok is false
+// if it falls outside the grid.
+func (g *Grid) CellAt(lat, lon float64) (x, y int, ok bool) {
+	az, r := archive2.BearingDistance(g.Lat, g.Lon, lat, lon)
+	return g.Cell(r*math.Sin(az*degToRad), r*math.Cos(az*degToRad))
+}
+
+// radarLocation returns the location of the radar from the first radial of the volume.
+func radarLocation(ar2 *archive2.Archive2) (lat, lon float64) {
+	for _, radials := range ar2.ElevationScans {
+		if len(radials) > 0 {
+			return float64(radials[0].VolumeData.Lat), float64(radials[0].VolumeData.Long)
+		}
+	}
+	return 0, 0
+}
+
+// SweepGrid returns a grid of the gates of a sweep, gates[i] holding the values
+// of radials[i] with the gate geometry of the named moment. Every cell takes
+// the value of the gate above it, range folded gates are missing. The grid
+// reaches extent meters of ground range from the radar.
+func SweepGrid(radials []*archive2.Message31, gates [][]float32, moment string, extent, spacing float64) *Grid {
+	s := NewSweepSampler(radials, gates, moment)
+	grid := NewGrid(s.Lat, s.Lon, extent, spacing)
+	for y := 0; y < grid.Size; y++ {
+		for x := 0; x < grid.Size; x++ {
+			if v, ok := s.Polar(grid.Polar(x, y)); ok && valid(v) {
+				grid.Set(x, y, v)
+			}
+		}
+	}
+	return grid
+}
+
+// SweepSampler looks up the gates of a sweep above locations on the ground.
+type SweepSampler struct {
+	// Lat and Lon of the radar.
+	Lat, Lon float64
+	sweep    *archive2.Sweep
+	gates    [][]float32
+	moment   string
+}
+
+// NewSweepSampler returns a SweepSampler of the gates of a sweep, gates[i]
+// holding the values of radials[i] with the gate geometry of the named moment.
+func NewSweepSampler(radials []*archive2.Message31, gates [][]float32, moment string) *SweepSampler {
+	s := &SweepSampler{sweep: archive2.NewSweep(radials), gates: gates, moment: moment}
+	if len(radials) > 0 {
+		s.Lat, s.Lon = float64(radials[0].VolumeData.Lat), float64(radials[0].VolumeData.Long)
+	}
+	return s
+}
+
+// Polar returns the value of the gate above the given azimuth in degrees and
+// ground range in meters from the radar. ok is false if no gate covers the
+// location, the value may be below threshold or range folded.
+func (s *SweepSampler) Polar(azimuth, groundRange float64) (v float32, ok bool) {
+	i := s.sweep.Index(azimuth)
+	if i < 0 || i >= len(s.gates) {
+		return 0, false
+	}
+	m := s.sweep.Radials[i].Moment(s.moment)
+	if m == nil {
+		return 0, false
+	}
+	g := m.GateIndex(archive2.SlantRange(groundRange, s.sweep.ElevationAngle))
+	if g < 0 || g >= len(s.gates[i]) {
+		return 0, false
+	}
+	return s.gates[i][g], true
+}
+
+// At returns the value of the gate above lat, lon, see Polar.
+func (s *SweepSampler) At(lat, lon float64) (v float32, ok bool) {
+	return s.Polar(archive2.BearingDistance(s.Lat, s.Lon, lat, lon))
+}
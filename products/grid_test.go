@@ -0,0 +1,77 @@
+package products
+
+import (
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestSweepGrid(t *testing.T) {
+	radials := []*archive2.Message31{}
+	gates := [][]float32{}
+	for az := float32(0); az < 360; az += 0.5 {
+		r := newRadial(az, 0.5)
+		r.ReflectivityData = newMoment(500, 1000, fill(50, 10))
+		v := fill(50, 10)
+		if az >= 80 && az < 100 {
+			// a cell 20 km east of the radar, range folded beyond it
+			for g := 18; g < 23; g++ {
+				v[g] = 55
+			}
+			v[30] = archive2.MomentDataFolded
+		}
+		radials = append(radials, r)
+		gates = append(gates, v)
+	}
+
+	grid := SweepGrid(radials, gates, "REF", 60000, 1000)
+	if grid.Size != 120 {
+		t.Fatalf("size = %d, want 120", grid.Size)
+	}
+	x, y, _ := grid.Cell(20500, 500)
+	if got := grid.At(x, y); got != 55 {
+		t.Errorf("cell 20 km east = %v, want 55", got)
+	}
+	x, y, _ = grid.Cell(-20500, 500)
+	if got := grid.At(x, y); got != 10 {
+		t.Errorf("cell 20 km west = %v, want 10", got)
+	}
+	x, y, _ = grid.Cell(30500, 500)
+	if got := grid.At(x, y); got != archive2.MomentDataBelowThreshold {
+		t.Errorf("range folded cell = %v, want missing", got)
+	}
+	x, y, _ = grid.Cell(55500, 500)
+	if got := grid.At(x, y); got != archive2.MomentDataBelowThreshold {
+		t.Errorf("cell beyond the last gate = %v, want missing", got)
+	}
+}
+
+func TestSweepSampler(t *testing.T) {
+	radials := []*archive2.Message31{}
+	gates := [][]float32{}
+	for az := float32(0.25); az < 360; az += 0.5 {
+		r := newRadial(az, 0.5)
+		r.VolumeData.Lat, r.VolumeData.Long = 35.333, -97.278
+		r.ReflectivityData = newMoment(500, 1000, fill(50, 10))
+		v := fill(50, 10)
+		if az > 90 && az < 91 {
+			v[20] = 55
+		}
+		radials = append(radials, r)
+		gates = append(gates, v)
+	}
+
+	s := NewSweepSampler(radials, gates, "REF")
+	lat, lon := archive2.Destination(s.Lat, s.Lon, 90.5, archive2.GroundRange(20500, 0.5))
+	if v, ok := s.At(lat, lon); !ok || v != 55 {
+		t.Errorf("gate 20 km east = %v, %v, want 55", v, ok)
+	}
+	lat, lon = archive2.Destination(s.Lat, s.Lon, 270, 20000)
+	if v, ok := s.At(lat, lon); !ok || v != 10 {
+		t.Errorf("gate 20 km west = %v, %v, want 10", v, ok)
+	}
+	lat, lon = archive2.Destination(s.Lat, s.Lon, 90.5, 80000)
+	if _, ok := s.At(lat, lon); ok {
+		t.Error("expected no gate beyond the last one")
+	}
+}
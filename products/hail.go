@@ -0,0 +1,268 @@
+package products
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// HDAOptions configures the hail detection algorithm.
+type HDAOptions struct {
+	// FreezingLevel and Minus20Level are the heights in meters above sea level
+	// of the 0°C and -20°C levels, usually taken from a nearby sounding or
+	// model analysis. The defaults are typical of the warm season and should
+	// be overridden.
+	FreezingLevel, Minus20Level float64
+	// LowerREF and UpperREF in dBZ bound the reflectivity weighting of the
+	// hail kinetic energy: echoes below LowerREF are ignored and echoes above
+	// UpperREF are assumed to be entirely hail.
+	LowerREF, UpperREF float32
+	// Extent is the range in meters covered by the output grids.
+	Extent float64
+	// Spacing is the size of the grid cells in meters.
+	Spacing float64
+	// MinCells is the number of connected grid cells with hail a hail cell needs.
+	MinCells int
+}
+
+// DefaultHDAOptions returns the adaptable parameters of the WSR-88D hail
+// detection algorithm.
+func DefaultHDAOptions() HDAOptions {
+	return HDAOptions{
+		FreezingLevel: 4000,
+		Minus20Level:  7000,
+		LowerREF:      40,
+		UpperREF:      50,
+		Extent:        230000,
+		Spacing:       1000,
+		MinCells:      4,
+	}
+}
+
+// HailGrids holds the hail detection algorithm outputs for a volume.
+type HailGrids struct {
+	// SHI is the severe hail index in J/m/s.
+	SHI *Grid
+	// MESH is the maximum expected size of hail in mm.
+	MESH *Grid
+	// POH is the probability of hail of any size, in percent.
+	POH *Grid
+	// POSH is the probability of severe hail, 19mm or larger, in percent.
+	POSH *Grid
+}
+
+// HailCell summarizes the hail detection over a connected region of the grid
+// with a positive severe hail index.
+type HailCell struct {
+	// Lat and Lon of the grid cell with the largest MESH.
+	Lat, Lon float64
+	SHI      float32
+	MESH     float32
+	POH      float32
+	POSH     float32
+	// Area of the cell in square meters.
+	Area float64
+}
+
+// poh maps the height of the 45 dBZ echo above the freezing level in km to
+// the probability of hail, from Waldvogel et al. (1979) as used by the WSR-88D.
+var poh = []struct {
+	height, prob float32
+}{
+	{1.625, 0}, {1.875, 10}, {2.0, 20}, {2.125, 30}, {2.375, 40}, {2.625, 50},
+	{2.925, 60}, {3.3, 70}, {3.75, 80}, {4.5, 90}, {5.5, 100},
+}
+
+// DetectHail runs the hail detection algorithm of Witt et al. (1998) over every
+// column of the volume, computing the severe hail index, MESH, POH and POSH.
+func DetectHail(ar2 *archive2.Archive2, opts HDAOptions) HailGrids {
+	lat, lon := radarLocation(ar2)
+	hg := HailGrids{
+		SHI:  NewGrid(lat, lon, opts.Extent, opts.Spacing),
+		MESH: NewGrid(lat, lon, opts.Extent, opts.Spacing),
+		POH:  NewGrid(lat, lon, opts.Extent, opts.Spacing),
+		POSH: NewGrid(lat, lon, opts.Extent, opts.Spacing),
+	}
+
+	sweeps := []*archive2.Sweep{}
+	for _, s := range ar2.Sweeps() {
+		if len(s.Radials) > 0 && s.Radials[0].ReflectivityData != nil {
+			sweeps = append(sweeps, s)
+		}
+	}
+	sort.SliceStable(sweeps, func(i, j int) bool {
+		return sweeps[i].ElevationAngle < sweeps[j].ElevationAngle
+	})
+	if len(sweeps) == 0 {
+		return hg
+	}
+	site := sweeps[0].Radials[0].VolumeData.SiteAltitude()
+
+	// warning threshold for POSH, with the freezing level in km above the radar
+	wt := 57.5*(opts.FreezingLevel-site)/1000 - 121
+	if wt < 20 {
+		wt = 20
+	}
+
+	type sample struct {
+		height float64
+		dbz    float32
+	}
+	column := make([]sample, 0, len(sweeps))
+
+	for y := 0; y < hg.SHI.Size; y++ {
+		for x := 0; x < hg.SHI.Size; x++ {
+			az, gr := hg.SHI.Polar(x, y)
+			if gr > opts.Extent {
+				continue
+			}
+
+			column = column[:0]
+			for _, s := range sweeps {
+				r := archive2.SlantRange(gr, s.ElevationAngle)
+				z, ok := s.ValueAt("REF", az, r)
+				if !ok {
+					continue
+				}
+				if !valid(z) {
+					z = -32
+				}
+				column = append(column, sample{site + archive2.BeamHeight(r, s.ElevationAngle), z})
+			}
+			if len(column) == 0 {
+				continue
+			}
+
+			var shi, h45 float64
+			for k, c := range column {
+				if c.dbz >= 45 && c.height > h45 {
+					h45 = c.height
+				}
+				if c.height < opts.FreezingLevel {
+					continue
+				}
+
+				// thickness of the layer sampled by this tilt
+				lo, hi := c.height, c.height
+				if k > 0 {
+					lo = (column[k-1].height + c.height) / 2
+				}
+				if k < len(column)-1 {
+					hi = (column[k+1].height + c.height) / 2
+				}
+				lo = math.Max(lo, opts.FreezingLevel)
+
+				shi += temperatureWeight(c.height, opts) * hailKineticEnergy(c.dbz, opts) * (hi - lo)
+			}
+			shi *= 0.1
+
+			hg.SHI.Set(x, y, float32(shi))
+			hg.MESH.Set(x, y, float32(2.54*math.Sqrt(shi)))
+			if shi > 0 {
+				hg.POSH.Set(x, y, float32(math.Max(0, math.Min(100, 29*math.Log(shi/wt)+50))))
+			} else {
+				hg.POSH.Set(x, y, 0)
+			}
+			hg.POH.Set(x, y, probabilityOfHail((h45-opts.FreezingLevel)/1000))
+		}
+	}
+	return hg
+}
+
+// hailKineticEnergy returns the hail kinetic energy flux in J/m^2/s.
+func hailKineticEnergy(dbz float32, opts HDAOptions) float64 {
+	var w float64
+	switch {
+	case dbz <= opts.LowerREF:
+		return 0
+	case dbz >= opts.UpperREF:
+		w = 1
+	default:
+		w = float64((dbz - opts.LowerREF) / (opts.UpperREF - opts.LowerREF))
+	}
+	return 5e-6 * math.Pow(10, 0.084*float64(dbz)) * w
+}
+
+// temperatureWeight weights the hail growth by height, from zero at the
+// freezing level to one at the -20°C level.
+func temperatureWeight(height float64, opts HDAOptions) float64 {
+	switch {
+	case height <= opts.FreezingLevel:
+		return 0
+	case height >= opts.Minus20Level:
+		return 1
+	}
+	return (height - opts.FreezingLevel) / (opts.Minus20Level - opts.FreezingLevel)
+}
+
+// probabilityOfHail returns the probability of hail in percent for a 45 dBZ
+// echo top dh km above the freezing level.
+func probabilityOfHail(dh float64) float32 {
+	var p float32
+	for _, v := range poh {
+		if float32(dh) >= v.height {
+			p = v.prob
+		}
+	}
+	return p
+}
+
+// HailCells groups the connected grid cells with a positive severe hail index
+// into hail cells, largest MESH first.
+func (hg HailGrids) HailCells(opts HDAOptions) []HailCell {
+	g := hg.SHI
+	visited := make([]bool, len(g.Data))
+	cells := []HailCell{}
+
+	for start := range g.Data {
+		if visited[start] || !valid(g.Data[start]) || g.Data[start] <= 0 {
+			continue
+		}
+
+		members := []int{}
+		stack := []int{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			members = append(members, c)
+			cx, cy := c%g.Size, c/g.Size
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := cx+dx, cy+dy
+					if nx < 0 || ny < 0 || nx >= g.Size || ny >= g.Size {
+						continue
+					}
+					n := ny*g.Size + nx
+					if !visited[n] && valid(g.Data[n]) && g.Data[n] > 0 {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		if len(members) < opts.MinCells {
+			continue
+		}
+
+		cell := HailCell{Area: float64(len(members)) * g.Spacing * g.Spacing}
+		best := members[0]
+		for _, m := range members {
+			if hg.MESH.Data[m] > hg.MESH.Data[best] {
+				best = m
+			}
+			cell.SHI = float32(math.Max(float64(cell.SHI), float64(hg.SHI.Data[m])))
+			cell.POH = float32(math.Max(float64(cell.POH), float64(hg.POH.Data[m])))
+			cell.POSH = float32(math.Max(float64(cell.POSH), float64(hg.POSH.Data[m])))
+		}
+		cell.MESH = hg.MESH.Data[best]
+		cell.Lat, cell.Lon = g.LatLon(best%g.Size, best/g.Size)
+		cells = append(cells, cell)
+	}
+
+	sort.SliceStable(cells, func(i, j int) bool {
+		return cells[i].MESH > cells[j].MESH
+	})
+	return cells
+}
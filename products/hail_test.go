@@ -0,0 +1,145 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestHailKineticEnergy(t *testing.T) {
+	opts := DefaultHDAOptions()
+	if e := hailKineticEnergy(40, opts); e != 0 {
+		t.Errorf("expected no hail energy at 40 dBZ, got %f", e)
+	}
+	// 60 dBZ is entirely hail: 5e-6 * 10^(0.084*60)
+	if e := hailKineticEnergy(60, opts); math.Abs(e-0.5482) > 0.001 {
+		t.Errorf("got %f want 0.5482", e)
+	}
+}
+
+func TestProbabilityOfHail(t *testing.T) {
+	if p := probabilityOfHail(1); p != 0 {
+		t.Errorf("got %f want 0", p)
+	}
+	if p := probabilityOfHail(3); p != 60 {
+		t.Errorf("got %f want 60", p)
+	}
+	if p := probabilityOfHail(6); p != 100 {
+		t.Errorf("got %f want 100", p)
+	}
+}
+
+// hailVolume returns a volume with tilts up to 15 degrees holding a 60 dBZ
+// core 30 to 40 km east of the radar in 20 dBZ echo.
+func hailVolume() *archive2.Archive2 {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for elv, el := range []float32{0.5, 3, 6, 9, 12, 15} {
+		for az := 0.25; az < 360; az += 0.5 {
+			ref := make([]float32, 400)
+			for g := range ref {
+				ref[g] = 20
+				gr := archive2.GroundRange(2125+float64(g)*250, float64(el))
+				if az > 80 && az < 100 && gr > 30000 && gr < 40000 {
+					ref[g] = 60
+				}
+			}
+			radial := newRadial(float32(az), el)
+			radial.VolumeData.Lat = 35
+			radial.VolumeData.Long = -97
+			radial.ReflectivityData = newMoment(2125, 250, ref)
+			ar2.ElevationScans[elv+1] = append(ar2.ElevationScans[elv+1], radial)
+		}
+	}
+	return ar2
+}
+
+func TestDetectHail(t *testing.T) {
+	ar2 := hailVolume()
+	opts := DefaultHDAOptions()
+	opts.FreezingLevel = 2000
+	opts.Minus20Level = 5000
+	opts.Extent = 60000
+	opts.Spacing = 2000
+
+	hg := DetectHail(ar2, opts)
+	x, y, _ := hg.SHI.Cell(35000, 0)
+	shi := hg.SHI.At(x, y)
+	if shi <= 0 {
+		t.Fatalf("no hail in the core, SHI %f", shi)
+	}
+	if mesh := hg.MESH.At(x, y); math.Abs(float64(mesh)-2.54*math.Sqrt(float64(shi))) > 0.01 {
+		t.Errorf("MESH %f for SHI %f", mesh, shi)
+	}
+	// the 45 dBZ echo reaches the top tilt more than 5.5 km above the freezing level
+	if p := hg.POH.At(x, y); p != 100 {
+		t.Errorf("POH %f want 100", p)
+	}
+	if p := hg.POSH.At(x, y); p <= 0 {
+		t.Errorf("POSH %f want more than 0", p)
+	}
+
+	x, y, _ = hg.SHI.Cell(-35000, 0)
+	if shi, poh := hg.SHI.At(x, y), hg.POH.At(x, y); shi != 0 || poh != 0 {
+		t.Errorf("SHI %f POH %f outside the core", shi, poh)
+	}
+
+	// growth is weighted fully above the -20°C level
+	opts.Minus20Level = opts.FreezingLevel + 1
+	x, y, _ = hg.SHI.Cell(35000, 0)
+	if v := DetectHail(ar2, opts).SHI.At(x, y); v <= shi {
+		t.Errorf("SHI %f with a lower -20°C level, want more than %f", v, shi)
+	}
+
+	// no hail grows below the freezing level
+	opts.FreezingLevel = 20000
+	opts.Minus20Level = 23000
+	if v := DetectHail(ar2, opts).SHI.At(x, y); v != 0 {
+		t.Errorf("SHI %f below the freezing level", v)
+	}
+}
+
+func TestHailCells(t *testing.T) {
+	opts := DefaultHDAOptions()
+	opts.Extent = 10000
+	hg := HailGrids{
+		SHI:  NewGrid(35, -97, opts.Extent, opts.Spacing),
+		MESH: NewGrid(35, -97, opts.Extent, opts.Spacing),
+		POH:  NewGrid(35, -97, opts.Extent, opts.Spacing),
+		POSH: NewGrid(35, -97, opts.Extent, opts.Spacing),
+	}
+	set := func(x, y int, mesh float32) {
+		hg.SHI.Set(x, y, mesh*mesh/2.54/2.54)
+		hg.MESH.Set(x, y, mesh)
+		hg.POH.Set(x, y, 100)
+		hg.POSH.Set(x, y, 50)
+	}
+	// a 3 by 3 cell, a 2 by 2 cell with larger hail and a single cell
+	for y := 2; y < 5; y++ {
+		for x := 2; x < 5; x++ {
+			set(x, y, 20)
+		}
+	}
+	set(3, 3, 30)
+	for y := 10; y < 12; y++ {
+		for x := 10; x < 12; x++ {
+			set(x, y, 40)
+		}
+	}
+	set(16, 16, 50)
+
+	cells := hg.HailCells(opts)
+	if len(cells) != 2 {
+		t.Fatalf("got %d cells want 2", len(cells))
+	}
+	if cells[0].MESH != 40 || cells[0].Area != 4*opts.Spacing*opts.Spacing {
+		t.Errorf("first cell %+v", cells[0])
+	}
+	c := cells[1]
+	if c.MESH != 30 || c.Area != 9*opts.Spacing*opts.Spacing || c.POH != 100 || c.POSH != 50 {
+		t.Errorf("second cell %+v", c)
+	}
+	if lat, lon := hg.SHI.LatLon(3, 3); c.Lat != lat || c.Lon != lon {
+		t.Errorf("second cell at %f, %f want %f, %f", c.Lat, c.Lon, lat, lon)
+	}
+}
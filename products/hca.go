@@ -0,0 +1,324 @@
+package products
+
+import (
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// HydrometeorClass is the category assigned to a gate by the hydrometeor
+// classification algorithm.
+type HydrometeorClass uint8
+
+const (
+	HydroNone HydrometeorClass = iota
+	HydroBiological
+	HydroGroundClutter
+	HydroIceCrystals
+	HydroDrySnow
+	HydroWetSnow
+	HydroRain
+	HydroHeavyRain
+	HydroGraupel
+	HydroHail
+)
+
+// HydrometeorClasses lists every class the algorithm can assign, in legend order.
+var HydrometeorClasses = []HydrometeorClass{
+	HydroBiological,
+	HydroGroundClutter,
+	HydroIceCrystals,
+	HydroDrySnow,
+	HydroWetSnow,
+	HydroRain,
+	HydroHeavyRain,
+	HydroGraupel,
+	HydroHail,
+}
+
+func (c HydrometeorClass) String() string {
+	switch c {
+	case HydroBiological:
+		return "Biological"
+	case HydroGroundClutter:
+		return "Ground Clutter"
+	case HydroIceCrystals:
+		return "Ice Crystals"
+	case HydroDrySnow:
+		return "Dry Snow"
+	case HydroWetSnow:
+		return "Wet Snow"
+	case HydroRain:
+		return "Rain"
+	case HydroHeavyRain:
+		return "Heavy Rain"
+	case HydroGraupel:
+		return "Graupel"
+	case HydroHail:
+		return "Hail"
+	}
+	return "None"
+}
+
+// Trapezoid is a trapezoidal membership function. Membership rises from 0 at
+// X1 to 1 at X2, stays at 1 until X3 and falls back to 0 at X4.
+type Trapezoid struct {
+	X1, X2, X3, X4 float32
+}
+
+// Membership returns the degree of membership of v, between 0 and 1.
+func (t Trapezoid) Membership(v float32) float32 {
+	switch {
+	case v <= t.X1 || v >= t.X4:
+		return 0
+	case v < t.X2:
+		return (v - t.X1) / (t.X2 - t.X1)
+	case v <= t.X3:
+		return 1
+	}
+	return (t.X4 - v) / (t.X4 - t.X3)
+}
+
+// ClassMembership holds the membership functions of a class for each of the
+// input variables. LKDP is 10log10(KDP), SDZ and SDPHI are the standard
+// deviations of reflectivity and differential phase along the radial.
+type ClassMembership struct {
+	REF, ZDR, RHO, LKDP, SDZ, SDPHI Trapezoid
+}
+
+// HCAWeights are the weights given to each input variable when aggregating
+// the memberships of a class.
+type HCAWeights struct {
+	REF, ZDR, RHO, LKDP, SDZ, SDPHI float32
+}
+
+// MeltingLayer bounds the melting layer in meters above mean sea level.
+type MeltingLayer struct {
+	Bottom, Top float64
+}
+
+// HCAOptions configures the hydrometeor classification.
+type HCAOptions struct {
+	Classes map[HydrometeorClass]ClassMembership
+	Weights HCAWeights
+	// MeltingLayer restricts the classes allowed at each gate height. A zero
+	// MeltingLayer disables the restriction.
+	MeltingLayer MeltingLayer
+	// MeltingLayerDetection, when found, replaces MeltingLayer with the
+	// melting layer detected in the azimuth sector of each radial.
+	MeltingLayerDetection *MeltingLayerDetection
+	// TextureGates is the number of gates used for the SDZ and SDPHI textures.
+	TextureGates int
+	KDP          KDPOptions
+}
+
+// DefaultHCAOptions returns membership functions adapted from Park et al.
+// (2009) "The Hydrometeor Classification Algorithm for the Polarimetric
+// WSR-88D", simplified to not depend on reflectivity.
+func DefaultHCAOptions() HCAOptions {
+	precipSDZ := Trapezoid{0, 0.5, 3, 6}
+	precipSDPHI := Trapezoid{0, 1, 15, 30}
+
+	return HCAOptions{
+		Classes: map[HydrometeorClass]ClassMembership{
+			HydroGroundClutter: {
+				REF: Trapezoid{15, 20, 70, 80}, ZDR: Trapezoid{-4, -2, 1, 2}, RHO: Trapezoid{0.5, 0.6, 0.9, 0.95},
+				LKDP: Trapezoid{-30, -25, 10, 20}, SDZ: Trapezoid{2, 4, 10, 15}, SDPHI: Trapezoid{30, 40, 50, 60},
+			},
+			HydroBiological: {
+				REF: Trapezoid{5, 10, 20, 30}, ZDR: Trapezoid{0, 2, 10, 12}, RHO: Trapezoid{0.3, 0.5, 0.8, 0.83},
+				LKDP: Trapezoid{-30, -25, 10, 10}, SDZ: Trapezoid{1, 2, 4, 7}, SDPHI: Trapezoid{8, 10, 40, 60},
+			},
+			HydroDrySnow: {
+				REF: Trapezoid{5, 10, 35, 40}, ZDR: Trapezoid{-0.3, 0, 0.3, 0.6}, RHO: Trapezoid{0.95, 0.98, 1, 1.01},
+				LKDP: Trapezoid{-30, -25, 0, 10}, SDZ: precipSDZ, SDPHI: precipSDPHI,
+			},
+			HydroWetSnow: {
+				REF: Trapezoid{25, 30, 40, 50}, ZDR: Trapezoid{0.5, 1, 2, 3}, RHO: Trapezoid{0.88, 0.92, 0.95, 0.985},
+				LKDP: Trapezoid{-30, -25, 10, 20}, SDZ: precipSDZ, SDPHI: precipSDPHI,
+			},
+			HydroIceCrystals: {
+				REF: Trapezoid{0, 5, 20, 25}, ZDR: Trapezoid{0.1, 0.4, 3, 3.3}, RHO: Trapezoid{0.95, 0.98, 1, 1.01},
+				LKDP: Trapezoid{-5, 0, 10, 15}, SDZ: precipSDZ, SDPHI: precipSDPHI,
+			},
+			HydroGraupel: {
+				REF: Trapezoid{25, 35, 50, 55}, ZDR: Trapezoid{-0.3, 0, 1, 1.3}, RHO: Trapezoid{0.9, 0.97, 1, 1.01},
+				LKDP: Trapezoid{-30, -25, 10, 20}, SDZ: precipSDZ, SDPHI: precipSDPHI,
+			},
+			HydroRain: {
+				REF: Trapezoid{5, 10, 45, 50}, ZDR: Trapezoid{0, 0.5, 2.5, 3.5}, RHO: Trapezoid{0.95, 0.97, 1, 1.01},
+				LKDP: Trapezoid{-10, -5, 5, 10}, SDZ: precipSDZ, SDPHI: precipSDPHI,
+			},
+			HydroHeavyRain: {
+				REF: Trapezoid{40, 45, 55, 60}, ZDR: Trapezoid{1.5, 2.5, 5, 6}, RHO: Trapezoid{0.92, 0.95, 1, 1.01},
+				LKDP: Trapezoid{0, 3, 13, 17}, SDZ: precipSDZ, SDPHI: precipSDPHI,
+			},
+			HydroHail: {
+				REF: Trapezoid{50, 55, 75, 80}, ZDR: Trapezoid{-0.3, 0, 1.5, 2.5}, RHO: Trapezoid{0.75, 0.85, 0.95, 1.01},
+				LKDP: Trapezoid{-10, -4, 10, 15}, SDZ: Trapezoid{1, 2, 4, 7}, SDPHI: precipSDPHI,
+			},
+		},
+		Weights:      HCAWeights{REF: 1, ZDR: 0.8, RHO: 1, LKDP: 0.6, SDZ: 0.5, SDPHI: 0.5},
+		TextureGates: 9,
+		KDP:          DefaultKDPOptions(),
+	}
+}
+
+// allowed reports whether a class can exist at the given height relative to
+// the melting layer.
+func (ml MeltingLayer) allowed(c HydrometeorClass, height float64) bool {
+	if ml.Top == 0 && ml.Bottom == 0 {
+		return true
+	}
+	switch {
+	case height > ml.Top:
+		// only frozen hydrometeors above the melting layer
+		switch c {
+		case HydroRain, HydroHeavyRain, HydroWetSnow:
+			return false
+		}
+	case height < ml.Bottom:
+		// snow melts before it reaches the bottom of the melting layer
+		switch c {
+		case HydroDrySnow, HydroWetSnow, HydroIceCrystals:
+			return false
+		}
+	default:
+		switch c {
+		case HydroDrySnow, HydroIceCrystals:
+			return false
+		}
+	}
+	return true
+}
+
+// HCA classifies every reflectivity gate of the radial using fuzzy logic over
+// the dual polarization moments. The returned gates are aligned with the
+// ReflectivityData moment and hold HydrometeorClass values, gates that could
+// not be classified are below threshold.
+func HCA(radial *archive2.Message31, opts HCAOptions) []float32 {
+	ref := scaled(radial.ReflectivityData)
+	if ref == nil {
+		return nil
+	}
+
+	zdr := scaled(radial.ZdrData)
+	rho := scaled(radial.RhoData)
+	var kdp, phi []float32
+	if radial.PhiData != nil {
+		kdp = KDP(radial, opts.KDP)
+		// the texture is taken over every gate, the low correlation gates
+		// masked for KDP are the clutter and biological echoes it identifies
+		phi = unfoldPhi(scaled(radial.PhiData))
+	}
+	half := opts.TextureGates / 2
+	ml := opts.MeltingLayer
+	if d := opts.MeltingLayerDetection; d != nil && d.Found {
+		ml = d.At(float64(radial.Header.AzimuthAngle))
+	}
+
+	classes := make([]float32, len(ref))
+	for i, z := range ref {
+		classes[i] = archive2.MomentDataBelowThreshold
+		if !valid(z) {
+			continue
+		}
+
+		r := radial.ReflectivityData.GateRange(i)
+		height := radial.GateHeight(r)
+
+		var in [6]float32
+		var has [6]bool
+		in[0], has[0] = z, true
+		in[1], has[1] = gateValue(radial.ZdrData, zdr, r)
+		in[2], has[2] = gateValue(radial.RhoData, rho, r)
+		if v, ok := gateValue(radial.PhiData, kdp, r); ok {
+			in[3], has[3] = float32(10*math.Log10(math.Max(float64(v), 0.001))), true
+		}
+		in[4], has[4] = texture(ref, i, half)
+		if radial.PhiData != nil {
+			if j := radial.PhiData.GateIndex(r); j >= 0 {
+				in[5], has[5] = texture(phi, j, half)
+			}
+		}
+
+		best := HydroNone
+		var bestScore float32
+		for _, c := range HydrometeorClasses {
+			m, ok := opts.Classes[c]
+			if !ok || !ml.allowed(c, height) {
+				continue
+			}
+			score := aggregate(m, opts.Weights, in, has)
+			if score > bestScore {
+				best, bestScore = c, score
+			}
+		}
+
+		if best != HydroNone {
+			classes[i] = float32(best)
+		}
+	}
+	return classes
+}
+
+// HCASweep classifies every radial of a sweep.
+func HCASweep(radials []*archive2.Message31, opts HCAOptions) [][]float32 {
+	hca := make([][]float32, len(radials))
+	for i, radial := range radials {
+		hca[i] = HCA(radial, opts)
+	}
+	return hca
+}
+
+// aggregate returns the weighted mean membership of a class over the
+// available input variables.
+func aggregate(m ClassMembership, w HCAWeights, in [6]float32, has [6]bool) float32 {
+	funcs := [6]Trapezoid{m.REF, m.ZDR, m.RHO, m.LKDP, m.SDZ, m.SDPHI}
+	weights := [6]float32{w.REF, w.ZDR, w.RHO, w.LKDP, w.SDZ, w.SDPHI}
+
+	var sum, total float32
+	for j := range funcs {
+		if !has[j] {
+			continue
+		}
+		sum += weights[j] * funcs[j].Membership(in[j])
+		total += weights[j]
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// gateValue returns the value of the gate of m at range r, if it is valid.
+func gateValue(m *archive2.DataMoment, gates []float32, r float64) (float32, bool) {
+	if gates == nil {
+		return 0, false
+	}
+	i := m.GateIndex(r)
+	if i < 0 || i >= len(gates) || !valid(gates[i]) {
+		return 0, false
+	}
+	return gates[i], true
+}
+
+// texture returns the standard deviation of the valid gates within half gates
+// of gate i.
+func texture(gates []float32, i, half int) (float32, bool) {
+	var sum, sumSq float64
+	n := 0
+	for j := i - half; j <= i+half; j++ {
+		if j < 0 || j >= len(gates) || !valid(gates[j]) {
+			continue
+		}
+		sum += float64(gates[j])
+		sumSq += float64(gates[j]) * float64(gates[j])
+		n++
+	}
+	if n < 3 {
+		return 0, false
+	}
+	mean := sum / float64(n)
+	return float32(math.Sqrt(math.Max(sumSq/float64(n)-mean*mean, 0))), true
+}
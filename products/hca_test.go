@@ -0,0 +1,73 @@
+package products
+
+import (
+	"testing"
+)
+
+func TestHCA(t *testing.T) {
+	radial := newRadial(0, 0.5)
+	radial.ReflectivityData = newMoment(2125, 250, fill(20, 30))
+	radial.ZdrData = newMoment(2125, 250, fill(20, 1.2))
+	radial.RhoData = newMoment(2125, 250, fill(20, 0.99))
+
+	classes := HCA(radial, DefaultHCAOptions())
+	if HydrometeorClass(classes[10]) != HydroRain {
+		t.Errorf("got %s want Rain", HydrometeorClass(classes[10]))
+	}
+
+	// same echo well above the melting layer can not be rain
+	opts := DefaultHCAOptions()
+	opts.MeltingLayer = MeltingLayer{Bottom: -1000, Top: -500}
+	classes = HCA(radial, opts)
+	if c := HydrometeorClass(classes[10]); c == HydroRain || c == HydroHeavyRain {
+		t.Errorf("got %s above the melting layer", c)
+	}
+}
+
+func TestHCAPhiTexture(t *testing.T) {
+	// a noisy differential phase tells clutter from hail in low correlation
+	// gates that are masked for KDP
+	phi := make([]float32, 20)
+	for i := range phi {
+		phi[i] = 20 + float32(i%2)*100
+	}
+	radial := newRadial(0, 0.5)
+	radial.ReflectivityData = newMoment(2125, 250, fill(20, 55))
+	radial.ZdrData = newMoment(2125, 250, fill(20, 1.5))
+	radial.RhoData = newMoment(2125, 250, fill(20, 0.89))
+
+	classes := HCA(radial, DefaultHCAOptions())
+	if c := HydrometeorClass(classes[10]); c != HydroHail {
+		t.Fatalf("without PHI got %s want Hail", c)
+	}
+
+	radial.PhiData = newScaledMoment(2125, 250, 2.8361, 2, phi)
+	classes = HCA(radial, DefaultHCAOptions())
+	if c := HydrometeorClass(classes[10]); c != HydroGroundClutter {
+		t.Errorf("got %s want Ground Clutter", c)
+	}
+}
+
+func TestHCAMeltingLayerSectors(t *testing.T) {
+	// the melting layer is below the echo in the first sector and above it
+	// over the rest of the volume
+	opts := DefaultHCAOptions()
+	opts.MeltingLayerDetection = &MeltingLayerDetection{
+		Overall: MeltingLayer{Bottom: 5000, Top: 6000},
+		Found:   true,
+		Sectors: []MeltingLayerSector{
+			{StartAzimuth: 0, EndAzimuth: 10, MeltingLayer: MeltingLayer{Bottom: -1000, Top: -500}, Found: true},
+			{StartAzimuth: 10, EndAzimuth: 20},
+		},
+	}
+	for az, rain := range map[float32]bool{5: false, 15: true, 180: true} {
+		radial := newRadial(az, 0.5)
+		radial.ReflectivityData = newMoment(2125, 250, fill(20, 30))
+		radial.ZdrData = newMoment(2125, 250, fill(20, 1.2))
+		radial.RhoData = newMoment(2125, 250, fill(20, 0.99))
+		c := HydrometeorClass(HCA(radial, opts)[10])
+		if (c == HydroRain) != rain {
+			t.Errorf("azimuth %.0f: got %s", az, c)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package products
+
+import (
+	"math"
+	"sort"
+)
+
+// Isoband is the area of a grid holding values from Min up to Max.
+type Isoband struct {
+	// Min is inclusive, Max exclusive and +Inf for the top band.
+	Min, Max float32
+	// Polygons hold rings of longitude, latitude pairs as in GeoJSON, the
+	// counterclockwise exterior ring first and then clockwise holes.
+	Polygons [][][][]float64
+}
+
+// Isobands traces the areas of the grid between consecutive thresholds with
+// marching squares, values are interpolated linearly between cell centers.
+// The last band holds every value above the last threshold. Missing cells
+// are outside of every band, they are interpolated as a value below the
+// lowest threshold so the contours of different bands don't touch at the
+// edge of the data. Rings smaller than minArea square meters are dropped to
+// remove speckle.
+func Isobands(grid *Grid, thresholds []float32, minArea float64) []Isoband {
+	levels := append([]float32{}, thresholds...)
+	sort.Slice(levels, func(i, j int) bool { return levels[i] < levels[j] })
+
+	if len(levels) == 0 {
+		return []Isoband{}
+	}
+	missing := levels[0] - (levels[len(levels)-1] - levels[0]) - 1
+
+	// rings of the area at or above every level
+	above := make([][]ring, len(levels))
+	for i, l := range levels {
+		above[i] = contours(grid, l, missing)
+	}
+
+	bands := []Isoband{}
+	cellArea := grid.Spacing * grid.Spacing
+	for i, l := range levels {
+		band := Isoband{Min: l, Max: float32(math.Inf(1))}
+		rings := append([]ring{}, above[i]...)
+		if i+1 < len(levels) {
+			band.Max = levels[i+1]
+			// the area above the next level is cut out of the band
+			for _, r := range above[i+1] {
+				rings = append(rings, r.reverse())
+			}
+		}
+		for _, p := range polygons(rings, minArea/cellArea) {
+			band.Polygons = append(band.Polygons, grid.lonLatRings(p))
+		}
+		bands = append(bands, band)
+	}
+	return bands
+}
+
+// ring is a closed contour in grid coordinates, x along columns and y up
+// along negative rows, with the area above the level on its left. The first
+// point is not repeated at the end.
+type ring [][2]float64
+
+func (r ring) reverse() ring {
+	rev := make(ring, len(r))
+	for i, p := range r {
+		rev[len(r)-1-i] = p
+	}
+	return rev
+}
+
+// area returns the signed area of the ring, positive when counterclockwise.
+func (r ring) area() float64 {
+	var a float64
+	for i, p := range r {
+		q := r[(i+1)%len(r)]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+// contains returns whether p is inside the ring using ray casting.
+func (r ring) contains(p [2]float64) bool {
+	in := false
+	for i, a := range r {
+		b := r[(i+1)%len(r)]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// contours returns the closed rings around the cells at or above level,
+// missing cells are interpolated as the missing value.
+func contours(grid *Grid, level, missing float32) []ring {
+	// the grid is padded with missing cells so every contour closes
+	size := grid.Size + 2
+	value := func(x, y int) float32 {
+		x, y = x-1, y-1
+		if x < 0 || y < 0 || x >= grid.Size || y >= grid.Size {
+			return missing
+		}
+		if v := grid.At(x, y); valid(v) {
+			return v
+		}
+		return missing
+	}
+	inside := func(x, y int) bool {
+		return value(x, y) >= level
+	}
+
+	// the crossing of the contour with the edge between two corners
+	crossing := func(x1, y1, x2, y2 int) [2]float64 {
+		v1, v2 := value(x1, y1), value(x2, y2)
+		t := math.Max(0, math.Min(1, float64((level-v1)/(v2-v1))))
+		return [2]float64{float64(x1) + t*float64(x2-x1), -(float64(y1) + t*float64(y2-y1))}
+	}
+
+	// segments keyed by the edge they start on, horizontal edges are even and
+	// vertical edges odd
+	type segment struct {
+		from, to int
+		start    [2]float64
+	}
+	next := map[int]segment{}
+	hEdge := func(x, y int) int { return 2 * (y*size + x) }
+	vEdge := func(x, y int) int { return 2*(y*size+x) + 1 }
+
+	for y := 0; y < size-1; y++ {
+		for x := 0; x < size-1; x++ {
+			tl, tr, br, bl := inside(x, y), inside(x+1, y), inside(x+1, y+1), inside(x, y+1)
+			if tl == tr && tr == br && br == bl {
+				continue
+			}
+
+			type edge struct {
+				id int
+				p  [2]float64
+			}
+			top := edge{hEdge(x, y), crossing(x, y, x+1, y)}
+			right := edge{vEdge(x+1, y), crossing(x+1, y, x+1, y+1)}
+			bottom := edge{hEdge(x, y+1), crossing(x, y+1, x+1, y+1)}
+			left := edge{vEdge(x, y), crossing(x, y, x, y+1)}
+
+			// pairs of edges joined by a segment, and a corner on the side
+			// of the segment cut off from the rest of the cell
+			type cut struct {
+				a, b   edge
+				corner [2]int
+			}
+			cuts := []cut{}
+			switch {
+			case tl == br && tr == bl:
+				// saddle, decided by the average of the corners
+				center := (value(x, y)+value(x+1, y)+value(x+1, y+1)+value(x, y+1))/4 >= level
+				if center == tl {
+					cuts = append(cuts, cut{top, right, [2]int{x + 1, y}}, cut{bottom, left, [2]int{x, y + 1}})
+				} else {
+					cuts = append(cuts, cut{left, top, [2]int{x, y}}, cut{right, bottom, [2]int{x + 1, y + 1}})
+				}
+			case tl != tr && tl != bl:
+				cuts = append(cuts, cut{left, top, [2]int{x, y}})
+			case tr != tl && tr != br:
+				cuts = append(cuts, cut{top, right, [2]int{x + 1, y}})
+			case br != tr && br != bl:
+				cuts = append(cuts, cut{right, bottom, [2]int{x + 1, y + 1}})
+			case bl != tl && bl != br:
+				cuts = append(cuts, cut{bottom, left, [2]int{x, y + 1}})
+			case tl == tr:
+				// top and bottom halves
+				cuts = append(cuts, cut{left, right, [2]int{x, y}})
+			default:
+				// left and right halves
+				cuts = append(cuts, cut{top, bottom, [2]int{x, y}})
+			}
+
+			for _, c := range cuts {
+				// orient the segment with the inside on its left
+				p, q := c.a, c.b
+				corner := [2]float64{float64(c.corner[0]), -float64(c.corner[1])}
+				cross := (q.p[0]-p.p[0])*(corner[1]-p.p[1]) - (q.p[1]-p.p[1])*(corner[0]-p.p[0])
+				if (cross > 0) != inside(c.corner[0], c.corner[1]) {
+					p, q = q, p
+				}
+				next[p.id] = segment{p.id, q.id, p.p}
+			}
+		}
+	}
+
+	// chain the segments into rings, in a stable order
+	starts := make([]int, 0, len(next))
+	for id := range next {
+		starts = append(starts, id)
+	}
+	sort.Ints(starts)
+	rings := []ring{}
+	for _, id := range starts {
+		s, ok := next[id]
+		if !ok {
+			continue
+		}
+		r := ring{}
+		for ok {
+			delete(next, s.from)
+			r = append(r, s.start)
+			s, ok = next[s.to]
+		}
+		if len(r) >= 3 {
+			rings = append(rings, r)
+		}
+	}
+	return rings
+}
+
+// polygons groups rings into polygons, every clockwise hole going to the
+// smallest counterclockwise exterior containing it. Rings with an area below
+// minArea in grid cells are dropped.
+func polygons(rings []ring, minArea float64) [][]ring {
+	type exterior struct {
+		ring
+		area     float64
+		min, max [2]float64
+		holes    []ring
+	}
+	exteriors := []*exterior{}
+	holes := []ring{}
+	for _, r := range rings {
+		a := r.area()
+		if math.Abs(a) < minArea || a == 0 {
+			continue
+		}
+		if a < 0 {
+			holes = append(holes, r)
+			continue
+		}
+		e := &exterior{ring: r, area: a, min: r[0], max: r[0]}
+		for _, p := range r {
+			e.min = [2]float64{math.Min(e.min[0], p[0]), math.Min(e.min[1], p[1])}
+			e.max = [2]float64{math.Max(e.max[0], p[0]), math.Max(e.max[1], p[1])}
+		}
+		exteriors = append(exteriors, e)
+	}
+	sort.SliceStable(exteriors, func(i, j int) bool { return exteriors[i].area < exteriors[j].area })
+
+	for _, h := range holes {
+		for _, e := range exteriors {
+			if h[0][0] < e.min[0] || h[0][0] > e.max[0] || h[0][1] < e.min[1] || h[0][1] > e.max[1] {
+				continue
+			}
+			// contours of different levels don't cross, any point will do
+			if e.contains(h[0]) {
+				e.holes = append(e.holes, h)
+				break
+			}
+		}
+	}
+
+	result := [][]ring{}
+	for _, e := range exteriors {
+		result = append(result, append([]ring{e.ring}, e.holes...))
+	}
+	return result
+}
+
+// lonLatRings converts rings in padded grid coordinates to closed rings of
+// longitude, latitude pairs.
+func (g *Grid) lonLatRings(rings []ring) [][][]float64 {
+	extent := g.Extent()
+	result := [][][]float64{}
+	for _, r := range rings {
+		coords := make([][]float64, 0, len(r)+1)
+		for _, p := range append(r, r[0]) {
+			// padded columns and rows are one off, cell centers half a cell in
+			east := (p[0]-1+0.5)*g.Spacing - extent
+			north := extent - (-p[1]-1+0.5)*g.Spacing
+			p := eastNorthLatLon(g.Lat, g.Lon, east, north)
+			coords = append(coords, []float64{p[1], p[0]})
+		}
+		result = append(result, coords)
+	}
+	return result
+}
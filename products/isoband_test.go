@@ -0,0 +1,82 @@
+package products
+
+import (
+	"math"
+	"testing"
+)
+
+// ringArea returns the signed area of a closed lon, lat ring in square degrees.
+func ringArea(r [][]float64) float64 {
+	var a float64
+	for i := 0; i+1 < len(r); i++ {
+		a += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return a / 2
+}
+
+func TestIsobands(t *testing.T) {
+	// a cone of reflectivity peaking at 60 dBZ 20 km east of the radar,
+	// dropping 1 dBZ per km, with missing data west of the radar
+	g := NewGrid(35, -97, 60000, 1000)
+	for y := 0; y < g.Size; y++ {
+		for x := 0; x < g.Size; x++ {
+			east, north := g.XY(x, y)
+			if east < 0 {
+				continue
+			}
+			g.Set(x, y, float32(60-math.Hypot(east-20000, north)/1000))
+		}
+	}
+	// speckle far from the cone
+	x, y, _ := g.Cell(45500, 45500)
+	g.Set(x, y, 45)
+
+	bands := Isobands(g, []float32{40, 20, 30}, 3e6)
+	if len(bands) != 3 {
+		t.Fatalf("%d bands, want 3", len(bands))
+	}
+	if bands[0].Min != 20 || bands[0].Max != 30 || !math.IsInf(float64(bands[2].Max), 1) {
+		t.Errorf("bands = %v-%v, %v-%v", bands[0].Min, bands[0].Max, bands[2].Min, bands[2].Max)
+	}
+
+	// the top band is a disk of 20 km radius, the lower bands rings around it
+	top := bands[2].Polygons
+	if len(top) != 1 || len(top[0]) != 1 {
+		t.Fatalf("top band has %d polygons, want a single disk", len(top))
+	}
+	if a := ringArea(top[0][0]); a <= 0 {
+		t.Errorf("exterior ring area = %v, want counterclockwise", a)
+	}
+	// 1 degree of latitude is 111 km, longitude 91 km at 35N
+	if r := math.Sqrt(ringArea(top[0][0])/math.Pi) * 100; math.Abs(r-20) > 1 {
+		t.Errorf("top band radius = %.1f km, want 20", r)
+	}
+	x0 := top[0][0][0]
+	if last := top[0][0][len(top[0][0])-1]; x0[0] != last[0] || x0[1] != last[1] {
+		t.Error("rings should be closed")
+	}
+
+	// missing data is below every band, the edge of the data fades through
+	// the lower bands which stay rings around the peak
+	middle := bands[1].Polygons
+	if len(middle) != 1 || len(middle[0]) != 2 {
+		t.Fatalf("30 dBZ band = %d polygons, want a single polygon with a hole", len(middle))
+	}
+	if a := ringArea(middle[0][1]); a >= 0 {
+		t.Errorf("hole area = %v, want clockwise", a)
+	}
+	lower := bands[0].Polygons
+	if len(lower) != 1 || len(lower[0]) != 2 {
+		t.Errorf("20 dBZ band = %d polygons, want a single polygon with a hole", len(lower))
+	}
+	for _, p := range lower[0][0] {
+		if p[0] < -97.0-0.001 {
+			t.Errorf("20 dBZ band reaches %v, west of the missing data", p)
+			break
+		}
+	}
+
+	if got := Isobands(NewGrid(35, -97, 10000, 1000), []float32{20}, 0); len(got[0].Polygons) != 0 {
+		t.Errorf("missing grid has %d polygons, want none", len(got[0].Polygons))
+	}
+}
@@ -0,0 +1,210 @@
+package products
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// KDPOptions configures the differential phase processing used to estimate
+// specific differential phase.
+type KDPOptions struct {
+	// MinRHO masks gates with a correlation coefficient below this value.
+	MinRHO float32
+	// MinREF masks gates with a reflectivity below this value in dBZ.
+	MinREF float32
+	// MedianGates is the length of the median filter applied to the unfolded
+	// differential phase, in gates.
+	MedianGates int
+	// ShortWindow is the length of the range window, in meters, used to fit
+	// KDP where the reflectivity is at least HeavyREF.
+	ShortWindow float64
+	// LongWindow is the length of the range window, in meters, used to fit
+	// KDP in lighter precipitation where the differential phase is noisier.
+	LongWindow float64
+	// HeavyREF is the reflectivity in dBZ at which the short window is used.
+	HeavyREF float32
+}
+
+// DefaultKDPOptions returns the options commonly used for S-band radars.
+func DefaultKDPOptions() KDPOptions {
+	return KDPOptions{
+		MinRHO:      0.9,
+		MinREF:      5,
+		MedianGates: 5,
+		ShortWindow: 2000,
+		LongWindow:  6000,
+		HeavyREF:    40,
+	}
+}
+
+// UnfoldPhi returns the differential phase of a radial in degrees, unfolded
+// where it wraps around from 360 to 0. Gates that are masked by the options
+// are returned as below threshold.
+func UnfoldPhi(radial *archive2.Message31, opts KDPOptions) []float32 {
+	phi := scaled(radial.PhiData)
+	if phi == nil {
+		return nil
+	}
+
+	rho := scaled(radial.RhoData)
+	ref := scaled(radial.ReflectivityData)
+
+	for i := range phi {
+		r := radial.PhiData.GateRange(i)
+		if !gateAbove(radial.RhoData, rho, r, opts.MinRHO) ||
+			!gateAbove(radial.ReflectivityData, ref, r, opts.MinREF) {
+			phi[i] = archive2.MomentDataBelowThreshold
+		}
+	}
+	return unfoldPhi(phi)
+}
+
+// unfoldPhi unfolds the valid gates of phi in place where the phase wraps
+// around from 360 to 0. Invalid gates are returned as below threshold.
+func unfoldPhi(phi []float32) []float32 {
+	prev := float32(math.NaN())
+	for i, v := range phi {
+		if !valid(v) {
+			phi[i] = archive2.MomentDataBelowThreshold
+			continue
+		}
+
+		if prev == prev {
+			for v < prev-180 {
+				v += 360
+			}
+		}
+		phi[i] = v
+		prev = v
+	}
+	return phi
+}
+
+// gateAbove reports whether the gate of m at range r holds a valid value of at
+// least min. Radials without the moment are not masked.
+func gateAbove(m *archive2.DataMoment, gates []float32, r float64, min float32) bool {
+	if gates == nil {
+		return true
+	}
+	i := m.GateIndex(r)
+	if i < 0 {
+		return false
+	}
+	return valid(gates[i]) && gates[i] >= min
+}
+
+// KDP estimates the specific differential phase of a radial in degrees per
+// kilometer. The differential phase is unfolded, masked and median filtered,
+// then KDP is fit as half the slope of the phase over a range window whose
+// length depends on the reflectivity at each gate. The returned gates are
+// aligned with the gates of the PhiData moment.
+func KDP(radial *archive2.Message31, opts KDPOptions) []float32 {
+	phi := medianFilter(UnfoldPhi(radial, opts), opts.MedianGates)
+	if phi == nil {
+		return nil
+	}
+
+	ref := scaled(radial.ReflectivityData)
+	interval := float64(radial.PhiData.DataMomentRangeSampleInterval)
+	if interval == 0 {
+		return nil
+	}
+	shortHalf := int(opts.ShortWindow / interval / 2)
+	longHalf := int(opts.LongWindow / interval / 2)
+
+	kdp := make([]float32, len(phi))
+	for i, v := range phi {
+		kdp[i] = archive2.MomentDataBelowThreshold
+		if !valid(v) {
+			continue
+		}
+
+		half := longHalf
+		if gateAbove(radial.ReflectivityData, ref, radial.PhiData.GateRange(i), opts.HeavyREF) {
+			half = shortHalf
+		}
+		if half < 1 {
+			half = 1
+		}
+
+		if slope, ok := phaseSlope(phi, i-half, i+half, interval); ok {
+			kdp[i] = float32(slope / 2)
+		}
+	}
+	return kdp
+}
+
+// KDPSweep estimates the specific differential phase for every radial of a
+// sweep.
+func KDPSweep(radials []*archive2.Message31, opts KDPOptions) [][]float32 {
+	kdp := make([][]float32, len(radials))
+	for i, radial := range radials {
+		kdp[i] = KDP(radial, opts)
+	}
+	return kdp
+}
+
+// phaseSlope fits a line to the valid phase values between gates start and end
+// and returns its slope in degrees per kilometer. At least half of the window
+// must be valid for the fit to be used.
+func phaseSlope(phi []float32, start, end int, interval float64) (float64, bool) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(phi)-1 {
+		end = len(phi) - 1
+	}
+
+	var sx, sy, sxx, sxy float64
+	n := 0
+	for j := start; j <= end; j++ {
+		if !valid(phi[j]) {
+			continue
+		}
+		x := float64(j) * interval / 1000
+		y := float64(phi[j])
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		n++
+	}
+
+	if n < 3 || n*2 < end-start+1 {
+		return 0, false
+	}
+	det := float64(n)*sxx - sx*sx
+	if det == 0 {
+		return 0, false
+	}
+	return (float64(n)*sxy - sx*sy) / det, true
+}
+
+// medianFilter applies a running median of the given length to the valid gates,
+// leaving invalid gates untouched.
+func medianFilter(gates []float32, length int) []float32 {
+	if gates == nil || length < 2 {
+		return gates
+	}
+
+	half := length / 2
+	out := make([]float32, len(gates))
+	window := make([]float32, 0, length)
+	for i, v := range gates {
+		if !valid(v) {
+			out[i] = v
+			continue
+		}
+		window = window[:0]
+		for j := i - half; j <= i+half; j++ {
+			if j >= 0 && j < len(gates) && valid(gates[j]) {
+				window = append(window, gates[j])
+			}
+		}
+		sort.Slice(window, func(a, b int) bool { return window[a] < window[b] })
+		out[i] = window[len(window)/2]
+	}
+	return out
+}
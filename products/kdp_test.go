@@ -0,0 +1,36 @@
+package products
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKDP(t *testing.T) {
+	// differential phase increasing 2 deg/km and wrapping past 360
+	phi := make([]float32, 200)
+	for i := range phi {
+		phi[i] = float32(math.Mod(300+float64(i)*0.25*2, 360))
+	}
+
+	radial := newRadial(0, 0.5)
+	// PHI is encoded as in level II data, 0 to 360 degrees in 10 bits
+	radial.PhiData = newScaledMoment(2125, 250, 2.8361, 2, phi)
+	radial.RhoData = newMoment(2125, 250, fill(200, 0.99))
+	radial.ReflectivityData = newMoment(2125, 250, fill(200, 45))
+
+	// the phase wraps past 360 at gate 120
+	unfolded := UnfoldPhi(radial, DefaultKDPOptions())
+	for _, i := range []int{0, 119, 121, 199} {
+		want := 300 + float64(i)*0.5
+		if math.Abs(float64(unfolded[i])-want) > 0.5 {
+			t.Errorf("gate %d: unfolded %f want %f", i, unfolded[i], want)
+		}
+	}
+
+	kdp := KDP(radial, DefaultKDPOptions())
+	for _, i := range []int{20, 100, 115, 125, 180} {
+		if math.Abs(float64(kdp[i])-1) > 0.05 {
+			t.Errorf("gate %d: got %f want 1", i, kdp[i])
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package products
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// MeltingLayerOptions configures the melting layer detection.
+type MeltingLayerOptions struct {
+	// MinElevation and MaxElevation bound the elevation angles in degrees that
+	// are searched. Low tilts smear the bright band over a large depth and
+	// high tilts sample it over a short range.
+	MinElevation, MaxElevation float32
+	// RHO, REF and ZDR are the ranges of values, Min to Max, a gate must fall
+	// within to be considered part of the melting layer.
+	MinRHO, MaxRHO float32
+	MinREF, MaxREF float32
+	MinZDR, MaxZDR float32
+	// SectorWidth is the width of the azimuth sectors in degrees.
+	SectorWidth float64
+	// MinGates is the number of melting layer gates a sector needs for its
+	// heights to be reported.
+	MinGates int
+	// BottomPercentile and TopPercentile select the heights, from the
+	// distribution of melting layer gate heights, reported as the bottom and
+	// top of the layer.
+	BottomPercentile, TopPercentile float64
+}
+
+// DefaultMeltingLayerOptions returns options adapted from Giangrande et al.
+// (2008) "An Automated Detection of the Bright Band Using WSR-88D Data".
+func DefaultMeltingLayerOptions() MeltingLayerOptions {
+	return MeltingLayerOptions{
+		MinElevation:     4,
+		MaxElevation:     10,
+		MinRHO:           0.90,
+		MaxRHO:           0.97,
+		MinREF:           30,
+		MaxREF:           47,
+		MinZDR:           0.8,
+		MaxZDR:           2.5,
+		SectorWidth:      10,
+		MinGates:         20,
+		BottomPercentile: 0.2,
+		TopPercentile:    0.8,
+	}
+}
+
+// MeltingLayerSector holds the melting layer detected within an azimuth sector.
+type MeltingLayerSector struct {
+	StartAzimuth, EndAzimuth float64
+	MeltingLayer
+	// Gates is the number of melting layer gates found in the sector.
+	Gates int
+	// Found is false when the sector held too few gates for a detection.
+	Found bool
+}
+
+// MeltingLayerDetection is the result of a melting layer detection over a volume.
+type MeltingLayerDetection struct {
+	// Overall is the melting layer detected from all the gates in the volume.
+	Overall MeltingLayer
+	Found   bool
+	Sectors []MeltingLayerSector
+}
+
+// At returns the melting layer at the given azimuth, falling back to the
+// overall melting layer for sectors without a detection.
+func (d MeltingLayerDetection) At(azimuth float64) MeltingLayer {
+	for _, s := range d.Sectors {
+		if s.Found && azimuth >= s.StartAzimuth && azimuth < s.EndAzimuth {
+			return s.MeltingLayer
+		}
+	}
+	return d.Overall
+}
+
+// DetectMeltingLayer finds the bottom and top heights of the melting layer
+// around the radar from the bright band signature: reduced correlation
+// coefficient along with enhanced reflectivity and differential reflectivity.
+func DetectMeltingLayer(ar2 *archive2.Archive2, opts MeltingLayerOptions) MeltingLayerDetection {
+	numSectors := int(math.Ceil(360 / opts.SectorWidth))
+	sectorHeights := make([][]float64, numSectors)
+	all := []float64{}
+
+	for _, elv := range ar2.Elevations() {
+		for _, radial := range ar2.ElevationScans[elv] {
+			el := radial.Header.ElevationAngle
+			if el < opts.MinElevation || el > opts.MaxElevation {
+				continue
+			}
+
+			heights := meltingLayerGates(radial, opts)
+			if len(heights) == 0 {
+				continue
+			}
+			s := int(float64(radial.Header.AzimuthAngle)/opts.SectorWidth) % numSectors
+			sectorHeights[s] = append(sectorHeights[s], heights...)
+			all = append(all, heights...)
+		}
+	}
+
+	d := MeltingLayerDetection{Sectors: make([]MeltingLayerSector, numSectors)}
+	if len(all) >= opts.MinGates {
+		d.Overall = percentileLayer(all, opts)
+		d.Found = true
+	}
+
+	for i, heights := range sectorHeights {
+		s := MeltingLayerSector{
+			StartAzimuth: float64(i) * opts.SectorWidth,
+			EndAzimuth:   math.Min(float64(i+1)*opts.SectorWidth, 360),
+			Gates:        len(heights),
+		}
+		if len(heights) >= opts.MinGates {
+			s.MeltingLayer = percentileLayer(heights, opts)
+			s.Found = true
+		}
+		d.Sectors[i] = s
+	}
+	return d
+}
+
+// meltingLayerGates returns the heights of the gates in the radial that show
+// the melting layer signature.
+func meltingLayerGates(radial *archive2.Message31, opts MeltingLayerOptions) []float64 {
+	rho := scaled(radial.RhoData)
+	ref := scaled(radial.ReflectivityData)
+	zdr := scaled(radial.ZdrData)
+	if rho == nil || ref == nil || zdr == nil {
+		return nil
+	}
+
+	heights := []float64{}
+	for i, v := range rho {
+		if !valid(v) || v < opts.MinRHO || v > opts.MaxRHO {
+			continue
+		}
+		r := radial.RhoData.GateRange(i)
+		z, ok := gateValue(radial.ReflectivityData, ref, r)
+		if !ok || z < opts.MinREF || z > opts.MaxREF {
+			continue
+		}
+		d, ok := gateValue(radial.ZdrData, zdr, r)
+		if !ok || d < opts.MinZDR || d > opts.MaxZDR {
+			continue
+		}
+		heights = append(heights, radial.GateHeight(r))
+	}
+	return heights
+}
+
+// percentileLayer returns the melting layer bounded by the configured
+// percentiles of the heights.
+func percentileLayer(heights []float64, opts MeltingLayerOptions) MeltingLayer {
+	sort.Float64s(heights)
+	return MeltingLayer{
+		Bottom: percentile(heights, opts.BottomPercentile),
+		Top:    percentile(heights, opts.TopPercentile),
+	}
+}
+
+// percentile returns the value at fraction p of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i]
+}
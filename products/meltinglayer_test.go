@@ -0,0 +1,38 @@
+package products
+
+import (
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestDetectMeltingLayer(t *testing.T) {
+	// bright band between 20 and 30km range at 6 degrees, roughly 2.1km to
+	// 3.2km above the radar.
+	rho := fill(200, 0.99)
+	ref := fill(200, 25)
+	zdr := fill(200, 0.3)
+	for i := 72; i < 112; i++ {
+		rho[i], ref[i], zdr[i] = 0.94, 40, 1.5
+	}
+
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for az := float32(0); az < 360; az += 1 {
+		r := newRadial(az, 6)
+		r.RhoData = newMoment(2125, 250, rho)
+		r.ReflectivityData = newMoment(2125, 250, ref)
+		r.ZdrData = newMoment(2125, 250, zdr)
+		ar2.ElevationScans[5] = append(ar2.ElevationScans[5], r)
+	}
+
+	d := DetectMeltingLayer(ar2, DefaultMeltingLayerOptions())
+	if !d.Found {
+		t.Fatal("expected a melting layer")
+	}
+	if d.Overall.Bottom < 2000 || d.Overall.Bottom > 2700 || d.Overall.Top < 2700 || d.Overall.Top > 3300 {
+		t.Errorf("unexpected melting layer %+v", d.Overall)
+	}
+	if len(d.Sectors) != 36 || !d.Sectors[0].Found {
+		t.Errorf("expected 36 sectors with detections")
+	}
+}
@@ -0,0 +1,310 @@
+package products
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// MicroburstOptions configures the detection of low level divergence and
+// descending reflectivity cores.
+type MicroburstOptions struct {
+	// MaxElevation is the highest elevation angle in degrees of the sweeps
+	// searched for divergence.
+	MaxElevation float64
+	// MaxRange ignores gates beyond this range in meters, where the beam is
+	// too high to see the outflow.
+	MaxRange float64
+	// Window is the largest distance in meters along the radial over which
+	// the velocity difference is measured.
+	Window float64
+	// DivergenceDeltaV and MicroburstDeltaV are the velocity differences in
+	// m/s across the window for divergence and microburst alerts.
+	DivergenceDeltaV, MicroburstDeltaV float32
+	// MinRadials is the number of adjacent radials a divergence signature
+	// needs.
+	MinRadials int
+	// CoreREF is the reflectivity in dBZ of cores tracked for descent.
+	CoreREF float32
+	// MinDescent is the drop in meters of the height of the maximum
+	// reflectivity of a cell between volumes for a descending core alert.
+	MinDescent float64
+	// MinCoreHeight is the height in meters above sea level the core has to
+	// start descending from.
+	MinCoreHeight float64
+	SCIT          SCITOptions
+}
+
+// DefaultMicroburstOptions returns options adapted from the thresholds used by
+// terminal doppler weather radars, a 10 m/s velocity difference within 4 km
+// for microbursts.
+func DefaultMicroburstOptions() MicroburstOptions {
+	return MicroburstOptions{
+		MaxElevation:     1,
+		MaxRange:         80000,
+		Window:           4000,
+		DivergenceDeltaV: 5,
+		MicroburstDeltaV: 10,
+		MinRadials:       2,
+		CoreREF:          50,
+		MinDescent:       1000,
+		MinCoreHeight:    3000,
+		SCIT:             DefaultSCITOptions(),
+	}
+}
+
+// AlertKind is the kind of an Alert.
+type AlertKind int
+
+const (
+	// DivergenceAlert is low level divergence below the microburst threshold.
+	DivergenceAlert AlertKind = iota
+	// MicroburstAlert is low level divergence above the microburst threshold.
+	MicroburstAlert
+	// DescendingCoreAlert is a reflectivity core descending from aloft, a
+	// precursor of microbursts.
+	DescendingCoreAlert
+)
+
+func (k AlertKind) String() string {
+	switch k {
+	case DivergenceAlert:
+		return "divergence"
+	case MicroburstAlert:
+		return "microburst"
+	case DescendingCoreAlert:
+		return "descending core"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the kind as its name.
+func (k AlertKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Alert is a microburst, divergence or descending core signature.
+type Alert struct {
+	Kind AlertKind `json:"kind"`
+	Time time.Time `json:"time"`
+	// Lat and Lon of the center of the signature.
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	// Azimuth in degrees and Range in meters along the ground from the radar.
+	Azimuth float64 `json:"azimuth"`
+	Range   float64 `json:"range"`
+	// Height in meters above sea level of the signature. For descending
+	// cores the current height of the maximum reflectivity.
+	Height float64 `json:"height"`
+	// DeltaV is the largest velocity difference in m/s across the
+	// divergence and Length the distance in meters it was measured over.
+	DeltaV float32 `json:"delta_v,omitempty"`
+	Length float64 `json:"length,omitempty"`
+	// CellID, MaxREF, Descent in meters and DescentRate in m/s describe
+	// descending cores.
+	CellID      int     `json:"cell,omitempty"`
+	MaxREF      float32 `json:"max_ref,omitempty"`
+	Descent     float64 `json:"descent,omitempty"`
+	DescentRate float64 `json:"descent_rate,omitempty"`
+}
+
+// Distance returns the distance in meters from the alert to lat, lon, to
+// check for alerts near a runway.
+func (a Alert) Distance(lat, lon float64) float64 {
+	_, d := archive2.BearingDistance(a.Lat, a.Lon, lat, lon)
+	return d
+}
+
+// DetectDivergence finds low level divergence signatures on the lowest sweeps
+// of the volume.
+func DetectDivergence(ar2 *archive2.Archive2, opts MicroburstOptions) []Alert {
+	alerts := []Alert{}
+	for _, sweep := range distinctSweeps(ar2, "VEL") {
+		if sweep.ElevationAngle > opts.MaxElevation {
+			break
+		}
+		for _, a := range divergence(sweep, opts) {
+			a.Time = ar2.VolumeHeader.Date()
+			alerts = append(alerts, a)
+		}
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].DeltaV > alerts[j].DeltaV })
+	return alerts
+}
+
+// divergence returns the divergence signatures of a single sweep.
+func divergence(sweep *archive2.Sweep, opts MicroburstOptions) []Alert {
+	type segment struct {
+		start, end int
+		dv         float32
+	}
+	// the strongest divergence starting at every gate
+	segments := map[gate]segment{}
+	for i, radial := range sweep.Radials {
+		m := radial.VelocityData
+		if m == nil {
+			continue
+		}
+		vel := sweep.Gates(i, "VEL")
+		n := int(opts.Window / float64(m.DataMomentRangeSampleInterval))
+		// differences beyond 1.5 times the Nyquist velocity are likely aliased
+		maxDV := float32(1.5 * float64(radial.RadialData.NyquistVelocity) / 100)
+		for g, v := range vel {
+			if m.GateRange(g) > opts.MaxRange {
+				break
+			}
+			if !valid(v) {
+				continue
+			}
+			best := segment{start: g}
+			for j := g + 1; j <= g+n && j < len(vel); j++ {
+				if !valid(vel[j]) {
+					continue
+				}
+				if dv := vel[j] - v; dv > best.dv && (maxDV <= 0 || dv <= maxDV) {
+					best.end, best.dv = j, dv
+				}
+			}
+			if best.dv >= opts.DivergenceDeltaV {
+				segments[gate{i, g}] = best
+			}
+		}
+	}
+
+	// group the connected gates, in gate order so the alerts are always the
+	// same
+	starts := make([]gate, 0, len(segments))
+	for g := range segments {
+		starts = append(starts, g)
+	}
+	sort.Slice(starts, func(i, j int) bool { return before(starts[i], starts[j]) })
+
+	alerts := []Alert{}
+	visited := map[gate]bool{}
+	for _, start := range starts {
+		if visited[start] {
+			continue
+		}
+		members := []gate{}
+		stack := []gate{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			members = append(members, c)
+			for da := -1; da <= 1; da++ {
+				for dg := -2; dg <= 2; dg++ {
+					n := gate{(c.radial + da + len(sweep.Radials)) % len(sweep.Radials), c.gate + dg}
+					if _, ok := segments[n]; ok && !visited[n] {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+
+		radials := map[int]bool{}
+		strongest := members[0]
+		for _, g := range members {
+			radials[g.radial] = true
+			if dv := segments[g].dv; dv > segments[strongest].dv || dv == segments[strongest].dv && before(g, strongest) {
+				strongest = g
+			}
+		}
+		if len(radials) < opts.MinRadials {
+			continue
+		}
+
+		s := segments[strongest]
+		radial := sweep.Radials[strongest.radial]
+		m := radial.VelocityData
+		r := (m.GateRange(s.start) + m.GateRange(s.end)) / 2
+		lat, lon, h := radial.GateLocation(r)
+		kind := DivergenceAlert
+		if s.dv >= opts.MicroburstDeltaV {
+			kind = MicroburstAlert
+		}
+		alerts = append(alerts, Alert{
+			Kind:    kind,
+			Lat:     lat,
+			Lon:     lon,
+			Azimuth: float64(radial.Header.AzimuthAngle),
+			Range:   archive2.GroundRange(r, sweep.ElevationAngle),
+			Height:  h,
+			DeltaV:  s.dv,
+			Length:  m.GateRange(s.end) - m.GateRange(s.start),
+		})
+	}
+	return alerts
+}
+
+// before orders gates by radial, then gate.
+func before(a, b gate) bool {
+	if a.radial != b.radial {
+		return a.radial < b.radial
+	}
+	return a.gate < b.gate
+}
+
+// MicroburstDetector detects divergence in every volume and descending
+// reflectivity cores by tracking storm cells across volumes.
+type MicroburstDetector struct {
+	opts    MicroburstOptions
+	tracker *Tracker
+	cells   map[int]StormCell
+}
+
+// NewMicroburstDetector returns a MicroburstDetector without any history.
+func NewMicroburstDetector(opts MicroburstOptions) *MicroburstDetector {
+	return &MicroburstDetector{
+		opts:    opts,
+		tracker: NewTracker(opts.SCIT),
+		cells:   map[int]StormCell{},
+	}
+}
+
+// Add returns the alerts of a volume. Volumes must be added in time order,
+// descending cores are only found from the second volume on.
+func (d *MicroburstDetector) Add(ar2 *archive2.Archive2) []Alert {
+	alerts := DetectDivergence(ar2, d.opts)
+
+	cells := d.tracker.Add(ar2)
+	alerts = append(alerts, descendingCores(d.cells, cells, d.opts)...)
+	d.cells = map[int]StormCell{}
+	for _, c := range cells {
+		d.cells[c.ID] = c
+	}
+	return alerts
+}
+
+// descendingCores compares the cells with the cells of the same track in the
+// previous volume and returns an alert for every core that descended.
+func descendingCores(previous map[int]StormCell, cells []StormCell, opts MicroburstOptions) []Alert {
+	alerts := []Alert{}
+	for _, c := range cells {
+		p, ok := previous[c.ID]
+		if !ok || p.MaxREF < opts.CoreREF || p.MaxREFHeight < opts.MinCoreHeight {
+			continue
+		}
+		descent := p.MaxREFHeight - c.MaxREFHeight
+		dt := c.Time.Sub(p.Time).Seconds()
+		if descent < opts.MinDescent || dt <= 0 {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Kind:        DescendingCoreAlert,
+			Time:        c.Time,
+			Lat:         c.Lat,
+			Lon:         c.Lon,
+			Azimuth:     c.Azimuth,
+			Range:       c.Range,
+			Height:      c.MaxREFHeight,
+			CellID:      c.ID,
+			MaxREF:      c.MaxREF,
+			Descent:     descent,
+			DescentRate: descent / dt,
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,73 @@
+package products
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestDetectDivergence(t *testing.T) {
+	// outflow of 12 m/s either side of a downdraft 30 km north of the radar
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for az := 0.25; az < 360; az += 0.5 {
+		vel := make([]float32, 400)
+		for g := range vel {
+			r := 2125 + float64(g)*250
+			x, y := r*math.Sin(az*degToRad), r*math.Cos(az*degToRad)
+			d := math.Hypot(x, y-30000)
+			if d < 3000 {
+				vel[g] = float32(12 * math.Min(d/1000, 1) * (y - 30000) / math.Max(d, 1))
+			}
+		}
+		radial := newRadial(float32(az), 0.5)
+		radial.VolumeData.Lat = 35
+		radial.VolumeData.Long = -97
+		radial.RadialData.NyquistVelocity = 3000
+		radial.VelocityData = newMoment(2125, 250, vel)
+		ar2.ElevationScans[1] = append(ar2.ElevationScans[1], radial)
+	}
+
+	alerts := DetectDivergence(ar2, DefaultMicroburstOptions())
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	a := alerts[0]
+	if a.Kind != MicroburstAlert || math.Abs(float64(a.DeltaV)-24) > 1 {
+		t.Errorf("got %s of %f m/s, want microburst of 24 m/s", a.Kind, a.DeltaV)
+	}
+	lat, lon := archive2.Destination(35, -97, 0, 30000)
+	if d := a.Distance(lat, lon); d > 1000 {
+		t.Errorf("alert is %f m from the downdraft", d)
+	}
+
+	// ties between gates are broken the same way every time
+	for i := 0; i < 20; i++ {
+		if again := DetectDivergence(ar2, DefaultMicroburstOptions()); len(again) != 1 || again[0] != a {
+			t.Fatalf("got %+v, then %+v", a, again)
+		}
+	}
+}
+
+func TestDescendingCores(t *testing.T) {
+	opts := DefaultMicroburstOptions()
+	start := time.Date(2013, 5, 20, 20, 0, 0, 0, time.UTC)
+	previous := map[int]StormCell{
+		1: {ID: 1, Time: start, MaxREF: 60, MaxREFHeight: 6000},
+		2: {ID: 2, Time: start, MaxREF: 60, MaxREFHeight: 5000},
+	}
+	cells := []StormCell{
+		{ID: 1, Time: start.Add(5 * time.Minute), MaxREF: 62, MaxREFHeight: 3600},
+		{ID: 2, Time: start.Add(5 * time.Minute), MaxREF: 60, MaxREFHeight: 4800},
+		{ID: 3, Time: start.Add(5 * time.Minute), MaxREF: 60, MaxREFHeight: 1000},
+	}
+
+	alerts := descendingCores(previous, cells, opts)
+	if len(alerts) != 1 || alerts[0].CellID != 1 {
+		t.Fatalf("expected a descending core alert for cell 1, got %+v", alerts)
+	}
+	if alerts[0].Descent != 2400 || alerts[0].DescentRate != 8 {
+		t.Errorf("got descent %f at %f m/s, want 2400 at 8 m/s", alerts[0].Descent, alerts[0].DescentRate)
+	}
+}
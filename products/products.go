@@ -0,0 +1,29 @@
+// Package products implements derived radar products computed from the base
+// data moments decoded by the archive2 package.
+package products
+
+import (
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+const (
+	degToRad = math.Pi / 180.0
+	radToDeg = 180.0 / math.Pi
+)
+
+// scaled returns the scaled gate values of a data moment, or nil if the moment
+// is not present in the radial.
+func scaled(m *archive2.DataMoment) []float32 {
+	if m == nil {
+		return nil
+	}
+	return m.ScaledData()
+}
+
+// valid reports whether v holds an actual measurement rather than one of the
+// below threshold or range folded markers.
+func valid(v float32) bool {
+	return v != archive2.MomentDataBelowThreshold && v != archive2.MomentDataFolded
+}
@@ -0,0 +1,63 @@
+package products
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// newMoment encodes values into a 16 bit data moment with gates starting at
+// first meters and spaced interval meters apart.
+func newMoment(first, interval uint16, values []float32) *archive2.DataMoment {
+	return newScaledMoment(first, interval, 100, 32768, values)
+}
+
+// newScaledMoment is newMoment with the given scale and offset. It panics on
+// values that do not fit the encoding.
+func newScaledMoment(first, interval uint16, scale, offset float32, values []float32) *archive2.DataMoment {
+	m := &archive2.DataMoment{}
+	m.NumberDataMomentGates = uint16(len(values))
+	m.DataMomentRange = first
+	m.DataMomentRangeSampleInterval = interval
+	m.DataWordSize = 16
+	m.Scale = scale
+	m.Offset = offset
+	m.Data = make([]byte, 2*len(values))
+	for i, v := range values {
+		var n uint16
+		switch v {
+		case archive2.MomentDataBelowThreshold:
+			n = 0
+		case archive2.MomentDataFolded:
+			n = 1
+		default:
+			f := math.Round(float64(v*m.Scale + m.Offset))
+			if f < 2 || f > math.MaxUint16 {
+				panic(fmt.Sprintf("gate %d: %f does not fit scale %f offset %f", i, v, scale, offset))
+			}
+			n = uint16(f)
+		}
+		binary.BigEndian.PutUint16(m.Data[2*i:], n)
+	}
+	return m
+}
+
+// newRadial returns a radial at the given azimuth and elevation angle.
+func newRadial(az, el float32) *archive2.Message31 {
+	r := &archive2.Message31{}
+	r.Header.AzimuthAngle = az
+	r.Header.ElevationAngle = el
+	r.Header.AzimuthResolutionSpacingCode = 1
+	return r
+}
+
+// fill returns n gates holding v.
+func fill(n int, v float32) []float32 {
+	gates := make([]float32, n)
+	for i := range gates {
+		gates[i] = v
+	}
+	return gates
+}
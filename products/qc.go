@@ -0,0 +1,162 @@
+package products
+
+import (
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// QCOptions configures the rules used to remove non meteorological echoes such
+// as ground clutter, anomalous propagation, biological targets and chaff. A
+// zero threshold disables its rule.
+type QCOptions struct {
+	// MinRHO removes gates with a correlation coefficient below this value.
+	// Weather is highly correlated while birds, insects and clutter are not.
+	MinRHO float32
+	// MaxPHITexture removes gates where the standard deviation of the
+	// differential phase along the radial, in degrees, exceeds this value.
+	MaxPHITexture float32
+	// MaxZDRTexture removes gates where the standard deviation of the
+	// differential reflectivity along the radial, in dB, exceeds this value.
+	MaxZDRTexture float32
+	// MinSNR removes gates with an estimated signal to noise ratio in dB below
+	// this value. Radials without a calibration constant are not checked.
+	MinSNR float32
+	// MaxCFP removes gates where the clutter filter removed more than this
+	// much power in dB.
+	MaxCFP float32
+	// TextureGates is the number of gates used to compute the textures.
+	TextureGates int
+}
+
+// DefaultQCOptions returns options that remove the most common non
+// meteorological echoes while leaving the precipitation intact.
+func DefaultQCOptions() QCOptions {
+	return QCOptions{
+		MinRHO:        0.8,
+		MaxPHITexture: 20,
+		MaxZDRTexture: 2.5,
+		MinSNR:        3,
+		MaxCFP:        20,
+		TextureGates:  9,
+	}
+}
+
+// QCMask marks the gates of a radial that are meteorological echoes.
+type QCMask struct {
+	first, interval float64
+	keep            []bool
+}
+
+// Keep reports whether the gate at slant range r in meters passed the quality
+// control. Ranges outside the mask are kept.
+func (m *QCMask) Keep(r float64) bool {
+	if m == nil || m.interval == 0 {
+		return true
+	}
+	i := int(math.Floor((r-m.first)/m.interval + 0.5))
+	if i < 0 || i >= len(m.keep) {
+		return true
+	}
+	return m.keep[i]
+}
+
+// Apply returns a copy of the scaled gates of moment m with the gates that
+// failed the quality control set below threshold.
+func (m *QCMask) Apply(moment *archive2.DataMoment, gates []float32) []float32 {
+	if moment == nil || gates == nil {
+		return gates
+	}
+	out := make([]float32, len(gates))
+	for i, v := range gates {
+		if m.Keep(moment.GateRange(i)) {
+			out[i] = v
+		} else {
+			out[i] = archive2.MomentDataBelowThreshold
+		}
+	}
+	return out
+}
+
+// QC evaluates the quality control rules for every gate of the radial. The
+// mask follows the gates of the reflectivity moment, or of the velocity moment
+// for radials without reflectivity. Rules whose moment is missing from the
+// radial are skipped.
+func QC(radial *archive2.Message31, opts QCOptions) *QCMask {
+	base := radial.ReflectivityData
+	if base == nil {
+		base = radial.VelocityData
+	}
+	if base == nil {
+		return &QCMask{}
+	}
+
+	mask := &QCMask{
+		first:    float64(base.DataMomentRange),
+		interval: float64(base.DataMomentRangeSampleInterval),
+		keep:     make([]bool, base.NumberDataMomentGates),
+	}
+
+	ref := scaled(radial.ReflectivityData)
+	rho := scaled(radial.RhoData)
+	zdr := scaled(radial.ZdrData)
+	cfp := scaled(radial.CfpData)
+	var phi []float32
+	if radial.PhiData != nil {
+		phi = UnfoldPhi(radial, KDPOptions{MinREF: -33})
+	}
+	half := opts.TextureGates / 2
+
+	for i := range mask.keep {
+		r := base.GateRange(i)
+		mask.keep[i] = true
+
+		if opts.MinRHO > 0 {
+			if v, ok := gateValue(radial.RhoData, rho, r); ok && v < opts.MinRHO {
+				mask.keep[i] = false
+				continue
+			}
+		}
+
+		if opts.MaxPHITexture > 0 && phi != nil {
+			if j := radial.PhiData.GateIndex(r); j >= 0 && valid(phi[j]) {
+				if sd, ok := texture(phi, j, half); ok && sd > opts.MaxPHITexture {
+					mask.keep[i] = false
+					continue
+				}
+			}
+		}
+
+		if opts.MaxZDRTexture > 0 && zdr != nil {
+			if j := radial.ZdrData.GateIndex(r); j >= 0 && valid(zdr[j]) {
+				if sd, ok := texture(zdr, j, half); ok && sd > opts.MaxZDRTexture {
+					mask.keep[i] = false
+					continue
+				}
+			}
+		}
+
+		// a zero calibration constant is unset, not a 0 dBZ noise level
+		if opts.MinSNR > 0 && radial.RadialData.CalibConstHorzChan != 0 {
+			if z, ok := gateValue(radial.ReflectivityData, ref, r); ok && SNR(radial, z, r) < opts.MinSNR {
+				mask.keep[i] = false
+				continue
+			}
+		}
+
+		if opts.MaxCFP > 0 {
+			if v, ok := gateValue(radial.CfpData, cfp, r); ok && v > opts.MaxCFP {
+				mask.keep[i] = false
+				continue
+			}
+		}
+	}
+	return mask
+}
+
+// SNR estimates the signal to noise ratio in dB of a reflectivity gate at
+// slant range r in meters from the horizontal channel calibration constant,
+// which is the reflectivity of a 0 dB SNR signal at 1km.
+func SNR(radial *archive2.Message31, dbz float32, r float64) float32 {
+	return dbz - radial.RadialData.CalibConstHorzChan - float32(20*math.Log10(r/1000))
+}
@@ -0,0 +1,44 @@
+package products
+
+import (
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestQC(t *testing.T) {
+	rho := fill(40, 0.98)
+	for i := 10; i < 20; i++ {
+		// bird bloom
+		rho[i] = 0.4
+	}
+
+	radial := newRadial(0, 0.5)
+	radial.ReflectivityData = newMoment(2125, 250, fill(40, 20))
+	radial.RhoData = newMoment(2125, 250, rho)
+	radial.RadialData.CalibConstHorzChan = -45
+
+	mask := QC(radial, DefaultQCOptions())
+	ref := mask.Apply(radial.ReflectivityData, radial.ReflectivityData.ScaledData())
+	for i, v := range ref {
+		masked := v == archive2.MomentDataBelowThreshold
+		if masked != (i >= 10 && i < 20) {
+			t.Errorf("gate %d: masked=%t", i, masked)
+		}
+	}
+}
+
+func TestQCWithoutCalibration(t *testing.T) {
+	// 20 dBZ out to 100km is well above the noise of a -45 dB calibration
+	// constant, an unset constant must not mask it
+	radial := newRadial(0, 0.5)
+	radial.ReflectivityData = newMoment(2125, 250, fill(400, 20))
+
+	mask := QC(radial, DefaultQCOptions())
+	ref := mask.Apply(radial.ReflectivityData, radial.ReflectivityData.ScaledData())
+	for i, v := range ref {
+		if v == archive2.MomentDataBelowThreshold {
+			t.Fatalf("gate %d masked", i)
+		}
+	}
+}
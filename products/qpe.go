@@ -0,0 +1,357 @@
+package products
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// ZR is a reflectivity to rain rate relationship of the form Z = A*R^B, with Z
+// in mm^6/m^3 and R in mm/h.
+type ZR struct {
+	A, B float64
+}
+
+// Z-R relationships used operationally by the WSR-88D network.
+var (
+	ZRMarshallPalmer = ZR{200, 1.6}
+	ZRConvective     = ZR{300, 1.4}
+	ZRTropical       = ZR{250, 1.2}
+	ZRCoolStratiform = ZR{130, 2.0}
+	ZRWarmStratiform = ZR{75, 2.0}
+)
+
+// ZRRelationships maps the names of the Z-R relationships to their values.
+var ZRRelationships = map[string]ZR{
+	"marshall-palmer": ZRMarshallPalmer,
+	"convective":      ZRConvective,
+	"tropical":        ZRTropical,
+	"cool-stratiform": ZRCoolStratiform,
+	"warm-stratiform": ZRWarmStratiform,
+}
+
+// RainRate returns the rain rate in mm/h for a reflectivity in dBZ.
+func (zr ZR) RainRate(dbz float32) float32 {
+	z := math.Pow(10, float64(dbz)/10)
+	return float32(math.Pow(z/zr.A, 1/zr.B))
+}
+
+// RainRateMethod selects the moments used to estimate rain rate.
+type RainRateMethod int
+
+const (
+	// RainRateZ estimates rain rate from reflectivity using a Z-R relationship.
+	RainRateZ RainRateMethod = iota
+	// RainRateKDP estimates rain rate from specific differential phase,
+	// falling back to R(Z) where KDP is not available or not positive.
+	RainRateKDP
+	// RainRateZZDR estimates rain rate from reflectivity and differential
+	// reflectivity, falling back to R(Z) where ZDR is not available.
+	RainRateZZDR
+)
+
+// QPEOptions configures the quantitative precipitation estimation.
+type QPEOptions struct {
+	Method RainRateMethod
+	ZR     ZR
+	// MinREF is the reflectivity in dBZ below which no rain is estimated.
+	MinREF float32
+	// MaxREF caps the reflectivity in dBZ to limit the contamination of the
+	// rain rate by hail.
+	MaxREF float32
+	// MinRHO excludes non meteorological echoes with a correlation coefficient
+	// below this value. Radials without RHO are not filtered.
+	MinRHO float32
+	// MaxHeight is the maximum beam height in meters above the radar used by
+	// the hybrid scan, keeping the estimate below the melting layer.
+	MaxHeight float64
+	// Blocked reports whether the beam at the given elevation angle is blocked
+	// at the azimuth in degrees and ground range in meters. A blocked tilt is
+	// skipped by the hybrid scan. nil means no tilt is blocked.
+	Blocked func(azimuth, groundRange, elevation float64) bool
+	// Extent is the range in meters covered by the output grids.
+	Extent float64
+	// Spacing is the size of the grid cells in meters.
+	Spacing float64
+	// MaxGap is the longest time between two volumes that is accumulated over.
+	// Gaps in the data longer than this are missing, see Accumulator.Missing.
+	MaxGap time.Duration
+	KDP    KDPOptions
+}
+
+// DefaultQPEOptions returns the options for R(Z) with the convective Z-R
+// relationship used by default by the WSR-88D. Like the WSR-88D precipitation
+// processing, gaps of more than 30 minutes between volumes are not filled.
+func DefaultQPEOptions() QPEOptions {
+	return QPEOptions{
+		Method:    RainRateZ,
+		ZR:        ZRConvective,
+		MinREF:    10,
+		MaxREF:    53,
+		MinRHO:    0.85,
+		MaxHeight: 5000,
+		Extent:    230000,
+		Spacing:   1000,
+		MaxGap:    30 * time.Minute,
+		KDP:       DefaultKDPOptions(),
+	}
+}
+
+// RainRate estimates the rain rate in mm/h for every reflectivity gate of the
+// radial. Gates without rain are below threshold.
+func RainRate(radial *archive2.Message31, opts QPEOptions) []float32 {
+	ref := scaled(radial.ReflectivityData)
+	if ref == nil {
+		return nil
+	}
+	rho := scaled(radial.RhoData)
+	zdr := scaled(radial.ZdrData)
+	var kdp []float32
+	if opts.Method == RainRateKDP && radial.PhiData != nil {
+		kdp = KDP(radial, opts.KDP)
+	}
+
+	rates := make([]float32, len(ref))
+	for i, z := range ref {
+		rates[i] = archive2.MomentDataBelowThreshold
+		if !valid(z) || z < opts.MinREF {
+			continue
+		}
+		r := radial.ReflectivityData.GateRange(i)
+		if rho != nil && !gateAbove(radial.RhoData, rho, r, opts.MinRHO) {
+			continue
+		}
+		if z > opts.MaxREF {
+			z = opts.MaxREF
+		}
+
+		rate := opts.ZR.RainRate(z)
+		switch opts.Method {
+		case RainRateKDP:
+			if k, ok := gateValue(radial.PhiData, kdp, r); ok && k > 0 {
+				rate = float32(44 * math.Pow(float64(k), 0.822))
+			}
+		case RainRateZZDR:
+			if d, ok := gateValue(radial.ZdrData, zdr, r); ok {
+				// Ryzhkov et al. (2005) S-band relationship
+				zl := math.Pow(10, float64(z)/10)
+				dl := math.Pow(10, float64(d)/10)
+				rate = float32(0.0142 * math.Pow(zl, 0.77) * math.Pow(dl, -1.67))
+			}
+		}
+		rates[i] = rate
+	}
+	return rates
+}
+
+// HybridScanRainRate estimates the rain rate in mm/h over a grid using the
+// hybrid scan: every cell uses the lowest tilt of the volume that is not
+// blocked, holds valid data and is below the maximum height.
+func HybridScanRainRate(ar2 *archive2.Archive2, opts QPEOptions) *Grid {
+	lat, lon := radarLocation(ar2)
+	grid := NewGrid(lat, lon, opts.Extent, opts.Spacing)
+
+	type tilt struct {
+		sweep *archive2.Sweep
+		rates [][]float32
+	}
+
+	tilts := []tilt{}
+	for _, sweep := range ar2.Sweeps() {
+		if len(sweep.Radials) == 0 || sweep.Radials[0].ReflectivityData == nil {
+			continue
+		}
+		rates := make([][]float32, len(sweep.Radials))
+		for i, radial := range sweep.Radials {
+			rates[i] = RainRate(radial, opts)
+		}
+		tilts = append(tilts, tilt{sweep, rates})
+	}
+	sort.SliceStable(tilts, func(i, j int) bool {
+		return tilts[i].sweep.ElevationAngle < tilts[j].sweep.ElevationAngle
+	})
+
+	for y := 0; y < grid.Size; y++ {
+		for x := 0; x < grid.Size; x++ {
+			az, gr := grid.Polar(x, y)
+			if gr > opts.Extent {
+				continue
+			}
+
+			for _, t := range tilts {
+				el := t.sweep.ElevationAngle
+				r := archive2.SlantRange(gr, el)
+				if archive2.BeamHeight(r, el) > opts.MaxHeight {
+					break
+				}
+				if opts.Blocked != nil && opts.Blocked(az, gr, el) {
+					continue
+				}
+				i := t.sweep.Index(az)
+				if i < 0 {
+					continue
+				}
+				g := t.sweep.Radials[i].ReflectivityData.GateIndex(r)
+				if g < 0 || g >= len(t.rates[i]) {
+					continue
+				}
+				if rate := t.rates[i][g]; valid(rate) {
+					grid.Set(x, y, rate)
+					break
+				}
+				// echo below threshold or too weak for rain on an unblocked
+				// tilt means no rain, only gates masked by quality control
+				// fall through to the next tilt
+				if ref := t.sweep.Gates(i, "REF"); g < len(ref) && (ref[g] == archive2.MomentDataBelowThreshold || valid(ref[g]) && ref[g] < opts.MinREF) {
+					grid.Set(x, y, 0)
+					break
+				}
+			}
+		}
+	}
+	return grid
+}
+
+// rateScan is the rain rate grid of a single volume.
+type rateScan struct {
+	time  time.Time
+	rates *Grid
+}
+
+// Accumulator accumulates rainfall over a sequence of volumes.
+type Accumulator struct {
+	opts  QPEOptions
+	scans []rateScan
+}
+
+// NewAccumulator returns an empty Accumulator.
+func NewAccumulator(opts QPEOptions) *Accumulator {
+	return &Accumulator{opts: opts}
+}
+
+// Add estimates the rain rate of a volume and adds it to the accumulation.
+// Volumes can be added in any order.
+func (a *Accumulator) Add(ar2 *archive2.Archive2) error {
+	return a.AddRate(ar2.VolumeHeader.Date(), HybridScanRainRate(ar2, a.opts))
+}
+
+// AddRate adds a rain rate grid valid at t to the accumulation. The grid must
+// cover the same cells as the grids already added.
+func (a *Accumulator) AddRate(t time.Time, rates *Grid) error {
+	if len(a.scans) > 0 {
+		g := a.scans[0].rates
+		if rates.Lat != g.Lat || rates.Lon != g.Lon || rates.Size != g.Size || rates.Spacing != g.Spacing {
+			return fmt.Errorf("rain rate grid at %s does not match the accumulation grid", t.Format(time.RFC3339))
+		}
+	}
+	a.scans = append(a.scans, rateScan{t, rates})
+	sort.SliceStable(a.scans, func(i, j int) bool {
+		return a.scans[i].time.Before(a.scans[j].time)
+	})
+	return nil
+}
+
+// Start returns the time of the first volume.
+func (a *Accumulator) Start() time.Time {
+	if len(a.scans) == 0 {
+		return time.Time{}
+	}
+	return a.scans[0].time
+}
+
+// End returns the time of the last volume.
+func (a *Accumulator) End() time.Time {
+	if len(a.scans) == 0 {
+		return time.Time{}
+	}
+	return a.scans[len(a.scans)-1].time
+}
+
+// Accumulation returns the rainfall in mm that fell between start and end. The
+// rain rate is interpolated linearly in time between consecutive volumes,
+// periods without volumes are missing and do not add rainfall.
+func (a *Accumulator) Accumulation(start, end time.Time) *Grid {
+	if len(a.scans) == 0 {
+		return nil
+	}
+
+	first := a.scans[0].rates
+	total := NewGrid(first.Lat, first.Lon, first.Extent(), first.Spacing)
+	for i := range total.Data {
+		total.Data[i] = 0
+	}
+
+	for i := 0; i < len(a.scans)-1; i++ {
+		s0, s1 := a.scans[i], a.scans[i+1]
+		if s1.time.Sub(s0.time) > a.opts.MaxGap {
+			continue
+		}
+
+		t0, t1, ok := clip(s0.time, s1.time, start, end)
+		if !ok {
+			continue
+		}
+
+		span := s1.time.Sub(s0.time).Hours()
+		w0 := t0.Sub(s0.time).Hours() / span
+		w1 := t1.Sub(s0.time).Hours() / span
+		hours := t1.Sub(t0).Hours()
+
+		for c := range total.Data {
+			r0, r1 := s0.rates.Data[c], s1.rates.Data[c]
+			if !valid(r0) {
+				r0 = 0
+			}
+			if !valid(r1) {
+				r1 = 0
+			}
+			// mean of the linearly interpolated rate between t0 and t1
+			mean := float64(r0) + (float64(r1)-float64(r0))*(w0+w1)/2
+			total.Data[c] += float32(mean * hours)
+		}
+	}
+	return total
+}
+
+// Missing returns how long of the period between start and end is not covered
+// by the volumes, either before the first volume, after the last one or in
+// gaps longer than MaxGap. The accumulations miss the rain of this time.
+func (a *Accumulator) Missing(start, end time.Time) time.Duration {
+	missing := end.Sub(start)
+	for i := 0; i < len(a.scans)-1; i++ {
+		s0, s1 := a.scans[i], a.scans[i+1]
+		if s1.time.Sub(s0.time) > a.opts.MaxGap {
+			continue
+		}
+		if t0, t1, ok := clip(s0.time, s1.time, start, end); ok {
+			missing -= t1.Sub(t0)
+		}
+	}
+	return missing
+}
+
+// clip clips the interval between t0 and t1 to the period between start and
+// end, ok is false if they do not overlap.
+func clip(t0, t1, start, end time.Time) (time.Time, time.Time, bool) {
+	if t0.Before(start) {
+		t0 = start
+	}
+	if t1.After(end) {
+		t1 = end
+	}
+	return t0, t1, t1.After(t0)
+}
+
+// LastAccumulation returns the rainfall in mm that fell during the period of
+// length d ending at the last volume. ex: one and three hour totals.
+func (a *Accumulator) LastAccumulation(d time.Duration) *Grid {
+	return a.Accumulation(a.End().Add(-d), a.End())
+}
+
+// StormTotal returns the rainfall in mm that fell over all the volumes.
+func (a *Accumulator) StormTotal() *Grid {
+	return a.Accumulation(a.Start(), a.End())
+}
@@ -0,0 +1,183 @@
+package products
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestZRRainRate(t *testing.T) {
+	// Z = 300R^1.4 gives roughly 12mm/h at 40 dBZ
+	if r := ZRConvective.RainRate(40); math.Abs(float64(r)-12.2) > 0.2 {
+		t.Errorf("got %f want ~12.2", r)
+	}
+}
+
+func TestAccumulation(t *testing.T) {
+	opts := DefaultQPEOptions()
+	a := NewAccumulator(opts)
+
+	start := time.Date(2021, 8, 30, 12, 0, 0, 0, time.UTC)
+	for i := 0; i <= 12; i++ {
+		g := NewGrid(30, -90, 2000, 1000)
+		for c := range g.Data {
+			g.Data[c] = 12
+		}
+		a.AddRate(start.Add(time.Duration(i)*5*time.Minute), g)
+	}
+
+	if v := a.StormTotal().At(0, 0); math.Abs(float64(v)-12) > 0.01 {
+		t.Errorf("storm total: got %f want 12", v)
+	}
+	if v := a.LastAccumulation(30*time.Minute).At(1, 1); math.Abs(float64(v)-6) > 0.01 {
+		t.Errorf("30 minute total: got %f want 6", v)
+	}
+}
+
+func TestAccumulationGap(t *testing.T) {
+	a := NewAccumulator(DefaultQPEOptions())
+
+	start := time.Date(2021, 8, 30, 12, 0, 0, 0, time.UTC)
+	// an hour of volumes, a six hour outage and another hour
+	for _, minutes := range []int{0, 30, 60, 420, 450, 480} {
+		g := NewGrid(30, -90, 2000, 1000)
+		for c := range g.Data {
+			g.Data[c] = 12
+		}
+		if err := a.AddRate(start.Add(time.Duration(minutes)*time.Minute), g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if v := a.StormTotal().At(0, 0); math.Abs(float64(v)-24) > 0.01 {
+		t.Errorf("storm total: got %f want 24", v)
+	}
+	if m := a.Missing(a.Start(), a.End()); m != 6*time.Hour {
+		t.Errorf("missing %s want 6h", m)
+	}
+	if m := a.Missing(start.Add(-time.Hour), start.Add(time.Hour)); m != time.Hour {
+		t.Errorf("missing %s before the first volume want 1h", m)
+	}
+}
+
+func TestAccumulationGrid(t *testing.T) {
+	a := NewAccumulator(DefaultQPEOptions())
+	start := time.Date(2021, 8, 30, 12, 0, 0, 0, time.UTC)
+	if err := a.AddRate(start, NewGrid(30, -90, 2000, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddRate(start.Add(5*time.Minute), NewGrid(30, -90, 2000, 500)); err == nil {
+		t.Error("added a grid of a different spacing")
+	}
+	if err := a.AddRate(start.Add(5*time.Minute), NewGrid(31, -90, 2000, 1000)); err == nil {
+		t.Error("added a grid of a different radar")
+	}
+}
+
+func TestHybridScanRainRate(t *testing.T) {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for elv, el := range []float32{0.5, 1.5} {
+		for az := 0.25; az < 360; az += 0.5 {
+			radial := newRadial(float32(az), el)
+			radial.VolumeData.Lat = 35
+			radial.VolumeData.Long = -97
+			radial.ReflectivityData = newMoment(2125, 250, fill(400, 40-10*float32(elv)))
+			ar2.ElevationScans[elv+1] = append(ar2.ElevationScans[elv+1], radial)
+		}
+	}
+
+	opts := DefaultQPEOptions()
+	opts.Extent = 20000
+	// the lowest tilt is blocked to the east
+	opts.Blocked = func(azimuth, groundRange, elevation float64) bool {
+		return elevation < 1 && azimuth > 45 && azimuth < 135
+	}
+	grid := HybridScanRainRate(ar2, opts)
+
+	x, y, _ := grid.Cell(0, 10000)
+	if v, want := grid.At(x, y), opts.ZR.RainRate(40); math.Abs(float64(v-want)) > 0.01 {
+		t.Errorf("north: got %f want %f from the lowest tilt", v, want)
+	}
+	x, y, _ = grid.Cell(10000, 0)
+	if v, want := grid.At(x, y), opts.ZR.RainRate(30); math.Abs(float64(v-want)) > 0.01 {
+		t.Errorf("east: got %f want %f from the second tilt", v, want)
+	}
+}
+
+func TestHybridScanWeakEcho(t *testing.T) {
+	// weak echo on the lowest tilt below a bright band on the second, with
+	// non meteorological echo on the lowest tilt to the east
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for elv, el := range []float32{0.5, 1.5} {
+		for az := 0.25; az < 360; az += 0.5 {
+			radial := newRadial(float32(az), el)
+			radial.VolumeData.Lat = 35
+			radial.VolumeData.Long = -97
+			ref, rho := float32(5), float32(0.99)
+			if elv == 0 && az > 45 && az < 135 {
+				ref, rho = 40, 0.5
+			}
+			if elv == 1 {
+				ref = 50
+			}
+			radial.ReflectivityData = newMoment(2125, 250, fill(400, ref))
+			radial.RhoData = newMoment(2125, 250, fill(400, rho))
+			ar2.ElevationScans[elv+1] = append(ar2.ElevationScans[elv+1], radial)
+		}
+	}
+
+	opts := DefaultQPEOptions()
+	opts.Extent = 20000
+	grid := HybridScanRainRate(ar2, opts)
+
+	x, y, _ := grid.Cell(0, 10000)
+	if v := grid.At(x, y); v != 0 {
+		t.Errorf("north: got %f want 0 from the weak echo of the lowest tilt", v)
+	}
+	x, y, _ = grid.Cell(10000, 0)
+	if v, want := grid.At(x, y), opts.ZR.RainRate(50); math.Abs(float64(v-want)) > 0.01 {
+		t.Errorf("east: got %f want %f from the second tilt", v, want)
+	}
+}
+
+func TestRainRateKDP(t *testing.T) {
+	// differential phase increasing 2 deg/km gives a KDP of 1 deg/km
+	phi := make([]float32, 200)
+	for i := range phi {
+		phi[i] = 20 + float32(i)*0.5
+	}
+	radial := newRadial(0, 0.5)
+	radial.ReflectivityData = newMoment(2125, 250, fill(200, 45))
+	radial.RhoData = newMoment(2125, 250, fill(200, 0.99))
+
+	opts := DefaultQPEOptions()
+	opts.Method = RainRateKDP
+	if r := RainRate(radial, opts)[100]; math.Abs(float64(r-opts.ZR.RainRate(45))) > 0.01 {
+		t.Errorf("without PHI got %f want R(Z)", r)
+	}
+
+	radial.PhiData = newScaledMoment(2125, 250, 2.8361, 2, phi)
+	// R = 44 KDP^0.822
+	if r := RainRate(radial, opts)[100]; math.Abs(float64(r)-44) > 2 {
+		t.Errorf("got %f want ~44", r)
+	}
+}
+
+func TestRainRateZZDR(t *testing.T) {
+	radial := newRadial(0, 0.5)
+	radial.ReflectivityData = newMoment(2125, 250, fill(20, 40))
+
+	opts := DefaultQPEOptions()
+	opts.Method = RainRateZZDR
+	if r := RainRate(radial, opts)[10]; math.Abs(float64(r-opts.ZR.RainRate(40))) > 0.01 {
+		t.Errorf("without ZDR got %f want R(Z)", r)
+	}
+
+	radial.ZdrData = newMoment(2125, 250, fill(20, 1))
+	// R = 0.0142 Z^0.77 ZDR^-1.67 gives roughly 11.6mm/h at 40 dBZ and 1 dB
+	if r := RainRate(radial, opts)[10]; math.Abs(float64(r)-11.6) > 0.1 {
+		t.Errorf("got %f want ~11.6", r)
+	}
+}
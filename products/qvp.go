@@ -0,0 +1,167 @@
+package products
+
+import (
+	"math"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// QVPOptions configures the quasi vertical profiles.
+type QVPOptions struct {
+	// Elevation is the elevation angle in degrees of the sweep used. The sweep
+	// closest to this angle is selected.
+	Elevation float64
+	// MaxHeight is the top of the profile in meters above sea level.
+	MaxHeight float64
+	// HeightStep is the depth in meters of the layers the gates are averaged
+	// into.
+	HeightStep float64
+	// MinCoverage is the fraction of the gates of a layer that must hold a
+	// valid value for the layer average to be kept.
+	MinCoverage float64
+	KDP         KDPOptions
+}
+
+// DefaultQVPOptions returns options for profiles at 10 degrees elevation up to
+// 10 km.
+func DefaultQVPOptions() QVPOptions {
+	return QVPOptions{
+		Elevation:   10,
+		MaxHeight:   10000,
+		HeightStep:  50,
+		MinCoverage: 0.3,
+		KDP:         DefaultKDPOptions(),
+	}
+}
+
+// QVPLevel holds the azimuthally averaged moments of a layer. Layers without
+// enough valid gates hold archive2.MomentDataBelowThreshold.
+type QVPLevel struct {
+	// Height in meters above sea level of the center of the layer.
+	Height float64
+	REF    float32
+	ZDR    float32
+	RHO    float32
+	KDP    float32
+}
+
+// QuasiVerticalProfile is the quasi vertical profile of a volume.
+type QuasiVerticalProfile struct {
+	Station        string
+	Time           time.Time
+	ElevationAngle float64
+	Levels         []QVPLevel
+}
+
+// QVPMoments are the moments averaged in a QVPLevel, in field order.
+var QVPMoments = []string{"REF", "ZDR", "RHO", "KDP"}
+
+// Value returns the average of the named moment, one of QVPMoments.
+func (l QVPLevel) Value(moment string) float32 {
+	switch moment {
+	case "REF":
+		return l.REF
+	case "ZDR":
+		return l.ZDR
+	case "RHO":
+		return l.RHO
+	case "KDP":
+		return l.KDP
+	}
+	return archive2.MomentDataBelowThreshold
+}
+
+// QVP averages REF, ZDR, RHO and KDP of a high elevation sweep over all
+// azimuths and maps the averages to height. REF and ZDR are averaged in linear
+// units. ok is false if the volume has no dual polarization sweep.
+func QVP(ar2 *archive2.Archive2, opts QVPOptions) (qvp QuasiVerticalProfile, ok bool) {
+	qvp.Station = string(ar2.VolumeHeader.ICAO[:])
+	qvp.Time = ar2.VolumeHeader.Date()
+
+	var sweep *archive2.Sweep
+	for _, s := range distinctSweeps(ar2, "RHO") {
+		if sweep == nil || math.Abs(s.ElevationAngle-opts.Elevation) < math.Abs(sweep.ElevationAngle-opts.Elevation) {
+			sweep = s
+		}
+	}
+	if sweep == nil {
+		return qvp, false
+	}
+	qvp.ElevationAngle = sweep.ElevationAngle
+
+	site := sweep.Radials[0].VolumeData.SiteAltitude()
+	levels := int(math.Ceil((opts.MaxHeight - site) / opts.HeightStep))
+	if levels <= 0 {
+		return qvp, false
+	}
+
+	type layer struct {
+		sum        float64
+		n, samples int
+	}
+	layers := map[string][]layer{}
+	for _, name := range QVPMoments {
+		layers[name] = make([]layer, levels)
+	}
+
+	for i, radial := range sweep.Radials {
+		var kdp []float32
+		if radial.PhiData != nil {
+			kdp = KDP(radial, opts.KDP)
+		}
+		for _, name := range QVPMoments {
+			m := radial.Moment(name)
+			gates := kdp
+			if name == "KDP" {
+				m = radial.PhiData
+			} else {
+				gates = sweep.Gates(i, name)
+			}
+			if m == nil {
+				continue
+			}
+			for g, v := range gates {
+				k := int((radial.GateHeight(m.GateRange(g)) - site) / opts.HeightStep)
+				if k < 0 || k >= levels {
+					continue
+				}
+				l := &layers[name][k]
+				l.samples++
+				if !valid(v) {
+					continue
+				}
+				switch name {
+				case "REF", "ZDR":
+					l.sum += math.Pow(10, float64(v)/10)
+				default:
+					l.sum += float64(v)
+				}
+				l.n++
+			}
+		}
+	}
+
+	average := func(name string, k int) float32 {
+		l := layers[name][k]
+		if l.n == 0 || float64(l.n) < opts.MinCoverage*float64(l.samples) {
+			return archive2.MomentDataBelowThreshold
+		}
+		mean := l.sum / float64(l.n)
+		if name == "REF" || name == "ZDR" {
+			mean = 10 * math.Log10(mean)
+		}
+		return float32(mean)
+	}
+
+	for k := 0; k < levels; k++ {
+		qvp.Levels = append(qvp.Levels, QVPLevel{
+			Height: site + (float64(k)+0.5)*opts.HeightStep,
+			REF:    average("REF", k),
+			ZDR:    average("ZDR", k),
+			RHO:    average("RHO", k),
+			KDP:    average("KDP", k),
+		})
+	}
+	return qvp, true
+}
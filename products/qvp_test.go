@@ -0,0 +1,52 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestQVP(t *testing.T) {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	for elv, el := range []float32{0.5, 9.9} {
+		for az := 0.5; az < 360; az++ {
+			ref, rho := make([]float32, 400), make([]float32, 400)
+			for g := range ref {
+				h := archive2.BeamHeight(2125+float64(g)*250, float64(el))
+				ref[g], rho[g] = 30, 0.99
+				if h > 3000 && h < 3500 {
+					rho[g] = 0.9
+				}
+			}
+			radial := newRadial(float32(az), el)
+			radial.ReflectivityData = newMoment(2125, 250, ref)
+			radial.RhoData = newMoment(2125, 250, rho)
+			ar2.ElevationScans[elv+1] = append(ar2.ElevationScans[elv+1], radial)
+		}
+	}
+
+	qvp, ok := QVP(ar2, DefaultQVPOptions())
+	if !ok {
+		t.Fatal("expected a profile")
+	}
+	if qvp.ElevationAngle != float64(float32(9.9)) {
+		t.Errorf("got elevation %f, want 9.9", qvp.ElevationAngle)
+	}
+
+	min := QVPLevel{RHO: 1}
+	for _, l := range qvp.Levels {
+		if valid(l.REF) && math.Abs(float64(l.REF)-30) > 0.1 {
+			t.Fatalf("got REF %f at %f, want 30", l.REF, l.Height)
+		}
+		if valid(l.RHO) && l.RHO < min.RHO {
+			min = l
+		}
+		if valid(l.ZDR) || valid(l.KDP) {
+			t.Fatalf("expected no ZDR or KDP at %f", l.Height)
+		}
+	}
+	if min.Height < 3000 || min.Height > 3500 {
+		t.Errorf("got RHO minimum at %f, want 3000-3500", min.Height)
+	}
+}
@@ -0,0 +1,332 @@
+package products
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// ShearOptions configures the linear least squares derivative (LLSD) used to
+// estimate azimuthal shear.
+type ShearOptions struct {
+	// RangeWindow and AzimuthWindow are the size in meters of the window,
+	// along and across the radial, used to fit the velocity field.
+	RangeWindow, AzimuthWindow float64
+	// MaxRadials limits the number of radials on each side of a gate used
+	// close to the radar, where radials are narrow.
+	MaxRadials int
+	// MinGates is the number of valid gates a window needs for a fit.
+	MinGates int
+}
+
+// DefaultShearOptions returns the LLSD window commonly used for WSR-88D super
+// resolution data.
+func DefaultShearOptions() ShearOptions {
+	return ShearOptions{
+		RangeWindow:   2500,
+		AzimuthWindow: 1500,
+		MaxRadials:    10,
+		MinGates:      8,
+	}
+}
+
+// AzimuthalShear estimates the azimuthal shear in s^-1 of every velocity gate
+// of a sweep using the linear least squares derivative method of Smith and
+// Elmore (2004). Positive values are cyclonic in the northern hemisphere. The
+// radials are expected in azimuthal order, as they are stored in the volume.
+// Velocities are used as is, aliased velocities will produce false shear.
+func AzimuthalShear(radials []*archive2.Message31, opts ShearOptions) [][]float32 {
+	vel := make([][]float32, len(radials))
+	for i, radial := range radials {
+		vel[i] = scaled(radial.VelocityData)
+	}
+
+	shear := make([][]float32, len(radials))
+	for i, radial := range radials {
+		if vel[i] == nil {
+			continue
+		}
+		m := radial.VelocityData
+		interval := float64(m.DataMomentRangeSampleInterval)
+		if interval == 0 {
+			continue
+		}
+		nr := int(opts.RangeWindow / interval / 2)
+		spacing := radial.Header.AzimuthResolutionSpacing() * degToRad
+
+		shear[i] = make([]float32, len(vel[i]))
+		for g, v := range vel[i] {
+			shear[i][g] = archive2.MomentDataBelowThreshold
+			if !valid(v) {
+				continue
+			}
+
+			r := m.GateRange(g)
+			na := int(math.Ceil(opts.AzimuthWindow / 2 / (r * spacing)))
+			if na > opts.MaxRadials {
+				na = opts.MaxRadials
+			}
+
+			var fit lsq3
+			for da := -na; da <= na; da++ {
+				j := (i + da + len(radials)) % len(radials)
+				if vel[j] == nil {
+					continue
+				}
+				dθ := float64(radials[j].Header.AzimuthAngle-radial.Header.AzimuthAngle) * degToRad
+				dθ = math.Remainder(dθ, 2*math.Pi)
+				for dg := -nr; dg <= nr; dg++ {
+					k := g + dg
+					if k < 0 || k >= len(vel[j]) || !valid(vel[j][k]) {
+						continue
+					}
+					rk := radials[j].VelocityData.GateRange(k)
+					fit.add(rk*dθ, rk-r, float64(vel[j][k]))
+				}
+			}
+
+			if fit.n < opts.MinGates {
+				continue
+			}
+			if b, ok := fit.slope(); ok {
+				shear[i][g] = float32(b)
+			}
+		}
+	}
+	return shear
+}
+
+// lsq3 accumulates the normal equations of the least squares fit of
+// z = a + b*x + c*y.
+type lsq3 struct {
+	n                                   int
+	sx, sy, sz, sxx, syy, sxy, sxz, syz float64
+}
+
+func (l *lsq3) add(x, y, z float64) {
+	l.n++
+	l.sx += x
+	l.sy += y
+	l.sz += z
+	l.sxx += x * x
+	l.syy += y * y
+	l.sxy += x * y
+	l.sxz += x * z
+	l.syz += y * z
+}
+
+// slope returns b, the derivative of z along x.
+func (l *lsq3) slope() (float64, bool) {
+	_, b, _, ok := l.solve()
+	return b, ok
+}
+
+// solve returns the coefficients of the fit using Cramer's rule.
+func (l *lsq3) solve() (a, b, c float64, ok bool) {
+	n := float64(l.n)
+	det := det3(n, l.sx, l.sy, l.sx, l.sxx, l.sxy, l.sy, l.sxy, l.syy)
+	if math.Abs(det) < 1e-9 {
+		return 0, 0, 0, false
+	}
+	a = det3(l.sz, l.sx, l.sy, l.sxz, l.sxx, l.sxy, l.syz, l.sxy, l.syy) / det
+	b = det3(n, l.sz, l.sy, l.sx, l.sxz, l.sxy, l.sy, l.syz, l.syy) / det
+	c = det3(n, l.sx, l.sz, l.sx, l.sxx, l.sxz, l.sy, l.sxy, l.syz) / det
+	return a, b, c, true
+}
+
+// det3 returns the determinant of the 3x3 matrix given row by row.
+func det3(a, b, c, d, e, f, g, h, i float64) float64 {
+	return a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+}
+
+// RotationOptions configures the rotation detection.
+type RotationOptions struct {
+	Shear ShearOptions
+	// MinShear is the azimuthal shear in s^-1 a gate needs to be part of a
+	// shear region.
+	MinShear float32
+	// MinGates is the number of gates a shear region needs to be reported.
+	MinGates int
+	// MaxDistance is the horizontal distance in meters within which shear
+	// regions on different sweeps are associated into a single rotation.
+	MaxDistance float64
+	// MinSweeps is the number of sweeps a rotation has to be found on.
+	MinSweeps int
+	// MaxRange ignores shear beyond this range in meters, where the beam is
+	// too wide to resolve a mesocyclone.
+	MaxRange float64
+}
+
+// DefaultRotationOptions returns options tuned to find mesocyclones.
+func DefaultRotationOptions() RotationOptions {
+	return RotationOptions{
+		Shear:       DefaultShearOptions(),
+		MinShear:    0.006,
+		MinGates:    10,
+		MaxDistance: 5000,
+		MinSweeps:   2,
+		MaxRange:    200000,
+	}
+}
+
+// ShearRegion is a contiguous region of strong azimuthal shear on a sweep.
+type ShearRegion struct {
+	ElevationAngle float64
+	// Azimuth in degrees and Range in meters of the shear weighted centroid.
+	Azimuth, Range float64
+	Lat, Lon       float64
+	// Height in meters above sea level of the centroid.
+	Height   float64
+	MaxShear float32
+	Gates    int
+}
+
+// Rotation is a vertically stacked set of shear regions, a possible
+// mesocyclone or tornadic vortex.
+type Rotation struct {
+	// Lat and Lon of the lowest shear region.
+	Lat, Lon float64
+	// Azimuth in degrees and Range in meters of the lowest shear region.
+	Azimuth, Range float64
+	// MaxShear is the strongest azimuthal shear in s^-1 found in the rotation.
+	MaxShear float32
+	// Base and Top are the heights in meters above sea level of the lowest
+	// and highest shear regions.
+	Base, Top float64
+	// Depth is the vertical extent of the rotation in meters.
+	Depth   float64
+	Regions []ShearRegion
+}
+
+// DetectRotation finds regions of strong cyclonic azimuthal shear on every
+// sweep of the volume and associates them vertically into rotations, strongest
+// first.
+func DetectRotation(ar2 *archive2.Archive2, opts RotationOptions) []Rotation {
+	sweeps := ar2.Sweeps()
+	sort.SliceStable(sweeps, func(i, j int) bool {
+		return sweeps[i].ElevationAngle < sweeps[j].ElevationAngle
+	})
+
+	rotations := []Rotation{}
+	for _, sweep := range sweeps {
+		if len(sweep.Radials) == 0 || sweep.Radials[0].VelocityData == nil {
+			continue
+		}
+		shear := AzimuthalShear(sweep.Radials, opts.Shear)
+		for _, region := range ShearRegions(sweep.Radials, shear, opts) {
+			associated := false
+			for i := range rotations {
+				top := rotations[i].Regions[len(rotations[i].Regions)-1]
+				if top.ElevationAngle == region.ElevationAngle {
+					continue
+				}
+				if _, d := archive2.BearingDistance(top.Lat, top.Lon, region.Lat, region.Lon); d <= opts.MaxDistance {
+					rotations[i].add(region)
+					associated = true
+					break
+				}
+			}
+			if !associated {
+				r := Rotation{Lat: region.Lat, Lon: region.Lon, Azimuth: region.Azimuth, Range: region.Range, Base: region.Height}
+				r.add(region)
+				rotations = append(rotations, r)
+			}
+		}
+	}
+
+	detected := []Rotation{}
+	for _, r := range rotations {
+		if len(r.Regions) >= opts.MinSweeps {
+			detected = append(detected, r)
+		}
+	}
+	sort.SliceStable(detected, func(i, j int) bool {
+		return detected[i].MaxShear > detected[j].MaxShear
+	})
+	return detected
+}
+
+func (r *Rotation) add(region ShearRegion) {
+	r.Regions = append(r.Regions, region)
+	if region.MaxShear > r.MaxShear {
+		r.MaxShear = region.MaxShear
+	}
+	r.Base = math.Min(r.Base, region.Height)
+	r.Top = math.Max(r.Top, region.Height)
+	r.Depth = r.Top - r.Base
+}
+
+// ShearRegions clusters the connected gates of a sweep with an azimuthal shear
+// of at least opts.MinShear into shear regions.
+func ShearRegions(radials []*archive2.Message31, shear [][]float32, opts RotationOptions) []ShearRegion {
+	visited := make([][]bool, len(shear))
+	for i := range shear {
+		visited[i] = make([]bool, len(shear[i]))
+	}
+	strong := func(i, g int) bool {
+		return g >= 0 && g < len(shear[i]) && valid(shear[i][g]) && shear[i][g] >= opts.MinShear &&
+			radials[i].VelocityData.GateRange(g) <= opts.MaxRange
+	}
+
+	regions := []ShearRegion{}
+	for i := range shear {
+		for g := range shear[i] {
+			if visited[i][g] || !strong(i, g) {
+				continue
+			}
+
+			// flood fill the connected strong shear gates
+			cluster := []gate{}
+			stack := []gate{{i, g}}
+			visited[i][g] = true
+			for len(stack) > 0 {
+				c := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				cluster = append(cluster, c)
+				for da := -1; da <= 1; da++ {
+					j := (c.radial + da + len(shear)) % len(shear)
+					for dg := -1; dg <= 1; dg++ {
+						k := c.gate + dg
+						if k >= 0 && k < len(visited[j]) && !visited[j][k] && strong(j, k) {
+							visited[j][k] = true
+							stack = append(stack, gate{j, k})
+						}
+					}
+				}
+			}
+
+			if len(cluster) < opts.MinGates {
+				continue
+			}
+
+			var region ShearRegion
+			var w, x, y, el, rr float64
+			for _, c := range cluster {
+				v := shear[c.radial][c.gate]
+				radial := radials[c.radial]
+				r := radial.VelocityData.GateRange(c.gate)
+				az := float64(radial.Header.AzimuthAngle) * degToRad
+				x += float64(v) * r * math.Sin(az)
+				y += float64(v) * r * math.Cos(az)
+				el += float64(v) * float64(radial.Header.ElevationAngle)
+				rr += float64(v) * r
+				w += float64(v)
+				if v > region.MaxShear {
+					region.MaxShear = v
+				}
+			}
+			region.Gates = len(cluster)
+			region.ElevationAngle = el / w
+			region.Azimuth = math.Mod(math.Atan2(x/w, y/w)*radToDeg+360, 360)
+			region.Range = rr / w
+
+			site := radials[cluster[0].radial]
+			lat, lon := float64(site.VolumeData.Lat), float64(site.VolumeData.Long)
+			region.Lat, region.Lon = archive2.Destination(lat, lon, region.Azimuth, archive2.GroundRange(region.Range, region.ElevationAngle))
+			region.Height = site.VolumeData.SiteAltitude() + archive2.BeamHeight(region.Range, region.ElevationAngle)
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
@@ -0,0 +1,55 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// rankineSweep returns a sweep of velocities of a cyclonic rankine vortex
+// centered at x0, y0 meters east and north of the radar.
+func rankineSweep(el, x0, y0, core, vmax float64) []*archive2.Message31 {
+	radials := []*archive2.Message31{}
+	for az := 0.25; az < 360; az += 0.5 {
+		vel := make([]float32, 300)
+		for g := range vel {
+			r := 2125 + float64(g)*250
+			x, y := r*math.Sin(az*degToRad), r*math.Cos(az*degToRad)
+			dx, dy := x-x0, y-y0
+			d := math.Hypot(dx, dy)
+			vt := vmax * d / core
+			if d > core {
+				vt = vmax * core / d
+			}
+			// counter clockwise tangential wind projected on the radial
+			u, v := -vt*dy/d, vt*dx/d
+			vel[g] = float32((u*x + v*y) / r)
+		}
+		radial := newRadial(float32(az), float32(el))
+		radial.VolumeData.Lat = 35
+		radial.VolumeData.Long = -97
+		radial.VelocityData = newMoment(2125, 250, vel)
+		radials = append(radials, radial)
+	}
+	return radials
+}
+
+func TestDetectRotation(t *testing.T) {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{
+		1: rankineSweep(0.5, 40000, 0, 1500, 30),
+		2: rankineSweep(1.5, 40000, 0, 1500, 30),
+	}}
+
+	rotations := DetectRotation(ar2, DefaultRotationOptions())
+	if len(rotations) == 0 {
+		t.Fatal("expected a rotation")
+	}
+	r := rotations[0]
+	if math.Abs(r.Azimuth-90) > 2 || math.Abs(r.Range-40000) > 2000 {
+		t.Errorf("rotation at %f deg %f m, want 90 deg 40000 m", r.Azimuth, r.Range)
+	}
+	if len(r.Regions) != 2 || r.Depth <= 0 {
+		t.Errorf("expected 2 regions with depth, got %d regions depth %f", len(r.Regions), r.Depth)
+	}
+}
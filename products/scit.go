@@ -0,0 +1,464 @@
+package products
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// SCITOptions configures the storm cell identification and tracking.
+type SCITOptions struct {
+	// Thresholds are the reflectivity thresholds in dBZ, ascending, used to
+	// find storm components. Components are split at higher thresholds to
+	// separate cells embedded in larger areas of precipitation.
+	Thresholds []float32
+	// MinArea is the area in square meters a component needs on a sweep.
+	MinArea float64
+	// MaxDistance is the horizontal distance in meters within which
+	// components on adjacent sweeps are associated into a cell.
+	MaxDistance float64
+	// MinComponents is the number of sweeps a cell has to be found on.
+	MinComponents int
+	// MaxRange ignores echoes beyond this range in meters.
+	MaxRange float64
+	// MaxSpeed is the fastest a cell is assumed to move in m/s, limiting the
+	// distance searched when associating cells between volumes.
+	MaxSpeed float64
+	// MinSearchRadius is the smallest distance in meters searched when
+	// associating cells between volumes.
+	MinSearchRadius float64
+	// MaxGap is the longest time a track can go without a cell before it ends.
+	MaxGap time.Duration
+	// Forecasts are the lead times of the forecast positions.
+	Forecasts []time.Duration
+}
+
+// DefaultSCITOptions returns options adapted from Johnson et al. (1998) "The
+// Storm Cell Identification and Tracking Algorithm".
+func DefaultSCITOptions() SCITOptions {
+	return SCITOptions{
+		Thresholds:      []float32{30, 40, 50, 60},
+		MinArea:         10e6,
+		MaxDistance:     5000,
+		MinComponents:   2,
+		MaxRange:        300000,
+		MaxSpeed:        35,
+		MinSearchRadius: 5000,
+		MaxGap:          20 * time.Minute,
+		Forecasts:       []time.Duration{15 * time.Minute, 30 * time.Minute, 45 * time.Minute, 60 * time.Minute},
+	}
+}
+
+// StormComponent is an area of reflectivity above a threshold on a single sweep.
+type StormComponent struct {
+	ElevationAngle float64
+	// East and North are the distances in meters from the radar of the
+	// reflectivity weighted centroid.
+	East, North float64
+	Height      float64
+	Area        float64
+	MaxREF      float32
+	// mass is the sum of the linear reflectivity times the gate area.
+	mass float64
+}
+
+// StormCell is a three dimensional storm cell identified in a volume.
+type StormCell struct {
+	// ID of the track the cell belongs to, zero before tracking.
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Lat and Lon of the mass weighted centroid.
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	// Azimuth in degrees and Range in meters along the ground of the centroid.
+	Azimuth float64 `json:"azimuth"`
+	Range   float64 `json:"range"`
+	// Base and Top are the heights in meters above sea level of the lowest
+	// and highest components of the cell.
+	Base   float64 `json:"base"`
+	Top    float64 `json:"top"`
+	MaxREF float32 `json:"max_ref"`
+	// MaxREFHeight is the height in meters above sea level of the maximum reflectivity.
+	MaxREFHeight float64 `json:"max_ref_height"`
+	// VIL is the cell based vertically integrated liquid in kg/m^2.
+	VIL float32 `json:"vil"`
+	// Motion of the cell, nil until the cell has been tracked over two volumes.
+	Motion   *StormMotion       `json:"motion"`
+	Forecast []ForecastPosition `json:"forecast,omitempty"`
+
+	east, north float64
+	components  []StormComponent
+}
+
+// ForecastPosition is the position of a cell extrapolated along its motion.
+type ForecastPosition struct {
+	Time time.Time `json:"time"`
+	Lat  float64   `json:"lat"`
+	Lon  float64   `json:"lon"`
+}
+
+// IdentifyCells finds the storm cells of a volume.
+func IdentifyCells(ar2 *archive2.Archive2, opts SCITOptions) []StormCell {
+	sweeps := distinctSweeps(ar2, "REF")
+	if len(sweeps) == 0 {
+		return nil
+	}
+	site := sweeps[0].Radials[0]
+	lat, lon := float64(site.VolumeData.Lat), float64(site.VolumeData.Long)
+	alt := site.VolumeData.SiteAltitude()
+
+	cells := []*StormCell{}
+	for _, sweep := range sweeps {
+		components := StormComponents(sweep, opts)
+		// associate the strongest components first
+		sort.SliceStable(components, func(i, j int) bool {
+			return components[i].MaxREF > components[j].MaxREF
+		})
+
+		for _, c := range components {
+			c.Height += alt
+			var best *StormCell
+			bestDist := opts.MaxDistance
+			for _, cell := range cells {
+				top := cell.components[len(cell.components)-1]
+				if top.ElevationAngle == c.ElevationAngle {
+					continue
+				}
+				if d := math.Hypot(top.East-c.East, top.North-c.North); d <= bestDist {
+					best, bestDist = cell, d
+				}
+			}
+			if best == nil {
+				best = &StormCell{}
+				cells = append(cells, best)
+			}
+			best.components = append(best.components, c)
+		}
+	}
+
+	identified := []StormCell{}
+	for _, cell := range cells {
+		if len(cell.components) < opts.MinComponents {
+			continue
+		}
+		cell.Time = ar2.VolumeHeader.Date()
+		cell.summarize(lat, lon)
+		identified = append(identified, *cell)
+	}
+	sort.SliceStable(identified, func(i, j int) bool {
+		return identified[i].VIL > identified[j].VIL
+	})
+	return identified
+}
+
+// summarize computes the attributes of a cell from its components.
+func (cell *StormCell) summarize(lat, lon float64) {
+	var mass float64
+	cell.Base = math.Inf(1)
+	cell.Top = math.Inf(-1)
+	for _, c := range cell.components {
+		cell.east += c.East * c.mass
+		cell.north += c.North * c.mass
+		mass += c.mass
+		cell.Base = math.Min(cell.Base, c.Height)
+		cell.Top = math.Max(cell.Top, c.Height)
+		if c.MaxREF > cell.MaxREF {
+			cell.MaxREF = c.MaxREF
+			cell.MaxREFHeight = c.Height
+		}
+	}
+	cell.east /= mass
+	cell.north /= mass
+
+	cell.Azimuth = math.Mod(math.Atan2(cell.east, cell.north)*radToDeg+360, 360)
+	cell.Range = math.Hypot(cell.east, cell.north)
+	cell.Lat, cell.Lon = archive2.Destination(lat, lon, cell.Azimuth, cell.Range)
+
+	// cell based VIL integrates the maximum reflectivity of each component,
+	// capped at 56 dBZ to limit the contribution of hail.
+	byHeight := append([]StormComponent{}, cell.components...)
+	sort.Slice(byHeight, func(i, j int) bool { return byHeight[i].Height < byHeight[j].Height })
+	var vil float64
+	for i := 0; i < len(byHeight)-1; i++ {
+		z0 := math.Pow(10, math.Min(float64(byHeight[i].MaxREF), 56)/10)
+		z1 := math.Pow(10, math.Min(float64(byHeight[i+1].MaxREF), 56)/10)
+		vil += 3.44e-6 * math.Pow((z0+z1)/2, 4.0/7.0) * (byHeight[i+1].Height - byHeight[i].Height)
+	}
+	cell.VIL = float32(vil)
+}
+
+// StormComponents finds the storm components of a sweep. Heights are above the radar.
+func StormComponents(sweep *archive2.Sweep, opts SCITOptions) []StormComponent {
+	ref := make([][]float32, len(sweep.Radials))
+	for i := range sweep.Radials {
+		ref[i] = sweep.Gates(i, "REF")
+	}
+
+	// components returns the connected areas above threshold t within the parent
+	var components func(t int, parent map[gate]bool) []StormComponent
+	components = func(t int, parent map[gate]bool) []StormComponent {
+		th := opts.Thresholds[t]
+		visited := map[gate]bool{}
+		above := func(g gate) bool {
+			if parent != nil && !parent[g] {
+				return false
+			}
+			if g.gate < 0 || g.gate >= len(ref[g.radial]) {
+				return false
+			}
+			v := ref[g.radial][g.gate]
+			return valid(v) && v >= th && sweep.Radials[g.radial].ReflectivityData.GateRange(g.gate) <= opts.MaxRange
+		}
+
+		found := []StormComponent{}
+		for i := range ref {
+			for j := range ref[i] {
+				start := gate{i, j}
+				if visited[start] || !above(start) {
+					continue
+				}
+
+				members := map[gate]bool{}
+				stack := []gate{start}
+				visited[start] = true
+				for len(stack) > 0 {
+					c := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					members[c] = true
+					for da := -1; da <= 1; da++ {
+						for dg := -1; dg <= 1; dg++ {
+							n := gate{(c.radial + da + len(ref)) % len(ref), c.gate + dg}
+							if !visited[n] && above(n) {
+								visited[n] = true
+								stack = append(stack, n)
+							}
+						}
+					}
+				}
+
+				c := component(sweep, ref, members)
+				if c.Area < opts.MinArea {
+					continue
+				}
+
+				// split the component when a higher threshold separates several cores
+				if t+1 < len(opts.Thresholds) {
+					if cores := components(t+1, members); len(cores) > 1 {
+						found = append(found, cores...)
+						continue
+					}
+				}
+				found = append(found, c)
+			}
+		}
+		return found
+	}
+
+	return components(0, nil)
+}
+
+// component computes the attributes of the component made of the members gates.
+func component(sweep *archive2.Sweep, ref [][]float32, members map[gate]bool) StormComponent {
+	c := StormComponent{ElevationAngle: sweep.ElevationAngle}
+	var r float64
+	for g := range members {
+		radial := sweep.Radials[g.radial]
+		m := radial.ReflectivityData
+		gr := m.GateRange(g.gate)
+		area := gr * radial.Header.AzimuthResolutionSpacing() * degToRad * float64(m.DataMomentRangeSampleInterval)
+		v := ref[g.radial][g.gate]
+		mass := math.Pow(10, float64(v)/10) * area
+
+		az := float64(radial.Header.AzimuthAngle) * degToRad
+		ground := archive2.GroundRange(gr, sweep.ElevationAngle)
+		c.East += ground * math.Sin(az) * mass
+		c.North += ground * math.Cos(az) * mass
+		r += gr * mass
+		c.mass += mass
+		c.Area += area
+		if v > c.MaxREF {
+			c.MaxREF = v
+		}
+	}
+	c.East /= c.mass
+	c.North /= c.mass
+	c.Height = archive2.BeamHeight(r/c.mass, sweep.ElevationAngle)
+	return c
+}
+
+// distinctSweeps returns the sweeps of the volume holding the moment, ordered
+// by elevation angle, keeping only the first sweep of split cuts that repeat
+// an elevation angle.
+func distinctSweeps(ar2 *archive2.Archive2, moment string) []*archive2.Sweep {
+	sweeps := []*archive2.Sweep{}
+	for _, s := range ar2.Sweeps() {
+		if len(s.Radials) == 0 || s.Radials[0].Moment(moment) == nil {
+			continue
+		}
+		duplicate := false
+		for _, o := range sweeps {
+			if math.Abs(o.ElevationAngle-s.ElevationAngle) < 0.2 {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			sweeps = append(sweeps, s)
+		}
+	}
+	sort.SliceStable(sweeps, func(i, j int) bool {
+		return sweeps[i].ElevationAngle < sweeps[j].ElevationAngle
+	})
+	return sweeps
+}
+
+// StormTrack is the history of a storm cell across volumes.
+type StormTrack struct {
+	ID    int         `json:"id"`
+	Cells []StormCell `json:"cells"`
+	// Motion of the cell over its track, nil for tracks with a single cell.
+	Motion *StormMotion `json:"motion"`
+}
+
+// Tracker associates the storm cells of consecutive volumes into tracks.
+type Tracker struct {
+	opts   SCITOptions
+	tracks []*StormTrack
+	nextID int
+}
+
+// NewTracker returns a Tracker without any tracks.
+func NewTracker(opts SCITOptions) *Tracker {
+	return &Tracker{opts: opts, nextID: 1}
+}
+
+// Add identifies the cells of a volume and associates them with the existing
+// tracks. Volumes must be added in time order. The cells are returned with
+// their track ID, motion and forecast positions.
+func (t *Tracker) Add(ar2 *archive2.Archive2) []StormCell {
+	return t.Update(IdentifyCells(ar2, t.opts))
+}
+
+// Update associates identified cells with the existing tracks.
+func (t *Tracker) Update(cells []StormCell) []StormCell {
+	if len(cells) == 0 {
+		return cells
+	}
+	now := cells[0].Time
+
+	type pair struct {
+		track, cell int
+		dist        float64
+	}
+	pairs := []pair{}
+	for ti, track := range t.tracks {
+		last := track.Cells[len(track.Cells)-1]
+		dt := now.Sub(last.Time)
+		if dt <= 0 || dt > t.opts.MaxGap {
+			continue
+		}
+		// first guess position of the cell extrapolated along its motion
+		east, north := last.east, last.north
+		if track.Motion != nil {
+			toward := (track.Motion.Direction + 180) * degToRad
+			east += math.Sin(toward) * track.Motion.Speed * dt.Seconds()
+			north += math.Cos(toward) * track.Motion.Speed * dt.Seconds()
+		}
+		radius := math.Max(t.opts.MaxSpeed*dt.Seconds(), t.opts.MinSearchRadius)
+		for ci, c := range cells {
+			if d := math.Hypot(c.east-east, c.north-north); d <= radius {
+				pairs = append(pairs, pair{ti, ci, d})
+			}
+		}
+	}
+
+	// closest pairs first
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].dist < pairs[j].dist })
+	usedTrack := map[int]bool{}
+	usedCell := map[int]bool{}
+	for _, p := range pairs {
+		if usedTrack[p.track] || usedCell[p.cell] {
+			continue
+		}
+		usedTrack[p.track], usedCell[p.cell] = true, true
+		cells[p.cell].ID = t.tracks[p.track].ID
+	}
+
+	for i := range cells {
+		var track *StormTrack
+		if !usedCell[i] {
+			track = &StormTrack{ID: t.nextID}
+			t.nextID++
+			t.tracks = append(t.tracks, track)
+			cells[i].ID = track.ID
+		} else {
+			for _, tr := range t.tracks {
+				if tr.ID == cells[i].ID {
+					track = tr
+				}
+			}
+		}
+		track.Cells = append(track.Cells, cells[i])
+		track.Motion = track.motion()
+		cells[i].Motion = track.Motion
+		cells[i].Forecast = forecast(cells[i], t.opts.Forecasts)
+		track.Cells[len(track.Cells)-1] = cells[i]
+	}
+	return cells
+}
+
+// Tracks returns every track seen so far.
+func (t *Tracker) Tracks() []*StormTrack {
+	return t.tracks
+}
+
+// motion fits the cell positions of the last 10 volumes of the track with a
+// least squares line.
+func (track *StormTrack) motion() *StormMotion {
+	cells := track.Cells
+	if len(cells) > 10 {
+		cells = cells[len(cells)-10:]
+	}
+	if len(cells) < 2 {
+		return nil
+	}
+
+	t0 := cells[0].Time
+	var st, stt, se, sn, ste, stn float64
+	n := float64(len(cells))
+	for _, c := range cells {
+		s := c.Time.Sub(t0).Seconds()
+		st += s
+		stt += s * s
+		se += c.east
+		sn += c.north
+		ste += s * c.east
+		stn += s * c.north
+	}
+	det := n*stt - st*st
+	if det == 0 {
+		return nil
+	}
+	u := (n*ste - st*se) / det
+	v := (n*stn - st*sn) / det
+
+	return &StormMotion{
+		Direction: math.Mod(math.Atan2(u, v)*radToDeg+180+360, 360),
+		Speed:     math.Hypot(u, v),
+	}
+}
+
+// forecast extrapolates the cell position along its motion.
+func forecast(c StormCell, leads []time.Duration) []ForecastPosition {
+	if c.Motion == nil {
+		return nil
+	}
+	positions := []ForecastPosition{}
+	for _, lead := range leads {
+		lat, lon := archive2.Destination(c.Lat, c.Lon, c.Motion.Direction+180, c.Motion.Speed*lead.Seconds())
+		positions = append(positions, ForecastPosition{c.Time.Add(lead), lat, lon})
+	}
+	return positions
+}
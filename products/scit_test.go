@@ -0,0 +1,82 @@
+package products
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// blobVolume returns a volume with a single 50 dBZ storm of the given radius
+// centered at x0, y0 meters east and north of the radar on two sweeps.
+func blobVolume(t time.Time, x0, y0, radius float64) *archive2.Archive2 {
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{}}
+	days := t.Sub(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 + 1
+	ar2.VolumeHeader.X_ModifiedJulianDate = int32(days)
+	ar2.VolumeHeader.X_ModifiedTime = int32(t.Sub(t.Truncate(24*time.Hour)) / time.Millisecond)
+
+	for elv, el := range []float32{0.5, 1.5} {
+		for az := 0.25; az < 360; az += 0.5 {
+			ref := make([]float32, 300)
+			for g := range ref {
+				r := 2125 + float64(g)*250
+				x, y := r*math.Sin(az*degToRad), r*math.Cos(az*degToRad)
+				ref[g] = archive2.MomentDataBelowThreshold
+				if math.Hypot(x-x0, y-y0) < radius {
+					ref[g] = 50
+				}
+			}
+			radial := newRadial(float32(az), el)
+			radial.VolumeData.Lat = 35
+			radial.VolumeData.Long = -97
+			radial.ReflectivityData = newMoment(2125, 250, ref)
+			ar2.ElevationScans[elv+1] = append(ar2.ElevationScans[elv+1], radial)
+		}
+	}
+	return ar2
+}
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2013, 5, 20, 20, 0, 0, 0, time.UTC)
+	tracker := NewTracker(DefaultSCITOptions())
+
+	first := tracker.Add(blobVolume(start, 30000, 0, 4000))
+	if len(first) != 1 {
+		t.Fatalf("expected 1 cell, got %d", len(first))
+	}
+	if first[0].MaxREF != 50 || first[0].VIL <= 0 {
+		t.Errorf("unexpected cell %+v", first[0])
+	}
+
+	second := tracker.Add(blobVolume(start.Add(5*time.Minute), 33000, 0, 4000))
+	if len(second) != 1 || second[0].ID != first[0].ID {
+		t.Fatalf("expected the cell to continue track %d", first[0].ID)
+	}
+	m := second[0].Motion
+	if m == nil || math.Abs(m.Speed-10) > 1 || math.Abs(m.Direction-270) > 5 {
+		t.Errorf("unexpected motion %+v", m)
+	}
+	if len(second[0].Forecast) != 4 {
+		t.Errorf("expected 4 forecast positions")
+	}
+}
+
+func TestStormTrackJSON(t *testing.T) {
+	track := StormTrack{ID: 1, Cells: []StormCell{{ID: 1, MaxREF: 55, MaxREFHeight: 4000}}, Motion: &StormMotion{Direction: 240, Speed: 15}}
+	b, err := json.Marshal(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Cells  []map[string]interface{} `json:"cells"`
+		Motion map[string]float64       `json:"motion"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Cells) != 1 || got.Cells[0]["max_ref"] != 55.0 || got.Cells[0]["max_ref_height"] != 4000.0 || got.Motion["speed"] != 15 {
+		t.Errorf("got %s", b)
+	}
+}
@@ -0,0 +1,101 @@
+package products
+
+import (
+	"math"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// StormMotion is the motion vector of a storm.
+type StormMotion struct {
+	// Direction the storm is moving from in degrees, meteorological convention.
+	Direction float64 `json:"direction"`
+	// Speed of the storm in m/s.
+	Speed float64 `json:"speed"`
+}
+
+// RadialComponent returns the component of the storm motion along a radial
+// with the given azimuth and elevation angle in degrees. Positive values are
+// away from the radar, matching the sign convention of the velocity moment.
+func (sm StormMotion) RadialComponent(azimuth, elevation float64) float64 {
+	toward := sm.Direction + 180
+	return sm.Speed * math.Cos((azimuth-toward)*degToRad) * math.Cos(elevation*degToRad)
+}
+
+// StormRelativeVelocity returns the storm relative velocity for every velocity
+// gate in the radial by subtracting the storm motion projected onto the radial.
+// Below threshold and range folded gates are passed through untouched.
+func StormRelativeVelocity(radial *archive2.Message31, sm StormMotion) []float32 {
+	gates := scaled(radial.VelocityData)
+	if gates == nil {
+		return nil
+	}
+
+	c := float32(sm.RadialComponent(float64(radial.Header.AzimuthAngle), float64(radial.Header.ElevationAngle)))
+	for i, v := range gates {
+		if valid(v) {
+			gates[i] = v - c
+		}
+	}
+	return gates
+}
+
+// StormRelativeMotion computes the storm relative velocity for every radial in
+// a sweep.
+func StormRelativeMotion(radials []*archive2.Message31, sm StormMotion) [][]float32 {
+	srm := make([][]float32, len(radials))
+	for i, radial := range radials {
+		srm[i] = StormRelativeVelocity(radial, sm)
+	}
+	return srm
+}
+
+// EstimateStormMotion estimates the storm motion from the velocity data of a
+// sweep when no observed motion is available.
+//
+// A uniform wind is fit to every valid velocity gate of the sweep using least
+// squares, then the classic "30R75" rule is applied: storms are assumed to move
+// at 75% of the mean wind speed, 30 degrees to the right of the mean wind. The
+// estimate is crude and sensitive to velocity aliasing, a user supplied motion
+// should be preferred whenever one is known. ok is false if the sweep does not
+// hold enough velocity data to fit a wind.
+func EstimateStormMotion(radials []*archive2.Message31) (sm StormMotion, ok bool) {
+	var sss, scc, ssc, svs, svc float64
+	n := 0
+	for _, radial := range radials {
+		gates := scaled(radial.VelocityData)
+		if gates == nil {
+			continue
+		}
+		az := float64(radial.Header.AzimuthAngle) * degToRad
+		cosEl := math.Cos(float64(radial.Header.ElevationAngle) * degToRad)
+		s := math.Sin(az) * cosEl
+		c := math.Cos(az) * cosEl
+		for _, v := range gates {
+			if !valid(v) {
+				continue
+			}
+			sss += s * s
+			scc += c * c
+			ssc += s * c
+			svs += float64(v) * s
+			svc += float64(v) * c
+			n++
+		}
+	}
+
+	det := sss*scc - ssc*ssc
+	if n < 2 || det == 0 {
+		return StormMotion{}, false
+	}
+
+	// u (eastward) and v (northward) components of the mean wind
+	u := (svs*scc - svc*ssc) / det
+	v := (svc*sss - svs*ssc) / det
+
+	toward := math.Atan2(u, v)*radToDeg + 30
+	return StormMotion{
+		Direction: math.Mod(toward+180+360, 360),
+		Speed:     0.75 * math.Hypot(u, v),
+	}, true
+}
@@ -0,0 +1,47 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestStormRelativeVelocity(t *testing.T) {
+	// storm moving from the west at 20 m/s, a radial pointing east sees the
+	// full storm motion away from the radar.
+	sm := StormMotion{Direction: 270, Speed: 20}
+
+	radial := newRadial(90, 0)
+	radial.VelocityData = newMoment(2125, 250, []float32{25, -5, archive2.MomentDataBelowThreshold})
+
+	got := StormRelativeVelocity(radial, sm)
+	want := []float32{5, -25, archive2.MomentDataBelowThreshold}
+	for i := range want {
+		if math.Abs(float64(got[i]-want[i])) > 0.01 {
+			t.Errorf("gate %d: got %f want %f", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEstimateStormMotion(t *testing.T) {
+	// uniform 20 m/s wind from the south
+	radials := []*archive2.Message31{}
+	for az := float32(0); az < 360; az += 10 {
+		r := newRadial(az, 0.5)
+		v := 20 * math.Cos(float64(az)*degToRad) * math.Cos(0.5*degToRad)
+		r.VelocityData = newMoment(2125, 250, fill(10, float32(v)))
+		radials = append(radials, r)
+	}
+
+	sm, ok := EstimateStormMotion(radials)
+	if !ok {
+		t.Fatal("expected an estimate")
+	}
+	if math.Abs(sm.Speed-15) > 0.1 {
+		t.Errorf("speed: got %f want 15", sm.Speed)
+	}
+	if math.Abs(sm.Direction-210) > 0.5 {
+		t.Errorf("direction: got %f want 210", sm.Direction)
+	}
+}
@@ -0,0 +1,426 @@
+package products
+
+import (
+	"math"
+	"sort"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// TDSOptions configures the tornado debris signature detection.
+type TDSOptions struct {
+	// MinREF is the reflectivity in dBZ lofted debris has to reach.
+	MinREF float32
+	// MaxRHO is the correlation coefficient debris has to fall below.
+	MaxRHO float32
+	// MaxZDR bounds the absolute differential reflectivity in dB, randomly
+	// tumbling debris has a ZDR near zero.
+	MaxZDR float32
+	// MinDeltaV is the difference in m/s between the outbound and inbound
+	// velocities of the couplet that has to surround the debris.
+	MinDeltaV float32
+	// CoupletWindow is the size in meters of the window searched around each
+	// gate for the velocity couplet.
+	CoupletWindow float64
+	// MaxElevation is the highest elevation angle in degrees searched.
+	MaxElevation float32
+	// MaxRange ignores gates beyond this range in meters.
+	MaxRange float64
+	// MinGates is the number of connected gates a signature needs.
+	MinGates int
+	// MaxDistance is the horizontal distance in meters within which
+	// signatures on different sweeps are associated.
+	MaxDistance float64
+}
+
+// DefaultTDSOptions returns thresholds adapted from Ryzhkov et al. (2005) and
+// the NWS warning decision training on tornado debris signatures.
+func DefaultTDSOptions() TDSOptions {
+	return TDSOptions{
+		MinREF:        35,
+		MaxRHO:        0.82,
+		MaxZDR:        1,
+		MinDeltaV:     30,
+		CoupletWindow: 2500,
+		MaxElevation:  10,
+		MaxRange:      150000,
+		MinGates:      3,
+		MaxDistance:   3000,
+	}
+}
+
+// TDS is a candidate tornado debris signature.
+type TDS struct {
+	Lat, Lon float64
+	// Azimuth in degrees and Range in meters of the signature on its lowest sweep.
+	Azimuth, Range float64
+	// Base and Top are the heights in meters above sea level of the lowest
+	// and highest sweeps the signature was found on.
+	Base, Top float64
+	Sweeps    int
+	Gates     int
+	MaxREF    float32
+	MinRHO    float32
+	MeanZDR   float32
+	MaxDeltaV float32
+	// Confidence of the detection between 0 and 1.
+	Confidence float32
+}
+
+// DetectTDS finds co-located high reflectivity, very low correlation
+// coefficient, near zero differential reflectivity and strong velocity
+// couplets in the volume, returning the candidate debris signatures with the
+// most confident first. In split cuts the velocity is taken from the Doppler
+// sweep with the closest elevation angle.
+func DetectTDS(ar2 *archive2.Archive2, opts TDSOptions) []TDS {
+	sweeps := ar2.Sweeps()
+	sort.SliceStable(sweeps, func(i, j int) bool {
+		return sweeps[i].ElevationAngle < sweeps[j].ElevationAngle
+	})
+
+	candidates := []TDS{}
+	for _, sweep := range sweeps {
+		if sweep.ElevationAngle > float64(opts.MaxElevation) || len(sweep.Radials) == 0 || sweep.Radials[0].RhoData == nil {
+			continue
+		}
+		vel := closestVelocitySweep(sweeps, sweep.ElevationAngle)
+		if vel == nil {
+			continue
+		}
+
+		for _, c := range tdsClusters(sweep, vel, opts) {
+			associated := false
+			for i := range candidates {
+				if _, d := archive2.BearingDistance(candidates[i].Lat, candidates[i].Lon, c.Lat, c.Lon); d <= opts.MaxDistance && c.Base > candidates[i].Top {
+					candidates[i].merge(c)
+					associated = true
+					break
+				}
+			}
+			if !associated {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+
+	for i := range candidates {
+		candidates[i].Confidence = candidates[i].confidence(opts)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	return candidates
+}
+
+// closestVelocitySweep returns the sweep holding velocity data with the
+// elevation angle closest to el, within half a degree.
+func closestVelocitySweep(sweeps []*archive2.Sweep, el float64) *archive2.Sweep {
+	var best *archive2.Sweep
+	bestDiff := 0.5
+	for _, s := range sweeps {
+		if len(s.Radials) == 0 || s.Radials[0].VelocityData == nil {
+			continue
+		}
+		if d := math.Abs(s.ElevationAngle - el); d <= bestDiff {
+			best, bestDiff = s, d
+		}
+	}
+	return best
+}
+
+// tdsClusters returns the debris signatures found on a single sweep.
+func tdsClusters(sweep, vel *archive2.Sweep, opts TDSOptions) []TDS {
+	type candidate struct {
+		ref, rho, zdr, deltaV float32
+	}
+
+	// find the gates that match the polarimetric signature and sit inside a couplet
+	found := map[gate]candidate{}
+	for i, radial := range sweep.Radials {
+		rho := sweep.Gates(i, "RHO")
+		for g, v := range rho {
+			if !valid(v) || v > opts.MaxRHO {
+				continue
+			}
+			r := radial.RhoData.GateRange(g)
+			if r > opts.MaxRange {
+				break
+			}
+			z, ok := gateValue(radial.ReflectivityData, sweep.Gates(i, "REF"), r)
+			if !ok || z < opts.MinREF {
+				continue
+			}
+			d, ok := gateValue(radial.ZdrData, sweep.Gates(i, "ZDR"), r)
+			if !ok || float32(math.Abs(float64(d))) > opts.MaxZDR {
+				continue
+			}
+			dv := coupletDeltaV(vel, float64(radial.Header.AzimuthAngle), r, opts.CoupletWindow)
+			if dv < opts.MinDeltaV {
+				continue
+			}
+			found[gate{i, g}] = candidate{z, v, d, dv}
+		}
+	}
+
+	// group the connected gates, in gate order so the clusters are always
+	// returned in the same order
+	starts := make([]gate, 0, len(found))
+	for g := range found {
+		starts = append(starts, g)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		if starts[i].radial != starts[j].radial {
+			return starts[i].radial < starts[j].radial
+		}
+		return starts[i].gate < starts[j].gate
+	})
+
+	visited := map[gate]bool{}
+	clusters := []TDS{}
+	for _, start := range starts {
+		if visited[start] {
+			continue
+		}
+		members := []gate{}
+		stack := []gate{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			members = append(members, c)
+			for da := -1; da <= 1; da++ {
+				for dg := -1; dg <= 1; dg++ {
+					n := gate{(c.radial + da + len(sweep.Radials)) % len(sweep.Radials), c.gate + dg}
+					if _, ok := found[n]; ok && !visited[n] {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		if len(members) < opts.MinGates {
+			continue
+		}
+
+		t := TDS{Sweeps: 1, Gates: len(members), MinRHO: 1}
+		var x, y, r, zdr float64
+		for _, m := range members {
+			c := found[m]
+			radial := sweep.Radials[m.radial]
+			gr := radial.RhoData.GateRange(m.gate)
+			az := float64(radial.Header.AzimuthAngle) * degToRad
+			x += gr * math.Sin(az)
+			y += gr * math.Cos(az)
+			r += gr
+			zdr += float64(c.zdr)
+			if c.ref > t.MaxREF {
+				t.MaxREF = c.ref
+			}
+			if c.rho < t.MinRHO {
+				t.MinRHO = c.rho
+			}
+			if c.deltaV > t.MaxDeltaV {
+				t.MaxDeltaV = c.deltaV
+			}
+		}
+		n := float64(len(members))
+		t.MeanZDR = float32(zdr / n)
+		t.Azimuth = math.Mod(math.Atan2(x, y)*radToDeg+360, 360)
+		t.Range = r / n
+
+		site := sweep.Radials[members[0].radial]
+		t.Lat, t.Lon = archive2.Destination(float64(site.VolumeData.Lat), float64(site.VolumeData.Long), t.Azimuth, archive2.GroundRange(t.Range, sweep.ElevationAngle))
+		t.Base = site.VolumeData.SiteAltitude() + archive2.BeamHeight(t.Range, sweep.ElevationAngle)
+		t.Top = t.Base
+		clusters = append(clusters, t)
+	}
+	return clusters
+}
+
+// gate identifies a gate of a sweep by radial and gate index.
+type gate struct{ radial, gate int }
+
+// coupletDeltaV returns the difference between the strongest outbound and
+// inbound velocities of a rotational couplet within a window centered at the
+// azimuth in degrees and slant range in meters. The two have to lie on
+// opposite sides of the center in azimuth, further apart across the radials
+// than along them, which rules out convergence and divergence. The velocities
+// are dealiased across the radials of the window first, see dealias.
+func coupletDeltaV(vel *archive2.Sweep, azimuth, r, window float64) float32 {
+	center := vel.Index(azimuth)
+	if center < 0 {
+		return 0
+	}
+	radial := vel.Radials[center]
+	spacing := radial.Header.AzimuthResolutionSpacing() * degToRad
+	na := int(math.Ceil(window / 2 / (r * spacing)))
+	if na > 10 {
+		na = 10
+	}
+	interval := float64(radial.VelocityData.DataMomentRangeSampleInterval)
+	ng := int(window / 2 / interval)
+	nyquist := float32(radial.RadialData.NyquistVelocity) / 100
+
+	// the window of gates, NaN where there is no valid velocity
+	w := make([][]float32, 2*na+1)
+	for da := range w {
+		w[da] = make([]float32, 2*ng+1)
+		for dg := range w[da] {
+			w[da][dg] = float32(math.NaN())
+		}
+		i := (center + da - na + len(vel.Radials)) % len(vel.Radials)
+		m := vel.Radials[i].VelocityData
+		if m == nil {
+			continue
+		}
+		gates := vel.Gates(i, "VEL")
+		g0 := m.GateIndex(r)
+		if g0 < 0 {
+			continue
+		}
+		for dg := range w[da] {
+			if g := g0 + dg - ng; g >= 0 && g < len(gates) && valid(gates[g]) {
+				w[da][dg] = gates[g]
+			}
+		}
+	}
+	if nyquist > 0 {
+		row := make([]float32, len(w))
+		for dg := range w[0] {
+			for da := range w {
+				row[da] = w[da][dg]
+			}
+			dealias(row, nyquist)
+			for da := range w {
+				w[da][dg] = row[da]
+			}
+		}
+	}
+
+	var maxV, minV gate
+	found := false
+	for da := range w {
+		for dg, v := range w[da] {
+			if v != v {
+				continue
+			}
+			if !found || v > w[maxV.radial][maxV.gate] {
+				maxV = gate{da, dg}
+			}
+			if !found || v < w[minV.radial][minV.gate] {
+				minV = gate{da, dg}
+			}
+			found = true
+		}
+	}
+	if !found {
+		return 0
+	}
+
+	// the center lies between the two maxima in azimuth
+	if maxV.radial == minV.radial || (maxV.radial-na)*(minV.radial-na) > 0 {
+		return 0
+	}
+	across := math.Abs(float64(maxV.radial-minV.radial)) * r * spacing
+	along := math.Abs(float64(maxV.gate-minV.gate)) * interval
+	if along > across {
+		return 0
+	}
+	return w[maxV.radial][maxV.gate] - w[minV.radial][minV.gate]
+}
+
+// dealias unfolds the velocities of a gate on the radials of a couplet window
+// in place, NaN where there is no velocity. Every velocity is unfolded by
+// multiples of twice the Nyquist velocity toward its neighbor on the side of
+// the window edge, walking in from both edges, and the right edge toward the
+// left edge, so the flow around the couplet is continuous. A couplet stronger
+// than the Nyquist velocity can not be unfolded by continuity across it, so
+// the two walks meet at the larger jump next to the center radial.
+func dealias(row []float32, nyquist float32) {
+	n := len(row)
+	left := make([]float32, n)
+	right := make([]float32, n)
+	ref := float32(math.NaN())
+	for i, v := range row {
+		left[i] = unfold(v, ref, nyquist)
+		if v == v {
+			ref = left[i]
+		}
+	}
+	for i := range row {
+		if row[i] == row[i] {
+			ref = left[i]
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		right[i] = unfold(row[i], ref, nyquist)
+		if row[i] == row[i] {
+			ref = right[i]
+		}
+	}
+
+	// the walks differ only after crossing a jump they could not unfold
+	split, jump := n-1, float32(0)
+	for i := range row {
+		if left[i] != right[i] && row[i] == row[i] {
+			for k := n/2 - 1; k <= n/2; k++ {
+				if d := float32(math.Abs(float64(left[k] - right[k+1]))); d > jump {
+					split, jump = k, d
+				}
+			}
+			break
+		}
+	}
+	for i := range row {
+		if i <= split {
+			row[i] = left[i]
+		} else {
+			row[i] = right[i]
+		}
+	}
+}
+
+// unfold returns the velocity v plus the multiple of twice the Nyquist
+// velocity closest to ref, v if either is NaN.
+func unfold(v, ref, nyquist float32) float32 {
+	if v != v || ref != ref {
+		return v
+	}
+	return v + 2*nyquist*float32(math.Round(float64((ref-v)/(2*nyquist))))
+}
+
+// merge adds a signature found on a higher sweep.
+func (t *TDS) merge(o TDS) {
+	n := float32(t.Gates + o.Gates)
+	t.MeanZDR = (t.MeanZDR*float32(t.Gates) + o.MeanZDR*float32(o.Gates)) / n
+	t.Gates += o.Gates
+	t.Sweeps += o.Sweeps
+	t.Top = math.Max(t.Top, o.Top)
+	if o.MaxREF > t.MaxREF {
+		t.MaxREF = o.MaxREF
+	}
+	if o.MinRHO < t.MinRHO {
+		t.MinRHO = o.MinRHO
+	}
+	if o.MaxDeltaV > t.MaxDeltaV {
+		t.MaxDeltaV = o.MaxDeltaV
+	}
+}
+
+// confidence scores how strongly each ingredient of the signature is present.
+func (t TDS) confidence(opts TDSOptions) float32 {
+	scores := []float32{
+		Trapezoid{opts.MinREF - 1, opts.MinREF + 15, 100, 101}.Membership(t.MaxREF),
+		Trapezoid{-1, 0, opts.MaxRHO - 0.2, opts.MaxRHO + 0.01}.Membership(t.MinRHO),
+		Trapezoid{-opts.MaxZDR - 0.01, -0.25, 0.25, opts.MaxZDR + 0.01}.Membership(t.MeanZDR),
+		Trapezoid{opts.MinDeltaV - 1, opts.MinDeltaV + 30, 500, 501}.Membership(t.MaxDeltaV),
+		Trapezoid{0, float32(opts.MinGates) * 4, 1e9, 1e9 + 1}.Membership(float32(t.Gates)),
+		Trapezoid{0, 3, 1e9, 1e9 + 1}.Membership(float32(t.Sweeps)),
+	}
+	var sum float32
+	for _, s := range scores {
+		sum += s
+	}
+	return sum / float32(len(scores))
+}
@@ -0,0 +1,109 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestDetectTDS(t *testing.T) {
+	vel := rankineSweep(0.5, 40000, 0, 1500, 35)
+	for _, radial := range vel {
+		ref := fill(300, 30)
+		rho := fill(300, 0.98)
+		zdr := fill(300, 1.5)
+		if radial.Header.AzimuthAngle > 88.5 && radial.Header.AzimuthAngle < 91.5 {
+			for g := 147; g < 153; g++ {
+				ref[g], rho[g], zdr[g] = 50, 0.6, 0.2
+			}
+		}
+		radial.ReflectivityData = newMoment(2125, 250, ref)
+		radial.RhoData = newMoment(2125, 250, rho)
+		radial.ZdrData = newMoment(2125, 250, zdr)
+	}
+
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{1: vel}}
+	found := DetectTDS(ar2, DefaultTDSOptions())
+	if len(found) != 1 {
+		t.Fatalf("expected 1 signature, got %d", len(found))
+	}
+	if found[0].Confidence <= 0.5 || found[0].MinRHO > 0.61 {
+		t.Errorf("unexpected signature %+v", found[0])
+	}
+}
+
+// velocitySweep returns a half degree sweep of 300 gates with the velocity
+// v(azimuth, range) and a Nyquist velocity in m/s.
+func velocitySweep(nyquist float64, v func(az, r float64) float32) *archive2.Sweep {
+	radials := []*archive2.Message31{}
+	for az := 0.25; az < 360; az += 0.5 {
+		vel := make([]float32, 300)
+		for g := range vel {
+			vel[g] = v(az, 2125+float64(g)*250)
+		}
+		radial := newRadial(float32(az), 0.5)
+		radial.RadialData.NyquistVelocity = uint16(nyquist * 100)
+		radial.VelocityData = newMoment(2125, 250, vel)
+		radials = append(radials, radial)
+	}
+	return archive2.NewSweep(radials)
+}
+
+func TestCoupletDeltaV(t *testing.T) {
+	rankine := archive2.NewSweep(rankineSweep(0.5, 40000, 0, 1500, 35))
+	if dv := coupletDeltaV(rankine, 90, 40000, 2500); dv < 30 {
+		t.Errorf("couplet: got %f want at least 30", dv)
+	}
+
+	// outbound flow meeting inbound flow along the radials
+	convergence := velocitySweep(0, func(az, r float64) float32 {
+		if r < 40000 {
+			return 20
+		}
+		return -20
+	})
+	if dv := coupletDeltaV(convergence, 90, 40000, 2500); dv != 0 {
+		t.Errorf("convergence: got %f want 0", dv)
+	}
+
+	// a uniform 25 m/s flow folded to -25 m/s by a 26 m/s Nyquist velocity
+	// looks like a couplet
+	shear := func(az, r float64) float32 {
+		if az < 90 {
+			return 25
+		}
+		return -25
+	}
+	if dv := coupletDeltaV(velocitySweep(0, shear), 90, 40000, 2500); dv != 50 {
+		t.Errorf("shear: got %f want 50", dv)
+	}
+	// unfolded to 25 and 27 m/s
+	if dv := coupletDeltaV(velocitySweep(26, shear), 90, 40000, 2500); dv > 2 {
+		t.Errorf("aliased: got %f want at most 2", dv)
+	}
+
+	// a 72 m/s gate to gate couplet folded by a 27 m/s Nyquist velocity
+	tornado := func(az, r float64) float32 {
+		d := (az - 90) * degToRad * r
+		v := 36 * d / 175
+		if math.Abs(d) > 175 {
+			v = 36 * 175 / d
+		}
+		return fold(float32(v), 27)
+	}
+	if dv := coupletDeltaV(velocitySweep(27, tornado), 90, 40000, 2500); dv < 70 || dv > 74 {
+		t.Errorf("tornado: got %f want 72", dv)
+	}
+}
+
+// fold returns the velocity v as measured with the Nyquist velocity.
+func fold(v, nyquist float32) float32 {
+	for v > nyquist {
+		v -= 2 * nyquist
+	}
+	for v < -nyquist {
+		v += 2 * nyquist
+	}
+	return v
+}
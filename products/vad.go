@@ -0,0 +1,271 @@
+package products
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+// VADOptions configures the velocity azimuth display wind estimates.
+type VADOptions struct {
+	// Ranges are the slant ranges in meters at which the wind is estimated on
+	// every sweep.
+	Ranges []float64
+	// RangeWindow is the depth in meters of the ring of gates averaged at each
+	// slant range.
+	RangeWindow float64
+	// MinElevation and MaxElevation bound the elevation angles in degrees
+	// used. Steep tilts project too much of the fall speed of precipitation
+	// onto the radial.
+	MinElevation, MaxElevation float32
+	// MinPoints is the number of radials with valid velocities a ring needs.
+	MinPoints int
+	// MaxGap is the largest azimuthal gap in degrees without data allowed in
+	// a ring.
+	MaxGap float64
+	// MaxRMS rejects fits whose RMS error in m/s exceeds this value.
+	MaxRMS float64
+	// LayerDepth is the depth in meters of the layers the estimates are
+	// averaged into for the profile.
+	LayerDepth float64
+}
+
+// DefaultVADOptions returns options similar to those of the WSR-88D VAD wind profile.
+func DefaultVADOptions() VADOptions {
+	return VADOptions{
+		Ranges:       []float64{10000, 15000, 20000, 25000, 30000, 40000, 50000, 60000},
+		RangeWindow:  2000,
+		MinElevation: 0.4,
+		MaxElevation: 20,
+		MinPoints:    100,
+		MaxGap:       30,
+		MaxRMS:       5,
+		LayerDepth:   304.8,
+	}
+}
+
+// WindEstimate is the wind fit to a ring of velocities on a single sweep.
+type WindEstimate struct {
+	ElevationAngle float64
+	// Range is the slant range of the ring in meters.
+	Range float64
+	// Height of the ring in meters above sea level.
+	Height float64
+	// U and V are the eastward and northward wind components in m/s.
+	U, V float64
+	// RMS is the root mean square error of the fit in m/s.
+	RMS    float64
+	Points int
+}
+
+// Speed returns the wind speed in m/s.
+func (w WindEstimate) Speed() float64 {
+	return math.Hypot(w.U, w.V)
+}
+
+// Direction returns the direction the wind blows from in degrees.
+func (w WindEstimate) Direction() float64 {
+	return windDirection(w.U, w.V)
+}
+
+// WindLevel is the wind at a single height of a WindProfile.
+type WindLevel struct {
+	// Height in meters above sea level of the center of the layer.
+	Height float64 `json:"height"`
+	// Direction the wind blows from in degrees.
+	Direction float64 `json:"direction"`
+	// Speed in m/s.
+	Speed float64 `json:"speed"`
+	// RMS is the mean RMS error of the estimates in the layer in m/s.
+	RMS       float64 `json:"rms"`
+	Estimates int     `json:"estimates"`
+}
+
+// WindProfile is the vertical wind profile derived from a volume.
+type WindProfile struct {
+	Station string      `json:"station"`
+	Time    time.Time   `json:"time"`
+	Levels  []WindLevel `json:"levels"`
+}
+
+// windDirection returns the direction in degrees the wind with components u,
+// v blows from.
+func windDirection(u, v float64) float64 {
+	return math.Mod(math.Atan2(-u, -v)*radToDeg+360, 360)
+}
+
+// VAD fits a uniform wind to the velocities of the sweep at slant range r in
+// meters. The velocities are unfolded around the ring and against a first fit
+// using the Nyquist velocity of each radial before the final fit. ok is false if the ring does
+// not meet the coverage and RMS requirements.
+func VAD(sweep *archive2.Sweep, r float64, opts VADOptions) (w WindEstimate, ok bool) {
+	type point struct {
+		az, v, nyquist float64
+	}
+
+	points := []point{}
+	for i, radial := range sweep.Radials {
+		m := radial.VelocityData
+		if m == nil {
+			continue
+		}
+		gates := sweep.Gates(i, "VEL")
+		lo := m.GateIndex(r - opts.RangeWindow/2)
+		hi := m.GateIndex(r + opts.RangeWindow/2)
+		if lo < 0 || hi < 0 {
+			continue
+		}
+		var sum float64
+		n := 0
+		for g := lo; g <= hi && g < len(gates); g++ {
+			if valid(gates[g]) {
+				sum += float64(gates[g])
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		points = append(points, point{
+			az:      float64(radial.Header.AzimuthAngle),
+			v:       sum / float64(n),
+			nyquist: float64(radial.RadialData.NyquistVelocity) / 100,
+		})
+	}
+
+	azimuths := make([]float64, len(points))
+	for i, p := range points {
+		azimuths[i] = p.az
+	}
+	if len(points) < opts.MinPoints || maxAzimuthGap(azimuths) > opts.MaxGap {
+		return w, false
+	}
+
+	fit := func() (a0, a1, b1, rms float64) {
+		// harmonic fit V = a0 + a1 cos(az) + b1 sin(az)
+		var fit lsq3
+		for _, p := range points {
+			az := p.az * degToRad
+			fit.add(math.Cos(az), math.Sin(az), p.v)
+		}
+		a0, a1, b1, _ = fit.solve()
+		for _, p := range points {
+			az := p.az * degToRad
+			e := p.v - (a0 + a1*math.Cos(az) + b1*math.Sin(az))
+			rms += e * e
+		}
+		return a0, a1, b1, math.Sqrt(rms / float64(len(points)))
+	}
+
+	// unfold around the ring so neighboring radials stay within the Nyquist
+	// velocity of each other, then against a first fit of the unfolded ring
+	sort.Slice(points, func(i, j int) bool { return points[i].az < points[j].az })
+	for i := 1; i < len(points); i++ {
+		if p := points[i]; p.nyquist > 0 {
+			n := math.Round((points[i-1].v - p.v) / (2 * p.nyquist))
+			points[i].v += n * 2 * p.nyquist
+		}
+	}
+
+	a0, a1, b1, _ := fit()
+	for i, p := range points {
+		if p.nyquist == 0 {
+			continue
+		}
+		az := p.az * degToRad
+		expected := a0 + a1*math.Cos(az) + b1*math.Sin(az)
+		// shift by multiples of twice the Nyquist velocity toward the fit
+		n := math.Round((expected - p.v) / (2 * p.nyquist))
+		points[i].v += n * 2 * p.nyquist
+	}
+	_, a1, b1, rms := fit()
+	if rms > opts.MaxRMS {
+		return w, false
+	}
+
+	el := sweep.ElevationAngle
+	cosEl := math.Cos(el * degToRad)
+	site := sweep.Radials[0].VolumeData.SiteAltitude()
+	return WindEstimate{
+		ElevationAngle: el,
+		Range:          r,
+		Height:         site + archive2.BeamHeight(r, el),
+		U:              b1 / cosEl,
+		V:              a1 / cosEl,
+		RMS:            rms,
+		Points:         len(points),
+	}, true
+}
+
+// maxAzimuthGap returns the largest gap in degrees between consecutive azimuths.
+func maxAzimuthGap(azimuths []float64) float64 {
+	if len(azimuths) == 0 {
+		return 360
+	}
+	sorted := append([]float64{}, azimuths...)
+	sort.Float64s(sorted)
+	gap := sorted[0] + 360 - sorted[len(sorted)-1]
+	for i := 1; i < len(sorted); i++ {
+		gap = math.Max(gap, sorted[i]-sorted[i-1])
+	}
+	return gap
+}
+
+// VADProfile estimates the wind at every configured range of every sweep of
+// the volume and averages the estimates into layers to build a vertical wind
+// profile.
+func VADProfile(ar2 *archive2.Archive2, opts VADOptions) WindProfile {
+	profile := WindProfile{
+		Station: string(ar2.VolumeHeader.ICAO[:]),
+		Time:    ar2.VolumeHeader.Date(),
+	}
+
+	estimates := []WindEstimate{}
+	for _, sweep := range ar2.Sweeps() {
+		if len(sweep.Radials) == 0 || sweep.Radials[0].VelocityData == nil {
+			continue
+		}
+		if sweep.ElevationAngle < float64(opts.MinElevation) || sweep.ElevationAngle > float64(opts.MaxElevation) {
+			continue
+		}
+		for _, r := range opts.Ranges {
+			if w, ok := VAD(sweep, r, opts); ok {
+				estimates = append(estimates, w)
+			}
+		}
+	}
+
+	type layer struct {
+		u, v, rms float64
+		n         int
+	}
+	layers := map[int]*layer{}
+	for _, w := range estimates {
+		k := int(math.Floor(w.Height / opts.LayerDepth))
+		if layers[k] == nil {
+			layers[k] = &layer{}
+		}
+		layers[k].u += w.U
+		layers[k].v += w.V
+		layers[k].rms += w.RMS
+		layers[k].n++
+	}
+
+	for k, l := range layers {
+		n := float64(l.n)
+		u, v := l.u/n, l.v/n
+		profile.Levels = append(profile.Levels, WindLevel{
+			Height:    (float64(k) + 0.5) * opts.LayerDepth,
+			Direction: windDirection(u, v),
+			Speed:     math.Hypot(u, v),
+			RMS:       l.rms / n,
+			Estimates: l.n,
+		})
+	}
+	sort.Slice(profile.Levels, func(i, j int) bool {
+		return profile.Levels[i].Height < profile.Levels[j].Height
+	})
+	return profile
+}
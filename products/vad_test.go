@@ -0,0 +1,33 @@
+package products
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+func TestVAD(t *testing.T) {
+	// 15 m/s wind from the south west, aliased by a 10 m/s Nyquist velocity
+	u, v := 15/math.Sqrt2, 15/math.Sqrt2
+	el := 2.4
+	radials := []*archive2.Message31{}
+	for az := 0.5; az < 360; az++ {
+		vr := (u*math.Sin(az*degToRad) + v*math.Cos(az*degToRad)) * math.Cos(el*degToRad)
+		vr = math.Remainder(vr, 20)
+		radial := newRadial(float32(az), float32(el))
+		radial.RadialData.NyquistVelocity = 1000
+		radial.VelocityData = newMoment(2125, 250, fill(200, float32(vr)))
+		radials = append(radials, radial)
+	}
+
+	opts := DefaultVADOptions()
+	opts.MaxRMS = 1
+	w, ok := VAD(archive2.NewSweep(radials), 30000, opts)
+	if !ok {
+		t.Fatal("expected a wind estimate")
+	}
+	if math.Abs(w.Speed()-15) > 0.1 || math.Abs(w.Direction()-225) > 1 {
+		t.Errorf("got %f m/s from %f, want 15 m/s from 225", w.Speed(), w.Direction())
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache holds the most recently used decoded volumes. Volumes are loaded once
+// however many requests ask for them at the same time.
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name  string
+	ready chan struct{}
+	vol   *volume
+	err   error
+}
+
+// newCache returns a cache holding up to size volumes.
+func newCache(size int) *cache {
+	if size < 1 {
+		size = 1
+	}
+	return &cache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the volume with the given name, calling load when it is not
+// cached. Failed loads are not cached.
+func (c *cache) get(name string, load func() (*volume, error)) (*volume, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[name]; ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		e := el.Value.(*cacheEntry)
+		<-e.ready
+		return e.vol, e.err
+	}
+
+	e := &cacheEntry{name: name, ready: make(chan struct{})}
+	c.entries[name] = c.order.PushFront(e)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cacheEntry).name)
+	}
+	c.mu.Unlock()
+
+	e.vol, e.err = load()
+	close(e.ready)
+	if e.err != nil {
+		c.mu.Lock()
+		if el, ok := c.entries[name]; ok && el.Value == e {
+			c.order.Remove(el)
+			delete(c.entries, name)
+		}
+		c.mu.Unlock()
+	}
+	return e.vol, e.err
+}
+
+// len returns the number of cached volumes.
+func (c *cache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	c := newCache(2)
+	loads := map[string]int{}
+	load := func(name string) func() (*volume, error) {
+		return func() (*volume, error) {
+			loads[name]++
+			return &volume{}, nil
+		}
+	}
+
+	a, _ := c.get("a", load("a"))
+	c.get("b", load("b"))
+	if v, _ := c.get("a", load("a")); v != a {
+		t.Error("a was not cached")
+	}
+	// b is the least recently used
+	c.get("c", load("c"))
+	c.get("a", load("a"))
+	c.get("b", load("b"))
+	if loads["a"] != 1 || loads["b"] != 2 || loads["c"] != 1 {
+		t.Errorf("loads = %v", loads)
+	}
+	if c.len() != 2 {
+		t.Errorf("%d cached volumes", c.len())
+	}
+
+	fail := errors.New("corrupt")
+	if _, err := c.get("d", func() (*volume, error) { return nil, fail }); err != fail {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := c.get("d", load("d")); err != nil || loads["d"] != 1 {
+		t.Error("failed load was cached")
+	}
+}
+
+func TestCacheConcurrentLoad(t *testing.T) {
+	c := newCache(1)
+	var loads int32
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.get("a", func() (*volume, error) {
+				atomic.AddInt32(&loads, 1)
+				<-release
+				return &volume{}, nil
+			})
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("volume loaded %d times", loads)
+	}
+}
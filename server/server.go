@@ -0,0 +1,472 @@
+// Package server serves a directory of archive 2 files over HTTP: a listing of
+// the volumes, PNG images and XYZ tiles of their products, and the values of
+// the gates above a location. A web viewer of the volumes is served at /.
+//
+//	GET /volumes                                          volumes, ?station= filters
+//	GET /volumes/{file}                                   location and elevation scans
+//	GET /volumes/{file}/point?elevation=&lat=&lon=        gate values, ?products= selects
+//	GET /volumes/{file}/{product}/{elevation}.png         image centered on the radar
+//	GET /tiles/{file}/{product}/{elevation}/{z}/{x}/{y}.png
+//	GET /wms?SERVICE=WMS&REQUEST=GetCapabilities              WMS 1.3.0
+//
+// Images and tiles take the name of a color scheme with ?scheme=. Files are
+// assumed not to change once written.
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/bwiggs/go-nexrad/tiles"
+	"github.com/sirupsen/logrus"
+)
+
+// Extent is the distance in meters from the radar to the edges of images.
+const Extent = 460000
+
+// maxImageSize limits the size of requested images in pixels.
+const maxImageSize = 4096
+
+// Options configures a Server.
+type Options struct {
+	// Dir is the directory of archive 2 files.
+	Dir string
+	// CacheSize is the number of decoded volumes kept in memory.
+	CacheSize int
+	// ImageSize is the width and height of images in pixels unless asked
+	// for with ?size=.
+	ImageSize int
+}
+
+// DefaultOptions returns the options of a server of the current directory.
+func DefaultOptions() Options {
+	return Options{
+		Dir:       ".",
+		CacheSize: 8,
+		ImageSize: 1024,
+	}
+}
+
+// Server serves the archive 2 files of a directory.
+type Server struct {
+	opts  Options
+	cache *cache
+	mux   *http.ServeMux
+	// open decodes an archive 2 file.
+	open func(path string) (*archive2.Archive2, error)
+
+	// layers summarizes the latest volume of every station by file name for
+	// the WMS capabilities.
+	layersMu sync.Mutex
+	layers   map[string]layerSummary
+}
+
+// New returns a server of the files in opts.Dir.
+func New(opts Options) *Server {
+	s := &Server{
+		opts:  opts,
+		cache: newCache(opts.CacheSize),
+		mux:   http.NewServeMux(),
+		open:  openVolume,
+	}
+	s.mux.HandleFunc("/volumes", s.handleVolumes)
+	s.mux.HandleFunc("/volumes/", s.handleVolume)
+	s.mux.HandleFunc("/tiles/", s.handleTile)
+	s.mux.HandleFunc("/wms", s.handleWMS)
+	s.mux.Handle("/", viewer())
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	s.mux.ServeHTTP(w, r)
+	logrus.Debugf("server: %s %s %s", r.Method, r.URL, time.Since(start))
+}
+
+// volumeInfo describes a file of the directory.
+type volumeInfo struct {
+	File    string    `json:"file"`
+	Station string    `json:"station"`
+	Time    time.Time `json:"time"`
+}
+
+// volumes returns the archive 2 files of the directory ordered by time.
+func (s *Server) volumes() ([]volumeInfo, error) {
+	files, err := ioutil.ReadDir(s.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	volumes := []volumeInfo{}
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		vh, err := readVolumeHeader(filepath.Join(s.opts.Dir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, volumeInfo{fi.Name(), station(vh), vh.Date()})
+	}
+	sort.SliceStable(volumes, func(i, j int) bool {
+		if !volumes[i].Time.Equal(volumes[j].Time) {
+			return volumes[i].Time.Before(volumes[j].Time)
+		}
+		return volumes[i].File < volumes[j].File
+	})
+	return volumes, nil
+}
+
+// readVolumeHeader reads the volume header record at the start of an archive 2
+// file without decoding the rest of the file.
+func readVolumeHeader(path string) (vh archive2.VolumeHeaderRecord, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return vh, err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	// older archive 2 files are gzipped
+	if magic, err := r.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		if r, err = gzip.NewReader(r); err != nil {
+			return vh, err
+		}
+	}
+	if err := binary.Read(r, binary.BigEndian, &vh); err != nil {
+		return vh, err
+	}
+	if !strings.HasPrefix(vh.FileName(), "AR2V") {
+		return vh, fmt.Errorf("%s is not an archive 2 file", path)
+	}
+	return vh, nil
+}
+
+// station returns the ICAO identifier of the radar of a volume.
+func station(vh archive2.VolumeHeaderRecord) string {
+	return strings.Trim(string(vh.ICAO[:]), "\x00 ")
+}
+
+// volume returns the decoded file of the directory with the given name.
+func (s *Server) volume(name string) (*volume, error) {
+	if name == "" || strings.HasPrefix(name, ".") || filepath.Base(name) != name {
+		return nil, os.ErrNotExist
+	}
+	return s.cache.get(name, func() (*volume, error) {
+		path := filepath.Join(s.opts.Dir, name)
+		if _, err := readVolumeHeader(path); err != nil {
+			logrus.Debug(err)
+			return nil, os.ErrNotExist
+		}
+		logrus.Infof("server: decoding %s", name)
+		ar2, err := s.open(path)
+		if err != nil {
+			return nil, err
+		}
+		return newVolume(ar2), nil
+	})
+}
+
+func (s *Server) handleVolumes(w http.ResponseWriter, r *http.Request) {
+	volumes, err := s.volumes()
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if station := strings.ToUpper(r.URL.Query().Get("station")); station != "" {
+		filtered := []volumeInfo{}
+		for _, v := range volumes {
+			if v.Station == station {
+				filtered = append(filtered, v)
+			}
+		}
+		volumes = filtered
+	}
+	writeJSON(w, volumes)
+}
+
+// handleVolume serves everything below /volumes/{file}.
+func (s *Server) handleVolume(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/volumes/"), "/")
+	vol, err := s.volume(parts[0])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		writeJSON(w, vol.info(parts[0]))
+	case len(parts) == 2 && parts[1] == "point":
+		s.handlePoint(w, r, vol)
+	case len(parts) == 3 && strings.HasSuffix(parts[2], ".png"):
+		product, elevation, ok := vol.parseSweep(parts[1], strings.TrimSuffix(parts[2], ".png"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.handleImage(w, r, vol, product, elevation)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// volumeDetail describes a decoded volume.
+type volumeDetail struct {
+	volumeInfo
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	// Height of the radar antenna above mean sea level in meters.
+	Height     float64         `json:"height"`
+	VCP        int             `json:"vcp"`
+	Elevations []elevationInfo `json:"elevations"`
+	Products   []string        `json:"products"`
+	// Schemes are the color schemes of every product.
+	Schemes map[string][]string `json:"schemes"`
+}
+
+// elevationInfo describes an elevation scan.
+type elevationInfo struct {
+	Number  int       `json:"number"`
+	Angle   float64   `json:"angle"`
+	Time    time.Time `json:"time"`
+	Moments []string  `json:"moments"`
+}
+
+func (v *volume) info(file string) volumeDetail {
+	d := volumeDetail{
+		volumeInfo: volumeInfo{file, station(v.ar2.VolumeHeader), v.ar2.VolumeHeader.Date()},
+		Lat:        v.lat,
+		Lon:        v.lon,
+		Elevations: []elevationInfo{},
+		Products:   Products,
+		Schemes:    map[string][]string{},
+	}
+	for _, product := range Products {
+		d.Schemes[product] = schemeNames(product)
+	}
+	for _, elv := range v.ar2.Elevations() {
+		radials := v.ar2.ElevationScans[elv]
+		if len(radials) == 0 {
+			continue
+		}
+		d.Height = radials[0].VolumeData.SiteAltitude()
+		d.VCP = int(radials[0].VolumeData.VolumeCoveragePatternNumber)
+		e := elevationInfo{
+			Number:  elv,
+			Angle:   archive2.NewSweep(radials).ElevationAngle,
+			Time:    radials[0].Header.Date(),
+			Moments: []string{},
+		}
+		for _, m := range []string{"REF", "VEL", "SW", "ZDR", "PHI", "RHO", "CFP"} {
+			if radials[0].Moment(m) != nil {
+				e.Moments = append(e.Moments, m)
+			}
+		}
+		d.Elevations = append(d.Elevations, e)
+	}
+	return d
+}
+
+// parseSweep parses the product and elevation number of a request, ok is
+// false if either is not held by the volume.
+func (v *volume) parseSweep(product, elevation string) (string, int, bool) {
+	product = strings.ToLower(product)
+	if _, ok := productMoments[product]; !ok {
+		return "", 0, false
+	}
+	elv, err := strconv.Atoi(elevation)
+	if err != nil || len(v.ar2.ElevationScans[elv]) == 0 {
+		return "", 0, false
+	}
+	return product, elv, true
+}
+
+// handleImage draws a product centered on the radar in the azimuthal
+// equidistant projection of nexrad-render, reaching Extent meters from the
+// radar.
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request, vol *volume, product string, elevation int) {
+	colorOf, ok := colorFunc(product, r.URL.Query().Get("scheme"))
+	if !ok {
+		http.Error(w, "unknown color scheme", http.StatusBadRequest)
+		return
+	}
+	size := s.opts.ImageSize
+	if q := r.URL.Query().Get("size"); q != "" {
+		var err error
+		if size, err = strconv.Atoi(q); err != nil || size < 1 || size > maxImageSize {
+			http.Error(w, fmt.Sprintf("size must be 1-%d", maxImageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sampler := vol.sampler(product, elevation)
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	mPerPx := 2 * Extent / float64(size)
+	for y := 0; y < size; y++ {
+		north := Extent - (float64(y)+0.5)*mPerPx
+		for x := 0; x < size; x++ {
+			east := (float64(x)+0.5)*mPerPx - Extent
+			az := math.Mod(math.Atan2(east, north)*180/math.Pi+360, 360)
+			if v, ok := sampler.Polar(az, math.Hypot(east, north)); ok && v != archive2.MomentDataBelowThreshold {
+				img.Set(x, y, colorOf(v))
+			}
+		}
+	}
+	writePNG(w, img)
+}
+
+// handleTile serves /tiles/{file}/{product}/{elevation}/{z}/{x}/{y}.png.
+func (s *Server) handleTile(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/tiles/"), "/")
+	if len(parts) != 6 || !strings.HasSuffix(parts[5], ".png") {
+		http.NotFound(w, r)
+		return
+	}
+	var t tiles.Tile
+	var err error
+	for i, p := range []*int{&t.Z, &t.X, &t.Y} {
+		if *p, err = strconv.Atoi(strings.TrimSuffix(parts[3+i], ".png")); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	if n := 1 << uint(t.Z); t.Z < 0 || t.Z > 24 || t.X < 0 || t.Y < 0 || t.X >= n || t.Y >= n {
+		http.NotFound(w, r)
+		return
+	}
+
+	vol, err := s.volume(parts[0])
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	product, elevation, ok := vol.parseSweep(parts[1], parts[2])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	colorOf, ok := colorFunc(product, r.URL.Query().Get("scheme"))
+	if !ok {
+		http.Error(w, "unknown color scheme", http.StatusBadRequest)
+		return
+	}
+
+	sampler := vol.sampler(product, elevation)
+	img, _ := tiles.Render(t, func(lat, lon float64) (color.Color, bool) {
+		v, ok := sampler.At(lat, lon)
+		if !ok || v == archive2.MomentDataBelowThreshold {
+			return nil, false
+		}
+		return colorOf(v), true
+	})
+	writePNG(w, img)
+}
+
+// pointResult holds the values of the gates above a location, nil when below
+// threshold, range folded or outside the sweep.
+type pointResult struct {
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Elevation int     `json:"elevation"`
+	// Azimuth from the radar in degrees, ground and slant range in meters
+	// and height of the beam center above mean sea level in meters.
+	Azimuth     float64             `json:"azimuth"`
+	GroundRange float64             `json:"ground_range"`
+	SlantRange  float64             `json:"slant_range"`
+	Height      float64             `json:"height"`
+	Values      map[string]*float32 `json:"values"`
+	// Labels name the class of hca values.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func (s *Server) handlePoint(w http.ResponseWriter, r *http.Request, vol *volume) {
+	q := r.URL.Query()
+	lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+	lon, err2 := strconv.ParseFloat(q.Get("lon"), 64)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "lat and lon are required", http.StatusBadRequest)
+		return
+	}
+	_, elevation, ok := vol.parseSweep("ref", q.Get("elevation"))
+	if !ok {
+		http.Error(w, "unknown elevation", http.StatusBadRequest)
+		return
+	}
+	names := []string{"ref", "vel", "sw", "zdr", "phi", "rho", "cfp"}
+	if p := q.Get("products"); p != "" {
+		names = strings.Split(strings.ToLower(p), ",")
+	}
+
+	radials := vol.ar2.ElevationScans[elevation]
+	az, gr := archive2.BearingDistance(vol.lat, vol.lon, lat, lon)
+	el := archive2.NewSweep(radials).ElevationAngle
+	slant := archive2.SlantRange(gr, el)
+	res := pointResult{
+		Lat:         lat,
+		Lon:         lon,
+		Elevation:   elevation,
+		Azimuth:     az,
+		GroundRange: gr,
+		SlantRange:  slant,
+		Height:      radials[0].VolumeData.SiteAltitude() + archive2.BeamHeight(slant, el),
+		Values:      map[string]*float32{},
+	}
+	for _, name := range names {
+		if _, ok := productMoments[name]; !ok {
+			http.Error(w, fmt.Sprintf("unknown product %s", name), http.StatusBadRequest)
+			return
+		}
+		res.Values[name] = nil
+		if v, ok := vol.sampler(name, elevation).Polar(az, gr); ok && v != archive2.MomentDataBelowThreshold && v != archive2.MomentDataFolded {
+			res.Values[name] = &v
+			if name == "hca" {
+				res.Labels = map[string]string{name: products.HydrometeorClass(v).String()}
+			}
+		}
+	}
+	writeJSON(w, res)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func writePNG(w http.ResponseWriter, img image.Image) {
+	w.Header().Set("Content-Type", "image/png")
+	if err := png.Encode(w, img); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// httpError reports a missing file as not found and other errors as internal
+// server errors.
+func httpError(w http.ResponseWriter, err error) {
+	if os.IsNotExist(err) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	logrus.Error(err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
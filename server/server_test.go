@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/tiles"
+)
+
+const (
+	radarLat = 35.333
+	radarLon = -97.278
+)
+
+// newMoment returns a data moment holding the values.
+func newMoment(first, interval uint16, values []float32) *archive2.DataMoment {
+	m := &archive2.DataMoment{}
+	m.NumberDataMomentGates = uint16(len(values))
+	m.DataMomentRange = first
+	m.DataMomentRangeSampleInterval = interval
+	m.DataWordSize = 16
+	m.Scale = 100
+	m.Offset = 32768
+	m.Data = make([]byte, 2*len(values))
+	for i, v := range values {
+		n := uint16(0)
+		if v != archive2.MomentDataBelowThreshold {
+			n = uint16(v*m.Scale + m.Offset)
+		}
+		binary.BigEndian.PutUint16(m.Data[2*i:], n)
+	}
+	return m
+}
+
+// newVolumeAt returns a volume of a single half degree sweep at 0.5 degrees
+// holding reflectivity of 20 dBZ out to 50 km and nothing beyond.
+func newVolumeAt(date time.Time) *archive2.Archive2 {
+	gates := make([]float32, 400)
+	for i := range gates {
+		gates[i] = archive2.MomentDataBelowThreshold
+		if i < 200 {
+			gates[i] = 20
+		}
+	}
+	radials := []*archive2.Message31{}
+	for i := 0; i < 720; i++ {
+		r := &archive2.Message31{}
+		r.Header.AzimuthAngle = float32(i)/2 + 0.25
+		r.Header.ElevationAngle = 0.5
+		r.Header.AzimuthResolutionSpacingCode = 1
+		r.VolumeData.Lat = radarLat
+		r.VolumeData.Long = radarLon
+		r.ReflectivityData = newMoment(125, 250, gates)
+		radials = append(radials, r)
+	}
+	ar2 := &archive2.Archive2{ElevationScans: map[int][]*archive2.Message31{1: radials}}
+	ar2.VolumeHeader = volumeHeader(date)
+	return ar2
+}
+
+func volumeHeader(date time.Time) archive2.VolumeHeaderRecord {
+	vh := archive2.VolumeHeaderRecord{}
+	copy(vh.X_FileName[:], "AR2V0006.001")
+	copy(vh.ICAO[:], "KTLX")
+	days := date.Sub(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour)
+	vh.X_ModifiedJulianDate = int32(days) + 1
+	vh.X_ModifiedTime = int32(date.Sub(date.Truncate(24*time.Hour)) / time.Millisecond)
+	return vh
+}
+
+// newTestServer returns a server of a directory of synthetic volumes at the
+// given times and a file that is not an archive 2 file.
+func newTestServer(t *testing.T, dates ...time.Time) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumes := map[string]*archive2.Archive2{}
+	for _, date := range dates {
+		name := "KTLX" + date.Format("20060102_150405") + "_V06"
+		ar2 := newVolumeAt(date)
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.Write(f, binary.BigEndian, ar2.VolumeHeader)
+		f.Close()
+		volumes[filepath.Join(dir, name)] = ar2
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not radar data\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Dir = dir
+	s := New(opts)
+	s.open = func(path string) (*archive2.Archive2, error) {
+		return volumes[path], nil
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func get(t *testing.T, h http.Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+var testDate = time.Date(2013, 5, 20, 20, 3, 56, 0, time.UTC)
+
+func TestVolumes(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate.Add(5*time.Minute), testDate)
+	defer cleanup()
+
+	w := get(t, s, "/volumes")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	volumes := decodeVolumes(t, w)
+	if len(volumes) != 2 {
+		t.Fatalf("volumes = %v", volumes)
+	}
+	if volumes[0].File != "KTLX20130520_200356_V06" || volumes[0].Station != "KTLX" || !volumes[0].Time.Equal(testDate) {
+		t.Errorf("volume = %+v", volumes[0])
+	}
+
+	if n := len(decodeVolumes(t, get(t, s, "/volumes?station=KFWS"))); n != 0 {
+		t.Errorf("%d volumes of KFWS", n)
+	}
+}
+
+func decodeVolumes(t *testing.T, w *httptest.ResponseRecorder) []volumeInfo {
+	volumes := []volumeInfo{}
+	if err := json.NewDecoder(w.Body).Decode(&volumes); err != nil {
+		t.Fatal(err)
+	}
+	return volumes
+}
+
+func TestVolume(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	w := get(t, s, "/volumes/KTLX20130520_200356_V06")
+	var d volumeDetail
+	if err := json.NewDecoder(w.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Station != "KTLX" || d.Lat != float64(float32(radarLat)) || len(d.Elevations) != 1 {
+		t.Fatalf("volume = %+v", d)
+	}
+	if e := d.Elevations[0]; e.Number != 1 || e.Angle != 0.5 || len(e.Moments) != 1 || e.Moments[0] != "REF" {
+		t.Errorf("elevation = %+v", e)
+	}
+
+	for _, url := range []string{"/volumes/README", "/volumes/missing", "/volumes/.hidden", "/volumes/KTLX20130520_200356_V06/ref/9.png", "/volumes/KTLX20130520_200356_V06/xyz/1.png"} {
+		if w := get(t, s, url); w.Code != http.StatusNotFound {
+			t.Errorf("%s status %d", url, w.Code)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	w := get(t, s, "/volumes/KTLX20130520_200356_V06/ref/1.png?size=92&scheme=scope")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 92 {
+		t.Errorf("size %v", img.Bounds())
+	}
+	// 10 km per pixel, gates reach 50 km
+	if _, _, _, a := img.At(46, 46).RGBA(); a == 0 {
+		t.Error("no data drawn at the radar")
+	}
+	if _, _, _, a := img.At(46, 30).RGBA(); a != 0 {
+		t.Error("data drawn beyond the last gate")
+	}
+
+	if w := get(t, s, "/volumes/KTLX20130520_200356_V06/ref/1.png?scheme=nope"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown scheme status %d", w.Code)
+	}
+}
+
+func TestTile(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	tile := tiles.At(8, radarLat, radarLon)
+	w := get(t, s, "/tiles/KTLX20130520_200356_V06/ref/1/"+tile.String()+".png")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var colored int
+	for y := 0; y < tiles.Size; y++ {
+		for x := 0; x < tiles.Size; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				colored++
+			}
+		}
+	}
+	if colored == 0 {
+		t.Error("tile holding the radar is empty")
+	}
+
+	if w := get(t, s, "/tiles/KTLX20130520_200356_V06/ref/1/8/256/0.png"); w.Code != http.StatusNotFound {
+		t.Errorf("tile outside the world status %d", w.Code)
+	}
+}
+
+func TestPoint(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	lat, lon := archive2.Destination(radarLat, radarLon, 90, 20000)
+	w := get(t, s, "/volumes/KTLX20130520_200356_V06/point?elevation=1&lat="+ftoa(lat)+"&lon="+ftoa(lon))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var p pointResult
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Values["ref"] == nil || *p.Values["ref"] != 20 {
+		t.Errorf("ref = %v", p.Values["ref"])
+	}
+	if v, ok := p.Values["vel"]; !ok || v != nil {
+		t.Errorf("vel = %v, %v", v, ok)
+	}
+	if p.Azimuth < 89.9 || p.Azimuth > 90.1 || p.GroundRange < 19990 || p.GroundRange > 20010 {
+		t.Errorf("azimuth %f range %f", p.Azimuth, p.GroundRange)
+	}
+
+	if w := get(t, s, "/volumes/KTLX20130520_200356_V06/point?elevation=1&lat=1"); w.Code != http.StatusBadRequest {
+		t.Errorf("missing lon status %d", w.Code)
+	}
+}
+
+func ftoa(f float64) string {
+	b, _ := json.Marshal(f)
+	return string(b)
+}
+
+func TestViewer(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	w := get(t, s, "/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<script src="viewer.js">`) {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	for _, f := range []string{"/viewer.js", "/viewer.css"} {
+		if w := get(t, s, f); w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s status %d", f, w.Code)
+		}
+	}
+
+	var d volumeDetail
+	if err := json.NewDecoder(get(t, s, "/volumes/KTLX20130520_200356_V06").Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if schemes := d.Schemes["ref"]; len(schemes) != 5 || schemes[0] != "noaa" {
+		t.Errorf("ref schemes = %v", schemes)
+	}
+}
+
+func TestTruncatedVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a volume header and the size of an LDM record that is missing
+	f, err := os.Create(filepath.Join(dir, "KTLX20130520_200356_V06"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.Write(f, binary.BigEndian, volumeHeader(testDate))
+	binary.Write(f, binary.BigEndian, int32(1000))
+	f.Close()
+
+	opts := DefaultOptions()
+	opts.Dir = dir
+	s := New(opts)
+	for _, url := range []string{"/volumes/KTLX20130520_200356_V06", "/volumes/KTLX20130520_200356_V06/ref/1.png"} {
+		if w := get(t, s, url); w.Code < 400 {
+			t.Errorf("%s status %d", url, w.Code)
+		}
+	}
+	if n := s.cache.len(); n != 0 {
+		t.Errorf("%d volumes cached", n)
+	}
+}
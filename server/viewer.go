@@ -0,0 +1,23 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// viewerFiles are the static files of the web viewer, a map of the tiles of
+// the server with controls to pick the volume, product, elevation and color
+// scheme and a readout of the gate values under the cursor.
+//
+//go:embed viewer
+var viewerFiles embed.FS
+
+// viewer returns the handler serving the web viewer.
+func viewer() http.Handler {
+	root, err := fs.Sub(viewerFiles, "viewer")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
@@ -0,0 +1,557 @@
+// NEXRAD viewer: a slippy map of the tiles of the server with the volumes of
+// a station on a time slider and the gate values under the cursor.
+'use strict';
+
+(function () {
+  const TILE = 256;
+  const MAX_LATITUDE = 85.0511287798066;
+  const EARTH_RADIUS = 6371000;
+  // distance in meters from the radar covered by the tiles
+  const EXTENT = 460000;
+  const MIN_ZOOM = 3;
+  const MAX_ZOOM = 14;
+  const BASE_MOMENTS = ['ref', 'vel', 'sw', 'zdr', 'phi', 'rho', 'cfp'];
+  const UNITS = {
+    ref: 'dBZ', vel: 'm/s', srm: 'm/s', sw: 'm/s', zdr: 'dB', phi: '°',
+    rho: '', cfp: 'dB', kdp: '°/km', hca: '', rr: 'mm/h',
+  };
+
+  const el = {};
+  for (const id of ['station', 'product', 'elevation', 'scheme', 'play', 'time', 'time-label',
+    'map', 'zoom-in', 'zoom-out', 'readout', 'status']) {
+    el[id] = document.getElementById(id);
+  }
+  const ctx = el.map.getContext('2d');
+
+  const state = {
+    volumes: [],
+    station: '',
+    index: 0,
+    detail: null,
+    product: 'ref',
+    elevation: 1,
+    scheme: 'noaa',
+    lat: 39,
+    lon: -96,
+    zoom: 4,
+  };
+
+  // decoded volume details by file and tile images by url, in load order
+  const details = new Map();
+  const images = new Map();
+  // the last loaded image drawn for every tile, shown until the tile of a new
+  // time or product arrives so scrubbing does not flicker
+  const shown = new Map();
+
+  function getJSON(url) {
+    return fetch(url).then((r) => {
+      if (!r.ok) {
+        throw new Error(`${r.status} ${url}`);
+      }
+      return r.json();
+    });
+  }
+
+  function status(msg) {
+    el.status.textContent = msg || '';
+  }
+
+  // ----------------------------------------------------------- projection
+
+  function clamp(v, min, max) {
+    return Math.max(min, Math.min(max, v));
+  }
+
+  // project returns the Web Mercator pixel coordinates of lat, lon at zoom z.
+  function project(lat, lon, z) {
+    const n = TILE * 2 ** z;
+    const φ = clamp(lat, -MAX_LATITUDE, MAX_LATITUDE) * Math.PI / 180;
+    return [(lon + 180) / 360 * n, (1 - Math.log(Math.tan(φ) + 1 / Math.cos(φ)) / Math.PI) / 2 * n];
+  }
+
+  function unproject(x, y, z) {
+    const n = TILE * 2 ** z;
+    return [Math.atan(Math.sinh(Math.PI * (1 - 2 * y / n))) * 180 / Math.PI, x / n * 360 - 180];
+  }
+
+  // destination returns the location distance meters from lat, lon along the
+  // bearing in degrees.
+  function destination(lat, lon, bearing, distance) {
+    const φ1 = lat * Math.PI / 180;
+    const λ1 = lon * Math.PI / 180;
+    const θ = bearing * Math.PI / 180;
+    const δ = distance / EARTH_RADIUS;
+    const φ2 = Math.asin(Math.sin(φ1) * Math.cos(δ) + Math.cos(φ1) * Math.sin(δ) * Math.cos(θ));
+    const λ2 = λ1 + Math.atan2(Math.sin(θ) * Math.sin(δ) * Math.cos(φ1), Math.cos(δ) - Math.sin(φ1) * Math.sin(φ2));
+    return [φ2 * 180 / Math.PI, λ2 * 180 / Math.PI];
+  }
+
+  function distance(lat1, lon1, lat2, lon2) {
+    const φ1 = lat1 * Math.PI / 180;
+    const φ2 = lat2 * Math.PI / 180;
+    const dφ = φ2 - φ1;
+    const dλ = (lon2 - lon1) * Math.PI / 180;
+    const a = Math.sin(dφ / 2) ** 2 + Math.cos(φ1) * Math.cos(φ2) * Math.sin(dλ / 2) ** 2;
+    return 2 * EARTH_RADIUS * Math.asin(Math.sqrt(a));
+  }
+
+  // view returns the size of the map and the pixel coordinates of its top
+  // left corner.
+  function view() {
+    const w = el.map.clientWidth;
+    const h = el.map.clientHeight;
+    const [cx, cy] = project(state.lat, state.lon, state.zoom);
+    return { w, h, left: cx - w / 2, top: cy - h / 2 };
+  }
+
+  function screenToLatLon(sx, sy) {
+    const v = view();
+    return unproject(v.left + sx, v.top + sy, state.zoom);
+  }
+
+  // ------------------------------------------------------------- drawing
+
+  let pending = false;
+
+  function redraw() {
+    if (!pending) {
+      pending = true;
+      requestAnimationFrame(draw);
+    }
+  }
+
+  function file() {
+    const volumes = stationVolumes();
+    return volumes.length ? volumes[state.index].file : '';
+  }
+
+  function tileURL(z, x, y) {
+    const f = encodeURIComponent(file());
+    return `tiles/${f}/${state.product}/${state.elevation}/${z}/${x}/${y}.png?scheme=${encodeURIComponent(state.scheme)}`;
+  }
+
+  function image(url) {
+    let img = images.get(url);
+    if (img) {
+      return img;
+    }
+    img = new Image();
+    img.onload = redraw;
+    img.src = url;
+    images.set(url, img);
+    // forget the oldest images
+    if (images.size > 600) {
+      images.delete(images.keys().next().value);
+    }
+    return img;
+  }
+
+  function draw() {
+    pending = false;
+    const dpr = window.devicePixelRatio || 1;
+    const v = view();
+    if (el.map.width !== Math.round(v.w * dpr) || el.map.height !== Math.round(v.h * dpr)) {
+      el.map.width = Math.round(v.w * dpr);
+      el.map.height = Math.round(v.h * dpr);
+    }
+    ctx.setTransform(dpr, 0, 0, dpr, 0, 0);
+    ctx.fillStyle = '#111';
+    ctx.fillRect(0, 0, v.w, v.h);
+
+    drawGraticule(v);
+    if (state.detail) {
+      drawTiles(v);
+      drawRadar(v);
+    }
+  }
+
+  function drawTiles(v) {
+    const z = state.zoom;
+    const n = 2 ** z;
+    const d = state.detail;
+
+    // only the tiles around the radar hold data
+    const north = destination(d.lat, d.lon, 0, EXTENT)[0];
+    const south = destination(d.lat, d.lon, 180, EXTENT)[0];
+    const dlon = Math.asin(Math.min(1, Math.sin(EXTENT / EARTH_RADIUS) / Math.cos(d.lat * Math.PI / 180))) * 180 / Math.PI;
+    const [x0, y0] = project(north, d.lon - dlon, z);
+    const [x1, y1] = project(south, d.lon + dlon, z);
+
+    const minX = Math.max(Math.floor(v.left / TILE), Math.floor(x0 / TILE));
+    const maxX = Math.min(Math.floor((v.left + v.w) / TILE), Math.floor(x1 / TILE));
+    const minY = Math.max(Math.floor(v.top / TILE), Math.floor(y0 / TILE), 0);
+    const maxY = Math.min(Math.floor((v.top + v.h) / TILE), Math.floor(y1 / TILE), n - 1);
+    let loading = 0;
+    for (let y = minY; y <= maxY; y++) {
+      for (let x = minX; x <= maxX; x++) {
+        const key = `${z}/${x}/${y}`;
+        const img = image(tileURL(z, x, y));
+        let drawn = shown.get(key);
+        if (img.complete && img.naturalWidth) {
+          shown.set(key, img);
+          drawn = img;
+        } else {
+          loading++;
+        }
+        if (drawn) {
+          ctx.drawImage(drawn, Math.round(x * TILE - v.left), Math.round(y * TILE - v.top));
+        }
+      }
+    }
+    status(loading ? `loading ${loading} tiles` : '');
+  }
+
+  // graticuleStep returns the spacing in degrees of the graticule at a zoom.
+  function graticuleStep(z) {
+    if (z < 4) return 20;
+    if (z < 6) return 5;
+    if (z < 8) return 2;
+    if (z < 10) return 1;
+    if (z < 12) return 0.25;
+    return 0.05;
+  }
+
+  function drawGraticule(v) {
+    const step = graticuleStep(state.zoom);
+    const [north, west] = unproject(v.left, v.top, state.zoom);
+    const [south, east] = unproject(v.left + v.w, v.top + v.h, state.zoom);
+    ctx.strokeStyle = 'rgba(255, 255, 255, 0.1)';
+    ctx.fillStyle = 'rgba(255, 255, 255, 0.35)';
+    ctx.lineWidth = 1;
+    ctx.font = '11px system-ui, sans-serif';
+    ctx.beginPath();
+    for (let lon = Math.ceil(west / step) * step; lon <= east; lon += step) {
+      const x = Math.round(project(0, lon, state.zoom)[0] - v.left) + 0.5;
+      ctx.moveTo(x, 0);
+      ctx.lineTo(x, v.h);
+      ctx.fillText(`${+lon.toFixed(2)}°`, x + 3, v.h - 4);
+    }
+    for (let lat = Math.ceil(south / step) * step; lat <= north; lat += step) {
+      const y = Math.round(project(lat, 0, state.zoom)[1] - v.top) + 0.5;
+      ctx.moveTo(0, y);
+      ctx.lineTo(v.w, y);
+      ctx.fillText(`${+lat.toFixed(2)}°`, v.w - 44, y - 3);
+    }
+    ctx.stroke();
+  }
+
+  // drawRadar draws range rings every 50 km around the radar.
+  function drawRadar(v) {
+    const d = state.detail;
+    ctx.strokeStyle = 'rgba(255, 255, 255, 0.25)';
+    ctx.lineWidth = 1;
+    for (let r = 50000; r <= EXTENT; r += 50000) {
+      ctx.beginPath();
+      for (let az = 0; az <= 360; az += 3) {
+        const [lat, lon] = destination(d.lat, d.lon, az, r);
+        const [x, y] = project(lat, lon, state.zoom);
+        if (az === 0) {
+          ctx.moveTo(x - v.left, y - v.top);
+        } else {
+          ctx.lineTo(x - v.left, y - v.top);
+        }
+      }
+      ctx.stroke();
+    }
+    const [x, y] = project(d.lat, d.lon, state.zoom);
+    ctx.fillStyle = '#fff';
+    ctx.fillRect(x - v.left - 2, y - v.top - 2, 4, 4);
+    ctx.font = 'bold 12px system-ui, sans-serif';
+    ctx.fillText(d.station, x - v.left + 6, y - v.top - 6);
+  }
+
+  // ---------------------------------------------------------- volumes
+
+  function stationVolumes() {
+    return state.volumes.filter((v) => v.station === state.station);
+  }
+
+  function formatTime(t) {
+    return t.replace('T', ' ');
+  }
+
+  function detail(f) {
+    if (!details.has(f)) {
+      const p = getJSON(`volumes/${encodeURIComponent(f)}`);
+      p.catch(() => details.delete(f));
+      details.set(f, p);
+    }
+    return details.get(f);
+  }
+
+  function options(select, values, labels, selected) {
+    select.innerHTML = '';
+    values.forEach((value, i) => {
+      const o = document.createElement('option');
+      o.value = value;
+      o.textContent = labels ? labels[i] : value;
+      select.appendChild(o);
+    });
+    select.value = selected;
+  }
+
+  // loadVolume shows the volume selected on the time slider.
+  function loadVolume() {
+    const volumes = stationVolumes();
+    el.time.max = Math.max(0, volumes.length - 1);
+    el.time.value = state.index;
+    if (!volumes.length) {
+      state.detail = null;
+      el['time-label'].textContent = 'no volumes';
+      redraw();
+      return Promise.resolve();
+    }
+    const v = volumes[state.index];
+    el['time-label'].textContent = formatTime(v.time);
+    status(`decoding ${v.file}`);
+    return detail(v.file).then((d) => {
+      if (file() !== v.file) {
+        return;
+      }
+      const first = !state.detail || state.detail.station !== d.station;
+      state.detail = d;
+
+      if (!d.products.includes(state.product)) {
+        state.product = d.products[0];
+      }
+      options(el.product, d.products, d.products.map((p) => p.toUpperCase()), state.product);
+
+      const numbers = d.elevations.map((e) => e.number);
+      if (!numbers.includes(state.elevation)) {
+        state.elevation = numbers[0];
+      }
+      options(el.elevation, numbers, d.elevations.map((e) => `${e.number} (${e.angle.toFixed(1)}°)`), state.elevation);
+      updateSchemes();
+
+      if (first) {
+        state.lat = d.lat;
+        state.lon = d.lon;
+        state.zoom = 7;
+      }
+      status('');
+      redraw();
+    }).catch((err) => status(err.message));
+  }
+
+  function updateSchemes() {
+    const schemes = state.detail.schemes[state.product] || ['noaa'];
+    if (!schemes.includes(state.scheme)) {
+      state.scheme = schemes[0];
+    }
+    options(el.scheme, schemes, null, state.scheme);
+  }
+
+  // loadVolumes refreshes the list of volumes, following the latest volume of
+  // the station when it is shown.
+  function loadVolumes() {
+    return getJSON('volumes').then((volumes) => {
+      const current = file();
+      const latest = state.index === stationVolumes().length - 1;
+      state.volumes = volumes;
+
+      const stations = [...new Set(volumes.map((v) => v.station))].sort();
+      if (!stations.includes(state.station)) {
+        state.station = stations[0] || '';
+      }
+      options(el.station, stations, null, state.station);
+
+      const files = stationVolumes().map((v) => v.file);
+      state.index = files.indexOf(current);
+      if (state.index < 0 || latest) {
+        state.index = Math.max(0, files.length - 1);
+      }
+      if (file() !== current) {
+        return loadVolume();
+      }
+      el.time.max = Math.max(0, files.length - 1);
+      el.time.value = state.index;
+      return undefined;
+    }).catch((err) => status(err.message));
+  }
+
+  // ------------------------------------------------------------ readout
+
+  let readoutTimer = 0;
+  let readoutRequest = 0;
+
+  function formatValue(name, v, labels) {
+    if (v === null || v === undefined) {
+      return '—';
+    }
+    if (labels && labels[name]) {
+      return labels[name];
+    }
+    const digits = name === 'rho' ? 3 : 1;
+    return `${v.toFixed(digits)} ${UNITS[name] || ''}`.trim();
+  }
+
+  function readout(sx, sy) {
+    clearTimeout(readoutTimer);
+    const d = state.detail;
+    if (!d) {
+      return;
+    }
+    const [lat, lon] = screenToLatLon(sx, sy);
+    if (distance(d.lat, d.lon, lat, lon) > EXTENT) {
+      el.readout.hidden = true;
+      return;
+    }
+    readoutTimer = setTimeout(() => {
+      const request = ++readoutRequest;
+      const names = [state.product, ...BASE_MOMENTS.filter((m) => m !== state.product)];
+      const url = `volumes/${encodeURIComponent(file())}/point?elevation=${state.elevation}` +
+        `&lat=${lat.toFixed(5)}&lon=${lon.toFixed(5)}&products=${names.join(',')}`;
+      getJSON(url).then((p) => {
+        if (request !== readoutRequest) {
+          return;
+        }
+        const rows = names.map((name) => {
+          const cls = name === state.product ? ' class="selected"' : '';
+          return `<tr${cls}><td>${name.toUpperCase()}</td><td>${formatValue(name, p.values[name], p.labels)}</td></tr>`;
+        });
+        el.readout.innerHTML =
+          `<div>${p.lat.toFixed(4)}, ${p.lon.toFixed(4)}</div>` +
+          `<div>${p.azimuth.toFixed(1)}° ${(p.ground_range / 1000).toFixed(1)} km, ${(p.height / 1000).toFixed(2)} km MSL</div>` +
+          `<table>${rows.join('')}</table>`;
+        el.readout.hidden = false;
+      }).catch(() => {
+        el.readout.hidden = true;
+      });
+    }, 120);
+  }
+
+  // -------------------------------------------------------- interaction
+
+  function zoomAt(zoom, sx, sy) {
+    zoom = clamp(zoom, MIN_ZOOM, MAX_ZOOM);
+    if (zoom === state.zoom) {
+      return;
+    }
+    // keep the location under the cursor in place
+    const [lat, lon] = screenToLatLon(sx, sy);
+    const [px, py] = project(lat, lon, zoom);
+    const v = view();
+    const [cx, cy] = [px - sx + v.w / 2, py - sy + v.h / 2];
+    state.zoom = zoom;
+    [state.lat, state.lon] = unproject(cx, cy, zoom);
+    redraw();
+  }
+
+  let drag = null;
+
+  el.map.addEventListener('pointerdown', (e) => {
+    const [cx, cy] = project(state.lat, state.lon, state.zoom);
+    drag = { x: e.clientX, y: e.clientY, cx, cy };
+    el.map.setPointerCapture(e.pointerId);
+    el.map.classList.add('dragging');
+  });
+
+  el.map.addEventListener('pointermove', (e) => {
+    const rect = el.map.getBoundingClientRect();
+    if (drag) {
+      const n = TILE * 2 ** state.zoom;
+      const cy = clamp(drag.cy - (e.clientY - drag.y), 0, n);
+      [state.lat, state.lon] = unproject(drag.cx - (e.clientX - drag.x), cy, state.zoom);
+      state.lon = ((state.lon + 540) % 360) - 180;
+      redraw();
+      return;
+    }
+    readout(e.clientX - rect.left, e.clientY - rect.top);
+  });
+
+  function endDrag() {
+    drag = null;
+    el.map.classList.remove('dragging');
+  }
+  el.map.addEventListener('pointerup', endDrag);
+  el.map.addEventListener('pointercancel', endDrag);
+  el.map.addEventListener('pointerleave', () => {
+    clearTimeout(readoutTimer);
+    readoutRequest++;
+    el.readout.hidden = true;
+  });
+
+  el.map.addEventListener('wheel', (e) => {
+    e.preventDefault();
+    const rect = el.map.getBoundingClientRect();
+    zoomAt(state.zoom + (e.deltaY < 0 ? 1 : -1), e.clientX - rect.left, e.clientY - rect.top);
+  }, { passive: false });
+
+  el.map.addEventListener('dblclick', (e) => {
+    const rect = el.map.getBoundingClientRect();
+    zoomAt(state.zoom + 1, e.clientX - rect.left, e.clientY - rect.top);
+  });
+
+  el['zoom-in'].addEventListener('click', () => zoomAt(state.zoom + 1, el.map.clientWidth / 2, el.map.clientHeight / 2));
+  el['zoom-out'].addEventListener('click', () => zoomAt(state.zoom - 1, el.map.clientWidth / 2, el.map.clientHeight / 2));
+
+  el.station.addEventListener('change', () => {
+    state.station = el.station.value;
+    state.index = Math.max(0, stationVolumes().length - 1);
+    loadVolume();
+  });
+
+  el.product.addEventListener('change', () => {
+    state.product = el.product.value;
+    updateSchemes();
+    redraw();
+  });
+
+  el.elevation.addEventListener('change', () => {
+    state.elevation = +el.elevation.value;
+    redraw();
+  });
+
+  el.scheme.addEventListener('change', () => {
+    state.scheme = el.scheme.value;
+    redraw();
+  });
+
+  function step(n) {
+    const count = stationVolumes().length;
+    if (count) {
+      state.index = (state.index + n + count) % count;
+      loadVolume();
+    }
+  }
+
+  el.time.addEventListener('input', () => {
+    state.index = +el.time.value;
+    loadVolume();
+  });
+
+  let playing = 0;
+
+  function togglePlay() {
+    if (playing) {
+      clearInterval(playing);
+      playing = 0;
+      el.play.innerHTML = '&#9654;';
+      return;
+    }
+    playing = setInterval(() => step(1), 700);
+    el.play.innerHTML = '&#10074;&#10074;';
+  }
+
+  el.play.addEventListener('click', togglePlay);
+
+  document.addEventListener('keydown', (e) => {
+    if (e.target.tagName === 'SELECT') {
+      return;
+    }
+    if (e.key === 'ArrowLeft') {
+      step(-1);
+    } else if (e.key === 'ArrowRight') {
+      step(1);
+    } else if (e.key === ' ') {
+      e.preventDefault();
+      togglePlay();
+    }
+  });
+
+  window.addEventListener('resize', redraw);
+
+  loadVolumes().then(redraw);
+  // pick up new files written to the directory
+  setInterval(loadVolumes, 60000);
+}());
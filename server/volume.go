@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/colorscheme"
+	"github.com/bwiggs/go-nexrad/products"
+)
+
+// Products are the products served, computed with their default options.
+var Products = []string{"ref", "vel", "srm", "sw", "zdr", "phi", "rho", "cfp", "kdp", "hca", "rr"}
+
+// productMoments maps products to the data moment their gates are aligned with.
+var productMoments = map[string]string{
+	"ref": "REF",
+	"vel": "VEL",
+	"srm": "VEL",
+	"sw":  "SW",
+	"zdr": "ZDR",
+	"phi": "PHI",
+	"rho": "RHO",
+	"cfp": "CFP",
+	"kdp": "PHI",
+	"hca": "REF",
+	"rr":  "REF",
+}
+
+// colorFunc returns the color function of a product in the named scheme, the
+// "noaa" scheme when scheme is empty.
+func colorFunc(product, scheme string) (func(float32) color.Color, bool) {
+	if scheme == "" {
+		scheme = "noaa"
+	}
+	fn, ok := colorscheme.Schemes[product][scheme]
+	return fn, ok
+}
+
+// schemeNames returns the color schemes of a product, "noaa" first.
+func schemeNames(product string) []string {
+	names := []string{"noaa"}
+	for name := range colorscheme.Schemes[product] {
+		if name != "noaa" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// volume is a decoded archive 2 file with the products computed from it so far.
+type volume struct {
+	ar2 *archive2.Archive2
+	// Lat and Lon of the radar, taken from the first radial.
+	lat, lon float64
+
+	mu       sync.Mutex
+	samplers map[string]*samplerEntry
+
+	hcaOnce sync.Once
+	hca     products.HCAOptions
+}
+
+// samplerEntry is a product of an elevation scan, ready is closed once it has
+// been computed.
+type samplerEntry struct {
+	ready   chan struct{}
+	sampler *products.SweepSampler
+}
+
+// openVolume decodes the archive 2 file at path.
+func openVolume(path string) (ar2 *archive2.Archive2, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// corrupt files can still cause runtime errors in the decoder
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to decode %s: %v", path, r)
+		}
+	}()
+	if ar2, err = archive2.ExtractErr(f); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %s", path, err)
+	}
+	return ar2, nil
+}
+
+func newVolume(ar2 *archive2.Archive2) *volume {
+	v := &volume{ar2: ar2, samplers: map[string]*samplerEntry{}}
+	for _, elv := range ar2.Elevations() {
+		if radials := ar2.ElevationScans[elv]; len(radials) > 0 {
+			v.lat, v.lon = float64(radials[0].VolumeData.Lat), float64(radials[0].VolumeData.Long)
+			break
+		}
+	}
+	return v
+}
+
+// sampler returns the sampler of a product of an elevation scan held by the
+// volume, computing the product the first time it is asked for. Products are
+// computed once however many requests ask for them at the same time, without
+// holding up requests for other products.
+func (v *volume) sampler(product string, elevation int) *products.SweepSampler {
+	key := fmt.Sprintf("%s/%d", product, elevation)
+	v.mu.Lock()
+	e, ok := v.samplers[key]
+	if !ok {
+		e = &samplerEntry{ready: make(chan struct{})}
+		v.samplers[key] = e
+	}
+	v.mu.Unlock()
+	if ok {
+		<-e.ready
+		return e.sampler
+	}
+
+	defer close(e.ready)
+	radials := v.ar2.ElevationScans[elevation]
+	e.sampler = products.NewSweepSampler(radials, v.sweepGates(product, radials), productMoments[product])
+	return e.sampler
+}
+
+// sweepGates returns the values of a product for every radial of a sweep.
+func (v *volume) sweepGates(product string, radials []*archive2.Message31) [][]float32 {
+	switch product {
+	case "srm":
+		sm, ok := products.EstimateStormMotion(radials)
+		if !ok {
+			break
+		}
+		return products.StormRelativeMotion(radials, sm)
+	case "kdp":
+		return products.KDPSweep(radials, products.DefaultKDPOptions())
+	case "hca":
+		v.hcaOnce.Do(func() {
+			v.hca = products.DefaultHCAOptions()
+			d := products.DetectMeltingLayer(v.ar2, products.DefaultMeltingLayerOptions())
+			v.hca.MeltingLayerDetection = &d
+		})
+		return products.HCASweep(radials, v.hca)
+	case "rr":
+		gates := make([][]float32, len(radials))
+		for i, radial := range radials {
+			gates[i] = products.RainRate(radial, products.DefaultQPEOptions())
+		}
+		return gates
+	}
+
+	// base moments, and ground relative velocity when no storm motion could
+	// be estimated
+	gates := make([][]float32, len(radials))
+	for i, radial := range radials {
+		if m := radial.Moment(productMoments[product]); m != nil {
+			gates[i] = m.ScaledData()
+		}
+	}
+	return gates
+}
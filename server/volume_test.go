@@ -0,0 +1,39 @@
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bwiggs/go-nexrad/products"
+)
+
+func TestVolumeSamplerConcurrent(t *testing.T) {
+	v := newVolume(newVolumeAt(testDate))
+
+	var wg sync.WaitGroup
+	got := make([]*products.SweepSampler, 16)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			product := "ref"
+			if i%2 == 1 {
+				product = "hca"
+			}
+			got[i] = v.sampler(product, 1)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range got {
+		if got[i] == nil || got[i] != got[i%2] {
+			t.Fatalf("sampler %d = %p, want %p", i, got[i], got[i%2])
+		}
+	}
+	if got[0] == got[1] {
+		t.Error("ref and hca share a sampler")
+	}
+	if len(v.samplers) != 2 {
+		t.Errorf("%d samplers computed", len(v.samplers))
+	}
+}
@@ -0,0 +1,507 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+	"github.com/bwiggs/go-nexrad/products"
+	"github.com/bwiggs/go-nexrad/tiles"
+	"github.com/sirupsen/logrus"
+)
+
+// The server is also a WMS 1.3.0 server at /wms for GIS applications such as
+// QGIS and ArcGIS. Every product of every elevation number is a layer named
+// {product}_{elevation}, ref_1 for the lowest reflectivity sweep, with the
+// color schemes as styles. The layers share a time dimension of the times of
+// the volumes. A map shows the volume of every station nearest to the time,
+// the latest when no time is given. Stations whose volume can not be decoded
+// are left out.
+
+// maxMapSize limits the width and height of maps in pixels.
+const maxMapSize = 4096
+
+// earthRadius of the Web Mercator projection in meters.
+const earthRadius = 6378137
+
+// productTitles are the titles of the layers of the products.
+var productTitles = map[string]string{
+	"ref": "Reflectivity",
+	"vel": "Velocity",
+	"srm": "Storm Relative Velocity",
+	"sw":  "Spectrum Width",
+	"zdr": "Differential Reflectivity",
+	"phi": "Differential Phase",
+	"rho": "Correlation Coefficient",
+	"cfp": "Clutter Filter Power Removed",
+	"kdp": "Specific Differential Phase",
+	"hca": "Hydrometeor Classification",
+	"rr":  "Rain Rate",
+}
+
+// wmsCRS are the coordinate reference systems of maps.
+var wmsCRS = []string{"CRS:84", "EPSG:4326", "EPSG:3857"}
+
+// wmsException is a service exception reported to the client.
+type wmsException struct {
+	code, message string
+}
+
+func (e *wmsException) Error() string {
+	return e.message
+}
+
+func (s *Server) handleWMS(w http.ResponseWriter, r *http.Request) {
+	// parameter names are case insensitive
+	params := map[string]string{}
+	for k, v := range r.URL.Query() {
+		params[strings.ToUpper(k)] = v[0]
+	}
+
+	var err error
+	switch req := params["REQUEST"]; {
+	case params["SERVICE"] != "" && !strings.EqualFold(params["SERVICE"], "WMS"):
+		err = &wmsException{"", "SERVICE must be WMS"}
+	case strings.EqualFold(req, "GetCapabilities"):
+		err = s.wmsCapabilities(w, r)
+	case strings.EqualFold(req, "GetMap"):
+		if v := params["VERSION"]; v != "" && v != "1.3.0" {
+			err = &wmsException{"", fmt.Sprintf("unsupported version %s, 1.3.0", v)}
+			break
+		}
+		err = s.wmsMap(w, params)
+	default:
+		err = &wmsException{"OperationNotSupported", fmt.Sprintf("unsupported request %q, GetCapabilities or GetMap", req)}
+	}
+	if err == nil {
+		return
+	}
+	e, ok := err.(*wmsException)
+	if !ok {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<ServiceExceptionReport version=\"1.3.0\" xmlns=\"http://www.opengis.net/ogc\">\n  <ServiceException")
+	if e.code != "" {
+		fmt.Fprintf(w, " code=%q", e.code)
+	}
+	fmt.Fprintf(w, ">%s</ServiceException>\n</ServiceExceptionReport>\n", escapeXML(e.message))
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// layerSummary is what the capabilities need of a volume: the location of
+// the radar and the elevation numbers holding radials.
+type layerSummary struct {
+	lat, lon   float64
+	elevations []int
+}
+
+// latest returns the summary of the most recent volume of every station.
+// Summaries are kept for as long as the volume is the latest of its station,
+// so only new volumes are decoded, not every volume on every request.
+func (s *Server) latest(volumes []volumeInfo) []layerSummary {
+	latest := map[string]volumeInfo{}
+	for _, v := range volumes {
+		latest[v.Station] = v
+	}
+
+	s.layersMu.Lock()
+	defer s.layersMu.Unlock()
+	layers := map[string]layerSummary{}
+	summaries := []layerSummary{}
+	for _, v := range latest {
+		l, ok := s.layers[v.File]
+		if !ok {
+			vol, err := s.volume(v.File)
+			if err != nil {
+				continue
+			}
+			l = layerSummary{lat: vol.lat, lon: vol.lon}
+			for _, elv := range vol.ar2.Elevations() {
+				if len(vol.ar2.ElevationScans[elv]) > 0 {
+					l.elevations = append(l.elevations, elv)
+				}
+			}
+		}
+		layers[v.File] = l
+		summaries = append(summaries, l)
+	}
+	s.layers = layers
+	return summaries
+}
+
+// nearest returns the volume of every station nearest to t.
+func nearest(volumes []volumeInfo, t time.Time) []volumeInfo {
+	best := map[string]volumeInfo{}
+	for _, v := range volumes {
+		b, ok := best[v.Station]
+		if !ok || math.Abs(v.Time.Sub(t).Seconds()) < math.Abs(b.Time.Sub(t).Seconds()) {
+			best[v.Station] = v
+		}
+	}
+	stations := []string{}
+	for station := range best {
+		stations = append(stations, station)
+	}
+	sort.Strings(stations)
+	nearest := []volumeInfo{}
+	for _, station := range stations {
+		nearest = append(nearest, best[station])
+	}
+	return nearest
+}
+
+// radarBounds returns the bounds in degrees of the circle reaching Extent
+// meters from a radar.
+func radarBounds(lat, lon float64) (west, south, east, north float64) {
+	δ := Extent / 6371000.0
+	north = math.Min(90, lat+δ*180/math.Pi)
+	south = math.Max(-90, lat-δ*180/math.Pi)
+	dlon := 180.0
+	if s := math.Sin(δ) / math.Cos(lat*math.Pi/180); s < 1 {
+		dlon = math.Asin(s) * 180 / math.Pi
+	}
+	return lon - dlon, south, lon + dlon, north
+}
+
+// mercator returns the Web Mercator coordinates in meters of lat, lon.
+func mercator(lat, lon float64) (x, y float64) {
+	lat = math.Max(-tiles.MaxLatitude, math.Min(tiles.MaxLatitude, lat))
+	φ := lat * math.Pi / 180
+	return earthRadius * lon * math.Pi / 180, earthRadius * math.Log(math.Tan(math.Pi/4+φ/2))
+}
+
+// fromMercator returns the latitude and longitude of Web Mercator coordinates.
+func fromMercator(x, y float64) (lat, lon float64) {
+	return math.Atan(math.Sinh(y/earthRadius)) * 180 / math.Pi, x / earthRadius * 180 / math.Pi
+}
+
+type capabilitiesLayer struct {
+	Name, Title string
+	Styles      []string
+}
+
+type capabilities struct {
+	URL                      string
+	West, South, East, North float64
+	MinX, MinY, MaxX, MaxY   float64
+	Times                    []string
+	Default                  string
+	Layers                   []capabilitiesLayer
+}
+
+var capabilitiesTemplate = template.Must(template.New("capabilities").Funcs(template.FuncMap{"xml": escapeXML}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<WMS_Capabilities version="1.3.0" xmlns="http://www.opengis.net/wms" xmlns:xlink="http://www.w3.org/1999/xlink">
+  <Service>
+    <Name>WMS</Name>
+    <Title>NEXRAD Level 2</Title>
+    <Abstract>Products of NEXRAD archive 2 volumes.</Abstract>
+    <OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}"/>
+    <MaxWidth>` + strconv.Itoa(maxMapSize) + `</MaxWidth>
+    <MaxHeight>` + strconv.Itoa(maxMapSize) + `</MaxHeight>
+  </Service>
+  <Capability>
+    <Request>
+      <GetCapabilities>
+        <Format>text/xml</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}?"/></Get></HTTP></DCPType>
+      </GetCapabilities>
+      <GetMap>
+        <Format>image/png</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{xml .URL}}?"/></Get></HTTP></DCPType>
+      </GetMap>
+    </Request>
+    <Exception>
+      <Format>XML</Format>
+    </Exception>
+    <Layer>
+      <Title>NEXRAD Level 2</Title>
+      <CRS>CRS:84</CRS>
+      <CRS>EPSG:4326</CRS>
+      <CRS>EPSG:3857</CRS>
+      <EX_GeographicBoundingBox>
+        <westBoundLongitude>{{.West}}</westBoundLongitude>
+        <eastBoundLongitude>{{.East}}</eastBoundLongitude>
+        <southBoundLatitude>{{.South}}</southBoundLatitude>
+        <northBoundLatitude>{{.North}}</northBoundLatitude>
+      </EX_GeographicBoundingBox>
+      <BoundingBox CRS="CRS:84" minx="{{.West}}" miny="{{.South}}" maxx="{{.East}}" maxy="{{.North}}"/>
+      <BoundingBox CRS="EPSG:4326" minx="{{.South}}" miny="{{.West}}" maxx="{{.North}}" maxy="{{.East}}"/>
+      <BoundingBox CRS="EPSG:3857" minx="{{printf "%.1f" .MinX}}" miny="{{printf "%.1f" .MinY}}" maxx="{{printf "%.1f" .MaxX}}" maxy="{{printf "%.1f" .MaxY}}"/>
+      {{- if .Times}}
+      <Dimension name="time" units="ISO8601" default="{{.Default}}" nearestValue="1">{{range $i, $t := .Times}}{{if $i}},{{end}}{{$t}}{{end}}</Dimension>
+      {{- end}}
+      {{- range .Layers}}
+      <Layer opaque="0">
+        <Name>{{.Name}}</Name>
+        <Title>{{xml .Title}}</Title>
+        {{- range .Styles}}
+        <Style>
+          <Name>{{.}}</Name>
+          <Title>{{.}}</Title>
+        </Style>
+        {{- end}}
+      </Layer>
+      {{- end}}
+    </Layer>
+  </Capability>
+</WMS_Capabilities>
+`))
+
+func (s *Server) wmsCapabilities(w http.ResponseWriter, r *http.Request) error {
+	volumes, err := s.volumes()
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	c := capabilities{URL: scheme + "://" + r.Host + r.URL.Path}
+
+	// the same time may be the time of volumes of several stations
+	seen := map[string]bool{}
+	for _, v := range volumes {
+		t := v.Time.UTC().Format(time.RFC3339)
+		if !seen[t] {
+			c.Times = append(c.Times, t)
+			seen[t] = true
+		}
+		c.Default = t
+	}
+
+	// the layers and bounds are those of the latest volume of every station
+	elevations := map[int]bool{}
+	first := true
+	for _, l := range s.latest(volumes) {
+		for _, elv := range l.elevations {
+			elevations[elv] = true
+		}
+		west, south, east, north := radarBounds(l.lat, l.lon)
+		if first {
+			c.West, c.South, c.East, c.North = west, south, east, north
+			first = false
+		}
+		c.West, c.South = math.Min(c.West, west), math.Min(c.South, south)
+		c.East, c.North = math.Max(c.East, east), math.Max(c.North, north)
+	}
+	if first {
+		c.West, c.South, c.East, c.North = -180, -90, 180, 90
+	}
+	c.MinX, c.MinY = mercator(c.South, c.West)
+	c.MaxX, c.MaxY = mercator(c.North, c.East)
+
+	numbers := []int{}
+	for elv := range elevations {
+		numbers = append(numbers, elv)
+	}
+	sort.Ints(numbers)
+	for _, product := range Products {
+		styles := schemeNames(product)
+		for _, elv := range numbers {
+			c.Layers = append(c.Layers, capabilitiesLayer{
+				Name:   fmt.Sprintf("%s_%d", product, elv),
+				Title:  fmt.Sprintf("%s (%s) elevation %d", productTitles[product], strings.ToUpper(product), elv),
+				Styles: styles,
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	return capabilitiesTemplate.Execute(w, c)
+}
+
+// mapLayer is a layer drawn on a map.
+type mapLayer struct {
+	product   string
+	elevation int
+	colorOf   func(float32) color.Color
+}
+
+// parseLayers parses the LAYERS and STYLES parameters of GetMap.
+func parseLayers(layers, styles string) ([]mapLayer, error) {
+	if layers == "" {
+		return nil, &wmsException{"LayerNotDefined", "LAYERS is required"}
+	}
+	names := strings.Split(layers, ",")
+	schemes := make([]string, len(names))
+	if styles != "" {
+		if schemes = strings.Split(styles, ","); len(schemes) != len(names) {
+			return nil, &wmsException{"StyleNotDefined", "STYLES must hold a style for every layer"}
+		}
+	}
+	parsed := []mapLayer{}
+	for i, name := range names {
+		l := mapLayer{}
+		parts := strings.SplitN(strings.ToLower(name), "_", 2)
+		var err error
+		if _, ok := productMoments[parts[0]]; !ok || len(parts) != 2 {
+			return nil, &wmsException{"LayerNotDefined", fmt.Sprintf("unknown layer %s", name)}
+		}
+		if l.elevation, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, &wmsException{"LayerNotDefined", fmt.Sprintf("unknown layer %s", name)}
+		}
+		l.product = parts[0]
+		var ok bool
+		if l.colorOf, ok = colorFunc(l.product, schemes[i]); !ok {
+			return nil, &wmsException{"StyleNotDefined", fmt.Sprintf("unknown style %s of layer %s", schemes[i], name)}
+		}
+		parsed = append(parsed, l)
+	}
+	return parsed, nil
+}
+
+// parseBBox parses the BBOX parameter of GetMap in the given CRS and returns
+// the function mapping the fraction of the map from the left and top to a
+// latitude and longitude.
+func parseBBox(bbox, crs string) (func(fx, fy float64) (lat, lon float64), error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return nil, &wmsException{"", "BBOX must be minx,miny,maxx,maxy"}
+	}
+	b := [4]float64{}
+	for i, p := range parts {
+		var err error
+		if b[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+			return nil, &wmsException{"", fmt.Sprintf("invalid BBOX %s", bbox)}
+		}
+	}
+	if b[0] >= b[2] || b[1] >= b[3] {
+		return nil, &wmsException{"", fmt.Sprintf("invalid BBOX %s", bbox)}
+	}
+	minX, minY, maxX, maxY := b[0], b[1], b[2], b[3]
+
+	switch strings.ToUpper(crs) {
+	case "CRS:84":
+		return func(fx, fy float64) (float64, float64) {
+			return maxY - fy*(maxY-minY), minX + fx*(maxX-minX)
+		}, nil
+	case "EPSG:4326":
+		// latitude first
+		return func(fx, fy float64) (float64, float64) {
+			return maxX - fy*(maxX-minX), minY + fx*(maxY-minY)
+		}, nil
+	case "EPSG:3857", "EPSG:900913":
+		return func(fx, fy float64) (float64, float64) {
+			return fromMercator(minX+fx*(maxX-minX), maxY-fy*(maxY-minY))
+		}, nil
+	}
+	return nil, &wmsException{"InvalidCRS", fmt.Sprintf("unsupported CRS %s, one of %s", crs, strings.Join(wmsCRS, ", "))}
+}
+
+// parseTime parses the TIME parameter of GetMap, a single time.
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &wmsException{"InvalidDimensionValue", fmt.Sprintf("invalid TIME %s, an ISO 8601 time", s)}
+}
+
+func (s *Server) wmsMap(w http.ResponseWriter, params map[string]string) error {
+	layers, err := parseLayers(params["LAYERS"], params["STYLES"])
+	if err != nil {
+		return err
+	}
+	crs := params["CRS"]
+	if crs == "" {
+		// the parameter of WMS 1.1 clients
+		crs = params["SRS"]
+	}
+	latLon, err := parseBBox(params["BBOX"], crs)
+	if err != nil {
+		return err
+	}
+	width, err1 := strconv.Atoi(params["WIDTH"])
+	height, err2 := strconv.Atoi(params["HEIGHT"])
+	if err1 != nil || err2 != nil || width < 1 || height < 1 || width > maxMapSize || height > maxMapSize {
+		return &wmsException{"", fmt.Sprintf("WIDTH and HEIGHT must be 1-%d", maxMapSize)}
+	}
+	if f := params["FORMAT"]; f != "" && f != "image/png" {
+		return &wmsException{"InvalidFormat", fmt.Sprintf("unsupported format %s, image/png", f)}
+	}
+
+	volumes, err := s.volumes()
+	if err != nil {
+		return err
+	}
+	var t time.Time
+	if q := params["TIME"]; q != "" && !strings.EqualFold(q, "current") {
+		if t, err = parseTime(q); err != nil {
+			return err
+		}
+	} else if len(volumes) > 0 {
+		t = volumes[len(volumes)-1].Time
+	}
+
+	// the samplers of every layer for every station
+	samplers := make([][]*products.SweepSampler, len(layers))
+	for _, v := range nearest(volumes, t) {
+		vol, err := s.volume(v.File)
+		if err != nil {
+			// leave out the station rather than failing the whole map
+			logrus.Errorf("server: %s: %s", v.File, err)
+			continue
+		}
+		for i, l := range layers {
+			if len(vol.ar2.ElevationScans[l.elevation]) > 0 {
+				samplers[i] = append(samplers[i], vol.sampler(l.product, l.elevation))
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	// WMS 1.3.0 maps are opaque unless TRANSPARENT=TRUE
+	if !strings.EqualFold(params["TRANSPARENT"], "true") {
+		bg, err := parseColor(params["BGCOLOR"])
+		if err != nil {
+			return err
+		}
+		draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.ZP, draw.Src)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			lat, lon := latLon((float64(x)+0.5)/float64(width), (float64(y)+0.5)/float64(height))
+			for i, l := range layers {
+				for _, sampler := range samplers[i] {
+					if v, ok := sampler.At(lat, lon); ok && v != archive2.MomentDataBelowThreshold {
+						img.Set(x, y, l.colorOf(v))
+						break
+					}
+				}
+			}
+		}
+	}
+	writePNG(w, img)
+	return nil
+}
+
+// parseColor parses the BGCOLOR parameter of GetMap, white by default.
+func parseColor(s string) (color.Color, error) {
+	if s == "" {
+		return color.White, nil
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "0X"), 16, 32)
+	if err != nil || len(s) != 8 {
+		return nil, &wmsException{"", fmt.Sprintf("invalid BGCOLOR %s, 0xRRGGBB", s)}
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwiggs/go-nexrad/archive2"
+)
+
+type testCapabilities struct {
+	Layer struct {
+		CRS       []string `xml:"CRS"`
+		Dimension struct {
+			Name    string `xml:"name,attr"`
+			Default string `xml:"default,attr"`
+			Values  string `xml:",chardata"`
+		}
+		BBox struct {
+			West  float64 `xml:"westBoundLongitude"`
+			East  float64 `xml:"eastBoundLongitude"`
+			South float64 `xml:"southBoundLatitude"`
+			North float64 `xml:"northBoundLatitude"`
+		} `xml:"EX_GeographicBoundingBox"`
+		Layers []struct {
+			Name   string
+			Styles []struct{ Name string } `xml:"Style"`
+		} `xml:"Layer"`
+	} `xml:"Capability>Layer"`
+}
+
+func TestWMSCapabilities(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate, testDate.Add(5*time.Minute))
+	defer cleanup()
+
+	w := get(t, s, "/wms?service=WMS&request=GetCapabilities")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var c testCapabilities
+	if err := xml.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	l := c.Layer
+	if len(l.CRS) != 3 {
+		t.Errorf("CRS = %v", l.CRS)
+	}
+	if l.Dimension.Name != "time" || l.Dimension.Values != "2013-05-20T20:03:56Z,2013-05-20T20:08:56Z" || l.Dimension.Default != "2013-05-20T20:08:56Z" {
+		t.Errorf("dimension = %+v", l.Dimension)
+	}
+	if l.BBox.South > radarLat-4 || l.BBox.North < radarLat+4 || l.BBox.West > radarLon-5 || l.BBox.East < radarLon+5 {
+		t.Errorf("bounding box = %+v", l.BBox)
+	}
+	// one elevation
+	if len(l.Layers) != len(Products) || l.Layers[0].Name != "ref_1" {
+		t.Fatalf("%d layers, first %s", len(l.Layers), l.Layers[0].Name)
+	}
+	if styles := l.Layers[0].Styles; len(styles) != 5 || styles[0].Name != "noaa" {
+		t.Errorf("styles = %v", styles)
+	}
+}
+
+func TestWMSCapabilitiesCached(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate, testDate.Add(5*time.Minute))
+	defer cleanup()
+	s.cache = newCache(1)
+	opened := 0
+	open := s.open
+	s.open = func(path string) (*archive2.Archive2, error) {
+		opened++
+		return open(path)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get(t, s, "/wms?SERVICE=WMS&REQUEST=GetCapabilities"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<Name>ref_1</Name>") {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		// evict the latest volume
+		get(t, s, "/volumes/KTLX20130520_200356_V06")
+	}
+	if opened != 2 {
+		t.Errorf("decoded %d volumes, want each once", opened)
+	}
+}
+
+func TestWMSMap(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	x, y := mercator(radarLat, radarLon)
+	for _, q := range []string{
+		fmt.Sprintf("CRS=EPSG:4326&BBOX=%f,%f,%f,%f", radarLat-1, radarLon-1, radarLat+1, radarLon+1),
+		fmt.Sprintf("CRS=CRS:84&BBOX=%f,%f,%f,%f", radarLon-1, radarLat-1, radarLon+1, radarLat+1),
+		fmt.Sprintf("CRS=EPSG:3857&BBOX=%f,%f,%f,%f&STYLES=scope&TIME=2013-05-20T20:00:00Z", x-100000, y-100000, x+100000, y+100000),
+	} {
+		w := get(t, s, "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&LAYERS=ref_1&FORMAT=image/png&WIDTH=100&HEIGHT=80&TRANSPARENT=TRUE&"+q)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status %d: %s", q, w.Code, w.Body)
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 80 {
+			t.Errorf("%s size %v", q, img.Bounds())
+		}
+		// the radar is at the center and the gates reach 50 km
+		if _, _, _, a := img.At(50, 40).RGBA(); a == 0 {
+			t.Errorf("%s: no data at the radar", q)
+		}
+		if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("%s: data in the corner", q)
+		}
+	}
+
+	// opaque unless TRANSPARENT=TRUE, white unless BGCOLOR is given
+	for q, want := range map[string][3]uint32{
+		"":                                    {0xff, 0xff, 0xff},
+		"&TRANSPARENT=FALSE&BGCOLOR=0x102030": {0x10, 0x20, 0x30},
+		"&BGCOLOR=0x102030":                   {0x10, 0x20, 0x30},
+	} {
+		w := get(t, s, "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&LAYERS=ref_1&CRS=CRS:84&BBOX=-180,-90,-170,-80&WIDTH=10&HEIGHT=10"+q)
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, g, b, a := img.At(5, 5).RGBA(); r>>8 != want[0] || g>>8 != want[1] || b>>8 != want[2] || a>>8 != 0xff {
+			t.Errorf("%s: background %x %x %x %x", q, r>>8, g>>8, b>>8, a>>8)
+		}
+	}
+}
+
+func TestWMSMapCorruptStation(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	// a second station whose file fails to decode
+	vh := volumeHeader(testDate)
+	copy(vh.ICAO[:], "KFWS")
+	f, err := os.Create(filepath.Join(s.opts.Dir, "KFWS20130520_200356_V06"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.Write(f, binary.BigEndian, vh)
+	f.Close()
+	open := s.open
+	s.open = func(path string) (*archive2.Archive2, error) {
+		if strings.Contains(path, "KFWS") {
+			return nil, errors.New("corrupt volume")
+		}
+		return open(path)
+	}
+
+	q := fmt.Sprintf("CRS=CRS:84&BBOX=%f,%f,%f,%f", radarLon-1, radarLat-1, radarLon+1, radarLat+1)
+	w := get(t, s, "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&LAYERS=ref_1&WIDTH=100&HEIGHT=100&TRANSPARENT=TRUE&"+q)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(50, 50).RGBA(); a == 0 {
+		t.Error("no data at the radar of the other station")
+	}
+}
+
+func TestWMSExceptions(t *testing.T) {
+	s, cleanup := newTestServer(t, testDate)
+	defer cleanup()
+
+	const getMap = "/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&WIDTH=10&HEIGHT=10&"
+	for q, code := range map[string]string{
+		getMap + "LAYERS=xyz_1&CRS=CRS:84&BBOX=-98,35,-97,36":                  "LayerNotDefined",
+		getMap + "LAYERS=ref_1&CRS=EPSG:32614&BBOX=0,0,1,1":                    "InvalidCRS",
+		getMap + "LAYERS=ref_1&STYLES=pastel&CRS=CRS:84&BBOX=-98,35,-97,36":    "StyleNotDefined",
+		getMap + "LAYERS=ref_1&CRS=CRS:84&BBOX=-98,35,-97,36&FORMAT=image/gif": "InvalidFormat",
+		getMap + "LAYERS=ref_1&CRS=CRS:84&BBOX=-98,35,-97,36&TIME=yesterday":   "InvalidDimensionValue",
+		"/wms?SERVICE=WMS&REQUEST=GetFeatureInfo":                              "OperationNotSupported",
+	} {
+		w := get(t, s, q)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `code="`+code+`"`) {
+			t.Errorf("%s: status %d %s, want %s", q, w.Code, w.Body, code)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	volumes := []volumeInfo{
+		{"a1", "KTLX", testDate},
+		{"b1", "KFWS", testDate.Add(time.Minute)},
+		{"a2", "KTLX", testDate.Add(5 * time.Minute)},
+		{"b2", "KFWS", testDate.Add(7 * time.Minute)},
+	}
+	got := nearest(volumes, testDate.Add(3*time.Minute))
+	if len(got) != 2 || got[0].File != "b1" || got[1].File != "a2" {
+		t.Errorf("nearest = %v", got)
+	}
+}
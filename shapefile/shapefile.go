@@ -0,0 +1,253 @@
+// Package shapefile writes ESRI shapefiles of polygons with attributes in
+// WGS 84 longitude, latitude.
+package shapefile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prj is the coordinate system of the shapefile, WGS 84 longitude, latitude.
+const prj = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+const (
+	fileCode     = 9994
+	version      = 1000
+	shapePolygon = 5
+	headerSize   = 100
+)
+
+// Field is a numeric attribute of the features.
+type Field struct {
+	// Name is at most 10 characters long.
+	Name string
+	// Width is the number of characters of the formatted values and Decimals
+	// the number of digits after the decimal point.
+	Width, Decimals int
+}
+
+// Writer writes polygons to the .shp, .shx, .dbf and .prj files of a
+// shapefile. The headers are completed when the writer is closed.
+type Writer struct {
+	fields       []Field
+	shp, shx     *os.File
+	dbf          *os.File
+	shpw, shxw   *bufio.Writer
+	dbfw         *bufio.Writer
+	records      int
+	offset       int // in 16 bit words
+	min, max     [2]float64
+	recordLength int
+}
+
+// Create creates the files of a shapefile at base, the path without an
+// extension, with the given attributes.
+func Create(base string, fields []Field) (*Writer, error) {
+	base = strings.TrimSuffix(base, ".shp")
+	recordLength := 1
+	for _, f := range fields {
+		if f.Name == "" || len(f.Name) > 10 {
+			return nil, fmt.Errorf("shapefile: invalid field name %q", f.Name)
+		}
+		if f.Width <= 0 || f.Width > 254 || f.Decimals < 0 || f.Decimals > 15 {
+			return nil, fmt.Errorf("shapefile: invalid width of field %s", f.Name)
+		}
+		recordLength += f.Width
+	}
+
+	if err := ioutil.WriteFile(base+".prj", []byte(prj), 0644); err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		fields:       fields,
+		offset:       headerSize / 2,
+		min:          [2]float64{math.Inf(1), math.Inf(1)},
+		max:          [2]float64{math.Inf(-1), math.Inf(-1)},
+		recordLength: recordLength,
+	}
+	files := []**os.File{&w.shp, &w.shx, &w.dbf}
+	for i, ext := range []string{".shp", ".shx", ".dbf"} {
+		f, err := os.Create(base + ext)
+		if err != nil {
+			w.closeFiles()
+			return nil, err
+		}
+		*files[i] = f
+	}
+	w.shpw, w.shxw, w.dbfw = bufio.NewWriter(w.shp), bufio.NewWriter(w.shx), bufio.NewWriter(w.dbf)
+
+	// the headers are rewritten on close, with the sizes and bounds
+	if err := w.writeHeaders(); err != nil {
+		w.closeFiles()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write adds a polygon of closed rings of longitude, latitude pairs, the first
+// being the exterior and any others holes, with the values of the fields.
+// Rings are reoriented as shapefiles require, the exterior clockwise and holes
+// counterclockwise. NaN values are written as null.
+func (w *Writer) Write(rings [][][]float64, values []float64) error {
+	if len(values) != len(w.fields) {
+		return fmt.Errorf("shapefile: %d values for %d fields", len(values), len(w.fields))
+	}
+	if len(rings) == 0 {
+		return errors.New("shapefile: polygon without rings")
+	}
+
+	points := 0
+	min := [2]float64{math.Inf(1), math.Inf(1)}
+	max := [2]float64{math.Inf(-1), math.Inf(-1)}
+	for _, r := range rings {
+		points += len(r)
+		for _, p := range r {
+			min = [2]float64{math.Min(min[0], p[0]), math.Min(min[1], p[1])}
+			max = [2]float64{math.Max(max[0], p[0]), math.Max(max[1], p[1])}
+		}
+	}
+
+	// shape type, box, part and point counts, part offsets and points
+	length := 4 + 32 + 8 + 4*len(rings) + 16*points
+	w.records++
+	binary.Write(w.shxw, binary.BigEndian, []int32{int32(w.offset), int32(length / 2)})
+	binary.Write(w.shpw, binary.BigEndian, []int32{int32(w.records), int32(length / 2)})
+	binary.Write(w.shpw, binary.LittleEndian, int32(shapePolygon))
+	binary.Write(w.shpw, binary.LittleEndian, []float64{min[0], min[1], max[0], max[1]})
+	binary.Write(w.shpw, binary.LittleEndian, []int32{int32(len(rings)), int32(points)})
+	start := 0
+	for _, r := range rings {
+		binary.Write(w.shpw, binary.LittleEndian, int32(start))
+		start += len(r)
+	}
+	for i, r := range rings {
+		// the exterior is clockwise, holes are counterclockwise
+		reverse := (area(r) > 0) == (i == 0)
+		for j := range r {
+			p := r[j]
+			if reverse {
+				p = r[len(r)-1-j]
+			}
+			binary.Write(w.shpw, binary.LittleEndian, []float64{p[0], p[1]})
+		}
+	}
+	w.offset += 4 + length/2
+	w.min = [2]float64{math.Min(w.min[0], min[0]), math.Min(w.min[1], min[1])}
+	w.max = [2]float64{math.Max(w.max[0], max[0]), math.Max(w.max[1], max[1])}
+
+	// not deleted
+	w.dbfw.WriteByte(' ')
+	for i, f := range w.fields {
+		s := strings.Repeat(" ", f.Width)
+		if v := values[i]; !math.IsNaN(v) && !math.IsInf(v, 0) {
+			s = strconv.FormatFloat(v, 'f', f.Decimals, 64)
+			if len(s) > f.Width {
+				return fmt.Errorf("shapefile: %s does not fit in field %s", s, f.Name)
+			}
+			s = strings.Repeat(" ", f.Width-len(s)) + s
+		}
+		w.dbfw.WriteString(s)
+	}
+	return nil
+}
+
+// area returns the signed area of a ring, positive when counterclockwise.
+func area(r [][]float64) float64 {
+	var a float64
+	for i, p := range r {
+		q := r[(i+1)%len(r)]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+// writeHeaders writes the headers of the files at their current position.
+func (w *Writer) writeHeaders() error {
+	min, max := w.min, w.max
+	if w.records == 0 {
+		min, max = [2]float64{}, [2]float64{}
+	}
+	for _, f := range []struct {
+		w      *bufio.Writer
+		length int
+	}{{w.shpw, w.offset}, {w.shxw, headerSize/2 + 4*w.records}} {
+		binary.Write(f.w, binary.BigEndian, []int32{fileCode, 0, 0, 0, 0, 0, int32(f.length)})
+		binary.Write(f.w, binary.LittleEndian, []int32{version, shapePolygon})
+		binary.Write(f.w, binary.LittleEndian, []float64{min[0], min[1], max[0], max[1], 0, 0, 0, 0})
+	}
+
+	now := time.Now()
+	w.dbfw.Write([]byte{0x03, byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(w.dbfw, binary.LittleEndian, uint32(w.records))
+	binary.Write(w.dbfw, binary.LittleEndian, uint16(32+32*len(w.fields)+1))
+	binary.Write(w.dbfw, binary.LittleEndian, uint16(w.recordLength))
+	w.dbfw.Write(make([]byte, 20))
+	for _, f := range w.fields {
+		name := make([]byte, 11)
+		copy(name, f.Name)
+		w.dbfw.Write(name)
+		w.dbfw.WriteByte('N')
+		w.dbfw.Write(make([]byte, 4))
+		w.dbfw.Write([]byte{byte(f.Width), byte(f.Decimals)})
+		w.dbfw.Write(make([]byte, 14))
+	}
+	return w.dbfw.WriteByte(0x0d)
+}
+
+// Close writes the headers and closes the files.
+func (w *Writer) Close() error {
+	// end of the dbf records
+	w.dbfw.WriteByte(0x1a)
+	err := w.flush()
+	if err == nil {
+		for _, f := range []*os.File{w.shp, w.shx, w.dbf} {
+			if _, err = f.Seek(0, 0); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		w.shpw.Reset(w.shp)
+		w.shxw.Reset(w.shx)
+		w.dbfw.Reset(w.dbf)
+		if err = w.writeHeaders(); err == nil {
+			err = w.flush()
+		}
+	}
+	if cerr := w.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// flush flushes the buffered writers.
+func (w *Writer) flush() error {
+	for _, b := range []*bufio.Writer{w.shpw, w.shxw, w.dbfw} {
+		if err := b.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeFiles closes the open files.
+func (w *Writer) closeFiles() error {
+	var err error
+	for _, f := range []*os.File{w.shp, w.shx, w.dbf} {
+		if f == nil {
+			continue
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
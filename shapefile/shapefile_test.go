@@ -0,0 +1,100 @@
+package shapefile
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "gates")
+
+	w, err := Create(base, []Field{{"REF", 8, 2}, {"VEL", 8, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// counterclockwise as in GeoJSON
+	square := [][]float64{{-97, 35}, {-96, 35}, {-96, 36}, {-97, 36}, {-97, 35}}
+	if err := w.Write([][][]float64{square}, []float64{42.5, math.NaN()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([][][]float64{square}, []float64{-3, 12.25}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([][][]float64{square}, []float64{1}); err == nil {
+		t.Error("expected an error writing too few values")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	shp, _ := ioutil.ReadFile(base + ".shp")
+	shx, _ := ioutil.ReadFile(base + ".shx")
+	dbf, _ := ioutil.ReadFile(base + ".dbf")
+	prj, _ := ioutil.ReadFile(base + ".prj")
+	if !strings.Contains(string(prj), "WGS_1984") {
+		t.Errorf("prj = %s", prj)
+	}
+
+	for name, data := range map[string][]byte{"shp": shp, "shx": shx} {
+		if code := binary.BigEndian.Uint32(data); code != fileCode {
+			t.Errorf("%s file code %d", name, code)
+		}
+		if length := int(binary.BigEndian.Uint32(data[24:])) * 2; length != len(data) {
+			t.Errorf("%s length %d, file is %d bytes", name, length, len(data))
+		}
+		if shape := binary.LittleEndian.Uint32(data[32:]); shape != shapePolygon {
+			t.Errorf("%s shape type %d", name, shape)
+		}
+		if xmin := math.Float64frombits(binary.LittleEndian.Uint64(data[36:])); xmin != -97 {
+			t.Errorf("%s xmin %f", name, xmin)
+		}
+	}
+
+	// second record, found through the index
+	offset := int(binary.BigEndian.Uint32(shx[headerSize+8:])) * 2
+	if n := binary.BigEndian.Uint32(shp[offset:]); n != 2 {
+		t.Errorf("record number %d, want 2", n)
+	}
+	content := shp[offset+8:]
+	if parts, points := binary.LittleEndian.Uint32(content[36:]), binary.LittleEndian.Uint32(content[40:]); parts != 1 || points != 5 {
+		t.Errorf("%d parts, %d points", parts, points)
+	}
+	// the exterior ring is clockwise
+	ring := [][]float64{}
+	for i := 0; i < 5; i++ {
+		p := content[48+16*i:]
+		ring = append(ring, []float64{
+			math.Float64frombits(binary.LittleEndian.Uint64(p)),
+			math.Float64frombits(binary.LittleEndian.Uint64(p[8:])),
+		})
+	}
+	if area(ring) >= 0 {
+		t.Errorf("exterior ring %v is not clockwise", ring)
+	}
+
+	if n := binary.LittleEndian.Uint32(dbf[4:]); n != 2 {
+		t.Errorf("%d dbf records, want 2", n)
+	}
+	headerLength := int(binary.LittleEndian.Uint16(dbf[8:]))
+	recordLength := int(binary.LittleEndian.Uint16(dbf[10:]))
+	if recordLength != 17 {
+		t.Errorf("record length %d, want 17", recordLength)
+	}
+	records := string(dbf[headerLength : headerLength+2*recordLength])
+	if want := "    42.50            -3.00   12.25"; records != want {
+		t.Errorf("records %q, want %q", records, want)
+	}
+	if dbf[len(dbf)-1] != 0x1a {
+		t.Error("missing dbf end of file marker")
+	}
+}
@@ -0,0 +1,109 @@
+// Package tiles renders XYZ tiles in the Web Mercator projection of Leaflet,
+// MapLibre and other slippy maps.
+package tiles
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Size is the width and height of a tile in pixels.
+const Size = 256
+
+// MaxLatitude is the latitude in degrees of the north and south edges of the
+// Web Mercator world.
+const MaxLatitude = 85.0511287798066
+
+// Tile is a tile at zoom level Z, column X from the antimeridian eastward and
+// row Y from the north.
+type Tile struct {
+	Z, X, Y int
+}
+
+func (t Tile) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
+
+// LatLon returns the latitude and longitude of the point x, y pixels from
+// the north west corner of the tile.
+func (t Tile) LatLon(x, y float64) (lat, lon float64) {
+	n := float64(int(1) << uint(t.Z))
+	fx := (float64(t.X) + x/Size) / n
+	fy := (float64(t.Y) + y/Size) / n
+	lon = fx*360 - 180
+	lat = math.Atan(math.Sinh(math.Pi*(1-2*fy))) * 180 / math.Pi
+	return lat, lon
+}
+
+// Bounds returns the edges of the tile in degrees.
+func (t Tile) Bounds() (west, south, east, north float64) {
+	north, west = t.LatLon(0, 0)
+	south, east = t.LatLon(Size, Size)
+	return west, south, east, north
+}
+
+// At returns the tile at zoom level z holding lat, lon.
+func At(z int, lat, lon float64) Tile {
+	n := 1 << uint(z)
+	lat = math.Max(-MaxLatitude, math.Min(MaxLatitude, lat))
+	φ := lat * math.Pi / 180
+	x := int(math.Floor((lon + 180) / 360 * float64(n)))
+	y := int(math.Floor((1 - math.Log(math.Tan(φ)+1/math.Cos(φ))/math.Pi) / 2 * float64(n)))
+	clamp := func(v int) int {
+		return int(math.Max(0, math.Min(float64(n-1), float64(v))))
+	}
+	return Tile{z, clamp(x), clamp(y)}
+}
+
+// Covering returns the tiles at zoom level z covering the box between the
+// given edges in degrees, row by row from the north west.
+func Covering(z int, west, south, east, north float64) []Tile {
+	nw := At(z, north, west)
+	se := At(z, south, east)
+	tiles := []Tile{}
+	for y := nw.Y; y <= se.Y; y++ {
+		for x := nw.X; x <= se.X; x++ {
+			tiles = append(tiles, Tile{z, x, y})
+		}
+	}
+	return tiles
+}
+
+// Render returns the image of a tile colored by the color of the center of
+// each pixel, transparent where color returns false. empty is true when no
+// pixel is colored.
+func Render(t Tile, colorAt func(lat, lon float64) (color.Color, bool)) (img *image.NRGBA, empty bool) {
+	img = image.NewNRGBA(image.Rect(0, 0, Size, Size))
+	empty = true
+	for y := 0; y < Size; y++ {
+		for x := 0; x < Size; x++ {
+			if c, ok := colorAt(t.LatLon(float64(x)+0.5, float64(y)+0.5)); ok {
+				img.Set(x, y, c)
+				empty = false
+			}
+		}
+	}
+	return img, empty
+}
+
+// WriteDir writes the image of a tile as dir/z/x/y.png.
+func WriteDir(dir string, t Tile, img image.Image) error {
+	path := filepath.Join(dir, fmt.Sprint(t.Z), fmt.Sprint(t.X), fmt.Sprintf("%d.png", t.Y))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,81 @@
+package tiles
+
+import (
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTileGeometry(t *testing.T) {
+	// the KTLX radar near Oklahoma City
+	tile := At(10, 35.333, -97.278)
+	if tile != (Tile{10, 235, 404}) {
+		t.Errorf("tile = %v, want 10/235/404", tile)
+	}
+	west, south, east, north := tile.Bounds()
+	if west > -97.278 || east < -97.278 || south > 35.333 || north < 35.333 {
+		t.Errorf("bounds %f %f %f %f do not hold the radar", west, south, east, north)
+	}
+	if math.Abs(east-west-360.0/1024) > 1e-9 {
+		t.Errorf("width %f, want %f", east-west, 360.0/1024)
+	}
+
+	world := Tile{0, 0, 0}
+	if lat, lon := world.LatLon(0, 0); math.Abs(lat-MaxLatitude) > 1e-9 || lon != -180 {
+		t.Errorf("north west corner %f, %f", lat, lon)
+	}
+	if lat, _ := world.LatLon(Size/2, Size/2); math.Abs(lat) > 1e-9 {
+		t.Errorf("center latitude %f, want 0", lat)
+	}
+
+	covering := Covering(10, -98, 35, -97, 36)
+	if first := covering[0]; first != At(10, 36, -98) {
+		t.Errorf("first tile %v", first)
+	}
+	if last := covering[len(covering)-1]; last != At(10, 35, -97) {
+		t.Errorf("last tile %v", last)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tile := At(8, 35.333, -97.278)
+	_, south, _, north := tile.Bounds()
+	middle := (south + north) / 2
+	img, empty := Render(tile, func(lat, lon float64) (color.Color, bool) {
+		return color.RGBA{255, 0, 0, 255}, lat > middle
+	})
+	if empty {
+		t.Fatal("expected a colored tile")
+	}
+	if _, _, _, a := img.At(10, 10).RGBA(); a == 0 {
+		t.Error("north pixel is transparent")
+	}
+	if _, _, _, a := img.At(10, Size-10).RGBA(); a != 0 {
+		t.Error("south pixel is colored")
+	}
+
+	if _, empty := Render(tile, func(lat, lon float64) (color.Color, bool) { return nil, false }); !empty {
+		t.Error("expected an empty tile")
+	}
+
+	dir, err := ioutil.TempDir("", "tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := WriteDir(dir, tile, img); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "8", "58", "101.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Error(err)
+	}
+}